@@ -18,8 +18,8 @@ RUN go mod download
 # 复制源代码
 COPY . .
 
-# 编译应用（启用CGO以支持sqlite3）
-RUN CGO_ENABLED=1 GOOS=linux go build -a -installsuffix cgo -ldflags="-w -s" -o opds-server ./cmd/server
+# 编译应用（启用CGO以支持sqlite3，sqlite_fts5标签启用全文搜索）
+RUN CGO_ENABLED=1 GOOS=linux go build -tags sqlite_fts5 -a -installsuffix cgo -ldflags="-w -s" -o opds-server ./cmd/server
 
 # 最终镜像
 FROM alpine:latest
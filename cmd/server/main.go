@@ -1,26 +1,50 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/audit"
 	"github.com/ricci/calibre-opds-go/internal/config"
 	"github.com/ricci/calibre-opds-go/internal/database"
 	"github.com/ricci/calibre-opds-go/internal/handlers"
+	"github.com/ricci/calibre-opds-go/internal/metrics"
+	"github.com/ricci/calibre-opds-go/internal/progress"
 	"github.com/ricci/calibre-opds-go/pkg/logger"
 )
 
 func main() {
-	// 初始化日志
-	logger.Init()
-	log.Println("Starting Calibre OPDS Server (Go Edition)...")
+	validate := flag.Bool("validate", false, "校验书库后退出，发现问题时返回非零状态码")
+	validateSample := flag.Int("validate-sample", 100, "校验时抽样检查的书籍数量")
+	flag.Parse()
 
 	// 加载配置
 	cfg := config.Load()
-	log.Printf("Database path: %s", cfg.DBPath)
-	log.Printf("Books path: %s", cfg.BooksPath)
+
+	// 初始化日志
+	logger.Init(cfg.LogLevel, cfg.LogFile, cfg.LogToConsole)
+	logger.Info.Println("Starting Calibre OPDS Server (Go Edition)...")
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.ConfigFile != "" {
+		logger.Info.Printf("Config file: %s", cfg.ConfigFile)
+	}
+	if cfg.LibraryRoot != "" {
+		discoverLibraries(cfg)
+	}
+	logger.Info.Printf("Database path: %s", cfg.DBPath)
+	logger.Info.Printf("Books path: %s", cfg.BooksPath)
+	if cfg.CanonicalBaseURL != "" {
+		logger.Info.Printf("Canonical base URL: %s", cfg.CanonicalBaseURL)
+	}
 
 	// 初始化数据库
 	db, err := database.NewDB(cfg.DBPath)
@@ -28,71 +52,246 @@ func main() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer db.Close()
+	db.SetAuthorAliases(cfg.AuthorAliases)
+	db.SetQueryTimeout(cfg.ConnectionTimeout)
+
+	// 校验模式
+	if *validate {
+		code := runValidate(os.Stdout, handlers.NewHandler(db, cfg), *validateSample)
+		db.Close()
+		os.Exit(code)
+	}
 
 	// 验证数据库
 	if err := db.Validate(); err != nil {
 		log.Fatalf("Database validation failed: %v", err)
 	}
 
-	bookCount, err := db.GetBooksCount("")
+	bookCount, err := db.GetBooksCountContext(context.Background(), "")
 	if err != nil {
 		log.Fatalf("Failed to get book count: %v", err)
 	}
-	log.Printf("Database loaded successfully. Total books: %d", bookCount)
+	logger.Info.Printf("Database loaded successfully. Total books: %d", bookCount)
+	if bookCount == 0 {
+		logger.Info.Printf("Library is empty: add books with Calibre to %s", cfg.BooksPath)
+	}
+
+	if cfg.FTSSearch {
+		db.EnableFTS()
+	}
+
+	// 数据库文件被替换时自动重新打开
+	if cfg.DBWatchInterval > 0 {
+		go db.Watch(cfg.DBWatchInterval, nil)
+	}
 
 	// 设置Gin模式
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// 创建路由
-	router := gin.Default()
-
 	// 初始化处理器
 	h := handlers.NewHandler(db, cfg)
+	if cfg.ProgressDBPath != "" {
+		store, err := progress.Open(cfg.ProgressDBPath)
+		if err != nil {
+			log.Fatalf("Failed to open progress database: %v", err)
+		}
+		defer store.Close()
+		h.SetProgressStore(store)
+		logger.Info.Printf("Reading progress database: %s", cfg.ProgressDBPath)
+	}
+	if cfg.DownloadAuditLog != "" {
+		auditLog, err := audit.Open(cfg.DownloadAuditLog, cfg.DownloadAuditMaxSize)
+		if err != nil {
+			log.Fatalf("Failed to open download audit log: %v", err)
+		}
+		defer auditLog.Close()
+		h.SetAuditLog(auditLog)
+		logger.Info.Printf("Download audit log: %s", cfg.DownloadAuditLog)
+	}
+
+	// 创建路由
+	router := gin.New()
+	if err := h.ConfigureTrustedProxies(router); err != nil {
+		log.Fatalf("Failed to configure trusted proxies: %v", err)
+	}
+	if cfg.SlowRequestThreshold > 0 {
+		// 只记录慢请求；慢请求属于WARNING级别，LOG_LEVEL=ERROR时不记录
+		if !logger.Enabled(logger.LevelWarning) {
+			logger.Error.Printf("SLOW_REQUEST_MS is set but LOG_LEVEL=%s discards slow request warnings", cfg.LogLevel)
+		}
+		router.Use(logger.SlowRequests(cfg.SlowRequestThreshold))
+		logger.Info.Printf("Logging only requests slower than %s", cfg.SlowRequestThreshold)
+	} else if logger.Enabled(logger.LevelInfo) {
+		// 访问日志属于INFO级别
+		if cfg.LogFormat == "json" {
+			router.Use(logger.AccessLog())
+		} else {
+			router.Use(gin.Logger())
+		}
+	}
+	router.Use(gin.CustomRecovery(h.RecoverPanic), h.RecordErrors())
+
+	var m *metrics.Metrics
+	if cfg.MetricsEnabled {
+		m = metrics.New()
+		h.SetMetrics(m)
+		db.SetQueryObserver(m.ObserveQuery)
+		router.Use(m.Middleware())
+	}
+
+	router.MaxMultipartMemory = cfg.MaxRequestBodySize
+
+	// 所有路由挂载在配置的路径前缀下
+	root := router.Group(cfg.BasePath)
+
+	if m != nil {
+		root.GET("/metrics", m.Handler(db.PoolStats))
+		logger.Info.Printf("Prometheus metrics: %s/metrics", cfg.BasePath)
+	}
 
 	// OPDS路由
-	opdsGroup := router.Group("/opds")
+	opdsGroup := root.Group("/opds")
 	{
-		opdsGroup.GET("", h.OPDSRoot)
-		opdsGroup.GET("/books", h.OPDSBooks)
-		opdsGroup.GET("/book/:id", h.OPDSBookDetail)
-		opdsGroup.GET("/authors", h.OPDSAuthors)
-		opdsGroup.GET("/series", h.OPDSSeries)
-		opdsGroup.GET("/tags", h.OPDSTags)
-		opdsGroup.GET("/cover/:id", h.GetCover)
+		feeds := opdsGroup.Group("", h.CacheControl(handlers.CacheFeed))
+		feeds.GET("", h.OPDSRoot)
+		feeds.GET("/books", h.OPDSBooks)
+		feeds.GET("/recent", h.OPDSRecent)
+		feeds.GET("/search.xml", h.OPDSSearch)
+		feeds.GET("/all", h.OPDSAll)
+		feeds.GET("/crawlable", h.OPDSAll)
+		feeds.GET("/book/:id", h.OPDSBookDetail)
+		feeds.GET("/authors", h.OPDSAuthors)
+		feeds.GET("/authors/letters", h.OPDSAuthorLetters)
+		feeds.GET("/series", h.OPDSSeries)
+		feeds.GET("/tags", h.OPDSTags)
+		feeds.GET("/publishers", h.OPDSPublishers)
+		feeds.GET("/tag/*name", h.OPDSTag)
+		feeds.GET("/changelog", h.OPDSChangelog)
+		feeds.GET("/decades", h.OPDSDecades)
+
+		// 按用户区分的内容，不设置公共缓存
+		opdsGroup.GET("/continue", h.OPDSContinueReading)
+		// 每次结果不同，不设置缓存
+		opdsGroup.GET("/random", h.OPDSRandom)
+		opdsGroup.GET("/cover/:id", h.CacheControl(handlers.CacheCover), h.GetCover)
+		// 重定向不带feed的缓存头
+		opdsGroup.GET("/book/:id/acquire/:format", h.OPDSAcquire)
 	}
 
 	// 文件下载路由
-	router.GET("/download/:id/:format", h.DownloadBook)
+	downloads := root.Group("/download", h.CacheControl(handlers.CacheDownload))
+	downloads.GET("/:id/:format", h.DownloadBook)
+	downloads.GET("/:id/opf", h.DownloadBookOPF)
+	downloads.GET("/:id/best", h.DownloadBestFormat)
+	downloads.GET("/series/*name", h.DownloadSeries)
 
 	// REST API路由
-	apiGroup := router.Group("/api")
+	apiGroup := root.Group("/api")
 	{
 		apiGroup.GET("/books", h.APIBooks)
+		apiGroup.GET("/random", h.APIRandom)
+		apiGroup.POST("/books/search", h.LimitBody(), h.APISearchBooks)
 		apiGroup.GET("/book/:id", h.APIBookDetail)
+		apiGroup.GET("/book/:id/preview", h.APIBookPreview)
+		apiGroup.GET("/book/:id/opf", h.APIBookOPF)
+		apiGroup.GET("/book/:id/similar", h.APIBookSimilar)
+		apiGroup.GET("/book/:id/cover/info", h.APICoverInfo)
+		apiGroup.GET("/covers/manifest", h.APICoverManifest)
+		apiGroup.POST("/book/:id/progress", h.LimitBody(), h.APIUpdateProgress)
+		apiGroup.GET("/search/suggest", h.APISearchSuggest)
 		apiGroup.GET("/stats", h.APIStats)
+		apiGroup.GET("/formats", h.APIFormats)
+		apiGroup.GET("/changelog", h.APIChangelog)
+		apiGroup.GET("/tags", h.APITags)
+		apiGroup.GET("/taxonomy", h.APITaxonomy)
+		apiGroup.GET("/incomplete", h.APIIncompleteBooks)
 		apiGroup.GET("/health", h.APIHealth)
 		apiGroup.GET("/connection-stats", h.APIConnectionStats)
+		apiGroup.GET("/cache-stats", h.APICacheStats)
+		apiGroup.GET("/config", h.APIConfig)
 		apiGroup.GET("/diagnose", h.APIDiagnose)
+		apiGroup.GET("/errors", h.RequireAdmin(), h.APIErrors)
 	}
 
 	// 启动服务器
-	host := getEnv("OPDS_HOST", "0.0.0.0")
-	port := getEnv("OPDS_PORT", "1580")
-	addr := fmt.Sprintf("%s:%s", host, port)
+	addr := fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
+
+	server := newHTTPServer(cfg, addr, router)
 
-	log.Printf("OPDS Catalog: http://%s/opds", addr)
-	log.Printf("Server starting on %s", addr)
+	if cfg.TLSEnabled() {
+		// 配置已在启动时校验
+		server.TLSConfig, _ = cfg.TLSConfig()
 
-	if err := router.Run(addr); err != nil {
+		logger.Info.Printf("OPDS Catalog: https://%s%s/opds", addr, cfg.BasePath)
+		logger.Info.Printf("Server starting on %s (HTTPS, TLS >= %s)", addr, cfg.TLSMinVersion)
+		err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	} else {
+		logger.Info.Printf("OPDS Catalog: http://%s%s/opds", addr, cfg.BasePath)
+		logger.Info.Printf("Server starting on %s (HTTP)", addr)
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// discoverLibraries 在LIBRARY_ROOT下查找书库并列出，默认位置没有数据库时使用发现的第一个书库。
+// 每个书库有自己的metadata.db，因此不加入X-Books-Root可切换的根目录（那些根目录共用同一个数据库）
+func discoverLibraries(cfg *config.Config) {
+	libraries, err := database.DiscoverLibraries(cfg.LibraryRoot)
+	if err != nil {
+		logger.Warning.Printf("Library discovery failed: %v", err)
+		return
+	}
+	logger.Info.Printf("Discovered %d libraries under %s", len(libraries), cfg.LibraryRoot)
+	for _, library := range libraries {
+		logger.Info.Printf("  %s", library)
+	}
+	if len(libraries) == 0 {
+		return
+	}
+
+	if _, err := os.Stat(cfg.DBPath); err != nil {
+		cfg.DBPath = filepath.Join(libraries[0], "metadata.db")
+		cfg.BooksPath = libraries[0]
+	}
+}
+
+// runValidate 执行书库校验并把报告写入w，返回进程退出码
+func runValidate(w io.Writer, h *handlers.Handler, sampleSize int) int {
+	report := h.ValidateLibrary(sampleSize)
+
+	fmt.Fprintln(w, "Library validation report")
+	fmt.Fprintf(w, "  Schema version:  %d\n", report.SchemaVersion)
+	fmt.Fprintf(w, "  Total books:     %d\n", report.TotalBooks)
+	fmt.Fprintf(w, "  Sampled books:   %d\n", report.SampledBooks)
+	printIssues(w, "Errors", report.Errors)
+	printIssues(w, "Missing files", report.MissingFiles)
+	printIssues(w, "Encoding issues", report.EncodingIssues)
+
+	if !report.OK() {
+		fmt.Fprintln(w, "Result: FAILED")
+		return 1
+	}
+	fmt.Fprintln(w, "Result: OK")
+	return 0
+}
+
+func printIssues(w io.Writer, title string, issues []string) {
+	fmt.Fprintf(w, "  %s: %d\n", title, len(issues))
+	for _, issue := range issues {
+		fmt.Fprintf(w, "    - %s\n", issue)
+	}
+}
+
+// newHTTPServer 创建带读取超时的HTTP服务器
+func newHTTPServer(cfg *config.Config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
 	}
-	return defaultValue
 }
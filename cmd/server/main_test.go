@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ricci/calibre-opds-go/internal/config"
+	"github.com/ricci/calibre-opds-go/internal/database"
+	"github.com/ricci/calibre-opds-go/internal/handlers"
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+// newValidateHandler 打开测试书库并创建处理器
+func newValidateHandler(t *testing.T, lib *testutil.Library) *handlers.Handler {
+	t.Helper()
+	t.Setenv("CALIBRE_DB_PATH", lib.DBPath)
+	t.Setenv("CALIBRE_BOOKS_PATH", lib.Root)
+	cfg := config.Load()
+
+	db, err := database.NewDB(lib.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return handlers.NewHandler(db, cfg)
+}
+
+func TestRunValidateExitCode(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.Exec(t, `PRAGMA user_version = 26`)
+	id := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Formats: []string{"EPUB"}})
+
+	var out strings.Builder
+	if code := runValidate(&out, newValidateHandler(t, lib), 10); code != 0 {
+		t.Errorf("good library: exit code %d, want 0\n%s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "Result: OK") {
+		t.Errorf("good library output:\n%s", out.String())
+	}
+
+	if err := os.Remove(filepath.Join(lib.BookDir(t, id), "Dune - Frank Herbert.epub")); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if code := runValidate(&out, newValidateHandler(t, lib), 10); code != 1 {
+		t.Errorf("broken library: exit code %d, want 1\n%s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "Missing files: 1") || !strings.Contains(out.String(), "Result: FAILED") {
+		t.Errorf("broken library output:\n%s", out.String())
+	}
+}
+
+func TestHTTPServerReadHeaderTimeout(t *testing.T) {
+	t.Setenv("READ_HEADER_TIMEOUT", "100ms")
+	cfg := config.Load()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newHTTPServer(cfg, listener.Addr().String(), http.NotFoundHandler())
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 请求头始终不发送完，服务器应在超时后关闭连接
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("connection was not closed by the server: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("connection closed after %v, want about 100ms", elapsed)
+	}
+}
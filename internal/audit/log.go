@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record 一次下载的审计记录
+type Record struct {
+	Time     time.Time `json:"time"`
+	ClientIP string    `json:"client_ip"`
+	User     string    `json:"user,omitempty"` // 受信任代理传递的用户名，未认证时为空
+	BookID   int       `json:"book_id"`
+	Format   string    `json:"format"`
+	Series   string    `json:"series,omitempty"` // 系列打包下载时的系列名
+}
+
+// Log 只追加的下载审计日志，每行一条JSON记录；文件超过大小上限时轮转为<path>.1
+type Log struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open 打开审计日志文件，不存在时创建；maxSize为0时不轮转
+func Open(path string, maxSize int64) (*Log, error) {
+	l := &Log{path: path, maxSize: maxSize}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open 以追加方式打开日志文件
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Write 追加一条记录，写入前按需轮转；轮转失败时记录仍写入当前文件，并返回轮转的错误
+func (l *Log) Write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var rotateErr error
+	if l.file != nil && l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		rotateErr = l.rotate()
+	}
+	if l.file == nil {
+		// 上次重新打开失败，再试一次
+		if err := l.open(); err != nil {
+			return errors.Join(rotateErr, err)
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// rotate 将当前文件重命名为<path>.1（覆盖上一个备份）并重新打开；
+// 重命名失败时重新打开原文件继续追加，重新打开也失败时l.file为nil
+func (l *Log) rotate() error {
+	l.file.Close()
+	l.file = nil
+	renameErr := os.Rename(l.path, l.path+".1")
+	if err := l.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", renameErr)
+	}
+	return nil
+}
+
+// Close 关闭日志文件
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
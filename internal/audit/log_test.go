@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countLines 返回文件的行数，文件不存在时返回0
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	n := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		n++
+	}
+	return n
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 1; i <= 3; i++ {
+		if err := l.Write(Record{BookID: i, Format: "EPUB"}); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	if got := countLines(t, path) + countLines(t, path+".1"); got != 3 {
+		t.Errorf("%d records across both files, want 3", got)
+	}
+	if countLines(t, path+".1") == 0 {
+		t.Error("log was not rotated")
+	}
+}
+
+func TestRotateFailureKeepsWriting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	// <path>.1是非空目录，重命名失败
+	if err := os.MkdirAll(filepath.Join(path+".1", "keep"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Open(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if err := l.Write(Record{BookID: 1, Format: "EPUB"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(Record{BookID: 2, Format: "EPUB"}); err == nil {
+		t.Error("expected the rotation error to be reported")
+	}
+	if err := l.Write(Record{BookID: 3, Format: "EPUB"}); err == nil {
+		t.Error("expected the rotation error to be reported")
+	}
+	if got := countLines(t, path); got != 3 {
+		t.Errorf("%d records in the log, want 3", got)
+	}
+}
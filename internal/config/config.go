@@ -1,47 +1,289 @@
 package config
 
 import (
+	"crypto/tls"
+	"fmt"
+	"image/color"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // Config 应用配置
 type Config struct {
+	// ConfigFile 加载的配置文件路径，为空表示未使用配置文件
+	ConfigFile string
+	// configFileErr 读取配置文件的错误，在Validate中返回
+	configFileErr error
+
 	// 数据库配置
 	DBPath            string
 	BooksPath         string
+	BooksRoots        []string // 允许通过X-Books-Root请求头切换的书籍根目录
+	LibraryRoot       string   // 启动时在该目录下查找Calibre书库，默认位置没有数据库时使用第一个
+	CoversPath        string   // 封面目录，按书籍相对路径优先查找封面，为空时只在书籍目录查找
 	ConnectionTimeout time.Duration
+	DBWatchInterval   time.Duration // 检查数据库文件是否被替换的间隔，0表示不检查
 
 	// 服务器配置
 	Host        string
 	Port        string
 	Environment string
+	BasePath    string // 挂载路径前缀，如 /library，为空表示挂载在根路径
+	// TrustedProxies 受信任的代理地址（IP或CIDR），只有来自这些地址的请求头才会被采信
+	TrustedProxies []string
+	// TrustProxyHeaders 按X-Forwarded-Proto/Host/Prefix生成链接；配置了TrustedProxies时只采信来自受信任代理的请求
+	TrustProxyHeaders bool
+	// CanonicalBaseURL 设置后所有生成的链接都使用该基础URL（含路径前缀），忽略请求的Host
+	CanonicalBaseURL string
+
+	// TLS配置，证书和私钥都设置时启用HTTPS
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSMinVersion    string // 最低TLS版本：1.0、1.1、1.2或1.3
+	TLSModernCiphers bool   // 只允许支持前向保密的AEAD密码套件（仅影响TLS 1.2及以下）
 
 	// 日志配置
 	LogLevel     string
-	LogFile      string
+	LogFile      string // 日志文件路径，为空时只输出到控制台（旧版本默认为calibre_opds.log）
 	LogToConsole bool
+	LogFormat    string // 访问日志格式：text或json
+	// SlowRequestThreshold 大于0时只记录耗时超过该阈值的请求
+	SlowRequestThreshold time.Duration
+
+	// ProgressDBPath 阅读进度数据库路径（可写），为空时不记录阅读进度
+	ProgressDBPath string
+	// DownloadAuditLog 下载审计日志文件路径（JSON Lines），为空时不记录
+	DownloadAuditLog string
+	// DownloadAuditMaxSize 审计日志超过该字节数时轮转，0表示不轮转
+	DownloadAuditMaxSize int64
+
+	// MetricsEnabled 在/metrics输出Prometheus指标
+	MetricsEnabled bool
+
+	// 诊断配置
+	AdminToken   string // 管理接口（如/api/errors）的访问令牌，为空时管理接口不可用
+	ErrorLogSize int    // 内存中保留的最近错误条数
+
+	// 写接口限制
+	MaxRequestBodySize  int64         // 请求体最大字节数，超出返回413
+	WriteRequestTimeout time.Duration // 读取请求体的超时时间
+
+	// HTTP服务器超时
+	ReadHeaderTimeout time.Duration // 读取请求头的超时时间，防止慢速请求头占用连接
+	ReadTimeout       time.Duration // 读取整个请求（含请求体）的超时时间，0表示不限制
+
+	// OPDS配置
+	ExtraAcquisitionRels []string // 下载链接额外输出的rel，用于兼容个别阅读器
+	OPFFallback          bool     // 数据库缺少简介/ISBN时从书籍目录的metadata.opf补充
+	PreferredFormats     []string // 首选下载格式，按优先级排列
+	EntryContent         bool     // 书籍条目输出XHTML内容块（封面、简介、下载链接）
+	CatalogAcquisition   bool     // 下载链接使用/opds/book/:id/acquire/:format，适合只允许访问目录路径的客户端
+	EntryMaxAuthors      int      // 列表feed中每个条目最多输出的作者数，超出部分以et al.代替，0表示不限制
+	FTSSearch            bool     // 在内存中建立全文索引，搜索按相关度排序（需以sqlite_fts5标签编译）
+	// AuthorAliases 作者别名，键为规范名，值为同一作者的其他写法
+	AuthorAliases map[string][]string
+	// AuthorCollapseThreshold 作者书籍数超过该值时按系列分组展示，0表示不分组
+	AuthorCollapseThreshold int
+	// NewWindow 添加时间在该时间窗口内的书籍标记为新书，0表示不标记
+	NewWindow time.Duration
+	// ClientProfiles 根据User-Agent识别常见阅读器并调整输出（内容块、下载链接rel）
+	ClientProfiles bool
+	// ClientProfileAgents 额外的User-Agent片段，键为内置配置名（koreader、thorium、calibre-companion、moonreader、default），优先于内置识别
+	ClientProfileAgents map[string][]string
+	// EmptyLibraryHint 书库为空时根目录显示如何添加书籍的提示，列表feed使用“还没有书籍”标题
+	EmptyLibraryHint bool
+	// CrawlablePageSize 可爬取feed每块的书籍数，0表示输出单个完整文档
+	CrawlablePageSize int
+	// DefaultFeedFormat 请求没有Accept头或Atom与OPDS-JSON同样可接受（如*/*）时输出的feed格式：atom或json
+	DefaultFeedFormat string
+	// Language 下载链接标题等文字使用的语言：zh或en
+	Language string
+	// CoverAspect 封面填充的目标宽高比（宽/高），0表示按原图输出
+	CoverAspect float64
+	// CoverBackground 封面填充使用的背景色
+	CoverBackground color.RGBA
+	// ThumbnailCacheDir 缩放和填充后封面的磁盘缓存目录
+	ThumbnailCacheDir string
+	// ThumbnailCacheMaxSize 磁盘缓存的最大字节数，超出时淘汰最久未使用的文件，0表示不限制
+	ThumbnailCacheMaxSize int64
+
+	// 缓存配置，各类响应的Cache-Control max-age
+	FeedCacheMaxAge     time.Duration // OPDS feed
+	CoverCacheMaxAge    time.Duration // 带版本号的封面，按immutable缓存
+	DownloadCacheMaxAge time.Duration // 书籍下载，过期后需重新验证
+
+	// 下载配置
+	SeriesZipMaxSize    int64 // 系列打包下载的最大总字节数
+	DownloadCompression bool  // 对TXT、HTML、FB2等文本类格式的下载启用gzip压缩
 }
 
-// Load 加载配置
+// Load 加载配置，优先级从高到低为：环境变量、配置文件、默认值。
+// 配置文件路径取自OPDS_CONFIG，未设置时使用可执行文件所在目录的config.yaml（不存在则忽略）；
+// 文件中的键与环境变量同名。数据库路径都未指定时仍按findDatabasePath查找
 func Load() *Config {
+	configFile, configFileErr := readConfigFile()
+
 	cfg := &Config{
+		ConfigFile:        configFile,
+		configFileErr:     configFileErr,
 		DBPath:            findDatabasePath(),
 		BooksPath:         getEnv("CALIBRE_BOOKS_PATH", "books"),
+		BooksRoots:        getListEnv("CALIBRE_BOOKS_ROOTS", nil),
+		LibraryRoot:       getEnv("LIBRARY_ROOT", ""),
+		CoversPath:        getEnv("COVERS_PATH", ""),
 		ConnectionTimeout: getDurationEnv("DB_CONNECTION_TIMEOUT", 30*time.Second),
+		DBWatchInterval:   getDurationEnv("DB_WATCH_INTERVAL", 10*time.Second),
 		Host:              getEnv("OPDS_HOST", "0.0.0.0"),
 		Port:              getEnv("OPDS_PORT", "1580"),
 		Environment:       getEnv("ENVIRONMENT", "development"),
+		BasePath:          normalizeBasePath(getEnv("OPDS_BASE_PATH", "")),
+		TrustedProxies:    getListEnv("OPDS_TRUSTED_PROXIES", nil),
+		TrustProxyHeaders: getBoolEnv("TRUST_PROXY_HEADERS", false),
+		CanonicalBaseURL:  strings.TrimRight(getEnv("CANONICAL_BASE_URL", ""), "/"),
+		TLSCertFile:       getEnv("TLS_CERT_FILE", getEnv("OPDS_TLS_CERT", "")),
+		TLSKeyFile:        getEnv("TLS_KEY_FILE", getEnv("OPDS_TLS_KEY", "")),
+		TLSMinVersion:     getEnv("TLS_MIN_VERSION", "1.2"),
+		TLSModernCiphers:  getBoolEnv("TLS_MODERN_CIPHERS", false),
 		LogLevel:          getEnv("LOG_LEVEL", "INFO"),
-		LogFile:           getEnv("LOG_FILE", "calibre_opds.log"),
+		LogFile:           getEnv("LOG_FILE", ""),
 		LogToConsole:      getBoolEnv("LOG_TO_CONSOLE", true),
+		LogFormat:         strings.ToLower(getEnv("LOG_FORMAT", defaultLogFormat(getEnv("ENVIRONMENT", "development")))),
+
+		SlowRequestThreshold: time.Duration(getIntEnv("SLOW_REQUEST_MS", 0)) * time.Millisecond,
+
+		ProgressDBPath: getEnv("PROGRESS_DB_PATH", ""),
+
+		DownloadAuditLog:     getEnv("DOWNLOAD_AUDIT_LOG", ""),
+		DownloadAuditMaxSize: int64(getIntEnv("DOWNLOAD_AUDIT_MAX_MB", 10)) << 20,
+
+		MetricsEnabled: getBoolEnv("OPDS_METRICS_ENABLED", false),
+
+		AdminToken:   getEnv("ADMIN_TOKEN", ""),
+		ErrorLogSize: getIntEnv("ERROR_LOG_SIZE", 100),
+
+		MaxRequestBodySize:  int64(getIntEnv("MAX_REQUEST_BODY_KB", 64)) << 10,
+		WriteRequestTimeout: getDurationEnv("WRITE_REQUEST_TIMEOUT", 10*time.Second),
+
+		ReadHeaderTimeout: getDurationEnv("READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       getDurationEnv("READ_TIMEOUT", 0),
+
+		ExtraAcquisitionRels: getListEnv("OPDS_EXTRA_ACQUISITION_RELS", nil),
+		OPFFallback:          getBoolEnv("OPF_FALLBACK", false),
+		PreferredFormats:     getListEnv("PREFERRED_FORMATS", []string{"EPUB", "AZW3", "MOBI", "PDF"}),
+		EntryContent:         getBoolEnv("OPDS_ENTRY_CONTENT", false),
+		CatalogAcquisition:   getBoolEnv("OPDS_CATALOG_ACQUISITION", false),
+		EntryMaxAuthors:      getIntEnv("OPDS_ENTRY_MAX_AUTHORS", 0),
+		FTSSearch:            getBoolEnv("FTS_SEARCH", false),
+
+		AuthorAliases:           getAliasEnv("AUTHOR_ALIASES"),
+		AuthorCollapseThreshold: getIntEnv("AUTHOR_COLLAPSE_THRESHOLD", 100),
+		NewWindow:               getDurationEnv("NEW_WINDOW", 0),
+		ClientProfiles:          getBoolEnv("CLIENT_PROFILES", false),
+		ClientProfileAgents:     getAliasEnv("CLIENT_PROFILE_AGENTS"),
+		EmptyLibraryHint:        getBoolEnv("EMPTY_LIBRARY_HINT", true),
+		CrawlablePageSize:       getIntEnv("CRAWLABLE_PAGE_SIZE", 0),
+		DefaultFeedFormat:       strings.ToLower(getEnv("OPDS_DEFAULT_FORMAT", "atom")),
+		Language:                strings.ToLower(getEnv("OPDS_LANGUAGE", "zh")),
+		CoverAspect:             getAspectEnv("COVER_ASPECT", 0),
+		CoverBackground:         getColorEnv("COVER_BACKGROUND", color.RGBA{R: 255, G: 255, B: 255, A: 255}),
+		ThumbnailCacheDir:       getEnv("THUMBNAIL_CACHE_DIR", filepath.Join(os.TempDir(), "calibre-opds-thumbnails")),
+		ThumbnailCacheMaxSize:   int64(getIntEnv("THUMBNAIL_CACHE_MAX_MB", 256)) << 20,
+
+		FeedCacheMaxAge:     getDurationEnv("CACHE_FEED_MAX_AGE", time.Minute),
+		CoverCacheMaxAge:    getDurationEnv("CACHE_COVER_MAX_AGE", 365*24*time.Hour),
+		DownloadCacheMaxAge: getDurationEnv("CACHE_DOWNLOAD_MAX_AGE", 0),
+
+		SeriesZipMaxSize:    int64(getIntEnv("SERIES_ZIP_MAX_MB", 1024)) << 20,
+		DownloadCompression: getBoolEnv("DOWNLOAD_COMPRESSION", true),
 	}
 
 	return cfg
 }
 
+// Validate 校验配置项的取值
+func (c *Config) Validate() error {
+	if c.configFileErr != nil {
+		return fmt.Errorf("config file: %w", c.configFileErr)
+	}
+	if c.CanonicalBaseURL != "" {
+		u, err := url.Parse(c.CanonicalBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("CANONICAL_BASE_URL must be an absolute http(s) URL, got %q", c.CanonicalBaseURL)
+		}
+		if u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("CANONICAL_BASE_URL must not contain a query or fragment, got %q", c.CanonicalBaseURL)
+		}
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("LOG_FORMAT must be text or json, got %q", c.LogFormat)
+	}
+	if c.DefaultFeedFormat != "atom" && c.DefaultFeedFormat != "json" {
+		return fmt.Errorf("OPDS_DEFAULT_FORMAT must be atom or json, got %q", c.DefaultFeedFormat)
+	}
+	if c.Language != "zh" && c.Language != "en" {
+		return fmt.Errorf("OPDS_LANGUAGE must be zh or en, got %q", c.Language)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if _, err := c.TLSConfig(); err != nil {
+		return err
+	}
+	if c.CoversPath != "" {
+		dir, err := os.Open(c.CoversPath)
+		if err != nil {
+			return fmt.Errorf("COVERS_PATH is not readable: %w", err)
+		}
+		info, err := dir.Stat()
+		dir.Close()
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("COVERS_PATH must be a readable directory, got %q", c.CoversPath)
+		}
+	}
+	return nil
+}
+
+// tlsVersions 支持配置的TLS版本
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// modernCipherSuites 支持前向保密的AEAD密码套件
+var modernCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// TLSEnabled 是否启用HTTPS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// TLSConfig 根据配置生成HTTPS服务使用的tls.Config
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	version, ok := tlsVersions[c.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("TLS_MIN_VERSION must be one of 1.0, 1.1, 1.2, 1.3, got %q", c.TLSMinVersion)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: version}
+	if c.TLSModernCiphers {
+		tlsConfig.CipherSuites = modernCipherSuites
+	}
+	return tlsConfig, nil
+}
+
 // findDatabasePath 智能查找数据库文件
 func findDatabasePath() string {
 	candidates := []string{
@@ -64,17 +306,44 @@ func findDatabasePath() string {
 	return candidates[0]
 }
 
-// getEnv 获取环境变量，如果不存在则返回默认值
+// defaultLogFormat 生产环境默认输出JSON访问日志，其他环境输出文本
+func defaultLogFormat(environment string) string {
+	if environment == "production" {
+		return "json"
+	}
+	return "text"
+}
+
+// normalizeBasePath 规范化路径前缀：以/开头、不以/结尾，根路径返回空字符串
+func normalizeBasePath(path string) string {
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// getEnv 获取环境变量（或配置文件中的同名项），如果不存在则返回默认值
 func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value := lookupEnv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
+// getIntEnv 获取整数类型环境变量
+func getIntEnv(key string, defaultValue int) int {
+	if value := lookupEnv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
 // getBoolEnv 获取布尔类型环境变量
 func getBoolEnv(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
+	if value := lookupEnv(key); value != "" {
 		if b, err := strconv.ParseBool(value); err == nil {
 			return b
 		}
@@ -82,9 +351,48 @@ func getBoolEnv(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// getListEnv 获取逗号分隔的列表类型环境变量
+func getListEnv(key string, defaultValue []string) []string {
+	value := lookupEnv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getAliasEnv 获取别名映射类型环境变量，格式为 规范名=别名1|别名2;规范名2=别名3
+func getAliasEnv(key string) map[string][]string {
+	value := lookupEnv(key)
+	if value == "" {
+		return nil
+	}
+
+	aliases := make(map[string][]string)
+	for _, group := range strings.Split(value, ";") {
+		name, variants, ok := strings.Cut(group, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			continue
+		}
+		for _, variant := range strings.Split(variants, "|") {
+			if variant = strings.TrimSpace(variant); variant != "" {
+				aliases[name] = append(aliases[name], variant)
+			}
+		}
+	}
+	return aliases
+}
+
 // getDurationEnv 获取时间间隔类型环境变量
 func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
+	if value := lookupEnv(key); value != "" {
 		if d, err := time.ParseDuration(value); err == nil {
 			return d
 		}
@@ -96,6 +404,42 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	return defaultValue
 }
 
+// getAspectEnv 获取宽高比类型环境变量，格式为"宽:高"（如2:3）或小数
+func getAspectEnv(key string, defaultValue float64) float64 {
+	value := lookupEnv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	if w, h, ok := strings.Cut(value, ":"); ok {
+		width, errW := strconv.ParseFloat(strings.TrimSpace(w), 64)
+		height, errH := strconv.ParseFloat(strings.TrimSpace(h), 64)
+		if errW == nil && errH == nil && width > 0 && height > 0 {
+			return width / height
+		}
+		return defaultValue
+	}
+
+	if ratio, err := strconv.ParseFloat(value, 64); err == nil && ratio > 0 {
+		return ratio
+	}
+	return defaultValue
+}
+
+// getColorEnv 获取颜色类型环境变量，格式为#RRGGBB
+func getColorEnv(key string, defaultValue color.RGBA) color.RGBA {
+	value := strings.TrimPrefix(lookupEnv(key), "#")
+	if len(value) != 6 {
+		return defaultValue
+	}
+
+	rgb, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return defaultValue
+	}
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 255}
+}
+
 // GetBooksFullPath 获取书籍完整路径
 func (c *Config) GetBooksFullPath() string {
 	if filepath.IsAbs(c.BooksPath) {
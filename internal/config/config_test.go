@@ -0,0 +1,142 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// selfSignedCert 生成localhost的自签名证书
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake 以客户端允许的最高版本maxVersion连接TLS服务并完成握手
+func handshake(addr string, maxVersion uint16) error {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+		MaxVersion:         maxVersion,
+	})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// startTLSServer 以cfg生成的tls.Config启动只做握手的TLS服务，返回监听地址
+func startTLSServer(t *testing.T, cfg *Config) string {
+	t.Helper()
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{selfSignedCert(t)}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestTLSMinVersionRejectsOldClients(t *testing.T) {
+	addr := startTLSServer(t, &Config{TLSMinVersion: "1.2", TLSModernCiphers: true})
+	if err := handshake(addr, tls.VersionTLS10); err == nil {
+		t.Error("TLS 1.0 handshake succeeded, want it rejected")
+	}
+	if err := handshake(addr, tls.VersionTLS12); err != nil {
+		t.Errorf("TLS 1.2 handshake failed: %v", err)
+	}
+
+	addr = startTLSServer(t, &Config{TLSMinVersion: "1.3"})
+	if err := handshake(addr, tls.VersionTLS12); err == nil {
+		t.Error("TLS 1.2 handshake succeeded with a 1.3 minimum, want it rejected")
+	}
+	if err := handshake(addr, tls.VersionTLS13); err != nil {
+		t.Errorf("TLS 1.3 handshake failed: %v", err)
+	}
+}
+
+func TestTLSConfigRejectsUnknownVersion(t *testing.T) {
+	cfg := &Config{TLSMinVersion: "1.4"}
+	if _, err := cfg.TLSConfig(); err == nil {
+		t.Error("expected an error for TLS_MIN_VERSION=1.4")
+	}
+}
+
+func TestCanonicalBaseURLValidation(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"https://opds.example.net/books/", "https://opds.example.net/books", false},
+		{"http://localhost:8080", "http://localhost:8080", false},
+		{"opds.example.net", "", true},
+		{"ftp://opds.example.net", "", true},
+		{"https://opds.example.net/?lang=en", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("CANONICAL_BASE_URL", tt.value)
+			cfg := Load()
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && cfg.CanonicalBaseURL != tt.want {
+				t.Errorf("CanonicalBaseURL = %q, want %q", cfg.CanonicalBaseURL, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorAliasesEnv(t *testing.T) {
+	t.Setenv("AUTHOR_ALIASES", " J.R.R. Tolkien = J. R. R. Tolkien | Tolkien ;=Nobody; Frank Herbert=;Ursula K. Le Guin=Ursula Le Guin")
+	got := getAliasEnv("AUTHOR_ALIASES")
+	want := map[string][]string{
+		"J.R.R. Tolkien":    {"J. R. R. Tolkien", "Tolkien"},
+		"Ursula K. Le Guin": {"Ursula Le Guin"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aliases = %v, want %v", got, want)
+	}
+}
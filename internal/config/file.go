@@ -0,0 +1,125 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// defaultConfigFile 未设置OPDS_CONFIG时在可执行文件所在目录查找的配置文件
+const defaultConfigFile = "config.yaml"
+
+// fileValues 配置文件中的取值，键为环境变量名（大写）
+var fileValues map[string]string
+
+// lookupEnv 按优先级获取配置项：环境变量 > 配置文件
+func lookupEnv(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fileValues[key]
+}
+
+// configFilePath 返回配置文件路径；explicit表示路径来自OPDS_CONFIG，此时文件必须存在
+func configFilePath() (path string, explicit bool) {
+	if path := os.Getenv("OPDS_CONFIG"); path != "" {
+		return path, true
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(filepath.Dir(exe), defaultConfigFile), false
+}
+
+// loadConfigFile 读取配置文件，扩展名为.toml时按TOML解析，否则按YAML解析；
+// 键与环境变量同名（不区分大小写），列表值按逗号拼接，映射值按AUTHOR_ALIASES的格式拼接
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]interface{})
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, &raw)
+	} else {
+		err = yaml.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		s, err := configValueString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+		values[strings.ToUpper(key)] = s
+	}
+	return values, nil
+}
+
+// configValueString 把配置文件中的值转换成环境变量形式的字符串
+func configValueString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configValueString(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		groups := make([]string, 0, len(names))
+		for _, name := range names {
+			s, err := configValueString(v[name])
+			if err != nil {
+				return "", err
+			}
+			groups = append(groups, name+"="+strings.ReplaceAll(s, ",", "|"))
+		}
+		return strings.Join(groups, ";"), nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("unsupported value type %T", value)
+}
+
+// readConfigFile 加载配置文件到fileValues，未显式指定且默认文件不存在时忽略
+func readConfigFile() (string, error) {
+	path, explicit := configFilePath()
+	if path == "" {
+		return "", nil
+	}
+	values, err := loadConfigFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return path, err
+	}
+	fileValues = values
+	return path, nil
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile 在临时目录写入配置文件并通过OPDS_CONFIG指定
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OPDS_CONFIG", path)
+	t.Cleanup(func() { fileValues = nil })
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"config.yaml", `
+opds_port: 8080
+log_level: WARNING
+tls_modern_ciphers: true
+db_connection_timeout: 45
+opds_trusted_proxies:
+  - 10.0.0.1
+  - 192.168.0.0/16
+author_aliases:
+  J.R.R. Tolkien: [J. R. R. Tolkien, Tolkien]
+`},
+		{"config.toml", `
+OPDS_PORT = 8080
+LOG_LEVEL = "WARNING"
+TLS_MODERN_CIPHERS = true
+DB_CONNECTION_TIMEOUT = "45s"
+OPDS_TRUSTED_PROXIES = ["10.0.0.1", "192.168.0.0/16"]
+
+[AUTHOR_ALIASES]
+"J.R.R. Tolkien" = ["J. R. R. Tolkien", "Tolkien"]
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.name, tt.content)
+			// 环境变量优先于配置文件
+			t.Setenv("LOG_LEVEL", "ERROR")
+
+			cfg := Load()
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if cfg.ConfigFile != path {
+				t.Errorf("ConfigFile = %q, want %q", cfg.ConfigFile, path)
+			}
+			if cfg.Port != "8080" || !cfg.TLSModernCiphers || cfg.ConnectionTimeout != 45*time.Second {
+				t.Errorf("port %q, modern ciphers %v, timeout %v", cfg.Port, cfg.TLSModernCiphers, cfg.ConnectionTimeout)
+			}
+			if cfg.LogLevel != "ERROR" {
+				t.Errorf("LogLevel = %q, want the environment value", cfg.LogLevel)
+			}
+			// 未配置LOG_FILE时只输出到控制台
+			if cfg.LogFile != "" {
+				t.Errorf("LogFile = %q, want the console-only default", cfg.LogFile)
+			}
+			if want := []string{"10.0.0.1", "192.168.0.0/16"}; !reflect.DeepEqual(cfg.TrustedProxies, want) {
+				t.Errorf("TrustedProxies = %v, want %v", cfg.TrustedProxies, want)
+			}
+			if want := map[string][]string{"J.R.R. Tolkien": {"J. R. R. Tolkien", "Tolkien"}}; !reflect.DeepEqual(cfg.AuthorAliases, want) {
+				t.Errorf("AuthorAliases = %v, want %v", cfg.AuthorAliases, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"invalid yaml", "config.yaml", "opds_port: [8080", "parse"},
+		{"invalid toml", "config.toml", "OPDS_PORT = ", "parse"},
+		// TOML的日期类型没有对应的环境变量形式
+		{"unsupported value", "config.toml", "STARTED = 1979-05-27", "unsupported value type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfigFile(t, tt.file, tt.content)
+			err := Load().Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	// OPDS_CONFIG指定的文件必须存在
+	t.Setenv("OPDS_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	if err := Load().Validate(); err == nil {
+		t.Error("missing OPDS_CONFIG file: Validate succeeded")
+	}
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"sort"
+	"strings"
+)
+
+// SetAuthorAliases 设置作者别名，键为规范名，值为同一作者的其他写法；应在开始处理请求前调用
+func (db *DB) SetAuthorAliases(aliases map[string][]string) {
+	canonical := make(map[string]string)
+	for name, variants := range aliases {
+		for _, variant := range variants {
+			if variant != name {
+				canonical[variant] = name
+			}
+		}
+	}
+	db.authorAliases = canonical
+}
+
+// canonicalAuthor 返回作者的规范名，没有别名时返回原名
+func (db *DB) canonicalAuthor(name string) string {
+	if canonical, ok := db.authorAliases[name]; ok {
+		return canonical
+	}
+	return name
+}
+
+// authorVariants 返回与该作者属于同一规范名的所有写法（包括规范名本身）
+func (db *DB) authorVariants(name string) []string {
+	canonical := db.canonicalAuthor(name)
+	names := []string{canonical}
+	for variant, target := range db.authorAliases {
+		if target == canonical {
+			names = append(names, variant)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// canonicalAuthorExpr 将a.name映射为规范名的SQL表达式及其参数
+func (db *DB) canonicalAuthorExpr() (string, []interface{}) {
+	if len(db.authorAliases) == 0 {
+		return "a.name", nil
+	}
+
+	variants := make([]string, 0, len(db.authorAliases))
+	for variant := range db.authorAliases {
+		variants = append(variants, variant)
+	}
+	sort.Strings(variants)
+
+	var expr strings.Builder
+	var args []interface{}
+	expr.WriteString("CASE a.name")
+	for _, variant := range variants {
+		expr.WriteString(" WHEN ? THEN ?")
+		args = append(args, variant, db.authorAliases[variant])
+	}
+	expr.WriteString(" ELSE a.name END")
+	return expr.String(), args
+}
+
+// expandAuthorFilter 将过滤条件中的作者展开为包括所有别名的写法
+func (db *DB) expandAuthorFilter(filter BookFilter) BookFilter {
+	if len(db.authorAliases) == 0 || len(filter.Authors) == 0 {
+		return filter
+	}
+
+	var authors []string
+	for _, author := range filter.Authors {
+		authors = append(authors, db.authorVariants(author)...)
+	}
+	filter.Authors = authors
+	return filter
+}
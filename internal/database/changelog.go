@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// changeAddTolerance 添加时间与修改时间相差不超过该值时视为新增；
+// Calibre添加书籍后会立即写入封面和元数据，修改时间通常比添加时间晚几秒
+const changeAddTolerance = 5 * time.Minute
+
+// 变更类型
+const (
+	ChangeAdded    = "added"
+	ChangeModified = "modified"
+)
+
+// GetChangelogContext 获取最近变更的书籍，按添加时间和修改时间中较新的一个倒序排列
+func (db *DB) GetChangelogContext(ctx context.Context, limit, offset int) ([]ChangelogEntry, error) {
+	ctx, cancel := db.withTimeout(ctx, "changelog")
+	defer cancel()
+
+	query := `
+		SELECT ` + bookSelectColumns + `
+		FROM ` + db.booksTable() + ` b
+		ORDER BY MAX(b.timestamp, b.last_modified) DESC, b.id DESC
+		LIMIT ? OFFSET ?
+	`
+
+	books, err := db.executeBookQuery(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ChangelogEntry, 0, len(books))
+	for _, book := range books {
+		entries = append(entries, newChangelogEntry(book))
+	}
+	return entries, nil
+}
+
+// GetRecentlyAddedContext 获取最近添加的书籍，按添加时间（books.timestamp）倒序排列，不受元数据修改影响
+func (db *DB) GetRecentlyAddedContext(ctx context.Context, limit, offset int) ([]Book, error) {
+	return db.GetBooksFilteredContext(ctx, limit, offset, BookFilter{Sort: "added", Order: "desc"})
+}
+
+// GetRecentlyAdded 不带ctx的GetRecentlyAddedContext
+func (db *DB) GetRecentlyAdded(limit, offset int) ([]Book, error) {
+	return db.GetRecentlyAddedContext(context.Background(), limit, offset)
+}
+
+// newChangelogEntry 根据添加时间和修改时间判断变更类型
+func newChangelogEntry(book Book) ChangelogEntry {
+	entry := ChangelogEntry{Book: book, Change: ChangeAdded, ChangedAt: book.Timestamp}
+	if book.LastModified.Sub(book.Timestamp) > changeAddTolerance {
+		entry.Change = ChangeModified
+		entry.ChangedAt = book.LastModified
+	}
+	return entry
+}
@@ -1,19 +1,40 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/ricci/calibre-opds-go/pkg/logger"
 )
 
 // DB 数据库连接
 type DB struct {
-	conn *sql.DB
+	// pool 当前的连接池，数据库文件被替换后由Watch切换为新的连接池
+	pool atomic.Pointer[sql.DB]
 	path string
+	// schema 当前数据库的结构，与连接池一起在数据库文件被替换后更新
+	schema atomic.Pointer[schema]
+
+	// notes Calibre笔记数据库连接，书库中没有笔记数据库时为nil
+	notes *sql.DB
+
+	// authorAliases 作者别名到规范名的映射
+	authorAliases map[string]string
+
+	// queryTimeout 单次查询的超时时间，0表示只在请求取消时中止
+	queryTimeout time.Duration
+	// observeQuery 查询耗时的回调，为nil时不统计
+	observeQuery func(op string, d time.Duration)
+
+	// fts 可选的内存全文索引
+	fts ftsState
 }
 
 // NewDB 创建新的数据库连接
@@ -23,7 +44,26 @@ func NewDB(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("database file not found: %s", dbPath)
 	}
 
-	// 打开数据库连接
+	conn, err := openPool(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DB{path: dbPath}
+	db.pool.Store(conn)
+	// 结构不受支持时仍然返回连接，由Validate报告具体问题
+	if s, err := loadSchema(conn); err == nil {
+		db.schema.Store(s)
+	} else {
+		logger.Error.Printf("Failed to detect database schema: %v", err)
+	}
+	db.notes = openNotesDB(dbPath)
+
+	return db, nil
+}
+
+// openPool 以只读方式打开数据库连接池
+func openPool(dbPath string) (*sql.DB, error) {
 	conn, err := sql.Open("sqlite3", dbPath+"?mode=ro")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -40,30 +80,92 @@ func NewDB(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	db := &DB{
-		conn: conn,
-		path: dbPath,
+	return conn, nil
+}
+
+// conn 返回当前的连接池
+func (db *DB) conn() *sql.DB {
+	return db.pool.Load()
+}
+
+// SetQueryTimeout 设置查询超时时间，0表示不限制
+func (db *DB) SetQueryTimeout(timeout time.Duration) {
+	db.queryTimeout = timeout
+}
+
+// SetQueryObserver 设置查询耗时的回调，op为查询名称（如books_filtered），为nil时不统计
+func (db *DB) SetQueryObserver(observe func(op string, d time.Duration)) {
+	db.observeQuery = observe
+}
+
+// withTimeout 为查询附加超时，返回的cancel同时记录查询耗时；单本书籍的关联数据查询沿用调用方的ctx，不单独设置超时
+func (db *DB) withTimeout(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+	if db.queryTimeout <= 0 {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, db.queryTimeout)
+	}
+	if db.observeQuery == nil {
+		return ctx, cancel
 	}
 
-	return db, nil
+	start := time.Now()
+	return ctx, func() {
+		cancel()
+		db.observeQuery(op, time.Since(start))
+	}
+}
+
+// PoolStats 返回当前连接池的统计信息，连接已关闭时返回零值
+func (db *DB) PoolStats() sql.DBStats {
+	if conn := db.conn(); conn != nil {
+		return conn.Stats()
+	}
+	return sql.DBStats{}
 }
 
 // Close 关闭数据库连接
 func (db *DB) Close() error {
-	if db.conn != nil {
-		return db.conn.Close()
+	if db.notes != nil {
+		db.notes.Close()
+	}
+	if index := db.fts.index.Swap(nil); index != nil {
+		index.conn.Close()
+	}
+	// 保留已关闭的连接池，之后的查询返回"sql: database is closed"而不是空指针
+	if conn := db.pool.Load(); conn != nil {
+		return conn.Close()
 	}
 	return nil
 }
 
+// requiredTables Calibre数据库必须包含的表
+var requiredTables = []string{"books", "authors", "tags", "series", "data"}
+
 // Validate 验证数据库结构
 func (db *DB) Validate() error {
-	// 检查必要的表是否存在
-	tables := []string{"books", "authors", "tags", "series", "data"}
-	for _, table := range tables {
+	if err := checkRequiredTables(db.conn()); err != nil {
+		return err
+	}
+	if db.schema.Load() == nil {
+		s, err := loadSchema(db.conn())
+		if err != nil {
+			return err
+		}
+		db.schema.Store(s)
+	}
+
+	logger.Info.Printf("Database validation successful")
+	return nil
+}
+
+// checkRequiredTables 检查必要的表是否存在
+func checkRequiredTables(conn *sql.DB) error {
+	for _, table := range requiredTables {
 		var name string
 		query := "SELECT name FROM sqlite_master WHERE type='table' AND name=?"
-		err := db.conn.QueryRow(query, table).Scan(&name)
+		err := conn.QueryRow(query, table).Scan(&name)
 		if err == sql.ErrNoRows {
 			return fmt.Errorf("required table '%s' not found", table)
 		}
@@ -71,178 +173,287 @@ func (db *DB) Validate() error {
 			return fmt.Errorf("failed to check table '%s': %w", table, err)
 		}
 	}
-
-	log.Printf("Database validation successful")
 	return nil
 }
 
-// GetBooksCount 获取书籍总数
-func (db *DB) GetBooksCount(search string) (int, error) {
+// SchemaVersion 获取数据库的schema版本（PRAGMA user_version）
+func (db *DB) SchemaVersion() (int, error) {
+	var version int
+	err := db.conn().QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// GetBooksCountContext 获取书籍总数
+func (db *DB) GetBooksCountContext(ctx context.Context, search string) (int, error) {
+	ctx, cancel := db.withTimeout(ctx, "books_count")
+	defer cancel()
+
 	var count int
 	var query string
 	var args []interface{}
 
 	if search != "" {
-		query = `SELECT COUNT(DISTINCT b.id) FROM books b 
-		         WHERE b.title LIKE ? OR b.author_sort LIKE ?`
-		searchTerm := "%" + search + "%"
-		args = []interface{}{searchTerm, searchTerm}
+		var condition string
+		condition, args = searchCondition(search)
+		query = "SELECT COUNT(DISTINCT b.id) FROM " + db.booksTable() + " b WHERE " + condition
 	} else {
-		query = "SELECT COUNT(*) FROM books"
+		query = "SELECT COUNT(*) FROM " + db.booksTable() + " b"
 	}
 
-	err := db.conn.QueryRow(query, args...).Scan(&count)
+	err := db.conn().QueryRowContext(ctx, query, args...).Scan(&count)
 	return count, err
 }
 
-// GetBooksCountFiltered 获取过滤后的书籍总数
-func (db *DB) GetBooksCountFiltered(search, author, series, tag string) (int, error) {
-	query := "SELECT COUNT(DISTINCT b.id) FROM books b"
-	var conditions []string
-	var args []interface{}
+// GetBooksCountFilteredContext 获取过滤后的书籍总数
+func (db *DB) GetBooksCountFilteredContext(ctx context.Context, filter BookFilter) (int, error) {
+	ctx, cancel := db.withTimeout(ctx, "books_count_filtered")
+	defer cancel()
 
-	if search != "" {
-		conditions = append(conditions, "(b.title LIKE ? OR b.author_sort LIKE ?)")
-		searchTerm := "%" + search + "%"
-		args = append(args, searchTerm, searchTerm)
-	}
-
-	if author != "" {
-		conditions = append(conditions, "EXISTS (SELECT 1 FROM books_authors_link bal JOIN authors a ON bal.author = a.id WHERE bal.book = b.id AND a.name = ?)")
-		args = append(args, author)
-	}
-
-	if series != "" {
-		conditions = append(conditions, "EXISTS (SELECT 1 FROM books_series_link bsl JOIN series s ON bsl.series = s.id WHERE bsl.book = b.id AND s.name = ?)")
-		args = append(args, series)
-	}
-
-	if tag != "" {
-		conditions = append(conditions, "EXISTS (SELECT 1 FROM books_tags_link btl JOIN tags t ON btl.tag = t.id WHERE btl.book = b.id AND t.name = ?)")
-		args = append(args, tag)
-	}
-
-	if len(conditions) > 0 {
-		query += " WHERE " + joinConditions(conditions, " AND ")
-	}
+	filter = db.expandAuthorFilter(filter)
+	where, args := filter.whereClause()
+	query := "SELECT COUNT(DISTINCT b.id) FROM " + db.booksTable() + " b" + where
 
 	var count int
-	err := db.conn.QueryRow(query, args...).Scan(&count)
+	err := db.conn().QueryRowContext(ctx, query, args...).Scan(&count)
 	return count, err
 }
 
-// GetBooks 获取书籍列表
-func (db *DB) GetBooks(limit, offset int, search string) ([]Book, error) {
+// GetBooksContext 获取书籍列表
+func (db *DB) GetBooksContext(ctx context.Context, limit, offset int, search string) ([]Book, error) {
+	ctx, cancel := db.withTimeout(ctx, "books")
+	defer cancel()
+
 	query := `
-		SELECT DISTINCT b.id, b.title, b.author_sort, b.path,
-		       b.series_index, b.isbn, b.pubdate, b.last_modified,
-		       b.has_cover, b.uuid
-		FROM books b
+		SELECT DISTINCT ` + bookSelectColumns + `
+		FROM ` + db.booksTable() + ` b
 	`
-	
+
 	var args []interface{}
-	
+
 	if search != "" {
-		query += " WHERE (b.title LIKE ? OR b.author_sort LIKE ?)"
-		searchTerm := "%" + search + "%"
-		args = append(args, searchTerm, searchTerm)
+		condition, searchArgs := searchCondition(search)
+		query += " WHERE " + condition
+		args = append(args, searchArgs...)
 	}
-	
+
 	query += " ORDER BY b.last_modified DESC LIMIT ? OFFSET ?"
 	args = append(args, limit, offset)
 
-	return db.executeBookQuery(query, args...)
+	return db.executeBookQuery(ctx, query, args...)
 }
 
-// GetBooksFiltered 获取过滤后的书籍列表
-func (db *DB) GetBooksFiltered(limit, offset int, search, author, series, tag string) ([]Book, error) {
+// GetBooksFilteredContext 获取过滤后的书籍列表
+func (db *DB) GetBooksFilteredContext(ctx context.Context, limit, offset int, filter BookFilter) ([]Book, error) {
+	ctx, cancel := db.withTimeout(ctx, "books_filtered")
+	defer cancel()
+
 	query := `
-		SELECT DISTINCT b.id, b.title, b.author_sort, b.path,
-		       b.series_index, b.isbn, b.pubdate, b.last_modified,
-		       b.has_cover, b.uuid
-		FROM books b
+		SELECT DISTINCT ` + bookSelectColumns + `
+		FROM ` + db.booksTable() + ` b
 	`
 
-	var conditions []string
-	var args []interface{}
+	filter = db.expandAuthorFilter(filter)
+	where, args := filter.whereClause()
+	query += where + filter.orderByClause() + " LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
 
-	if search != "" {
-		conditions = append(conditions, "(b.title LIKE ? OR b.author_sort LIKE ?)")
-		searchTerm := "%" + search + "%"
-		args = append(args, searchTerm, searchTerm)
-	}
+	return db.executeBookQuery(ctx, query, args...)
+}
 
-	if author != "" {
-		conditions = append(conditions, "EXISTS (SELECT 1 FROM books_authors_link bal JOIN authors a ON bal.author = a.id WHERE bal.book = b.id AND a.name = ?)")
-		args = append(args, author)
-	}
+// StreamBooksFilteredContext 逐本读取过滤后的书籍并调用fn，不在内存中保留整个列表；fn返回错误时停止读取。
+// 全量输出可能超过查询超时，因此只在ctx取消时中止
+func (db *DB) StreamBooksFilteredContext(ctx context.Context, limit, offset int, filter BookFilter, fn func(*Book) error) error {
+	query := `
+		SELECT DISTINCT ` + bookSelectColumns + `
+		FROM ` + db.booksTable() + ` b
+	`
 
-	if series != "" {
-		conditions = append(conditions, "EXISTS (SELECT 1 FROM books_series_link bsl JOIN series s ON bsl.series = s.id WHERE bsl.book = b.id AND s.name = ?)")
-		args = append(args, series)
-	}
+	filter = db.expandAuthorFilter(filter)
+	where, args := filter.whereClause()
+	query += where + filter.orderByClause() + " LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
 
-	if tag != "" {
-		conditions = append(conditions, "EXISTS (SELECT 1 FROM books_tags_link btl JOIN tags t ON btl.tag = t.id WHERE btl.book = b.id AND t.name = ?)")
-		args = append(args, tag)
-	}
+	return db.streamBookQuery(ctx, query, args, fn)
+}
 
-	if len(conditions) > 0 {
-		query += " WHERE " + joinConditions(conditions, " AND ")
-	}
+// GetRandomBooksContext 随机获取n本书籍
+func (db *DB) GetRandomBooksContext(ctx context.Context, n int) ([]Book, error) {
+	ctx, cancel := db.withTimeout(ctx, "random_books")
+	defer cancel()
 
-	query += " ORDER BY b.last_modified DESC LIMIT ? OFFSET ?"
-	args = append(args, limit, offset)
+	query := `
+		SELECT ` + bookSelectColumns + `
+		FROM ` + db.booksTable() + ` b
+		ORDER BY RANDOM() LIMIT ?
+	`
 
-	return db.executeBookQuery(query, args...)
+	return db.executeBookQuery(ctx, query, n)
+}
+
+// GetRandomBooks 不带ctx的GetRandomBooksContext
+func (db *DB) GetRandomBooks(n int) ([]Book, error) {
+	return db.GetRandomBooksContext(context.Background(), n)
 }
 
 // executeBookQuery 执行书籍查询并加载关联数据
-func (db *DB) executeBookQuery(query string, args ...interface{}) ([]Book, error) {
-	rows, err := db.conn.Query(query, args...)
+func (db *DB) executeBookQuery(ctx context.Context, query string, args ...interface{}) ([]Book, error) {
+	var books []Book
+	err := db.streamBookQuery(ctx, query, args, func(book *Book) error {
+		books = append(books, *book)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return books, nil
+}
+
+// bookSelectColumns 书籍查询的列，顺序与streamBookQuery和GetBookDetailContext中的Scan一致
+const bookSelectColumns = `b.id, b.title, b.author_sort, b.path,
+		       b.series_index, b.isbn, b.pubdate, b.last_modified,
+		       b.has_cover, COALESCE(b.uuid, ''), b.timestamp`
+
+// streamBookQuery 执行书籍查询，每读取relationBatchSize本书批量加载一次关联数据，再逐本调用fn
+func (db *DB) streamBookQuery(ctx context.Context, query string, args []interface{}, fn func(*Book) error) error {
+	rows, err := db.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
 	defer rows.Close()
 
-	var books []Book
+	batch := make([]*Book, 0, relationBatchSize)
+	flush := func() error {
+		db.loadBookRelations(ctx, batch)
+		for _, book := range batch {
+			if err := fn(book); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
 	for rows.Next() {
-		var book Book
+		book := new(Book)
 		err := rows.Scan(
 			&book.ID, &book.Title, &book.AuthorSort, &book.Path,
 			&book.SeriesIndex, &book.ISBN, &book.PubDate, &book.LastModified,
-			&book.HasCover, &book.UUID,
+			&book.HasCover, &book.UUID, &book.Timestamp,
 		)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		// 加载关联数据
-		book.Authors, _ = db.GetBookAuthors(book.ID)
-		book.Tags, _ = db.GetBookTags(book.ID)
-		book.Series, _ = db.GetBookSeries(book.ID)
-		book.Formats, _ = db.GetBookFormats(book.ID)
+		batch = append(batch, book)
+		if len(batch) == relationBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-		books = append(books, book)
+	return flush()
+}
+
+// GetSeriesBooksContext 获取系列中的所有书籍，按系列序号排序
+func (db *DB) GetSeriesBooksContext(ctx context.Context, seriesName string) ([]Book, error) {
+	ctx, cancel := db.withTimeout(ctx, "series_books")
+	defer cancel()
+
+	query := `
+		SELECT DISTINCT ` + bookSelectColumns + `
+		FROM ` + db.booksTable() + ` b
+		JOIN books_series_link bsl ON bsl.book = b.id
+		JOIN series s ON bsl.series = s.id
+		WHERE s.name = ?
+		ORDER BY b.series_index, b.id
+	`
+
+	return db.executeBookQuery(ctx, query, seriesName)
+}
+
+// GetSimilarByTagsContext 按共同标签数量从多到少获取与该书相似的其他书籍，至少有一个共同标签
+func (db *DB) GetSimilarByTagsContext(ctx context.Context, bookID, limit int) ([]Book, error) {
+	ctx, cancel := db.withTimeout(ctx, "similar_by_tags")
+	defer cancel()
+
+	query := `
+		SELECT ` + bookSelectColumns + `
+		FROM ` + db.booksTable() + ` b
+		JOIN (
+			SELECT other.book AS book, COUNT(*) AS shared
+			FROM books_tags_link own
+			JOIN books_tags_link other ON other.tag = own.tag AND other.book != own.book
+			WHERE own.book = ?
+			GROUP BY other.book
+		) st ON st.book = b.id
+		ORDER BY st.shared DESC, b.timestamp DESC, b.id
+		LIMIT ?
+	`
+
+	return db.executeBookQuery(ctx, query, bookID, limit)
+}
+
+// GetAuthorSeriesContext 获取作者作品所属的系列及各系列中该作者的书籍数量
+func (db *DB) GetAuthorSeriesContext(ctx context.Context, authorName string) ([]SeriesInfo, error) {
+	ctx, cancel := db.withTimeout(ctx, "author_series")
+	defer cancel()
+
+	names := db.authorVariants(authorName)
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(names)), ",")
+	query := `
+		SELECT s.name, s.sort, COUNT(DISTINCT b.id) as book_count
+		FROM series s
+		JOIN books_series_link bsl ON s.id = bsl.series
+		JOIN ` + db.booksTable() + ` b ON bsl.book = b.id
+		JOIN books_authors_link bal ON bal.book = b.id
+		JOIN authors a ON bal.author = a.id
+		WHERE a.name IN (` + placeholders + `)
+		GROUP BY s.id, s.name, s.sort
+		ORDER BY s.sort
+	`
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
 	}
+	rows, err := db.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	return books, rows.Err()
+	var seriesList []SeriesInfo
+	for rows.Next() {
+		var series SeriesInfo
+		if err := rows.Scan(&series.Name, &series.Sort, &series.BookCount); err != nil {
+			return nil, err
+		}
+		seriesList = append(seriesList, series)
+	}
+
+	return seriesList, rows.Err()
 }
 
-// GetBookDetail 获取书籍详情
-func (db *DB) GetBookDetail(bookID int) (*Book, error) {
+// GetBookDetailContext 获取书籍详情
+func (db *DB) GetBookDetailContext(ctx context.Context, bookID int) (*Book, error) {
+	ctx, cancel := db.withTimeout(ctx, "book_detail")
+	defer cancel()
+
 	query := `
-		SELECT b.id, b.title, b.author_sort, b.path, b.series_index,
-		       b.isbn, b.pubdate, b.last_modified, b.has_cover, b.uuid
-		FROM books b
+		SELECT ` + bookSelectColumns + `
+		FROM ` + db.booksTable() + ` b
 		WHERE b.id = ?
 	`
 
 	var book Book
-	err := db.conn.QueryRow(query, bookID).Scan(
+	err := db.conn().QueryRowContext(ctx, query, bookID).Scan(
 		&book.ID, &book.Title, &book.AuthorSort, &book.Path,
 		&book.SeriesIndex, &book.ISBN, &book.PubDate, &book.LastModified,
-		&book.HasCover, &book.UUID,
+		&book.HasCover, &book.UUID, &book.Timestamp,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -252,24 +463,63 @@ func (db *DB) GetBookDetail(bookID int) (*Book, error) {
 	}
 
 	// 获取评论
-	var comments sql.NullString
-	commentQuery := "SELECT text FROM comments WHERE book = ?"
-	db.conn.QueryRow(commentQuery, bookID).Scan(&comments)
-	if comments.Valid {
-		book.Comments = comments.String
+	book.Comments, err = db.GetBookCommentsContext(ctx, bookID)
+	if err != nil {
+		return nil, err
 	}
 
 	// 加载关联数据
-	book.Authors, _ = db.GetBookAuthors(book.ID)
-	book.Tags, _ = db.GetBookTags(book.ID)
-	book.Series, _ = db.GetBookSeries(book.ID)
-	book.Formats, _ = db.GetBookFormats(book.ID)
+	book.Rating, _ = db.GetBookRatingContext(ctx, book.ID)
+	book.Authors, _ = db.GetBookAuthorsContext(ctx, book.ID)
+	book.Tags, _ = db.GetBookTagsContext(ctx, book.ID)
+	book.Languages, _ = db.GetBookLanguagesContext(ctx, book.ID)
+	book.Series, _ = db.GetBookSeriesContext(ctx, book.ID)
+	book.Formats, _ = db.GetBookFormatsContext(ctx, book.ID)
+	book.Identifiers, _ = db.GetBookIdentifiersContext(ctx, book.ID)
+	book.Notes, _ = db.GetBookNotesContext(ctx, book.ID)
 
 	return &book, nil
 }
 
-// GetBookAuthors 获取书籍作者
-func (db *DB) GetBookAuthors(bookID int) ([]Author, error) {
+// GetBookRatingContext 获取书籍评分（0-10），没有评分时返回nil
+func (db *DB) GetBookRatingContext(ctx context.Context, bookID int) (*int, error) {
+	var rating sql.NullInt64
+	query := "SELECT r.rating FROM books_ratings_link brl JOIN ratings r ON brl.rating = r.id WHERE brl.book = ?"
+	err := db.conn().QueryRowContext(ctx, query, bookID).Scan(&rating)
+	if err == sql.ErrNoRows || (err == nil && !rating.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	value := int(rating.Int64)
+	return &value, nil
+}
+
+// GetBookCommentsContext 获取书籍简介，存在多条记录时按顺序合并非空内容
+func (db *DB) GetBookCommentsContext(ctx context.Context, bookID int) (string, error) {
+	rows, err := db.conn().QueryContext(ctx, "SELECT text FROM comments WHERE book = ? ORDER BY id", bookID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var parts []string
+	for rows.Next() {
+		var text sql.NullString
+		if err := rows.Scan(&text); err != nil {
+			return "", err
+		}
+		if text.Valid && strings.TrimSpace(text.String) != "" {
+			parts = append(parts, text.String)
+		}
+	}
+
+	return strings.Join(parts, "\n"), rows.Err()
+}
+
+// GetBookAuthorsContext 获取书籍作者
+func (db *DB) GetBookAuthorsContext(ctx context.Context, bookID int) ([]Author, error) {
 	query := `
 		SELECT a.name, a.sort
 		FROM authors a
@@ -278,7 +528,7 @@ func (db *DB) GetBookAuthors(bookID int) ([]Author, error) {
 		ORDER BY bal.id
 	`
 
-	rows, err := db.conn.Query(query, bookID)
+	rows, err := db.conn().QueryContext(ctx, query, bookID)
 	if err != nil {
 		return nil, err
 	}
@@ -296,8 +546,8 @@ func (db *DB) GetBookAuthors(bookID int) ([]Author, error) {
 	return authors, rows.Err()
 }
 
-// GetBookTags 获取书籍标签
-func (db *DB) GetBookTags(bookID int) ([]string, error) {
+// GetBookTagsContext 获取书籍标签
+func (db *DB) GetBookTagsContext(ctx context.Context, bookID int) ([]string, error) {
 	query := `
 		SELECT t.name
 		FROM tags t
@@ -306,7 +556,7 @@ func (db *DB) GetBookTags(bookID int) ([]string, error) {
 		ORDER BY t.name
 	`
 
-	rows, err := db.conn.Query(query, bookID)
+	rows, err := db.conn().QueryContext(ctx, query, bookID)
 	if err != nil {
 		return nil, err
 	}
@@ -324,18 +574,71 @@ func (db *DB) GetBookTags(bookID int) ([]string, error) {
 	return tags, rows.Err()
 }
 
-// GetBookSeries 获取书籍系列
-func (db *DB) GetBookSeries(bookID int) (*Series, error) {
+// GetBookLanguagesContext 获取书籍语言代码，按Calibre中的顺序排列
+func (db *DB) GetBookLanguagesContext(ctx context.Context, bookID int) ([]string, error) {
+	query := `
+		SELECT l.lang_code
+		FROM languages l
+		JOIN books_languages_link bll ON l.id = bll.lang_code
+		WHERE bll.book = ?
+		ORDER BY bll.item_order
+	`
+
+	rows, err := db.conn().QueryContext(ctx, query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var languages []string
+	for rows.Next() {
+		var language string
+		if err := rows.Scan(&language); err != nil {
+			return nil, err
+		}
+		languages = append(languages, language)
+	}
+
+	return languages, rows.Err()
+}
+
+// GetBookLanguages 不带ctx的GetBookLanguagesContext
+func (db *DB) GetBookLanguages(bookID int) ([]string, error) {
+	return db.GetBookLanguagesContext(context.Background(), bookID)
+}
+
+// GetBookIdentifiersContext 获取书籍标识符（identifiers表），键为类型，如isbn、amazon、goodreads
+func (db *DB) GetBookIdentifiersContext(ctx context.Context, bookID int) (map[string]string, error) {
+	rows, err := db.conn().QueryContext(ctx, "SELECT type, val FROM identifiers WHERE book = ? ORDER BY type", bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identifiers := make(map[string]string)
+	for rows.Next() {
+		var idType, value string
+		if err := rows.Scan(&idType, &value); err != nil {
+			return nil, err
+		}
+		identifiers[idType] = value
+	}
+
+	return identifiers, rows.Err()
+}
+
+// GetBookSeriesContext 获取书籍系列
+func (db *DB) GetBookSeriesContext(ctx context.Context, bookID int) (*Series, error) {
 	query := `
 		SELECT s.name, s.sort, b.series_index
 		FROM series s
 		JOIN books_series_link bsl ON s.id = bsl.series
-		JOIN books b ON bsl.book = b.id
+		JOIN ` + db.booksTable() + ` b ON bsl.book = b.id
 		WHERE b.id = ?
 	`
 
 	var series Series
-	err := db.conn.QueryRow(query, bookID).Scan(&series.Name, &series.Sort, &series.Index)
+	err := db.conn().QueryRowContext(ctx, query, bookID).Scan(&series.Name, &series.Sort, &series.Index)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -346,8 +649,8 @@ func (db *DB) GetBookSeries(bookID int) (*Series, error) {
 	return &series, nil
 }
 
-// GetBookFormats 获取书籍格式
-func (db *DB) GetBookFormats(bookID int) ([]Format, error) {
+// GetBookFormatsContext 获取书籍格式
+func (db *DB) GetBookFormatsContext(ctx context.Context, bookID int) ([]Format, error) {
 	query := `
 		SELECT format, uncompressed_size, name
 		FROM data
@@ -355,7 +658,7 @@ func (db *DB) GetBookFormats(bookID int) ([]Format, error) {
 		ORDER BY format
 	`
 
-	rows, err := db.conn.Query(query, bookID)
+	rows, err := db.conn().QueryContext(ctx, query, bookID)
 	if err != nil {
 		return nil, err
 	}
@@ -367,25 +670,152 @@ func (db *DB) GetBookFormats(bookID int) ([]Format, error) {
 		if err := rows.Scan(&format.Format, &format.Size, &format.Filename); err != nil {
 			return nil, err
 		}
+		normalizeKepub(&format)
 		formats = append(formats, format)
 	}
 
 	return formats, rows.Err()
 }
 
-// GetAuthors 获取作者列表
-func (db *DB) GetAuthors(limit, offset int) ([]AuthorInfo, error) {
+// authorInitialExpr 作者排序名首字符的分组表达式：A-Z、0-9、CJK（中日韩文字）及其他（#）
+const authorInitialExpr = `CASE
+		WHEN upper(substr(COALESCE(a.sort, a.name), 1, 1)) BETWEEN 'A' AND 'Z' THEN upper(substr(COALESCE(a.sort, a.name), 1, 1))
+		WHEN substr(COALESCE(a.sort, a.name), 1, 1) BETWEEN '0' AND '9' THEN '0-9'
+		WHEN unicode(substr(COALESCE(a.sort, a.name), 1, 1)) BETWEEN 12352 AND 12543
+			OR unicode(substr(COALESCE(a.sort, a.name), 1, 1)) BETWEEN 13312 AND 40959
+			OR unicode(substr(COALESCE(a.sort, a.name), 1, 1)) BETWEEN 44032 AND 55215 THEN 'CJK'
+		ELSE '#'
+	END`
+
+// 首字母分组中的特殊分组，排在A-Z之后
+const (
+	InitialDigits = "0-9"
+	InitialCJK    = "CJK"
+	InitialOther  = "#"
+)
+
+// initialRank 首字母分组的排序权重
+func initialRank(initial string) int {
+	switch initial {
+	case InitialDigits:
+		return 1
+	case InitialCJK:
+		return 2
+	case InitialOther:
+		return 3
+	}
+	return 0
+}
+
+// GetPubDecadesContext 按出版年代分组统计书籍数量，年代倒序，同时返回出版日期未知的书籍数
+func (db *DB) GetPubDecadesContext(ctx context.Context) ([]DecadeInfo, int, error) {
+	ctx, cancel := db.withTimeout(ctx, "pub_decades")
+	defer cancel()
+
 	query := `
-		SELECT DISTINCT a.name, a.sort, COUNT(b.id) as book_count
-		FROM authors a
-		JOIN books_authors_link bal ON a.id = bal.author
-		JOIN books b ON bal.book = b.id
-		GROUP BY a.id, a.name, a.sort
-		ORDER BY a.sort
+		SELECT CAST(strftime('%Y', b.pubdate) AS INTEGER) / 10 * 10 AS decade, COUNT(*)
+		FROM ` + db.booksTable() + ` b
+		WHERE ` + knownPubDateCondition + `
+		GROUP BY decade
+		ORDER BY decade DESC
+	`
+
+	rows, err := db.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var decades []DecadeInfo
+	for rows.Next() {
+		var decade DecadeInfo
+		if err := rows.Scan(&decade.Decade, &decade.BookCount); err != nil {
+			return nil, 0, err
+		}
+		decades = append(decades, decade)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	unknown, err := db.GetBooksCountFilteredContext(ctx, BookFilter{NoPubDate: true})
+	if err != nil {
+		return nil, 0, err
+	}
+	return decades, unknown, nil
+}
+
+// GetAuthorInitialsContext 按作者排序名首字母分组统计作者数量
+func (db *DB) GetAuthorInitialsContext(ctx context.Context) ([]AuthorInitial, error) {
+	ctx, cancel := db.withTimeout(ctx, "author_initials")
+	defer cancel()
+
+	nameExpr, args := db.canonicalAuthorExpr()
+	query := `
+		SELECT initial, COUNT(*) FROM (
+			SELECT DISTINCT ` + nameExpr + `, ` + authorInitialExpr + ` AS initial
+			FROM authors a
+			JOIN books_authors_link bal ON a.id = bal.author
+		)
+		GROUP BY initial
+	`
+
+	rows, err := db.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var initials []AuthorInitial
+	for rows.Next() {
+		var initial AuthorInitial
+		if err := rows.Scan(&initial.Initial, &initial.AuthorCount); err != nil {
+			return nil, err
+		}
+		initials = append(initials, initial)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(initials, func(i, j int) bool {
+		ri, rj := initialRank(initials[i].Initial), initialRank(initials[j].Initial)
+		if ri != rj {
+			return ri < rj
+		}
+		return initials[i].Initial < initials[j].Initial
+	})
+
+	return initials, nil
+}
+
+// GetAuthorsContext 获取作者列表，initial非空时只返回该首字母分组下的作者
+func (db *DB) GetAuthorsContext(ctx context.Context, limit, offset int, initial string) ([]AuthorInfo, error) {
+	ctx, cancel := db.withTimeout(ctx, "authors")
+	defer cancel()
+
+	// 别名按规范名合并为一个作者
+	nameExpr, args := db.canonicalAuthorExpr()
+	query := `
+		SELECT name, MIN(sort), COUNT(DISTINCT book) as book_count FROM (
+			SELECT ` + nameExpr + ` AS name, a.sort AS sort, b.id AS book
+			FROM authors a
+			JOIN books_authors_link bal ON a.id = bal.author
+			JOIN ` + db.booksTable() + ` b ON bal.book = b.id
+	`
+	if initial != "" {
+		query += " WHERE " + authorInitialExpr + " = ?"
+		args = append(args, initial)
+	}
+	query += `
+		)
+		GROUP BY name
+		ORDER BY MIN(sort)
 		LIMIT ? OFFSET ?
 	`
+	args = append(args, limit, offset)
 
-	rows, err := db.conn.Query(query, limit, offset)
+	rows, err := db.conn().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -403,19 +833,22 @@ func (db *DB) GetAuthors(limit, offset int) ([]AuthorInfo, error) {
 	return authors, rows.Err()
 }
 
-// GetSeries 获取系列列表
-func (db *DB) GetSeries(limit, offset int) ([]SeriesInfo, error) {
+// GetSeriesContext 获取系列列表
+func (db *DB) GetSeriesContext(ctx context.Context, limit, offset int) ([]SeriesInfo, error) {
+	ctx, cancel := db.withTimeout(ctx, "series")
+	defer cancel()
+
 	query := `
 		SELECT DISTINCT s.name, s.sort, COUNT(b.id) as book_count
 		FROM series s
 		JOIN books_series_link bsl ON s.id = bsl.series
-		JOIN books b ON bsl.book = b.id
+		JOIN ` + db.booksTable() + ` b ON bsl.book = b.id
 		GROUP BY s.id, s.name, s.sort
 		ORDER BY s.sort
 		LIMIT ? OFFSET ?
 	`
 
-	rows, err := db.conn.Query(query, limit, offset)
+	rows, err := db.conn().QueryContext(ctx, query, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -433,19 +866,78 @@ func (db *DB) GetSeries(limit, offset int) ([]SeriesInfo, error) {
 	return seriesList, rows.Err()
 }
 
-// GetTags 获取标签列表
-func (db *DB) GetTags(limit, offset int) ([]Tag, error) {
+// GetPublishersContext 获取出版社列表及每个出版社的书籍数量
+func (db *DB) GetPublishersContext(ctx context.Context, limit, offset int) ([]PublisherInfo, error) {
+	ctx, cancel := db.withTimeout(ctx, "publishers")
+	defer cancel()
+
 	query := `
-		SELECT DISTINCT t.name, COUNT(b.id) as book_count
+		SELECT p.name, COUNT(b.id) as book_count
+		FROM publishers p
+		JOIN books_publishers_link bpl ON p.id = bpl.publisher
+		JOIN ` + db.booksTable() + ` b ON bpl.book = b.id
+		GROUP BY p.id, p.name
+		ORDER BY COALESCE(p.sort, p.name)
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := db.conn().QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var publishers []PublisherInfo
+	for rows.Next() {
+		var publisher PublisherInfo
+		if err := rows.Scan(&publisher.Name, &publisher.BookCount); err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, publisher)
+	}
+
+	return publishers, rows.Err()
+}
+
+// GetPublishers 不带ctx的GetPublishersContext
+func (db *DB) GetPublishers(limit, offset int) ([]PublisherInfo, error) {
+	return db.GetPublishersContext(context.Background(), limit, offset)
+}
+
+// tagSortOrders 标签列表的排序方式：name按名称，count按书籍数量，recent按带有该标签的书籍最近修改时间
+var tagSortOrders = map[string]string{
+	"name":   "t.name",
+	"count":  "book_count DESC, t.name",
+	"recent": "MAX(b.last_modified) DESC, t.name",
+}
+
+// IsValidTagSort 判断标签排序方式是否受支持
+func IsValidTagSort(sort string) bool {
+	_, ok := tagSortOrders[sort]
+	return ok
+}
+
+// GetTagsContext 获取标签列表，sort为name、count或recent，其他值按名称排序
+func (db *DB) GetTagsContext(ctx context.Context, limit, offset int, sort string) ([]Tag, error) {
+	ctx, cancel := db.withTimeout(ctx, "tags")
+	defer cancel()
+
+	order, ok := tagSortOrders[sort]
+	if !ok {
+		order = tagSortOrders["name"]
+	}
+
+	query := `
+		SELECT t.name, COUNT(DISTINCT b.id) as book_count
 		FROM tags t
 		JOIN books_tags_link btl ON t.id = btl.tag
-		JOIN books b ON btl.book = b.id
+		JOIN ` + db.booksTable() + ` b ON btl.book = b.id
 		GROUP BY t.id, t.name
-		ORDER BY t.name
+		ORDER BY ` + order + `
 		LIMIT ? OFFSET ?
 	`
 
-	rows, err := db.conn.Query(query, limit, offset)
+	rows, err := db.conn().QueryContext(ctx, query, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -463,26 +955,118 @@ func (db *DB) GetTags(limit, offset int) ([]Tag, error) {
 	return tags, rows.Err()
 }
 
-// GetStats 获取统计信息
-func (db *DB) GetStats() (*Stats, error) {
+// incompleteBooksQuery 标记每本书缺少的内容，只保留至少缺少一项的书籍
+func (db *DB) incompleteBooksQuery() string {
+	return `
+		SELECT id, title, path, missing_cover, missing_formats, missing_authors FROM (
+			SELECT b.id, b.title, b.path,
+			       b.has_cover = 0 AS missing_cover,
+			       NOT EXISTS (SELECT 1 FROM data d WHERE d.book = b.id) AS missing_formats,
+			       NOT EXISTS (SELECT 1 FROM books_authors_link bal WHERE bal.book = b.id) AS missing_authors
+			FROM ` + db.booksTable() + ` b
+		)
+		WHERE missing_cover OR missing_formats OR missing_authors
+	`
+}
+
+// GetIncompleteBooksCountContext 获取不完整书籍总数
+func (db *DB) GetIncompleteBooksCountContext(ctx context.Context) (int, error) {
+	ctx, cancel := db.withTimeout(ctx, "incomplete_books_count")
+	defer cancel()
+
+	var count int
+	err := db.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+db.incompleteBooksQuery()+")").Scan(&count)
+	return count, err
+}
+
+// GetIncompleteBooksContext 获取缺少封面、格式或作者的书籍列表
+func (db *DB) GetIncompleteBooksContext(ctx context.Context, limit, offset int) ([]IncompleteBook, error) {
+	ctx, cancel := db.withTimeout(ctx, "incomplete_books")
+	defer cancel()
+
+	rows, err := db.conn().QueryContext(ctx, db.incompleteBooksQuery()+" ORDER BY id LIMIT ? OFFSET ?", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []IncompleteBook{}
+	for rows.Next() {
+		var book IncompleteBook
+		if err := rows.Scan(&book.ID, &book.Title, &book.Path,
+			&book.MissingCover, &book.MissingFormats, &book.MissingAuthors); err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+
+	return books, rows.Err()
+}
+
+// GetSearchSuggestionsContext 获取以prefix开头的书名和作者名，按书籍数量排序
+func (db *DB) GetSearchSuggestionsContext(ctx context.Context, prefix string, limit int) ([]Suggestion, error) {
+	ctx, cancel := db.withTimeout(ctx, "search_suggestions")
+	defer cancel()
+
+	query := `
+		SELECT text, type, book_count FROM (
+			SELECT b.title AS text, 'title' AS type, COUNT(*) AS book_count
+			FROM ` + db.booksTable() + ` b
+			WHERE b.title LIKE ?` + likeEscape + `
+			GROUP BY b.title
+			UNION ALL
+			SELECT a.name AS text, 'author' AS type, COUNT(bal.book) AS book_count
+			FROM authors a
+			JOIN books_authors_link bal ON a.id = bal.author
+			WHERE a.name LIKE ?` + likeEscape + `
+			GROUP BY a.id, a.name
+		)
+		ORDER BY book_count DESC, text
+		LIMIT ?
+	`
+
+	prefixTerm := escapeLike(prefix) + "%"
+	rows, err := db.conn().QueryContext(ctx, query, prefixTerm, prefixTerm, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []Suggestion{}
+	for rows.Next() {
+		var suggestion Suggestion
+		if err := rows.Scan(&suggestion.Text, &suggestion.Type, &suggestion.BookCount); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	return suggestions, rows.Err()
+}
+
+// GetStatsContext 获取统计信息
+func (db *DB) GetStatsContext(ctx context.Context) (*Stats, error) {
+	ctx, cancel := db.withTimeout(ctx, "stats")
+	defer cancel()
+
 	stats := &Stats{
 		Formats: make(map[string]int),
 	}
 
 	// 获取书籍总数
-	err := db.conn.QueryRow("SELECT COUNT(*) FROM books").Scan(&stats.TotalBooks)
+	err := db.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM "+db.booksTable()+" b").Scan(&stats.TotalBooks)
 	if err != nil {
 		return nil, err
 	}
 
 	// 获取作者总数
-	err = db.conn.QueryRow("SELECT COUNT(*) FROM authors").Scan(&stats.TotalAuthors)
+	err = db.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM authors").Scan(&stats.TotalAuthors)
 	if err != nil {
 		return nil, err
 	}
 
 	// 获取格式统计
-	rows, err := db.conn.Query("SELECT format, COUNT(*) FROM data GROUP BY format")
+	rows, err := db.conn().QueryContext(ctx, "SELECT format, COUNT(*) FROM data GROUP BY format")
 	if err != nil {
 		return nil, err
 	}
@@ -500,6 +1084,44 @@ func (db *DB) GetStats() (*Stats, error) {
 	return stats, rows.Err()
 }
 
+// GetFormatsContext 获取书库中所有格式及各格式的书籍数量和文件总大小，按书籍数量从多到少排序
+func (db *DB) GetFormatsContext(ctx context.Context) ([]FormatInfo, error) {
+	ctx, cancel := db.withTimeout(ctx, "formats")
+	defer cancel()
+
+	query := `
+		SELECT format, COUNT(DISTINCT book), COALESCE(SUM(uncompressed_size), 0)
+		FROM data
+		GROUP BY format
+		ORDER BY COUNT(DISTINCT book) DESC, format
+	`
+
+	rows, err := db.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var formats []FormatInfo
+	for rows.Next() {
+		var format FormatInfo
+		if err := rows.Scan(&format.Format, &format.BookCount, &format.TotalSize); err != nil {
+			return nil, err
+		}
+		formats = append(formats, format)
+	}
+
+	return formats, rows.Err()
+}
+
+// normalizeKepub 将以EPUB格式记录的.kepub.epub文件识别为KEPUB格式
+func normalizeKepub(format *Format) {
+	if strings.EqualFold(format.Format, "EPUB") && strings.HasSuffix(strings.ToLower(format.Filename), ".kepub") {
+		format.Format = "KEPUB"
+		format.Filename = format.Filename[:len(format.Filename)-len(".kepub")]
+	}
+}
+
 // 辅助函数
 func joinConditions(conditions []string, separator string) string {
 	result := ""
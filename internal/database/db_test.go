@@ -0,0 +1,251 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+func TestIncompleteBooks(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	complete := lib.AddBook(t, testutil.Book{Title: "Complete", Authors: []string{"A"}, Formats: []string{"EPUB"}})
+	lib.SetCover(t, complete, []byte("cover"))
+	noCover := lib.AddBook(t, testutil.Book{Title: "No cover", Authors: []string{"A"}, Formats: []string{"EPUB"}})
+	noFormats := lib.AddBook(t, testutil.Book{Title: "No formats", Authors: []string{"A"}})
+	lib.SetCover(t, noFormats, []byte("cover"))
+	noAuthors := lib.AddBook(t, testutil.Book{Title: "No authors", Formats: []string{"EPUB"}})
+	lib.SetCover(t, noAuthors, []byte("cover"))
+	nothing := lib.AddBook(t, testutil.Book{Title: "Nothing"})
+	db := newTestDB(t, lib)
+
+	books, err := db.GetIncompleteBooksContext(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type flags struct {
+		id                      int
+		cover, formats, authors bool
+	}
+	var got []flags
+	for _, b := range books {
+		got = append(got, flags{b.ID, b.MissingCover, b.MissingFormats, b.MissingAuthors})
+	}
+	want := []flags{
+		{noCover, true, false, false},
+		{noFormats, false, true, false},
+		{noAuthors, false, false, true},
+		{nothing, true, true, true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incomplete books = %+v, want %+v", got, want)
+	}
+
+	count, err := db.GetIncompleteBooksCountContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(want) {
+		t.Errorf("count = %d, want %d", count, len(want))
+	}
+}
+
+func TestAuthorInitials(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	for _, book := range []struct {
+		title  string
+		author string
+	}{
+		{"Dune", "Frank Herbert"},
+		{"Dune Messiah", "Frank Herbert"},
+		{"Emma", "jane austen"},
+		{"Hamlet", "William Shakespeare"},
+		{"1Q84", "1984 Collective"},
+		{"挪威的森林", "村上春树"},
+		{"素食者", "한강"},
+		{"こころ", "なつめそうせき"},
+		{"Germinal", "Émile Zola"},
+	} {
+		lib.AddBook(t, testutil.Book{Title: book.title, Authors: []string{book.author}})
+	}
+	// 按排序名而不是显示名分组
+	lib.Exec(t, `UPDATE authors SET sort = 'Herbert, Frank' WHERE name = 'Frank Herbert'`)
+	lib.Exec(t, `UPDATE authors SET sort = 'Shakespeare, William' WHERE name = 'William Shakespeare'`)
+	db := newTestDB(t, lib)
+
+	initials, err := db.GetAuthorInitialsContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AuthorInitial{
+		{"H", 1},
+		{"J", 1},
+		{"S", 1},
+		{InitialDigits, 1},
+		{InitialCJK, 3},
+		{InitialOther, 1},
+	}
+	if !reflect.DeepEqual(initials, want) {
+		t.Errorf("initials = %v, want %v", initials, want)
+	}
+
+	authors, err := db.GetAuthorsContext(context.Background(), 10, 0, InitialCJK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, author := range authors {
+		names = append(names, author.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"なつめそうせき", "村上春树", "한강"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("CJK authors = %v, want %v", names, want)
+	}
+}
+
+func TestTwoAuthorBook(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	id := lib.AddBook(t, testutil.Book{Title: "Hunters of Dune", Authors: []string{"Brian Herbert", "Kevin J. Anderson"}})
+	lib.AddBook(t, testutil.Book{Title: "Emma", Authors: []string{"Jane Austen"}})
+	lib.Exec(t, `UPDATE books SET author_sort = 'Herbert, Brian & Anderson, Kevin J.' WHERE id = ?`, id)
+	lib.Exec(t, `UPDATE authors SET sort = 'Herbert, Brian' WHERE name = 'Brian Herbert'`)
+	lib.Exec(t, `UPDATE authors SET sort = 'Anderson, Kevin J.' WHERE name = 'Kevin J. Anderson'`)
+	db := newTestDB(t, lib)
+	ctx := context.Background()
+
+	// 两位作者都能单独搜索和过滤
+	for _, search := range []string{"Brian Herbert", "Kevin J. Anderson", "Anderson"} {
+		if got := searchTitles(t, db, search); !reflect.DeepEqual(got, []string{"Hunters of Dune"}) {
+			t.Errorf("search %q = %v", search, got)
+		}
+	}
+	for _, author := range []string{"Brian Herbert", "Kevin J. Anderson"} {
+		books, err := db.GetBooksFilteredContext(ctx, 10, 0, BookFilter{Authors: []string{author}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(books) != 1 || books[0].ID != id {
+			t.Errorf("author filter %q = %v", author, books)
+		}
+	}
+
+	// 作者列表按authors表分别列出，不使用合并的author_sort
+	authors, err := db.GetAuthorsContext(ctx, 10, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for _, author := range authors {
+		got[author.Name] = author.BookCount
+	}
+	want := map[string]int{"Brian Herbert": 1, "Kevin J. Anderson": 1, "Jane Austen": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("authors = %v, want %v", got, want)
+	}
+
+	book, err := db.GetBookDetailContext(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(book.Authors) != 2 || book.Authors[0].Name != "Brian Herbert" || book.Authors[1].Name != "Kevin J. Anderson" {
+		t.Errorf("book authors = %v", book.Authors)
+	}
+}
+
+func TestBookComments(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	present := lib.AddBook(t, testutil.Book{Title: "Present", Comments: "<p>Spice</p>"})
+	null := lib.AddBook(t, testutil.Book{Title: "Null"})
+	lib.Exec(t, `INSERT INTO comments(book, text) VALUES (?, NULL)`, null)
+	multiple := lib.AddBook(t, testutil.Book{Title: "Multiple", Comments: "First"})
+	lib.Exec(t, `INSERT INTO comments(book, text) VALUES (?, NULL), (?, '  '), (?, 'Second')`, multiple, multiple, multiple)
+	missing := lib.AddBook(t, testutil.Book{Title: "Missing"})
+	db := newTestDB(t, lib)
+
+	tests := []struct {
+		id   int
+		want string
+	}{
+		{present, "<p>Spice</p>"},
+		{null, ""},
+		{multiple, "First\nSecond"},
+		{missing, ""},
+	}
+	for _, tt := range tests {
+		book, err := db.GetBookDetailContext(context.Background(), tt.id)
+		if err != nil {
+			t.Fatalf("book %d: %v", tt.id, err)
+		}
+		if book.Comments != tt.want {
+			t.Errorf("book %d: comments %q, want %q", tt.id, book.Comments, tt.want)
+		}
+	}
+}
+
+func TestAuthorAliases(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "The Hobbit", Authors: []string{"J.R.R. Tolkien"}})
+	lib.AddBook(t, testutil.Book{Title: "The Silmarillion", Authors: []string{"J. R. R. Tolkien"}})
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}})
+	db := newTestDB(t, lib)
+	db.SetAuthorAliases(map[string][]string{"J.R.R. Tolkien": {"J. R. R. Tolkien"}})
+	ctx := context.Background()
+
+	authors, err := db.GetAuthorsContext(ctx, 100, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, author := range authors {
+		counts[author.Name] = author.BookCount
+	}
+	// 两种写法合并为规范名下的一个作者
+	want := map[string]int{"J.R.R. Tolkien": 2, "Frank Herbert": 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("authors = %v, want %v", counts, want)
+	}
+
+	for _, name := range []string{"J.R.R. Tolkien", "J. R. R. Tolkien"} {
+		filter := BookFilter{Authors: []string{name}}
+		books, err := db.GetBooksFilteredContext(ctx, 100, 0, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var titles []string
+		for _, book := range books {
+			titles = append(titles, book.Title)
+		}
+		sort.Strings(titles)
+		if want := []string{"The Hobbit", "The Silmarillion"}; !reflect.DeepEqual(titles, want) {
+			t.Errorf("author %q: books %v, want %v", name, titles, want)
+		}
+		count, err := db.GetBooksCountFilteredContext(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Errorf("author %q: count %d, want 2", name, count)
+		}
+	}
+}
+
+func TestNonContextWrappers(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Language: "eng", Publisher: "Chilton"})
+	db := newTestDB(t, lib)
+
+	if books, err := db.GetRecentlyAdded(10, 0); err != nil || len(books) != 1 {
+		t.Errorf("GetRecentlyAdded = %d books, %v", len(books), err)
+	}
+	if books, err := db.GetRandomBooks(10); err != nil || len(books) != 1 {
+		t.Errorf("GetRandomBooks = %d books, %v", len(books), err)
+	}
+	if languages, err := db.GetBookLanguages(1); err != nil || !reflect.DeepEqual(languages, []string{"eng"}) {
+		t.Errorf("GetBookLanguages = %v, %v", languages, err)
+	}
+	if publishers, err := db.GetPublishers(10, 0); err != nil || len(publishers) != 1 || publishers[0].Name != "Chilton" {
+		t.Errorf("GetPublishers = %+v, %v", publishers, err)
+	}
+}
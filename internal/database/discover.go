@@ -0,0 +1,73 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// libraryDiscoveryDepth 查找书库时最多向下遍历的目录层数
+const libraryDiscoveryDepth = 4
+
+// DiscoverLibraries 在root下查找Calibre书库（包含结构完整的metadata.db的目录），按路径排序返回书库目录
+func DiscoverLibraries(root string) ([]string, error) {
+	root = filepath.Clean(root)
+	if _, err := os.Stat(root); err != nil {
+		return nil, fmt.Errorf("library root not accessible: %w", err)
+	}
+
+	var libraries []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 无权限等无法读取的目录直接跳过
+			if d != nil && d.IsDir() && path != root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return fs.SkipDir
+		}
+
+		if isCalibreLibrary(path) {
+			libraries = append(libraries, path)
+			// 书库内部是作者和书籍目录，不会再嵌套书库
+			return fs.SkipDir
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		if rel != "." && strings.Count(rel, string(filepath.Separator))+1 >= libraryDiscoveryDepth {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(libraries)
+	return libraries, nil
+}
+
+// isCalibreLibrary 判断目录是否为Calibre书库：存在metadata.db且包含必要的表
+func isCalibreLibrary(dir string) bool {
+	dbPath := filepath.Join(dir, "metadata.db")
+	if info, err := os.Stat(dbPath); err != nil || info.IsDir() {
+		return false
+	}
+
+	conn, err := sql.Open("sqlite3", dbPath+"?mode=ro")
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	return checkRequiredTables(conn) == nil
+}
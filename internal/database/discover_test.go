@@ -0,0 +1,50 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+func TestDiscoverLibrariesNested(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{
+		"Books",               // 根目录下一层
+		"users/alice/Library", // 嵌套三层
+		"Books/Nested",        // 书库内部不再查找
+		".hidden/Library",     // 隐藏目录跳过
+		"a/b/c/d/Library",     // 超过遍历深度
+	} {
+		testutil.NewLibraryAt(t, filepath.Join(root, filepath.FromSlash(dir)))
+	}
+
+	// 有metadata.db但缺少必要的表，不是Calibre书库
+	notLibrary := filepath.Join(root, "other")
+	if err := os.MkdirAll(notLibrary, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(notLibrary, "metadata.db"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	libraries, err := DiscoverLibraries(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(root, "Books"),
+		filepath.Join(root, "users", "alice", "Library"),
+	}
+	if !reflect.DeepEqual(libraries, want) {
+		t.Errorf("DiscoverLibraries = %v, want %v", libraries, want)
+	}
+}
+
+func TestDiscoverLibrariesMissingRoot(t *testing.T) {
+	if _, err := DiscoverLibraries(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing library root")
+	}
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BookFilter 书籍过滤和排序条件
+type BookFilter struct {
+	Search      string   // 关键字，按空白拆分，每个词须出现在书名、作者、简介、系列或标签中
+	Authors     []string // 作者，匹配其中任意一个
+	Series      string   // 系列名
+	NoSeries    bool     // 只返回不属于任何系列的书籍
+	Tags        []string // 标签，必须全部匹配
+	Language    string   // 语言代码，如eng
+	Publisher   string   // 出版社名
+	Format      string   // 电子书格式，如EPUB，不区分大小写
+	PubDateFrom string   // 出版日期下限（含），格式YYYY-MM-DD
+	PubDateTo   string   // 出版日期上限（含），格式YYYY-MM-DD
+	MinRating   *int     // 评分下限（含），Calibre评分范围0-10
+	MaxRating   *int     // 评分上限（含）
+	HasCover    *bool    // 是否有封面，为nil时不过滤
+	NoPubDate   bool     // 只返回出版日期未知的书籍
+	Sort        string   // 排序字段，见SortFields
+	Order       string   // 排序方向：asc或desc
+}
+
+// searchTermCondition 单个关键字匹配书名、作者（author_sort、任意单个作者的姓名或排序名）、简介、系列或标签，
+// 与全文索引覆盖的字段相同；多作者书籍的author_sort形如"Smith, John & Doe, Jane"，按作者表匹配才能搜到"John Smith"
+const searchTermCondition = "(b.title LIKE ?" + likeEscape + " OR b.author_sort LIKE ?" + likeEscape +
+	" OR EXISTS (SELECT 1 FROM books_authors_link bal JOIN authors a ON bal.author = a.id WHERE bal.book = b.id AND (a.name LIKE ?" + likeEscape + " OR a.sort LIKE ?" + likeEscape + "))" +
+	" OR EXISTS (SELECT 1 FROM comments c WHERE c.book = b.id AND c.text LIKE ?" + likeEscape + ")" +
+	" OR EXISTS (SELECT 1 FROM books_series_link bsl JOIN series s ON bsl.series = s.id WHERE bsl.book = b.id AND s.name LIKE ?" + likeEscape + ")" +
+	" OR EXISTS (SELECT 1 FROM books_tags_link btl JOIN tags t ON btl.tag = t.id WHERE btl.book = b.id AND t.name LIKE ?" + likeEscape + "))"
+
+// searchTermArgs searchTermCondition的参数个数
+var searchTermArgs = strings.Count(searchTermCondition, "?")
+
+// searchCondition 生成关键字搜索的条件和参数：与全文索引一样按空白拆分关键字，每个词都需匹配
+func searchCondition(search string) (string, []interface{}) {
+	terms := strings.Fields(search)
+	if len(terms) == 0 {
+		terms = []string{search}
+	}
+
+	conditions := make([]string, 0, len(terms))
+	var args []interface{}
+	for _, term := range terms {
+		conditions = append(conditions, searchTermCondition)
+		pattern := "%" + escapeLike(term) + "%"
+		for i := 0; i < searchTermArgs; i++ {
+			args = append(args, pattern)
+		}
+	}
+	return "(" + joinConditions(conditions, " AND ") + ")", args
+}
+
+// likeEscape LIKE子句的转义声明，参数需经escapeLike处理
+const likeEscape = ` ESCAPE '\'`
+
+// likeEscaper 转义LIKE通配符，使%和_按字面匹配
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike 转义关键字中的LIKE通配符和转义字符本身
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// knownPubDateCondition 出版日期已知；Calibre用0101-01-01表示未知日期
+const knownPubDateCondition = "(b.pubdate IS NOT NULL AND strftime('%Y', b.pubdate) >= '1000')"
+
+// sortColumns 排序字段到数据库列的映射，只允许使用白名单中的列
+var sortColumns = map[string]string{
+	"title":    "b.sort",
+	"author":   "b.author_sort",
+	"pubdate":  "b.pubdate",
+	"added":    "b.timestamp",
+	"modified": "b.last_modified",
+}
+
+// SortFields 支持的排序字段
+func SortFields() []string {
+	return []string{"title", "author", "pubdate", "added", "modified"}
+}
+
+// IsValidSort 判断排序字段是否受支持
+func IsValidSort(sort string) bool {
+	_, ok := sortColumns[sort]
+	return ok
+}
+
+// conditions 生成WHERE条件和参数
+func (f *BookFilter) conditions() ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if f.Search != "" {
+		condition, searchArgs := searchCondition(f.Search)
+		conditions = append(conditions, condition)
+		args = append(args, searchArgs...)
+	}
+
+	if len(f.Authors) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(f.Authors)), ",")
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM books_authors_link bal JOIN authors a ON bal.author = a.id WHERE bal.book = b.id AND a.name IN ("+placeholders+"))")
+		for _, author := range f.Authors {
+			args = append(args, author)
+		}
+	}
+
+	if f.Series != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM books_series_link bsl JOIN series s ON bsl.series = s.id WHERE bsl.book = b.id AND s.name = ?)")
+		args = append(args, f.Series)
+	}
+
+	if f.NoSeries {
+		conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM books_series_link bsl WHERE bsl.book = b.id)")
+	}
+
+	for _, tag := range f.Tags {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM books_tags_link btl JOIN tags t ON btl.tag = t.id WHERE btl.book = b.id AND t.name = ?)")
+		args = append(args, tag)
+	}
+
+	if f.Publisher != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM books_publishers_link bpl JOIN publishers p ON bpl.publisher = p.id WHERE bpl.book = b.id AND p.name = ?)")
+		args = append(args, f.Publisher)
+	}
+
+	if f.Language != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM books_languages_link bll JOIN languages l ON bll.lang_code = l.id WHERE bll.book = b.id AND l.lang_code = ?)")
+		args = append(args, f.Language)
+	}
+
+	if f.Format != "" {
+		// Calibre的data表以大写保存格式
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM data d WHERE d.book = b.id AND d.format = ?)")
+		args = append(args, strings.ToUpper(f.Format))
+	}
+
+	if f.PubDateFrom != "" || f.PubDateTo != "" {
+		// 指定日期范围时排除出版日期未知的书籍，否则0101-01-01会落入只有上限的范围
+		conditions = append(conditions, knownPubDateCondition)
+	}
+
+	if f.PubDateFrom != "" {
+		conditions = append(conditions, "date(b.pubdate) >= ?")
+		args = append(args, f.PubDateFrom)
+	}
+
+	if f.PubDateTo != "" {
+		conditions = append(conditions, "date(b.pubdate) <= ?")
+		args = append(args, f.PubDateTo)
+	}
+
+	if f.MinRating != nil {
+		conditions = append(conditions, "(SELECT r.rating FROM books_ratings_link brl JOIN ratings r ON brl.rating = r.id WHERE brl.book = b.id) >= ?")
+		args = append(args, *f.MinRating)
+	}
+
+	if f.MaxRating != nil {
+		conditions = append(conditions, "(SELECT r.rating FROM books_ratings_link brl JOIN ratings r ON brl.rating = r.id WHERE brl.book = b.id) <= ?")
+		args = append(args, *f.MaxRating)
+	}
+
+	if f.NoPubDate {
+		conditions = append(conditions, "NOT "+knownPubDateCondition)
+	}
+
+	if f.HasCover != nil {
+		conditions = append(conditions, "b.has_cover = ?")
+		args = append(args, *f.HasCover)
+	}
+
+	return conditions, args
+}
+
+// IsSearchOnly 判断是否只有关键字搜索、没有其他过滤条件和排序，此时可以使用全文索引按相关度排序
+func (f *BookFilter) IsSearchOnly() bool {
+	rest := *f
+	rest.Search = ""
+	return f.Search != "" && !rest.hasConditions() && f.Sort == ""
+}
+
+// HasNonAuthorFilters 判断除作者外是否还有其他过滤条件或排序，只按作者过滤时作者页面可以按系列分组
+func (f *BookFilter) HasNonAuthorFilters() bool {
+	rest := *f
+	rest.Authors = nil
+	return rest.hasConditions() || f.Sort != ""
+}
+
+// hasConditions 判断是否有任何过滤条件；以conditions为准，新增的过滤条件无需在别处登记
+func (f *BookFilter) hasConditions() bool {
+	conditions, _ := f.conditions()
+	return len(conditions) > 0
+}
+
+// whereClause 生成带WHERE关键字的条件子句，没有条件时返回空字符串
+func (f *BookFilter) whereClause() (string, []interface{}) {
+	conditions, args := f.conditions()
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + joinConditions(conditions, " AND "), args
+}
+
+// orderByClause 生成ORDER BY子句，默认按修改时间倒序
+func (f *BookFilter) orderByClause() string {
+	column, ok := sortColumns[f.Sort]
+	if !ok {
+		column = sortColumns["modified"]
+	}
+
+	direction := "DESC"
+	if strings.EqualFold(f.Order, "asc") {
+		direction = "ASC"
+	}
+
+	return fmt.Sprintf(" ORDER BY %s %s, b.id %s", column, direction, direction)
+}
@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+// newTestDB 打开测试书库
+func newTestDB(t *testing.T, lib *testutil.Library) *DB {
+	t.Helper()
+	db, err := NewDB(lib.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// searchTitles 返回LIKE搜索匹配的书名（按书名排序）
+func searchTitles(t *testing.T, db *DB, search string) []string {
+	t.Helper()
+	books, err := db.GetBooksFilteredContext(context.Background(), 100, 0, BookFilter{Search: search})
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := []string{}
+	for _, book := range books {
+		titles = append(titles, book.Title)
+	}
+	sort.Strings(titles)
+
+	count, err := db.GetBooksCountFilteredContext(context.Background(), BookFilter{Search: search})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(titles) {
+		t.Errorf("search %q: count %d, but %d books returned", search, count, len(titles))
+	}
+	return titles
+}
+
+func TestSearchMatchesSameFieldsAsFTS(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Series: "Dune Chronicles", Tags: []string{"Science Fiction"}, Comments: "<p>Desert planet Arrakis</p>"})
+	lib.AddBook(t, testutil.Book{Title: "Foundation", Authors: []string{"Isaac Asimov"}, Series: "Foundation", Tags: []string{"Science Fiction", "Classic"}, Comments: "<p>Psychohistory</p>"})
+	lib.AddBook(t, testutil.Book{Title: "Emma", Authors: []string{"Jane Austen"}, Tags: []string{"Classic"}})
+	db := newTestDB(t, lib)
+
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{"Dune", []string{"Dune"}},                          // 书名
+		{"Asimov", []string{"Foundation"}},                  // 作者
+		{"Arrakis", []string{"Dune"}},                       // 简介
+		{"Chronicles", []string{"Dune"}},                    // 系列
+		{"Classic", []string{"Emma", "Foundation"}},         // 标签
+		{"Science Fiction", []string{"Dune", "Foundation"}}, // 多个词分别匹配
+		{"Classic Psychohistory", []string{"Foundation"}},   // 每个词都需匹配，可以在不同字段
+		{"Austen Arrakis", []string{}},                      // 不同书籍中的词不匹配
+		{"  fiction  ", []string{"Dune", "Foundation"}},     // 首尾空白
+	}
+	for _, tt := range tests {
+		if got := searchTitles(t, db, tt.search); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search %q = %v, want %v", tt.search, got, tt.want)
+		}
+	}
+}
+
+func TestIsSearchOnly(t *testing.T) {
+	rating := 6
+	hasCover := true
+	tests := []struct {
+		name   string
+		filter BookFilter
+		want   bool
+	}{
+		{"search only", BookFilter{Search: "dune"}, true},
+		{"order without sort", BookFilter{Search: "dune", Order: "asc"}, true},
+		{"no search", BookFilter{}, false},
+		{"with author", BookFilter{Search: "dune", Authors: []string{"Frank Herbert"}}, false},
+		{"with format", BookFilter{Search: "dune", Format: "EPUB"}, false},
+		{"with rating", BookFilter{Search: "dune", MinRating: &rating}, false},
+		{"with cover", BookFilter{Search: "dune", HasCover: &hasCover}, false},
+		{"with pubdate", BookFilter{Search: "dune", PubDateTo: "2000-12-31"}, false},
+		{"with sort", BookFilter{Search: "dune", Sort: "title"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.IsSearchOnly(); got != tt.want {
+			t.Errorf("%s: IsSearchOnly() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHasNonAuthorFilters(t *testing.T) {
+	noCover := false
+	tests := []struct {
+		name   string
+		filter BookFilter
+		want   bool
+	}{
+		{"author only", BookFilter{Authors: []string{"Ann"}}, false},
+		{"empty", BookFilter{}, false},
+		{"order without sort", BookFilter{Authors: []string{"Ann"}, Order: "asc"}, false},
+		{"with search", BookFilter{Authors: []string{"Ann"}, Search: "x"}, true},
+		{"with series", BookFilter{Authors: []string{"Ann"}, Series: "S"}, true},
+		{"with no series", BookFilter{Authors: []string{"Ann"}, NoSeries: true}, true},
+		{"with tags", BookFilter{Authors: []string{"Ann"}, Tags: []string{"x"}}, true},
+		{"with cover", BookFilter{Authors: []string{"Ann"}, HasCover: &noCover}, true},
+		{"with sort", BookFilter{Authors: []string{"Ann"}, Sort: "title"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.HasNonAuthorFilters(); got != tt.want {
+			t.Errorf("%s: HasNonAuthorFilters() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTagsFilterRequiresAllTags(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Tags: []string{"scifi", "hugo-winner"}})
+	lib.AddBook(t, testutil.Book{Title: "Solaris", Authors: []string{"Stanislaw Lem"}, Tags: []string{"scifi"}})
+	lib.AddBook(t, testutil.Book{Title: "Middlemarch", Authors: []string{"George Eliot"}, Tags: []string{"classic", "hugo-winner"}})
+	db := newTestDB(t, lib)
+
+	tests := []struct {
+		tags []string
+		want []string
+	}{
+		{[]string{"scifi"}, []string{"Dune", "Solaris"}},
+		{[]string{"scifi", "hugo-winner"}, []string{"Dune"}},
+		{[]string{"hugo-winner", "scifi"}, []string{"Dune"}},
+		{[]string{"scifi", "classic"}, []string{}},
+	}
+	for _, tt := range tests {
+		filter := BookFilter{Tags: tt.tags, Sort: "title", Order: "asc"}
+		books, err := db.GetBooksFilteredContext(context.Background(), 100, 0, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles := []string{}
+		for _, book := range books {
+			titles = append(titles, book.Title)
+		}
+		if !reflect.DeepEqual(titles, tt.want) {
+			t.Errorf("tags %v: got %v, want %v", tt.tags, titles, tt.want)
+		}
+		count, err := db.GetBooksCountFilteredContext(context.Background(), filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != len(tt.want) {
+			t.Errorf("tags %v: count %d, want %d", tt.tags, count, len(tt.want))
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"100%", `100\%`},
+		{"a_b", `a\_b`},
+		{`c:\books`, `c:\\books`},
+		{`50%_\`, `50\%\_\\`},
+	}
+	for _, tt := range tests {
+		if got := escapeLike(tt.in); got != tt.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSearchMatchesWildcardsLiterally(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "100% Pure", Authors: []string{"Ann"}})
+	lib.AddBook(t, testutil.Book{Title: "1000 Nights", Authors: []string{"Ann"}})
+	lib.AddBook(t, testutil.Book{Title: "snake_case", Authors: []string{"Bob"}})
+	lib.AddBook(t, testutil.Book{Title: "snakeXcase", Authors: []string{"Bob"}})
+	lib.AddBook(t, testutil.Book{Title: `C:\Books`, Authors: []string{"Cy"}})
+	db := newTestDB(t, lib)
+
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{"100%", []string{"100% Pure"}},
+		{"%", []string{"100% Pure"}},
+		{"snake_case", []string{"snake_case"}},
+		{"_", []string{"snake_case"}},
+		{`C:\Books`, []string{`C:\Books`}},
+	}
+	for _, tt := range tests {
+		if got := searchTitles(t, db, tt.search); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search %q = %v, want %v", tt.search, got, tt.want)
+		}
+	}
+
+	// 搜索建议的前缀匹配同样按字面处理通配符
+	suggestions, err := db.GetSearchSuggestionsContext(context.Background(), "snake_", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 1 || suggestions[0].Text != "snake_case" {
+		t.Errorf("suggestions for %q = %+v, want only snake_case", "snake_", suggestions)
+	}
+}
@@ -0,0 +1,255 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ricci/calibre-opds-go/pkg/logger"
+)
+
+// ErrFTSUnavailable 全文索引不可用（未启用、尚未建立、SQLite未编译FTS5或关键字过短），调用方应退回LIKE搜索
+var ErrFTSUnavailable = errors.New("full-text index unavailable")
+
+// ftsMinTermLength trigram分词器能匹配的最短关键字（字符数）
+const ftsMinTermLength = 3
+
+// ftsRank 按字段加权的bm25相关度，依次为书名、作者、简介、系列、标签；值越小越相关
+const ftsRank = "bm25(books_fts, 10.0, 5.0, 1.0, 3.0, 2.0)"
+
+// ftsIndex 内存中的FTS5索引，只用一个连接，连接关闭后内存数据库随之消失
+type ftsIndex struct {
+	conn    *sql.DB
+	builtAt time.Time
+}
+
+// ftsState 全文索引的状态
+type ftsState struct {
+	enabled  atomic.Bool
+	building atomic.Bool
+	index    atomic.Pointer[ftsIndex]
+}
+
+// htmlTagPattern 简介中的HTML标签，建立索引前去除
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// EnableFTS 启用全文索引并在后台建立；SQLite不支持FTS5（编译时未使用sqlite_fts5标签）时记录日志并保持LIKE搜索
+func (db *DB) EnableFTS() {
+	db.fts.enabled.Store(true)
+	db.rebuildFTS()
+}
+
+// rebuildFTS 在后台重建全文索引，同一时间只有一次重建
+func (db *DB) rebuildFTS() {
+	if !db.fts.building.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer db.fts.building.Store(false)
+
+		start := time.Now()
+		index, count, err := db.buildFTSIndex()
+		if err != nil {
+			logger.Warning.Printf("Full-text index unavailable, using LIKE search: %v", err)
+			return
+		}
+		if old := db.fts.index.Swap(index); old != nil {
+			old.conn.Close()
+		}
+		logger.Info.Printf("Full-text index built: %d books in %s", count, time.Since(start).Round(time.Millisecond))
+	}()
+}
+
+// buildFTSIndex 从书库读取书名、作者、简介、系列和标签，建立新的内存索引
+func (db *DB) buildFTSIndex() (*ftsIndex, int, error) {
+	// 记录开始时间，建立期间书库的修改会在下次查询时触发重建
+	builtAt := time.Now()
+
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, 0, err
+	}
+	conn.SetMaxOpenConns(1)
+	conn.SetMaxIdleConns(1)
+
+	_, err = conn.Exec("CREATE VIRTUAL TABLE books_fts USING fts5(title, authors, comments, series, tags, tokenize='trigram')")
+	if err != nil {
+		conn.Close()
+		return nil, 0, fmt.Errorf("create fts5 table: %w", err)
+	}
+
+	count, err := db.fillFTSIndex(conn)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	return &ftsIndex{conn: conn, builtAt: builtAt}, count, nil
+}
+
+// fillFTSIndex 把书库中的书籍写入索引，rowid为书籍ID
+func (db *DB) fillFTSIndex(conn *sql.DB) (int, error) {
+	rows, err := db.conn().Query(`
+		SELECT b.id, b.title,
+		       COALESCE((SELECT group_concat(a.name, ' ') FROM books_authors_link bal JOIN authors a ON bal.author = a.id WHERE bal.book = b.id), ''),
+		       COALESCE((SELECT group_concat(c.text, ' ') FROM comments c WHERE c.book = b.id), ''),
+		       COALESCE((SELECT s.name FROM books_series_link bsl JOIN series s ON bsl.series = s.id WHERE bsl.book = b.id), ''),
+		       COALESCE((SELECT group_concat(t.name, ' ') FROM books_tags_link btl JOIN tags t ON btl.tag = t.id WHERE btl.book = b.id), '')
+		FROM ` + db.booksTable() + ` b
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	tx, err := conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare("INSERT INTO books_fts (rowid, title, authors, comments, series, tags) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+
+	count := 0
+	for rows.Next() {
+		var id int
+		var title, authors, comments, series, tags string
+		if err := rows.Scan(&id, &title, &authors, &comments, &series, &tags); err != nil {
+			return 0, err
+		}
+		comments = htmlTagPattern.ReplaceAllString(comments, " ")
+		if _, err := insert.Exec(id, title, authors, comments, series, tags); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// ftsQuery 把搜索关键字转换为FTS5查询：按空白拆分，每个词作为短语匹配，所有词都需匹配；
+// 有词短于trigram的最小长度时返回false
+func ftsQuery(search string) (string, bool) {
+	terms := strings.Fields(search)
+	if len(terms) == 0 {
+		return "", false
+	}
+	phrases := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < ftsMinTermLength {
+			return "", false
+		}
+		phrases = append(phrases, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	return strings.Join(phrases, " "), true
+}
+
+// GetBooksFTSContext 用全文索引搜索书名、作者、简介、系列和标签，按相关度排序，同时返回匹配总数；
+// 索引不可用或关键字无法用索引匹配时返回ErrFTSUnavailable。书库在索引建立后被修改时在后台重建索引
+func (db *DB) GetBooksFTSContext(ctx context.Context, search string, limit, offset int) ([]Book, int, error) {
+	if !db.fts.enabled.Load() {
+		return nil, 0, ErrFTSUnavailable
+	}
+	index := db.fts.index.Load()
+	if index == nil {
+		return nil, 0, ErrFTSUnavailable
+	}
+	query, ok := ftsQuery(search)
+	if !ok {
+		return nil, 0, ErrFTSUnavailable
+	}
+	if db.ModTime().After(index.builtAt) {
+		db.rebuildFTS()
+	}
+
+	ctx, cancel := db.withTimeout(ctx, "books_fts")
+	defer cancel()
+
+	var total int
+	err := index.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM books_fts WHERE books_fts MATCH ?", query).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := index.conn.QueryContext(ctx,
+		"SELECT rowid FROM books_fts WHERE books_fts MATCH ? ORDER BY "+ftsRank+" LIMIT ? OFFSET ?",
+		query, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	var ids []interface{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(ids) == 0 {
+		return nil, total, nil
+	}
+
+	books, err := db.getBooksByIDs(ctx, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	return books, total, nil
+}
+
+// GetBooksByIDsContext 按给定顺序批量加载书籍，已不存在的书籍被跳过
+func (db *DB) GetBooksByIDsContext(ctx context.Context, ids []int) ([]Book, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := db.withTimeout(ctx, "books_by_ids")
+	defer cancel()
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return db.getBooksByIDs(ctx, args)
+}
+
+// getBooksByIDs 按给定顺序加载书籍，已不存在的书籍被跳过
+func (db *DB) getBooksByIDs(ctx context.Context, ids []interface{}) ([]Book, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	query := `
+		SELECT ` + bookSelectColumns + `
+		FROM ` + db.booksTable() + ` b
+		WHERE b.id IN (` + placeholders + `)
+	`
+
+	byID := make(map[int]Book, len(ids))
+	err := db.streamBookQuery(ctx, query, ids, func(book *Book) error {
+		byID[book.ID] = *book
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	books := make([]Book, 0, len(byID))
+	for _, id := range ids {
+		if book, ok := byID[id.(int)]; ok {
+			books = append(books, book)
+		}
+	}
+	return books, nil
+}
@@ -14,15 +14,29 @@ type Book struct {
 	ISBN         *string   `json:"isbn,omitempty"`
 	PubDate      *string   `json:"pubdate,omitempty"`
 	LastModified time.Time `json:"last_modified"`
+	Timestamp    time.Time `json:"timestamp"` // 添加到书库的时间
 	HasCover     bool      `json:"has_cover"`
 	UUID         string    `json:"uuid"`
 	Comments     string    `json:"comments,omitempty"`
-	
+	Rating       *int      `json:"rating,omitempty"` // Calibre评分，范围0-10
+	IsNew        bool      `json:"is_new,omitempty"` // 在新书窗口内添加，由处理器根据配置标记
+
 	// 关联数据
-	Authors []Author `json:"authors,omitempty"`
-	Tags    []string `json:"tags,omitempty"`
-	Series  *Series  `json:"series,omitempty"`
-	Formats []Format `json:"formats,omitempty"`
+	Authors   []Author `json:"authors,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Languages []string `json:"languages,omitempty"` // ISO 639-2语言代码，如eng、zho
+	Series    *Series  `json:"series,omitempty"`
+	Formats   []Format `json:"formats,omitempty"`
+	Notes     []Note   `json:"notes,omitempty"`
+	// Identifiers identifiers表中的标识符，键为类型，如isbn、amazon、goodreads
+	Identifiers map[string]string `json:"identifiers,omitempty"`
+}
+
+// Note Calibre笔记，附加在书籍的作者、系列或标签上
+type Note struct {
+	Field string `json:"field"` // authors、series或tags
+	Item  string `json:"item"`  // 作者名、系列名或标签名
+	Doc   string `json:"doc"`   // 笔记内容（HTML）
 }
 
 // Author 作者模型
@@ -58,6 +72,32 @@ type AuthorInfo struct {
 	BookCount int    `json:"book_count"`
 }
 
+// AuthorInitial 作者首字母分组
+type AuthorInitial struct {
+	Initial     string `json:"initial"`
+	AuthorCount int    `json:"author_count"`
+}
+
+// DecadeInfo 按出版年代分组的书籍数量
+type DecadeInfo struct {
+	Decade    int `json:"decade"` // 年代的起始年份，如1990
+	BookCount int `json:"book_count"`
+}
+
+// FormatInfo 书库中某种格式的书籍数量和文件总大小
+type FormatInfo struct {
+	Format    string `json:"format"`
+	BookCount int    `json:"book_count"`
+	TotalSize int64  `json:"total_size"` // 字节
+}
+
+// ChangelogEntry 书库变更记录，Change为added或modified
+type ChangelogEntry struct {
+	Book
+	Change    string    `json:"change"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
 // SeriesInfo 系列信息（用于列表）
 type SeriesInfo struct {
 	Name      string `json:"name"`
@@ -65,9 +105,49 @@ type SeriesInfo struct {
 	BookCount int    `json:"book_count"`
 }
 
+// PublisherInfo 出版社信息（用于列表）
+type PublisherInfo struct {
+	Name      string `json:"name"`
+	BookCount int    `json:"book_count"`
+}
+
+// IncompleteBook 缺少封面、格式或作者的书籍
+type IncompleteBook struct {
+	ID             int    `json:"id"`
+	Title          string `json:"title"`
+	Path           string `json:"path"`
+	MissingCover   bool   `json:"missing_cover"`
+	MissingFormats bool   `json:"missing_formats"`
+	MissingAuthors bool   `json:"missing_authors"`
+}
+
+// Suggestion 搜索建议
+type Suggestion struct {
+	Text      string `json:"text"`
+	Type      string `json:"type"` // title 或 author
+	BookCount int    `json:"book_count"`
+}
+
 // Stats 统计信息
 type Stats struct {
 	TotalBooks   int            `json:"total_books"`
 	TotalAuthors int            `json:"total_authors"`
 	Formats      map[string]int `json:"formats"`
 }
+
+// SQLiteInfo SQLite数据库文件的存储信息
+type SQLiteInfo struct {
+	PageSize    int    `json:"page_size"`
+	PageCount   int    `json:"page_count"`
+	JournalMode string `json:"journal_mode"`
+	UserVersion int    `json:"user_version"`
+	FileSize    int64  `json:"file_size"` // 磁盘上的文件大小（字节）
+}
+
+// IntegrityResult PRAGMA integrity_check的结果
+type IntegrityResult struct {
+	OK        bool      `json:"ok"`
+	Messages  []string  `json:"messages"` // 检查通过时为["ok"]
+	CheckedAt time.Time `json:"checked_at"`
+	Duration  string    `json:"duration"`
+}
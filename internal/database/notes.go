@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+
+	"github.com/ricci/calibre-opds-go/pkg/logger"
+)
+
+// notesLinks 支持笔记的字段，以及书籍到该字段条目的关联查询
+var notesLinks = []struct {
+	field string
+	query string
+}{
+	{"authors", "SELECT a.id, a.name FROM books_authors_link bal JOIN authors a ON bal.author = a.id WHERE bal.book = ? ORDER BY bal.id"},
+	{"series", "SELECT s.id, s.name FROM books_series_link bsl JOIN series s ON bsl.series = s.id WHERE bsl.book = ?"},
+	{"tags", "SELECT t.id, t.name FROM books_tags_link btl JOIN tags t ON btl.tag = t.id WHERE btl.book = ? ORDER BY t.name"},
+}
+
+// openNotesDB 打开Calibre 7及以上版本的笔记数据库（.calibre/notes/notes.db），不存在或无法打开时返回nil
+func openNotesDB(dbPath string) *sql.DB {
+	notesPath := filepath.Join(filepath.Dir(dbPath), ".calibre", "notes", "notes.db")
+	if _, err := os.Stat(notesPath); err != nil {
+		return nil
+	}
+
+	conn, err := sql.Open("sqlite3", notesPath+"?mode=ro")
+	if err != nil {
+		logger.Warning.Printf("Failed to open notes database %s: %v", notesPath, err)
+		return nil
+	}
+	if err := conn.Ping(); err != nil {
+		logger.Warning.Printf("Failed to open notes database %s: %v", notesPath, err)
+		conn.Close()
+		return nil
+	}
+
+	logger.Info.Printf("Notes database loaded: %s", notesPath)
+	return conn
+}
+
+// GetBookNotesContext 获取书籍作者、系列和标签上的笔记，没有笔记数据库时返回nil
+func (db *DB) GetBookNotesContext(ctx context.Context, bookID int) ([]Note, error) {
+	if db.notes == nil {
+		return nil, nil
+	}
+
+	var notes []Note
+	for _, link := range notesLinks {
+		items, err := db.linkedItems(ctx, link.query, bookID)
+		if err != nil {
+			return notes, err
+		}
+
+		for _, item := range items {
+			var doc sql.NullString
+			err := db.notes.QueryRowContext(ctx, "SELECT doc FROM notes WHERE colname = ? AND item = ?", link.field, item.id).Scan(&doc)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return notes, err
+			}
+			if doc.Valid && doc.String != "" {
+				notes = append(notes, Note{Field: link.field, Item: item.name, Doc: doc.String})
+			}
+		}
+	}
+
+	return notes, nil
+}
+
+// linkedItem 书籍关联的作者、系列或标签
+type linkedItem struct {
+	id   int
+	name string
+}
+
+// linkedItems 执行关联查询，返回书籍关联的条目
+func (db *DB) linkedItems(ctx context.Context, query string, bookID int) ([]linkedItem, error) {
+	rows, err := db.conn().QueryContext(ctx, query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []linkedItem
+	for rows.Next() {
+		var item linkedItem
+		if err := rows.Scan(&item.id, &item.name); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// SQLiteInfo 读取页大小、页数、日志模式等PRAGMA信息及数据库文件大小
+func (db *DB) SQLiteInfo() (*SQLiteInfo, error) {
+	info := &SQLiteInfo{}
+	pragmas := []struct {
+		name string
+		dest interface{}
+	}{
+		{"page_size", &info.PageSize},
+		{"page_count", &info.PageCount},
+		{"journal_mode", &info.JournalMode},
+		{"user_version", &info.UserVersion},
+	}
+	for _, pragma := range pragmas {
+		if err := db.conn().QueryRow("PRAGMA " + pragma.name).Scan(pragma.dest); err != nil {
+			return nil, fmt.Errorf("failed to read PRAGMA %s: %w", pragma.name, err)
+		}
+	}
+
+	if stat, err := os.Stat(db.path); err == nil {
+		info.FileSize = stat.Size()
+	}
+	return info, nil
+}
+
+// IntegrityCheck 执行PRAGMA integrity_check；需要读取整个数据库，大书库上耗时较长
+func (db *DB) IntegrityCheck() (*IntegrityResult, error) {
+	start := time.Now()
+	rows, err := db.conn().Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &IntegrityResult{CheckedAt: start.UTC()}
+	for rows.Next() {
+		var message string
+		if err := rows.Scan(&message); err != nil {
+			return nil, err
+		}
+		result.Messages = append(result.Messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result.OK = len(result.Messages) == 1 && result.Messages[0] == "ok"
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	return result, nil
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// relationBatchSize streamBookQuery每批加载关联数据的书籍数量
+const relationBatchSize = 100
+
+// loadBookRelations 用WHERE book IN (...)批量加载一批书籍的评分、作者、标签、语言、系列、格式和标识符，
+// 每种关联数据一次查询；与逐本加载时一致，某项查询失败时该项保留空值
+func (db *DB) loadBookRelations(ctx context.Context, books []*Book) {
+	if len(books) == 0 {
+		return
+	}
+
+	// 同一本书可能在结果中出现多次，按ID分组后一起填充
+	byID := make(map[int][]*Book, len(books))
+	ids := make([]interface{}, 0, len(books))
+	for _, book := range books {
+		if _, ok := byID[book.ID]; !ok {
+			ids = append(ids, book.ID)
+		}
+		byID[book.ID] = append(byID[book.ID], book)
+		book.Identifiers = make(map[string]string)
+	}
+	in := "(" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")"
+
+	db.queryRelation(ctx, `
+		SELECT brl.book, r.rating
+		FROM books_ratings_link brl
+		JOIN ratings r ON brl.rating = r.id
+		WHERE brl.book IN `+in, ids, func(rows *sql.Rows) error {
+		var bookID int
+		var rating sql.NullInt64
+		if err := rows.Scan(&bookID, &rating); err != nil {
+			return err
+		}
+		if !rating.Valid {
+			return nil
+		}
+		for _, book := range byID[bookID] {
+			if book.Rating == nil {
+				value := int(rating.Int64)
+				book.Rating = &value
+			}
+		}
+		return nil
+	})
+
+	db.queryRelation(ctx, `
+		SELECT bal.book, a.name, a.sort
+		FROM authors a
+		JOIN books_authors_link bal ON a.id = bal.author
+		WHERE bal.book IN `+in+`
+		ORDER BY bal.id`, ids, func(rows *sql.Rows) error {
+		var bookID int
+		var author Author
+		if err := rows.Scan(&bookID, &author.Name, &author.Sort); err != nil {
+			return err
+		}
+		for _, book := range byID[bookID] {
+			book.Authors = append(book.Authors, author)
+		}
+		return nil
+	})
+
+	db.queryRelation(ctx, `
+		SELECT btl.book, t.name
+		FROM tags t
+		JOIN books_tags_link btl ON t.id = btl.tag
+		WHERE btl.book IN `+in+`
+		ORDER BY t.name`, ids, func(rows *sql.Rows) error {
+		var bookID int
+		var tag string
+		if err := rows.Scan(&bookID, &tag); err != nil {
+			return err
+		}
+		for _, book := range byID[bookID] {
+			book.Tags = append(book.Tags, tag)
+		}
+		return nil
+	})
+
+	db.queryRelation(ctx, `
+		SELECT bll.book, l.lang_code
+		FROM languages l
+		JOIN books_languages_link bll ON l.id = bll.lang_code
+		WHERE bll.book IN `+in+`
+		ORDER BY bll.item_order`, ids, func(rows *sql.Rows) error {
+		var bookID int
+		var language string
+		if err := rows.Scan(&bookID, &language); err != nil {
+			return err
+		}
+		for _, book := range byID[bookID] {
+			book.Languages = append(book.Languages, language)
+		}
+		return nil
+	})
+
+	// 系列序号已在书籍查询中读取，这里只需要系列名称
+	db.queryRelation(ctx, `
+		SELECT bsl.book, s.name, s.sort
+		FROM series s
+		JOIN books_series_link bsl ON s.id = bsl.series
+		WHERE bsl.book IN `+in, ids, func(rows *sql.Rows) error {
+		var bookID int
+		var name, sortName string
+		if err := rows.Scan(&bookID, &name, &sortName); err != nil {
+			return err
+		}
+		for _, book := range byID[bookID] {
+			if book.Series != nil {
+				continue
+			}
+			book.Series = &Series{Name: name, Sort: sortName}
+			if book.SeriesIndex != nil {
+				index := *book.SeriesIndex
+				book.Series.Index = &index
+			}
+		}
+		return nil
+	})
+
+	db.queryRelation(ctx, `
+		SELECT book, format, uncompressed_size, name
+		FROM data
+		WHERE book IN `+in+`
+		ORDER BY format`, ids, func(rows *sql.Rows) error {
+		var bookID int
+		var format Format
+		if err := rows.Scan(&bookID, &format.Format, &format.Size, &format.Filename); err != nil {
+			return err
+		}
+		normalizeKepub(&format)
+		for _, book := range byID[bookID] {
+			book.Formats = append(book.Formats, format)
+		}
+		return nil
+	})
+
+	db.queryRelation(ctx, "SELECT book, type, val FROM identifiers WHERE book IN "+in+" ORDER BY type", ids, func(rows *sql.Rows) error {
+		var bookID int
+		var idType, value string
+		if err := rows.Scan(&bookID, &idType, &value); err != nil {
+			return err
+		}
+		for _, book := range byID[bookID] {
+			book.Identifiers[idType] = value
+		}
+		return nil
+	})
+}
+
+// queryRelation 执行关联数据查询并对每一行调用scan
+func (db *DB) queryRelation(ctx context.Context, query string, args []interface{}, scan func(*sql.Rows) error) error {
+	rows, err := db.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+func TestBatchedRelationsMatchPerBookQueries(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	// 超过一批的数量，覆盖批次边界
+	for i := 1; i <= relationBatchSize+25; i++ {
+		book := testutil.Book{
+			Title:    fmt.Sprintf("Book %03d", i),
+			Authors:  []string{fmt.Sprintf("Author %d", i%7), fmt.Sprintf("Co-Author %d", i%5)},
+			Tags:     []string{"Fiction", fmt.Sprintf("Tag %d", i%3)},
+			Formats:  []string{"EPUB", "PDF"},
+			Language: "eng",
+		}
+		if i%4 == 0 {
+			book.Series = "Series"
+			book.SeriesIndex = float64(i)
+		}
+		if i%2 == 0 {
+			book.Tags = nil
+			book.Formats = nil
+		}
+		id := lib.AddBook(t, book)
+		if i%3 == 0 {
+			lib.Exec(t, `INSERT INTO ratings(rating) VALUES (?)`, i%10)
+			lib.Exec(t, `INSERT INTO books_ratings_link(book, rating) VALUES (?, last_insert_rowid())`, id)
+			lib.Exec(t, `INSERT INTO identifiers(book, type, val) VALUES (?, 'isbn', ?)`, id, fmt.Sprintf("978%010d", i))
+		}
+	}
+	db := newTestDB(t, lib)
+	ctx := context.Background()
+
+	books, err := db.GetBooksContext(ctx, 1000, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != relationBatchSize+25 {
+		t.Fatalf("got %d books", len(books))
+	}
+	for _, book := range books {
+		want := Book{Identifiers: map[string]string{}}
+		want.Rating, _ = db.GetBookRatingContext(ctx, book.ID)
+		want.Authors, _ = db.GetBookAuthorsContext(ctx, book.ID)
+		want.Tags, _ = db.GetBookTagsContext(ctx, book.ID)
+		want.Languages, _ = db.GetBookLanguagesContext(ctx, book.ID)
+		want.Series, _ = db.GetBookSeriesContext(ctx, book.ID)
+		want.Formats, _ = db.GetBookFormatsContext(ctx, book.ID)
+		want.Identifiers, _ = db.GetBookIdentifiersContext(ctx, book.ID)
+
+		got := Book{
+			Rating: book.Rating, Authors: book.Authors, Tags: book.Tags, Languages: book.Languages,
+			Series: book.Series, Formats: book.Formats, Identifiers: book.Identifiers,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("book %d:\n got %+v\nwant %+v", book.ID, got, want)
+		}
+	}
+}
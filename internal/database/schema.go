@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ricci/calibre-opds-go/pkg/logger"
+)
+
+// bookColumn books表中的一列及其在旧版本Calibre中缺失时的替代表达式
+type bookColumn struct {
+	name     string
+	fallback string // 为空表示必需列
+}
+
+// bookColumns 查询使用的books表列，按Calibre不同版本的差异提供替代值
+var bookColumns = []bookColumn{
+	{name: "id"},
+	{name: "title"},
+	{name: "path"},
+	{name: "sort", fallback: "title"},
+	{name: "author_sort", fallback: "''"},
+	{name: "series_index", fallback: "1.0"},
+	{name: "isbn", fallback: "NULL"},
+	{name: "pubdate", fallback: "NULL"},
+	// 两个时间列互为替代，保留列的声明类型以便驱动解析为时间
+	{name: "timestamp", fallback: "last_modified"},
+	{name: "last_modified", fallback: "timestamp"},
+	{name: "has_cover", fallback: "0"},
+	{name: "uuid", fallback: "NULL"},
+}
+
+// schema 启动时探测到的数据库结构
+type schema struct {
+	// columns 各表包含的列
+	columns map[string]map[string]bool
+	// books 查询书籍时使用的数据源，列齐全时为books表，否则为补齐缺失列的子查询
+	books string
+}
+
+// loadSchema 通过PRAGMA table_info读取必要表的列，并生成书籍查询的数据源
+func loadSchema(conn *sql.DB) (*schema, error) {
+	s := &schema{columns: make(map[string]map[string]bool)}
+	for _, table := range requiredTables {
+		columns, err := tableColumns(conn, table)
+		if err != nil {
+			return nil, err
+		}
+		s.columns[table] = columns
+	}
+
+	books, err := s.booksSource()
+	if err != nil {
+		return nil, err
+	}
+	s.books = books
+	return s, nil
+}
+
+// tableColumns 返回表的所有列名
+func tableColumns(conn *sql.DB, table string) (map[string]bool, error) {
+	rows, err := conn.Query(fmt.Sprintf("PRAGMA table_info(%q)", table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect table '%s': %w", table, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			defaultValue     sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// hasColumn 判断表中是否存在该列
+func (s *schema) hasColumn(table, column string) bool {
+	return s.columns[table][column]
+}
+
+// booksSource 生成书籍查询的数据源，缺失的列使用替代表达式补齐，使查询可以统一引用b.<列名>
+func (s *schema) booksSource() (string, error) {
+	var (
+		selects []string
+		missing []string
+	)
+	for _, column := range bookColumns {
+		if s.hasColumn("books", column.name) {
+			selects = append(selects, column.name)
+			continue
+		}
+		if column.fallback == "" || isIdentifier(column.fallback) && !s.hasColumn("books", column.fallback) {
+			return "", fmt.Errorf("required column 'books.%s' not found", column.name)
+		}
+		selects = append(selects, column.fallback+" AS "+column.name)
+		missing = append(missing, column.name)
+	}
+
+	if len(missing) == 0 {
+		return "books", nil
+	}
+	logger.Warning.Printf("books table lacks columns %s, using fallback values", strings.Join(missing, ", "))
+	return "(SELECT " + strings.Join(selects, ", ") + " FROM books)", nil
+}
+
+// isIdentifier 判断替代表达式是否为列名
+func isIdentifier(expr string) bool {
+	for _, r := range expr {
+		if !(r == '_' || r >= 'a' && r <= 'z') {
+			return false
+		}
+	}
+	return expr != ""
+}
+
+// booksTable 返回书籍查询的数据源，在FROM或JOIN中使用
+func (db *DB) booksTable() string {
+	if s := db.schema.Load(); s != nil {
+		return s.books
+	}
+	return "books"
+}
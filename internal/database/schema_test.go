@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+func TestBooksTableSchemaVariants(t *testing.T) {
+	tests := []struct {
+		name     string
+		drop     []string
+		fallback bool
+	}{
+		{"current schema", nil, false},
+		// 旧版本Calibre没有uuid、has_cover、last_modified和isbn列
+		{"old schema", []string{"uuid", "has_cover", "last_modified", "isbn"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lib := testutil.NewLibrary(t)
+			added := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Formats: []string{"EPUB"}, Added: added})
+			lib.AddBook(t, testutil.Book{Title: "Emma", Authors: []string{"Jane Austen"}, Added: added})
+			for _, column := range tt.drop {
+				lib.Exec(t, "ALTER TABLE books DROP COLUMN "+column)
+			}
+			db := newTestDB(t, lib)
+			ctx := context.Background()
+
+			if got := strings.HasPrefix(db.booksTable(), "(SELECT"); got != tt.fallback {
+				t.Errorf("booksTable() = %q, fallback %v, want %v", db.booksTable(), got, tt.fallback)
+			}
+			if err := db.Validate(); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+
+			if count, err := db.GetBooksCountContext(ctx, ""); err != nil || count != 2 {
+				t.Errorf("count = %d, %v, want 2", count, err)
+			}
+			if count, err := db.GetBooksCountContext(ctx, "dune"); err != nil || count != 1 {
+				t.Errorf("search count = %d, %v, want 1", count, err)
+			}
+			stats, err := db.GetStatsContext(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.TotalBooks != 2 || stats.Formats["EPUB"] != 1 {
+				t.Errorf("stats = %+v", stats)
+			}
+			if titles := searchTitles(t, db, ""); strings.Join(titles, ",") != "Dune,Emma" {
+				t.Errorf("books = %v", titles)
+			}
+
+			book, err := db.GetBookDetailContext(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if book == nil || book.Title != "Dune" || len(book.Formats) != 1 {
+				t.Fatalf("book = %+v", book)
+			}
+			// 缺失的修改时间用添加时间代替
+			if !book.Timestamp.Equal(added) || !book.LastModified.Equal(added) {
+				t.Errorf("timestamp %v, last_modified %v, want %v", book.Timestamp, book.LastModified, added)
+			}
+			if tt.fallback && (book.UUID != "" || book.HasCover || book.ISBN != nil) {
+				t.Errorf("fallback values: uuid %q, has_cover %v, isbn %v", book.UUID, book.HasCover, book.ISBN)
+			}
+		})
+	}
+}
+
+func TestBooksTableMissingRequiredColumns(t *testing.T) {
+	for _, drop := range [][]string{{"path"}, {"timestamp", "last_modified"}} {
+		lib := testutil.NewLibrary(t)
+		for _, column := range drop {
+			lib.Exec(t, "ALTER TABLE books DROP COLUMN "+column)
+		}
+		db := newTestDB(t, lib)
+		if err := db.Validate(); err == nil {
+			t.Errorf("dropped %v: Validate succeeded, want an error", drop)
+		}
+	}
+}
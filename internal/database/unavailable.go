@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// availabilityTimeout 检查数据库连接是否可用的超时时间
+const availabilityTimeout = 2 * time.Second
+
+// IsUnavailable 判断错误是否由数据库不可用（文件被卸载、无法打开、被其他进程锁定、连接已关闭）引起，而非查询本身的问题
+func (db *DB) IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if isConnectionError(err) {
+		return true
+	}
+
+	// 其他错误通过ping确认连接是否仍然可用
+	conn := db.conn()
+	if conn == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), availabilityTimeout)
+	defer cancel()
+	if err := conn.PingContext(ctx); err != nil {
+		return true
+	}
+	// ping使用的连接可能仍然有效，再检查文件是否还能读取
+	var version int
+	return conn.QueryRowContext(ctx, "PRAGMA schema_version").Scan(&version) != nil
+}
+
+// isConnectionError 判断错误本身是否表示连接或文件层面的故障
+func isConnectionError(err error) bool {
+	if errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code {
+		case sqlite3.ErrCantOpen, sqlite3.ErrIoErr, sqlite3.ErrNotADB, sqlite3.ErrBusy, sqlite3.ErrLocked:
+			return true
+		}
+	}
+	// database/sql关闭后返回未导出的errDBClosed
+	return strings.Contains(err.Error(), "sql: database is closed")
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"os"
+	"time"
+
+	"github.com/ricci/calibre-opds-go/pkg/logger"
+)
+
+// Watch 每隔interval检查数据库文件是否被替换（Calibre通过重命名原子替换metadata.db），
+// 替换后重新打开连接池，直到stop关闭（stop为nil时随进程一直运行）。原地写入不改变文件，SQLite自身能看到更新，无需重新打开
+func (db *DB) Watch(interval time.Duration, stop <-chan struct{}) {
+	current, err := os.Stat(db.path)
+	if err != nil {
+		logger.Warning.Printf("Failed to stat database %s: %v", db.path, err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(db.path)
+		if err != nil {
+			// 替换过程中文件可能短暂不存在，下次再检查
+			continue
+		}
+		if current != nil && os.SameFile(current, info) {
+			continue
+		}
+
+		if err := db.reopen(); err != nil {
+			logger.Error.Printf("Failed to reopen replaced database %s: %v", db.path, err)
+			continue
+		}
+		current = info
+		logger.Info.Printf("Database file %s was replaced, connection pool reopened", db.path)
+	}
+}
+
+// ModTime 返回数据库文件（包括WAL文件）最近的修改时间，无法读取时返回零值
+func (db *DB) ModTime() time.Time {
+	var latest time.Time
+	for _, path := range []string{db.path, db.path + "-wal"} {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// stalePoolGrace 替换连接池后延迟关闭旧连接池的时间：刚取得旧连接池但尚未开始查询的请求在此期间仍可使用，
+// 已开始的查询由Close等待完成
+var stalePoolGrace = 30 * time.Second
+
+// reopen 打开新的连接池并替换当前连接池；旧连接池延迟stalePoolGrace后关闭
+func (db *DB) reopen() error {
+	conn, err := openPool(db.path)
+	if err != nil {
+		return err
+	}
+	if err := checkRequiredTables(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	s, err := loadSchema(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	db.schema.Store(s)
+	if old := db.pool.Swap(conn); old != nil {
+		time.AfterFunc(stalePoolGrace, func() { old.Close() })
+	}
+	return nil
+}
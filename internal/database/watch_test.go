@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+// bookTitle 返回书籍1的标题
+func bookTitle(t *testing.T, db *DB) string {
+	t.Helper()
+	book, err := db.GetBookDetailContext(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book == nil {
+		t.Fatal("book 1 not found")
+	}
+	return book.Title
+}
+
+func TestWatchReopensReplacedDatabase(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Old", Authors: []string{"Author"}})
+
+	db, err := NewDB(lib.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if got := bookTitle(t, db); got != "Old" {
+		t.Fatalf("title = %q, want Old", got)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go db.Watch(10*time.Millisecond, stop)
+
+	// 与Calibre一样，先写好新文件再通过重命名原子替换
+	replacement := testutil.NewLibraryAt(t, filepath.Join(t.TempDir(), "new"))
+	replacement.AddBook(t, testutil.Book{Title: "New", Authors: []string{"Author"}})
+	stale := db.conn()
+	if err := os.Rename(replacement.DBPath, lib.DBPath); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for bookTitle(t, db) != "New" {
+		if time.Now().After(deadline) {
+			t.Fatal("replaced database was not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 替换前取得旧连接池的请求在宽限期内仍能查询
+	var count int
+	if err := stale.QueryRow("SELECT COUNT(*) FROM books").Scan(&count); err != nil {
+		t.Errorf("query on the previous pool failed: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"strings"
 	"unicode/utf8"
 
+	"golang.org/x/net/html"
 	"golang.org/x/text/encoding/simplifiedchinese"
 	"golang.org/x/text/encoding/traditionalchinese"
 )
@@ -37,6 +38,11 @@ func ConvertToUTF8(text string) string {
 	return text
 }
 
+// HasEncodingIssue 检查文本是否不是有效的UTF-8或包含乱码
+func HasEncodingIssue(text string) bool {
+	return !utf8.ValidString(text) || hasGarbledChars(text)
+}
+
 // hasGarbledChars 检查是否包含乱码特征
 func hasGarbledChars(text string) bool {
 	return strings.Contains(text, "�") || strings.Contains(text, "□")
@@ -55,3 +61,42 @@ func SafeConvert(text interface{}) string {
 
 	return ConvertToUTF8(str)
 }
+
+// HTMLToText 提取HTML中的可见文本，丢弃脚本、样式等内容
+func HTMLToText(src string) string {
+	z := html.NewTokenizer(strings.NewReader(src))
+	var sb strings.Builder
+	skip := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(sb.String())
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style", "head", "title":
+				skip++
+			case "p", "div", "br", "h1", "h2", "h3", "h4", "h5", "h6", "li":
+				sb.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style", "head", "title":
+				if skip > 0 {
+					skip--
+				}
+			}
+		case html.TextToken:
+			if skip == 0 {
+				text := strings.Join(strings.Fields(string(z.Text())), " ")
+				if text != "" {
+					if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+						sb.WriteString(" ")
+					}
+					sb.WriteString(text)
+				}
+			}
+		}
+	}
+}
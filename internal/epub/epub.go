@@ -0,0 +1,189 @@
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/ricci/calibre-opds-go/internal/encoding"
+	"github.com/ricci/calibre-opds-go/internal/opf"
+)
+
+// maxEntrySize 读取单个压缩包条目的最大字节数，防止压缩炸弹
+const maxEntrySize = 32 << 20
+
+// Book 打开的EPUB文件
+type Book struct {
+	zip     *zip.ReadCloser
+	opfPath string
+	pkg     *opf.Package
+}
+
+// container META-INF/container.xml
+type container struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// Open 打开EPUB文件并解析其OPF
+func Open(filename string) (*Book, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open epub: %w", err)
+	}
+
+	book := &Book{zip: zr}
+	if err := book.loadPackage(); err != nil {
+		zr.Close()
+		return nil, err
+	}
+	return book, nil
+}
+
+// Close 关闭EPUB文件
+func (b *Book) Close() error {
+	return b.zip.Close()
+}
+
+// Package 返回EPUB的OPF文档
+func (b *Book) Package() *opf.Package {
+	return b.pkg
+}
+
+// loadPackage 通过container.xml定位并解析OPF
+func (b *Book) loadPackage() error {
+	data, err := b.ReadFile("META-INF/container.xml")
+	if err != nil {
+		return fmt.Errorf("failed to read container.xml: %w", err)
+	}
+
+	var c container
+	if err := xml.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("failed to parse container.xml: %w", err)
+	}
+	if len(c.Rootfiles) == 0 || c.Rootfiles[0].FullPath == "" {
+		return errors.New("no rootfile in container.xml")
+	}
+
+	b.opfPath = c.Rootfiles[0].FullPath
+	data, err = b.ReadFile(b.opfPath)
+	if err != nil {
+		return fmt.Errorf("failed to read opf: %w", err)
+	}
+
+	b.pkg, err = opf.Parse(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse opf: %w", err)
+	}
+	return nil
+}
+
+// ReadFile 读取压缩包中的文件
+func (b *Book) ReadFile(name string) ([]byte, error) {
+	rc, err := b.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(io.LimitReader(rc, maxEntrySize))
+}
+
+// Open 打开压缩包中的文件
+func (b *Book) Open(name string) (io.ReadCloser, error) {
+	for _, f := range b.zip.File {
+		if f.Name == name {
+			return f.Open()
+		}
+	}
+	return nil, fmt.Errorf("file %s not found in epub", name)
+}
+
+// ResolveHref 将OPF中的相对路径转换为压缩包内的路径
+func (b *Book) ResolveHref(href string) string {
+	if i := strings.IndexAny(href, "#?"); i >= 0 {
+		href = href[:i]
+	}
+	return path.Join(path.Dir(b.opfPath), href)
+}
+
+// CoverImage 查找封面图片，返回其在压缩包内的路径和MIME类型；
+// 依次按EPUB 3的cover-image属性、EPUB 2的<meta name="cover">和ID或路径中含cover的图片查找
+func (b *Book) CoverImage() (string, string, error) {
+	manifest := &b.pkg.Manifest
+	for _, item := range manifest.Items {
+		if strings.Contains(" "+item.Properties+" ", " cover-image ") {
+			return b.ResolveHref(item.Href), item.MediaType, nil
+		}
+	}
+
+	if id := b.pkg.Metadata.Meta("cover"); id != "" {
+		if item := manifest.Item(id); item != nil && strings.HasPrefix(item.MediaType, "image/") {
+			return b.ResolveHref(item.Href), item.MediaType, nil
+		}
+	}
+
+	for _, item := range manifest.Items {
+		if strings.HasPrefix(item.MediaType, "image/") &&
+			(strings.Contains(strings.ToLower(item.ID), "cover") || strings.Contains(strings.ToLower(item.Href), "cover")) {
+			return b.ResolveHref(item.Href), item.MediaType, nil
+		}
+	}
+	return "", "", errors.New("no cover image in epub")
+}
+
+// SpineItems 按阅读顺序返回正文文件在压缩包内的路径
+func (b *Book) SpineItems() []string {
+	var items []string
+	for _, ref := range b.pkg.Spine.ItemRefs {
+		if item := b.pkg.Manifest.Item(ref.IDRef); item != nil {
+			items = append(items, b.ResolveHref(item.Href))
+		}
+	}
+	return items
+}
+
+// PreviewText 按阅读顺序提取正文纯文本，最多返回maxBytes字节，第二个返回值表示是否被截断
+func (b *Book) PreviewText(maxBytes int) (string, bool, error) {
+	var sb strings.Builder
+	for _, item := range b.SpineItems() {
+		data, err := b.ReadFile(item)
+		if err != nil {
+			return "", false, err
+		}
+
+		text := encoding.HTMLToText(string(data))
+		if text == "" {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(text)
+
+		if sb.Len() >= maxBytes {
+			return truncateUTF8(sb.String(), maxBytes), true, nil
+		}
+	}
+	return sb.String(), false, nil
+}
+
+// truncateUTF8 按字节截断字符串，不切断多字节字符
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	for maxBytes > 0 && !isRuneStart(s[maxBytes]) {
+		maxBytes--
+	}
+	return s[:maxBytes]
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
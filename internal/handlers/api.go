@@ -1,30 +1,268 @@
 package handlers
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/database"
+	"github.com/ricci/calibre-opds-go/internal/encoding"
+	"github.com/ricci/calibre-opds-go/internal/epub"
+	"github.com/ricci/calibre-opds-go/internal/opf"
+	"github.com/ricci/calibre-opds-go/pkg/logger"
 )
 
-// APIBooks REST API书籍列表
+// APIBooks REST API书籍列表，支持与OPDS书籍列表相同的author、series、tag过滤
 func (h *Handler) APIBooks(c *gin.Context) {
 	search := c.Query("search")
-	limit := getIntParam(c, "limit", 20, 100)
+	author := c.Query("author")
+	series := c.Query("series")
+	tags := getTagsParam(c)
+	limit := getLimitParam(c, defaultBooksPageSize, maxPageSize)
 	offset := getIntParam(c, "offset", 0, 0)
 
-	books, err := h.db.GetBooks(limit, offset, search)
+	hasCover := getBoolParam(c, "has_cover")
+	filter := database.BookFilter{
+		Search:   search,
+		Series:   series,
+		Tags:     tags,
+		HasCover: hasCover,
+	}
+	if author != "" {
+		filter.Authors = []string{author}
+	}
+
+	totalBooks, err := h.db.GetBooksCountFilteredContext(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get book count"})
+		return
+	}
+
+	// 分页信息同时通过响应头提供，便于通用HTTP客户端翻页
+	params := url.Values{}
+	if search != "" {
+		params.Set("search", search)
+	}
+	if author != "" {
+		params.Set("author", author)
+	}
+	if series != "" {
+		params.Set("series", series)
+	}
+	for _, tag := range tags {
+		params.Add("tag", tag)
+	}
+	if hasCover != nil {
+		params.Set("has_cover", boolParam(*hasCover))
+	}
+	if link := paginationLinks(h.baseURL(c)+"/api/books", params, limit, offset, totalBooks); link != "" {
+		c.Header("Link", link)
+	}
+	c.Header("X-Total-Count", strconv.Itoa(totalBooks))
+
+	h.streamBooks(c, limit, offset, totalBooks, filter)
+}
+
+// streamBooks 边查询边输出书籍列表JSON，输出与gin.H{"books", "limit", "offset", "total", "count", "has_more"}相同，
+// 但不在内存中保留整个列表；total为符合条件的书籍总数，count为本页书籍数
+func (h *Handler) streamBooks(c *gin.Context, limit, offset, total int, filter database.BookFilter) {
+	w := c.Writer
+	enc := json.NewEncoder(w)
+	count := 0
+
+	begin := func() {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, `{"books":[`)
+	}
+
+	err := h.db.StreamBooksFilteredContext(c.Request.Context(), limit, offset, filter, func(book *database.Book) error {
+		if count == 0 {
+			begin()
+		} else {
+			io.WriteString(w, ",")
+		}
+		count++
+
+		h.markNewBooks(book)
+		return enc.Encode(book)
+	})
+	if err != nil {
+		if count == 0 {
+			c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get books"})
+			return
+		}
+		// 响应已经开始输出，客户端只能得到不完整的JSON
+		logger.Error.Printf("Failed to stream books: %v", err)
+		c.Abort()
+		return
+	}
+
+	if count == 0 {
+		begin()
+	}
+	fmt.Fprintf(w, `],"limit":%d,"offset":%d,"total":%d,"count":%d,"has_more":%t}`,
+		limit, offset, total, count, offset+count < total)
+}
+
+// bookPtrs 返回指向切片中各书籍的指针
+func bookPtrs(books []database.Book) []*database.Book {
+	ptrs := make([]*database.Book, len(books))
+	for i := range books {
+		ptrs[i] = &books[i]
+	}
+	return ptrs
+}
+
+// paginationLinks 生成RFC 8288格式的分页Link响应头，包含next、prev和last
+func paginationLinks(base string, params url.Values, limit, offset, total int) string {
+	if limit <= 0 {
+		return ""
+	}
+
+	link := func(rel string, pageOffset int) string {
+		pageParams := url.Values{}
+		for key, values := range params {
+			pageParams[key] = values
+		}
+		pageParams.Set("limit", strconv.Itoa(limit))
+		pageParams.Set("offset", strconv.Itoa(pageOffset))
+		return fmt.Sprintf("<%s?%s>; rel=\"%s\"", base, pageParams.Encode(), rel)
+	}
+
+	var links []string
+	if offset+limit < total {
+		links = append(links, link("next", offset+limit))
+	}
+	if offset > 0 {
+		prevOffset := offset - limit
+		if prevOffset < 0 {
+			prevOffset = 0
+		}
+		links = append(links, link("prev", prevOffset))
+	}
+	if total > 0 {
+		links = append(links, link("last", (total-1)/limit*limit))
+	}
+
+	return strings.Join(links, ", ")
+}
+
+// bookSearchRequest 复杂搜索请求体
+type bookSearchRequest struct {
+	Search  string   `json:"search"`
+	Authors []string `json:"authors"`
+	Series  string   `json:"series"`
+	Tags    []string `json:"tags"`
+	PubDate *struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	} `json:"pubdate"`
+	Rating *struct {
+		Min *int `json:"min"`
+		Max *int `json:"max"`
+	} `json:"rating"`
+	HasCover *bool  `json:"has_cover"`
+	Sort     string `json:"sort"`
+	Order    string `json:"order"`
+	Limit    int    `json:"limit"`
+	Offset   int    `json:"offset"`
+}
+
+// toFilter 校验请求体并转换为数据库过滤条件
+func (r *bookSearchRequest) toFilter() (database.BookFilter, error) {
+	filter := database.BookFilter{
+		Search:   r.Search,
+		Authors:  r.Authors,
+		Series:   r.Series,
+		Tags:     r.Tags,
+		HasCover: r.HasCover,
+		Sort:     r.Sort,
+		Order:    r.Order,
+	}
+
+	if r.PubDate != nil {
+		for _, date := range []string{r.PubDate.From, r.PubDate.To} {
+			if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+				return filter, fmt.Errorf("invalid pubdate %q, expected YYYY-MM-DD", date)
+			}
+		}
+		filter.PubDateFrom = r.PubDate.From
+		filter.PubDateTo = r.PubDate.To
+	}
+
+	if r.Rating != nil {
+		for _, rating := range []*int{r.Rating.Min, r.Rating.Max} {
+			if rating != nil && (*rating < 0 || *rating > 10) {
+				return filter, fmt.Errorf("invalid rating %d, expected 0-10", *rating)
+			}
+		}
+		filter.MinRating = r.Rating.Min
+		filter.MaxRating = r.Rating.Max
+	}
+
+	if r.Sort != "" && !database.IsValidSort(r.Sort) {
+		return filter, fmt.Errorf("invalid sort %q, expected one of %s", r.Sort, strings.Join(database.SortFields(), ", "))
+	}
+	if r.Order != "" && r.Order != "asc" && r.Order != "desc" {
+		return filter, fmt.Errorf("invalid order %q, expected asc or desc", r.Order)
+	}
+	if r.Limit < 0 || r.Offset < 0 {
+		return filter, fmt.Errorf("limit and offset must not be negative")
+	}
+
+	return filter, nil
+}
+
+// APISearchBooks 使用JSON请求体进行复杂条件搜索
+func (h *Handler) APISearchBooks(c *gin.Context) {
+	var req bookSearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(bodyErrorStatus(err), gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	filter, err := req.toFilter()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultBooksPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	books, err := h.db.GetBooksFilteredContext(c.Request.Context(), limit, req.Offset, filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get books"})
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get books"})
 		return
 	}
 
+	total, err := h.db.GetBooksCountFilteredContext(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get book count"})
+		return
+	}
+	h.markNewBooks(bookPtrs(books)...)
+
 	c.JSON(http.StatusOK, gin.H{
-		"books":  books,
-		"total":  len(books),
+		"books":  emptyIfNil(books),
+		"total":  total,
 		"limit":  limit,
-		"offset": offset,
+		"offset": req.Offset,
 	})
 }
 
@@ -36,9 +274,250 @@ func (h *Handler) APIBookDetail(c *gin.Context) {
 		return
 	}
 
-	book, err := h.db.GetBookDetail(bookID)
+	book, err := h.db.GetBookDetailContext(c.Request.Context(), bookID)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get book"})
+		return
+	}
+	if book == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+	h.applyOPFFallback(h.booksPath(c), book)
+	h.markNewBooks(book)
+
+	// include_cover=true时内嵌封面缩略图，没有封面时省略
+	var coverURI string
+	if include := getBoolParam(c, "include_cover"); include != nil && *include {
+		coverURI = h.bookCoverDataURI(h.booksPath(c), book)
+	}
+
+	if c.Query("verbose") == "1" {
+		c.JSON(http.StatusOK, struct {
+			verboseBook
+			CoverDataURI string `json:"cover_data_uri,omitempty"`
+		}{newVerboseBook(book), coverURI})
+		return
+	}
+	c.JSON(http.StatusOK, struct {
+		*database.Book
+		CoverDataURI string `json:"cover_data_uri,omitempty"`
+	}{book, coverURI})
+}
+
+// bookCoverDataURI 返回书籍封面缩略图的data URI，没有封面或读取失败时返回空字符串
+func (h *Handler) bookCoverDataURI(root string, book *database.Book) string {
+	coverPath, _ := h.findBookCover(root, book)
+	if coverPath == "" {
+		return ""
+	}
+	uri, err := coverDataURI(coverPath, h.config.CoverAspect, h.config.CoverBackground)
+	if err != nil {
+		logger.Warning.Printf("Failed to build cover thumbnail for book %d: %v", book.ID, err)
+		return ""
+	}
+	return uri
+}
+
+// verboseBook 书籍详情的完整输出，缺失的字段以null或空数组表示而不省略
+type verboseBook struct {
+	ID           int               `json:"id"`
+	Title        string            `json:"title"`
+	AuthorSort   string            `json:"author_sort"`
+	Path         string            `json:"path"`
+	SeriesIndex  *float64          `json:"series_index"`
+	ISBN         *string           `json:"isbn"`
+	PubDate      *string           `json:"pubdate"`
+	LastModified time.Time         `json:"last_modified"`
+	Timestamp    time.Time         `json:"timestamp"`
+	HasCover     bool              `json:"has_cover"`
+	UUID         string            `json:"uuid"`
+	Comments     *string           `json:"comments"`
+	Rating       *int              `json:"rating"`
+	IsNew        bool              `json:"is_new"`
+	Authors      []database.Author `json:"authors"`
+	Tags         []string          `json:"tags"`
+	Languages    []string          `json:"languages"`
+	Series       *verboseSeries    `json:"series"`
+	Formats      []database.Format `json:"formats"`
+	Notes        []database.Note   `json:"notes"`
+	Identifiers  map[string]string `json:"identifiers"`
+}
+
+// verboseSeries 系列的完整输出
+type verboseSeries struct {
+	Name  string   `json:"name"`
+	Sort  string   `json:"sort"`
+	Index *float64 `json:"index"`
+}
+
+// newVerboseBook 将书籍转换为完整输出
+func newVerboseBook(book *database.Book) verboseBook {
+	v := verboseBook{
+		ID:           book.ID,
+		Title:        book.Title,
+		AuthorSort:   book.AuthorSort,
+		Path:         book.Path,
+		SeriesIndex:  book.SeriesIndex,
+		ISBN:         book.ISBN,
+		PubDate:      book.PubDate,
+		LastModified: book.LastModified,
+		Timestamp:    book.Timestamp,
+		HasCover:     book.HasCover,
+		UUID:         book.UUID,
+		Rating:       book.Rating,
+		IsNew:        book.IsNew,
+		Authors:      book.Authors,
+		Tags:         book.Tags,
+		Languages:    book.Languages,
+		Formats:      book.Formats,
+		Notes:        book.Notes,
+		Identifiers:  book.Identifiers,
+	}
+
+	if book.Comments != "" {
+		v.Comments = &book.Comments
+	}
+	if book.Series != nil {
+		v.Series = &verboseSeries{
+			Name:  book.Series.Name,
+			Sort:  book.Series.Sort,
+			Index: book.Series.Index,
+		}
+	}
+	if v.Authors == nil {
+		v.Authors = []database.Author{}
+	}
+	if v.Tags == nil {
+		v.Tags = []string{}
+	}
+	if v.Languages == nil {
+		v.Languages = []string{}
+	}
+	if v.Formats == nil {
+		v.Formats = []database.Format{}
+	}
+	if v.Notes == nil {
+		v.Notes = []database.Note{}
+	}
+
+	return v
+}
+
+// 试读预览大小（字节）
+const (
+	defaultPreviewSize = 8 << 10
+	maxPreviewSize     = 64 << 10
+)
+
+// APIBookSimilar 按共同标签推荐相似书籍
+func (h *Handler) APIBookSimilar(c *gin.Context) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+	limit := getLimitParam(c, 10, maxPageSize)
+
+	book, err := h.db.GetBookDetailContext(c.Request.Context(), bookID)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get book"})
+		return
+	}
+	if book == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+
+	books, err := h.db.GetSimilarByTagsContext(c.Request.Context(), bookID, limit)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get similar books"})
+		return
+	}
+	if books == nil {
+		books = []database.Book{}
+	}
+	h.markNewBooks(bookPtrs(books)...)
+
+	c.JSON(http.StatusOK, gin.H{"books": books})
+}
+
+// APIRandom 随机挑选的书籍，count参数指定数量
+func (h *Handler) APIRandom(c *gin.Context) {
+	books, err := h.db.GetRandomBooksContext(c.Request.Context(), getRandomCount(c))
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get books"})
+		return
+	}
+	if books == nil {
+		books = []database.Book{}
+	}
+	h.markNewBooks(bookPtrs(books)...)
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"books": books})
+}
+
+// APIBookOPF 以metadata.opf格式导出书籍元数据
+func (h *Handler) APIBookOPF(c *gin.Context) {
+	if data, ok := h.bookOPF(c); ok {
+		c.Data(http.StatusOK, "application/oebps-package+xml;charset=utf-8", data)
+	}
+}
+
+// DownloadBookOPF 以附件形式下载书籍的metadata.opf
+func (h *Handler) DownloadBookOPF(c *gin.Context) {
+	if data, ok := h.bookOPF(c); ok {
+		c.Header("Content-Disposition", `attachment; filename="metadata.opf"`)
+		c.Data(http.StatusOK, "application/oebps-package+xml;charset=utf-8", data)
+	}
+}
+
+// bookOPF 生成书籍的metadata.opf，失败时写入错误响应并返回false
+func (h *Handler) bookOPF(c *gin.Context) ([]byte, bool) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return nil, false
+	}
+
+	book, err := h.db.GetBookDetailContext(c.Request.Context(), bookID)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get book"})
+		return nil, false
+	}
+	if book == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return nil, false
+	}
+	root := h.booksPath(c)
+	h.applyOPFFallback(root, book)
+
+	// 封面引用使用实际的文件名，封面可能是PNG等格式
+	var coverFile string
+	if coverPath, _ := h.findBookCover(root, book); coverPath != "" {
+		coverFile = filepath.Base(coverPath)
+	}
+
+	data, err := opf.Export(book, book.Identifiers, coverFile)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate OPF"})
+		return nil, false
+	}
+	return data, true
+}
+
+// APIBookPreview 从EPUB中提取开头部分正文作为试读内容
+func (h *Handler) APIBookPreview(c *gin.Context) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	book, err := h.db.GetBookDetailContext(c.Request.Context(), bookID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get book"})
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get book"})
 		return
 	}
 	if book == nil {
@@ -46,24 +525,200 @@ func (h *Handler) APIBookDetail(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, book)
+	format := findFormat(book, "EPUB")
+	if format == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Preview is only available for EPUB books"})
+		return
+	}
+
+	filePath := findBookFile(bookDir(h.booksPath(c), book), format)
+	if filePath == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	epubBook, err := epub.Open(filePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open EPUB"})
+		return
+	}
+	defer epubBook.Close()
+
+	maxSize := getIntParam(c, "size", defaultPreviewSize, maxPreviewSize)
+	text, truncated, err := epubBook.PreviewText(maxSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extract preview"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":        book.ID,
+		"title":     book.Title,
+		"format":    format.Format,
+		"text":      text,
+		"size":      len(text),
+		"truncated": truncated,
+	})
+}
+
+// APISearchSuggest 搜索自动补全建议
+func (h *Handler) APISearchSuggest(c *gin.Context) {
+	query := c.Query("q")
+	limit := getLimitParam(c, 10, 50)
+
+	if query == "" {
+		c.JSON(http.StatusOK, gin.H{"query": query, "suggestions": []database.Suggestion{}})
+		return
+	}
+
+	suggestions, err := h.db.GetSearchSuggestionsContext(c.Request.Context(), query, limit)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get suggestions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":       query,
+		"suggestions": suggestions,
+	})
+}
+
+// APIIncompleteBooks 缺少封面、格式或作者的书籍列表
+func (h *Handler) APIIncompleteBooks(c *gin.Context) {
+	limit := getLimitParam(c, defaultBooksPageSize, maxPageSize)
+	offset := getIntParam(c, "offset", 0, 0)
+
+	books, err := h.db.GetIncompleteBooksContext(c.Request.Context(), limit, offset)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get incomplete books"})
+		return
+	}
+
+	total, err := h.db.GetIncompleteBooksCountContext(c.Request.Context())
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get incomplete book count"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"books":  books,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// maxTaxonomyItems /api/taxonomy 每类最多返回的条目数
+const maxTaxonomyItems = 10000
+
+// APITaxonomy 一次性返回所有标签、作者和系列及其书籍数量，供前端缓存
+func (h *Handler) APITaxonomy(c *gin.Context) {
+	tags, err := h.db.GetTagsContext(c.Request.Context(), maxTaxonomyItems, 0, "name")
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get tags"})
+		return
+	}
+
+	authors, err := h.db.GetAuthorsContext(c.Request.Context(), maxTaxonomyItems, 0, "")
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get authors"})
+		return
+	}
+
+	seriesList, err := h.db.GetSeriesContext(c.Request.Context(), maxTaxonomyItems, 0)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get series"})
+		return
+	}
+
+	writeJSON(c, http.StatusOK, gin.H{
+		"tags":    emptyIfNil(tags),
+		"authors": emptyIfNil(authors),
+		"series":  emptyIfNil(seriesList),
+		"truncated": len(tags) == maxTaxonomyItems ||
+			len(authors) == maxTaxonomyItems ||
+			len(seriesList) == maxTaxonomyItems,
+	})
 }
 
 // APIStats REST API统计信息
 func (h *Handler) APIStats(c *gin.Context) {
-	stats, err := h.db.GetStats()
+	stats, err := h.db.GetStatsContext(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get stats"})
 		return
 	}
 
 	c.JSON(http.StatusOK, stats)
 }
 
+// APITags 标签列表，支持sort=name|count|recent
+func (h *Handler) APITags(c *gin.Context) {
+	limit := getLimitParam(c, defaultListPageSize, maxPageSize)
+	offset := getIntParam(c, "offset", 0, 0)
+	tagSort := c.DefaultQuery("sort", "name")
+	if !database.IsValidTagSort(tagSort) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort"})
+		return
+	}
+
+	tags, err := h.db.GetTagsContext(c.Request.Context(), limit, offset, tagSort)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get tags"})
+		return
+	}
+	if tags == nil {
+		tags = []database.Tag{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags, "limit": limit, "offset": offset, "sort": tagSort})
+}
+
+// APIFormats 书库中所有格式及其书籍数量和文件总大小
+func (h *Handler) APIFormats(c *gin.Context) {
+	formats, err := h.db.GetFormatsContext(c.Request.Context())
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get formats"})
+		return
+	}
+	if formats == nil {
+		formats = []database.FormatInfo{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"formats": formats})
+}
+
+// APIChangelog 最近新增和修改的书籍，按变更时间倒序
+func (h *Handler) APIChangelog(c *gin.Context) {
+	limit := getLimitParam(c, defaultBooksPageSize, maxPageSize)
+	offset := getIntParam(c, "offset", 0, 0)
+
+	total, err := h.db.GetBooksCountContext(c.Request.Context(), "")
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get book count"})
+		return
+	}
+
+	changes, err := h.db.GetChangelogContext(c.Request.Context(), limit, offset)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get changelog"})
+		return
+	}
+	for i := range changes {
+		h.markNewBooks(&changes[i].Book)
+	}
+
+	if link := paginationLinks(h.baseURL(c)+"/api/changelog", url.Values{}, limit, offset, total); link != "" {
+		c.Header("Link", link)
+	}
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.JSON(http.StatusOK, gin.H{"changes": changes, "limit": limit, "offset": offset, "total": total})
+}
+
 // APIHealth 健康检查
 func (h *Handler) APIHealth(c *gin.Context) {
 	// 测试数据库连接
-	count, err := h.db.GetBooksCount("")
+	count, err := h.db.GetBooksCountContext(c.Request.Context(), "")
 	if err != nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"status":    "unhealthy",
@@ -94,13 +749,52 @@ func (h *Handler) APIConnectionStats(c *gin.Context) {
 	c.JSON(http.StatusOK, stats)
 }
 
-// APIDiagnose 诊断信息
+// APIConfig 公开的只读配置，供前端确定默认下载格式、分页大小和已启用的功能；不包含路径、令牌等敏感配置
+func (h *Handler) APIConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"preferred_formats": h.config.PreferredFormats,
+		"page_size": gin.H{
+			"books":   defaultBooksPageSize,
+			"lists":   defaultListPageSize,
+			"maximum": maxPageSize,
+		},
+		"features": gin.H{
+			"opf_fallback":              h.config.OPFFallback,
+			"extra_acquisition_rels":    h.config.ExtraAcquisitionRels,
+			"entry_content":             h.config.EntryContent,
+			"catalog_acquisition":       h.config.CatalogAcquisition,
+			"entry_max_authors":         h.config.EntryMaxAuthors,
+			"fts_search":                h.config.FTSSearch,
+			"author_aliases":            len(h.config.AuthorAliases) > 0,
+			"author_collapse_threshold": h.config.AuthorCollapseThreshold,
+			"new_window_seconds":        int64(h.config.NewWindow / time.Second),
+			"client_profiles":           h.config.ClientProfiles,
+			"empty_library_hint":        h.config.EmptyLibraryHint,
+			"crawlable_page_size":       h.config.CrawlablePageSize,
+			"default_feed_format":       h.config.DefaultFeedFormat,
+			"language":                  h.config.Language,
+			"cover_aspect":              h.config.CoverAspect,
+			"download_compression":      h.config.DownloadCompression,
+			"library_switching":         len(h.config.BooksRoots) > 0,
+			"reading_progress":          h.progress != nil,
+			"metrics":                   h.config.MetricsEnabled,
+			"tls":                       h.config.TLSEnabled(),
+		},
+	})
+}
+
+// APIDiagnose 诊断信息；integrity_check=1会对整个数据库执行完整性检查，开销大，需要管理令牌
 func (h *Handler) APIDiagnose(c *gin.Context) {
+	runIntegrity := c.Query("integrity_check") == "1"
+	if runIntegrity && !h.authorizeAdmin(c) {
+		return
+	}
+
 	// 获取统计信息
-	stats, _ := h.db.GetStats()
+	stats, _ := h.db.GetStatsContext(c.Request.Context())
 
 	// 获取样本书籍
-	sampleBooks, _ := h.db.GetBooks(3, 0, "")
+	sampleBooks, _ := h.db.GetBooksContext(c.Request.Context(), 3, 0, "")
 
 	diagnosis := gin.H{
 		"application": gin.H{
@@ -115,8 +809,137 @@ func (h *Handler) APIDiagnose(c *gin.Context) {
 			},
 			"sample_books": sampleBooks,
 		},
+		"sqlite":    h.sqliteDiagnosis(runIntegrity),
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 	}
 
 	c.JSON(http.StatusOK, diagnosis)
 }
+
+// sqliteDiagnosis SQLite存储信息和完整性检查结果；完整性检查开销大，只在runIntegrity时执行，否则返回上次的结果
+func (h *Handler) sqliteDiagnosis(runIntegrity bool) gin.H {
+	result := gin.H{}
+
+	if info, err := h.db.SQLiteInfo(); err != nil {
+		result["error"] = err.Error()
+	} else {
+		result["page_size"] = info.PageSize
+		result["page_count"] = info.PageCount
+		result["journal_mode"] = info.JournalMode
+		result["user_version"] = info.UserVersion
+		result["file_size"] = info.FileSize
+	}
+
+	if runIntegrity {
+		if h.integrityRunning.TryLock() {
+			integrity, err := h.db.IntegrityCheck()
+			h.integrityRunning.Unlock()
+			if err != nil {
+				result["integrity_check_error"] = err.Error()
+			} else {
+				h.integrity.Store(integrity)
+			}
+		} else {
+			result["integrity_check_error"] = "integrity check already running"
+		}
+	}
+	// 从未检查时为null，使用integrity_check=1执行检查
+	result["integrity_check"] = h.integrity.Load()
+
+	return result
+}
+
+// ValidationReport 书库校验报告
+type ValidationReport struct {
+	SchemaVersion  int      `json:"schema_version"`
+	TotalBooks     int      `json:"total_books"`
+	SampledBooks   int      `json:"sampled_books"`
+	MissingFiles   []string `json:"missing_files,omitempty"`
+	EncodingIssues []string `json:"encoding_issues,omitempty"`
+	Errors         []string `json:"errors,omitempty"`
+}
+
+// OK 校验是否全部通过
+func (r *ValidationReport) OK() bool {
+	return len(r.MissingFiles) == 0 && len(r.EncodingIssues) == 0 && len(r.Errors) == 0
+}
+
+// ValidateLibrary 校验书库：数据库结构、schema版本、抽样检查文件是否存在及编码问题
+func (h *Handler) ValidateLibrary(sampleSize int) *ValidationReport {
+	report := &ValidationReport{}
+	ctx := context.Background()
+
+	if err := h.db.Validate(); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+
+	version, err := h.db.SchemaVersion()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to read schema version: %v", err))
+	} else if version == 0 {
+		report.Errors = append(report.Errors, "schema version is 0, not a Calibre library database")
+	}
+	report.SchemaVersion = version
+
+	stats, err := h.db.GetStatsContext(ctx)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to get stats: %v", err))
+		return report
+	}
+	report.TotalBooks = stats.TotalBooks
+
+	// 抽样检查书籍
+	books, err := h.db.GetBooksContext(ctx, sampleSize, 0, "")
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to get sample books: %v", err))
+		return report
+	}
+	report.SampledBooks = len(books)
+
+	for i := range books {
+		book := &books[i]
+		if encoding.HasEncodingIssue(book.Title) {
+			report.EncodingIssues = append(report.EncodingIssues, fmt.Sprintf("book %d: title %q", book.ID, book.Title))
+		}
+		for _, author := range book.Authors {
+			if encoding.HasEncodingIssue(author.Name) {
+				report.EncodingIssues = append(report.EncodingIssues, fmt.Sprintf("book %d: author %q", book.ID, author.Name))
+			}
+		}
+		for j := range book.Formats {
+			if h.BookFilePath(book, &book.Formats[j]) == "" {
+				report.MissingFiles = append(report.MissingFiles, fmt.Sprintf("book %d: %s (%s)", book.ID, book.Formats[j].Format, book.Path))
+			}
+		}
+	}
+
+	return report
+}
+
+// writeJSON 输出JSON，客户端支持时使用gzip压缩
+func writeJSON(c *gin.Context, status int, obj interface{}) {
+	if !acceptsGzip(c) {
+		c.JSON(status, obj)
+		return
+	}
+
+	c.Header("Content-Encoding", "gzip")
+	c.Header("Vary", "Accept-Encoding")
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(status)
+
+	gz := gzip.NewWriter(c.Writer)
+	defer gz.Close()
+	if err := json.NewEncoder(gz).Encode(obj); err != nil {
+		c.Error(err)
+	}
+}
+
+// emptyIfNil 将nil切片转换为空切片，使JSON输出[]而不是null
+func emptyIfNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
@@ -0,0 +1,696 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/database"
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+// addBooks 添加n本带作者、系列、标签和格式的书籍
+func addBooks(t *testing.T, lib *testutil.Library, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		lib.AddBook(t, testutil.Book{
+			Title:       fmt.Sprintf("Book %02d", i),
+			Authors:     []string{fmt.Sprintf("Author %d", i%3)},
+			Series:      "Series",
+			SeriesIndex: float64(i),
+			Tags:        []string{"Fiction", fmt.Sprintf("Tag %d", i%2)},
+			Formats:     []string{"EPUB", "PDF"},
+			Comments:    fmt.Sprintf("<p>Comment %d</p>", i),
+			Language:    "eng",
+		})
+	}
+}
+
+// decodeJSON 把JSON解码为通用结构，便于比较
+func decodeJSON(t *testing.T, data []byte) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+	return v
+}
+
+func TestAPIBooksStreamMatchesBuffered(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	addBooks(t, lib, 7)
+	h, router := newTestServer(t, lib, nil)
+
+	for _, page := range []struct{ limit, offset int }{{3, 1}, {50, 0}, {5, 20}} {
+		rec := get(router, fmt.Sprintf("/api/books?limit=%d&offset=%d", page.limit, page.offset), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d", rec.Code)
+		}
+		streamed := decodeJSON(t, rec.Body.Bytes())
+
+		// 同样的查询先读入内存再整体编码
+		books, err := h.db.GetBooksFilteredContext(context.Background(), page.limit, page.offset, database.BookFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if books == nil {
+			books = []database.Book{}
+		}
+		for i := range books {
+			h.markNewBooks(&books[i])
+		}
+		data, err := json.Marshal(gin.H{
+			"books":    books,
+			"limit":    page.limit,
+			"offset":   page.offset,
+			"total":    7,
+			"count":    len(books),
+			"has_more": page.offset+len(books) < 7,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buffered := decodeJSON(t, data); !reflect.DeepEqual(streamed, buffered) {
+			t.Errorf("limit=%d offset=%d: streamed output differs\nstreamed: %s\nbuffered: %s",
+				page.limit, page.offset, rec.Body.Bytes(), data)
+		}
+	}
+}
+
+func TestAPIBookSimilar(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	dune := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Tags: []string{"SF"}})
+	lib.AddBook(t, testutil.Book{Title: "Hyperion", Authors: []string{"Dan Simmons"}, Tags: []string{"SF"}})
+	lib.AddBook(t, testutil.Book{Title: "Emma", Authors: []string{"Jane Austen"}, Tags: []string{"Romance"}})
+	_, router := newTestServer(t, lib, nil)
+
+	rec := get(router, fmt.Sprintf("/api/book/%d/similar", dune), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Books []database.Book `json:"books"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Books) != 1 || resp.Books[0].Title != "Hyperion" {
+		t.Errorf("similar = %+v", resp.Books)
+	}
+
+	if rec := get(router, "/api/book/999/similar", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("nonexistent book: status %d, want 404", rec.Code)
+	}
+}
+
+func TestAPIBooksTagFilter(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Tags: []string{"scifi", "hugo-winner"}})
+	lib.AddBook(t, testutil.Book{Title: "Hyperion", Authors: []string{"Dan Simmons"}, Tags: []string{"scifi", "hugo-winner"}})
+	lib.AddBook(t, testutil.Book{Title: "Solaris", Authors: []string{"Stanislaw Lem"}, Tags: []string{"scifi"}})
+	_, router := newTestServer(t, lib, nil)
+
+	for _, query := range []string{"tag=scifi&tag=hugo-winner", "tag=scifi,hugo-winner"} {
+		rec := get(router, "/api/books?limit=1&"+query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", query, rec.Code)
+		}
+		var resp struct {
+			Books []database.Book `json:"books"`
+			Total int             `json:"total"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Total != 2 || len(resp.Books) != 1 {
+			t.Errorf("%s: total %d, %d books", query, resp.Total, len(resp.Books))
+		}
+		link := rec.Header().Get("Link")
+		if !strings.Contains(link, "tag=scifi") || !strings.Contains(link, "tag=hugo-winner") {
+			t.Errorf("%s: Link does not keep both tags: %s", query, link)
+		}
+	}
+}
+
+func TestAPISearchSuggest(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}})
+	lib.AddBook(t, testutil.Book{Title: "Dune Messiah", Authors: []string{"Frank Herbert"}})
+	lib.AddBook(t, testutil.Book{Title: "Children of Dune", Authors: []string{"Frank Herbert"}})
+	lib.AddBook(t, testutil.Book{Title: "Hyperion", Authors: []string{"Dan Simmons"}})
+	for i := 0; i < 60; i++ {
+		lib.AddBook(t, testutil.Book{Title: fmt.Sprintf("Zed %02d", i), Authors: []string{"Zoe"}})
+	}
+	_, router := newTestServer(t, lib, nil)
+
+	suggest := func(query string) []database.Suggestion {
+		t.Helper()
+		rec := get(router, "/api/search/suggest?"+query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", query, rec.Code)
+		}
+		var resp struct {
+			Suggestions []database.Suggestion `json:"suggestions"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Suggestions
+	}
+
+	// 只匹配书名和作者名的前缀
+	var got []string
+	for _, s := range suggest("q=d") {
+		got = append(got, s.Type+":"+s.Text)
+	}
+	want := []string{"author:Dan Simmons", "title:Dune", "title:Dune Messiah"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("q=d: %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"q=Zed&limit=5", 5},
+		{"q=Zed", 10},
+		{"q=Zed&limit=100", 50},
+		{"q=Zed&limit=0", 1},
+		{"q=Zed&limit=-1", 1},
+	}
+	for _, tt := range tests {
+		if got := suggest(tt.query); len(got) != tt.want {
+			t.Errorf("%s: %d suggestions, want %d", tt.query, len(got), tt.want)
+		}
+	}
+}
+
+func TestValidateLibrary(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup 在打开数据库之前修改书库
+		setup          func(t *testing.T, lib *testutil.Library, id int)
+		ok             bool
+		errors         int
+		missingFiles   int
+		encodingIssues int
+	}{
+		{"good library", func(t *testing.T, lib *testutil.Library, id int) {}, true, 0, 0, 0},
+		{"missing file", func(t *testing.T, lib *testutil.Library, id int) {
+			if err := os.Remove(filepath.Join(lib.BookDir(t, id), "Dune - Frank Herbert.epub")); err != nil {
+				t.Fatal(err)
+			}
+		}, false, 0, 1, 0},
+		{"garbled title", func(t *testing.T, lib *testutil.Library, id int) {
+			lib.Exec(t, `UPDATE books SET title = 'Dune �' WHERE id = ?`, id)
+		}, false, 0, 0, 1},
+		{"not a Calibre database", func(t *testing.T, lib *testutil.Library, id int) {
+			lib.Exec(t, `PRAGMA user_version = 0`)
+		}, false, 1, 0, 0},
+		{"missing table", func(t *testing.T, lib *testutil.Library, id int) {
+			lib.Exec(t, `DROP TABLE series`)
+		}, false, 1, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lib := testutil.NewLibrary(t)
+			lib.Exec(t, `PRAGMA user_version = 26`)
+			id := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Formats: []string{"EPUB", "PDF"}})
+			lib.AddBook(t, testutil.Book{Title: "Emma", Authors: []string{"Jane Austen"}, Formats: []string{"EPUB"}})
+			tt.setup(t, lib, id)
+			h, _ := newTestServer(t, lib, nil)
+
+			report := h.ValidateLibrary(10)
+			if report.OK() != tt.ok || len(report.Errors) != tt.errors ||
+				len(report.MissingFiles) != tt.missingFiles || len(report.EncodingIssues) != tt.encodingIssues {
+				t.Errorf("report = %+v", report)
+			}
+			if tt.errors == 0 && (report.TotalBooks != 2 || report.SampledBooks != 2 || report.SchemaVersion != 26) {
+				t.Errorf("report = %+v", report)
+			}
+		})
+	}
+}
+
+func TestAPIConfigOmitsSecrets(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	keyPath := filepath.Join(t.TempDir(), "server.key")
+	_, router := newTestServer(t, lib, map[string]string{
+		"TLS_CERT_FILE":      filepath.Join(t.TempDir(), "server.crt"),
+		"TLS_KEY_FILE":       keyPath,
+		"ADMIN_TOKEN":        "admin-secret-token",
+		"PREFERRED_FORMATS":  "EPUB,PDF",
+		"OPDS_ENTRY_CONTENT": "true",
+	})
+
+	rec := get(router, "/api/config", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, secret := range []string{lib.DBPath, lib.Root, keyPath, "admin-secret-token"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("config exposes %q:\n%s", secret, body)
+		}
+	}
+
+	var got struct {
+		PreferredFormats []string               `json:"preferred_formats"`
+		PageSize         map[string]int         `json:"page_size"`
+		Features         map[string]interface{} `json:"features"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.PreferredFormats, []string{"EPUB", "PDF"}) {
+		t.Errorf("preferred_formats = %v", got.PreferredFormats)
+	}
+	if got.PageSize["books"] != defaultBooksPageSize || got.PageSize["maximum"] != maxPageSize {
+		t.Errorf("page_size = %v", got.PageSize)
+	}
+	for _, key := range []string{
+		"opf_fallback", "extra_acquisition_rels", "entry_content", "catalog_acquisition",
+		"entry_max_authors", "fts_search", "author_aliases", "author_collapse_threshold",
+		"new_window_seconds", "client_profiles", "empty_library_hint", "crawlable_page_size",
+		"default_feed_format", "language", "cover_aspect", "download_compression",
+		"library_switching", "reading_progress", "metrics", "tls",
+	} {
+		if _, ok := got.Features[key]; !ok {
+			t.Errorf("missing feature %q", key)
+		}
+	}
+	if got.Features["entry_content"] != true || got.Features["tls"] != true || got.Features["reading_progress"] != false {
+		t.Errorf("features = %v", got.Features)
+	}
+}
+
+func TestOPFFallback(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	missing := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}})
+	present := lib.AddBook(t, testutil.Book{Title: "Emma", Authors: []string{"Jane Austen"}, Comments: "From the database"})
+	const metadata = `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title>Dune</dc:title>
+    <dc:description>  From metadata.opf  </dc:description>
+    <dc:identifier opf:scheme="ISBN">9780441013593</dc:identifier>
+  </metadata>
+</package>`
+	lib.WriteFile(t, missing, "metadata.opf", []byte(metadata))
+	lib.WriteFile(t, present, "metadata.opf", []byte(metadata))
+
+	type result struct {
+		Comments string  `json:"comments"`
+		ISBN     *string `json:"isbn"`
+	}
+	detail := func(router http.Handler, id int) result {
+		t.Helper()
+		rec := get(router, fmt.Sprintf("/api/book/%d", id), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("book %d: status %d", id, rec.Code)
+		}
+		var r result
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		_, router := newTestServer(t, lib, nil)
+		if got := detail(router, missing); got.Comments != "" || (got.ISBN != nil && *got.ISBN != "") {
+			t.Errorf("fallback applied while disabled: %+v", got)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		_, router := newTestServer(t, lib, map[string]string{"OPF_FALLBACK": "true"})
+		got := detail(router, missing)
+		if got.Comments != "From metadata.opf" || got.ISBN == nil || *got.ISBN != "9780441013593" {
+			t.Errorf("missing metadata not filled: %+v", got)
+		}
+		// 数据库已有的简介不被覆盖
+		if got := detail(router, present); got.Comments != "From the database" {
+			t.Errorf("database comments replaced: %q", got.Comments)
+		}
+	})
+}
+
+func TestAPITaxonomy(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Series: "Dune", Tags: []string{"SF", "Classic"}})
+	lib.AddBook(t, testutil.Book{Title: "Dune Messiah", Authors: []string{"Frank Herbert"}, Series: "Dune", Tags: []string{"SF"}})
+	lib.AddBook(t, testutil.Book{Title: "Emma", Authors: []string{"Jane Austen"}, Tags: []string{"Classic"}})
+	_, router := newTestServer(t, lib, nil)
+
+	type item struct {
+		Name      string `json:"name"`
+		BookCount int    `json:"book_count"`
+	}
+	var got struct {
+		Tags      []item `json:"tags"`
+		Authors   []item `json:"authors"`
+		Series    []item `json:"series"`
+		Truncated bool   `json:"truncated"`
+	}
+
+	rec := get(router, "/api/taxonomy", map[string]string{"Accept-Encoding": "gzip"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("response is not gzipped")
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(gz).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	byName := func(items []item) map[string]int {
+		counts := map[string]int{}
+		for _, it := range items {
+			counts[it.Name] = it.BookCount
+		}
+		return counts
+	}
+	if want := map[string]int{"SF": 2, "Classic": 2}; !reflect.DeepEqual(byName(got.Tags), want) {
+		t.Errorf("tags = %v, want %v", got.Tags, want)
+	}
+	if want := map[string]int{"Frank Herbert": 2, "Jane Austen": 1}; !reflect.DeepEqual(byName(got.Authors), want) {
+		t.Errorf("authors = %v, want %v", got.Authors, want)
+	}
+	if want := map[string]int{"Dune": 2}; !reflect.DeepEqual(byName(got.Series), want) {
+		t.Errorf("series = %v, want %v", got.Series, want)
+	}
+	if got.Truncated {
+		t.Error("truncated = true for a small library")
+	}
+}
+
+// searchResult POST搜索的响应
+type searchResult struct {
+	Books []struct {
+		Title string `json:"title"`
+	} `json:"books"`
+	Total int `json:"total"`
+	Limit int `json:"limit"`
+}
+
+// titles 返回搜索结果中的书名
+func (r searchResult) titles() []string {
+	titles := []string{}
+	for _, book := range r.Books {
+		titles = append(titles, book.Title)
+	}
+	return titles
+}
+
+func TestAPISearchBooksBody(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	dune := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Tags: []string{"SF"}, PubDate: time.Date(1965, 8, 1, 0, 0, 0, 0, time.UTC)})
+	lib.SetCover(t, dune, []byte("cover"))
+	lib.AddBook(t, testutil.Book{Title: "Children of Dune", Authors: []string{"Frank Herbert"}, Tags: []string{"SF"}, PubDate: time.Date(1976, 4, 1, 0, 0, 0, 0, time.UTC)})
+	lib.AddBook(t, testutil.Book{Title: "Emma", Authors: []string{"Jane Austen"}, Tags: []string{"Classic"}, PubDate: time.Date(1815, 12, 23, 0, 0, 0, 0, time.UTC)})
+	_, router := newTestServer(t, lib, nil)
+
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{`{"tags": ["SF"], "sort": "title", "order": "asc"}`, []string{"Children of Dune", "Dune"}},
+		{`{"search": "dune", "has_cover": true}`, []string{"Dune"}},
+		{`{"pubdate": {"from": "1900-01-01"}, "sort": "pubdate", "order": "desc"}`, []string{"Children of Dune", "Dune"}},
+		{`{}`, []string{"Children of Dune", "Dune", "Emma"}},
+	}
+	for _, tt := range tests {
+		rec := post(router, "/api/books/search", tt.body, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.body, rec.Code, rec.Body.String())
+		}
+		var got searchResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		titles := got.titles()
+		if tt.body == `{}` {
+			sort.Strings(titles)
+		}
+		if !reflect.DeepEqual(titles, tt.want) || got.Total != len(tt.want) {
+			t.Errorf("%s: titles %v (total %d), want %v", tt.body, titles, got.Total, tt.want)
+		}
+	}
+
+	for _, body := range []string{
+		`{"tags": `,
+		`{"tags": "SF"}`,
+		`{"pubdate": {"from": "1965/08/01"}}`,
+		`{"rating": {"min": 11}}`,
+		`{"sort": "password"}`,
+		`{"order": "sideways"}`,
+		`{"limit": -1}`,
+	} {
+		rec := post(router, "/api/books/search", body, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), `"error"`) {
+			t.Errorf("%s: body %s has no error", body, rec.Body.String())
+		}
+	}
+}
+
+func TestAPIBooksHasCoverFilter(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	for _, title := range []string{"Dune", "Emma", "Ulysses"} {
+		id := lib.AddBook(t, testutil.Book{Title: title, Authors: []string{"A"}})
+		if title != "Emma" {
+			lib.SetCover(t, id, []byte("cover"))
+		}
+	}
+	_, router := newTestServer(t, lib, nil)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"has_cover=true", []string{"Dune", "Ulysses"}},
+		{"has_cover=1", []string{"Dune", "Ulysses"}},
+		{"has_cover=false", []string{"Emma"}},
+		{"has_cover=0", []string{"Emma"}},
+		{"", []string{"Dune", "Emma", "Ulysses"}},
+		// 无法解析的值与其他布尔参数一样按未设置处理
+		{"has_cover=maybe", []string{"Dune", "Emma", "Ulysses"}},
+	}
+	for _, tt := range tests {
+		rec := get(router, "/api/books?"+tt.query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.query, rec.Code)
+		}
+		var got searchResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		titles := got.titles()
+		sort.Strings(titles)
+		if !reflect.DeepEqual(titles, tt.want) || got.Total != len(tt.want) {
+			t.Errorf("%s: titles %v (total %d), want %v", tt.query, titles, got.Total, tt.want)
+		}
+	}
+}
+
+func TestAPIBookDetailVerbose(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	// 没有作者、标签、格式、简介、评分和系列的书籍
+	id := lib.AddBook(t, testutil.Book{Title: "Bare"})
+	_, router := newTestServer(t, lib, nil)
+
+	fields := func(target string) map[string]json.RawMessage {
+		t.Helper()
+		rec := get(router, target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", target, rec.Code)
+		}
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	verbose := fields(fmt.Sprintf("/api/book/%d?verbose=1", id))
+	want := map[string]string{
+		"comments":    "null",
+		"rating":      "null",
+		"series":      "null",
+		"is_new":      "false",
+		"authors":     "[]",
+		"tags":        "[]",
+		"languages":   "[]",
+		"formats":     "[]",
+		"notes":       "[]",
+		"identifiers": "{}",
+	}
+	for key, value := range want {
+		got, ok := verbose[key]
+		if !ok {
+			t.Errorf("verbose output is missing %q", key)
+			continue
+		}
+		if string(got) != value {
+			t.Errorf("%s = %s, want %s", key, got, value)
+		}
+	}
+
+	// 默认输出省略空字段
+	compact := fields(fmt.Sprintf("/api/book/%d", id))
+	for _, key := range []string{"comments", "rating", "series", "authors", "tags", "formats"} {
+		if _, ok := compact[key]; ok {
+			t.Errorf("compact output contains empty %q", key)
+		}
+	}
+}
+
+func TestAPIBooksPaginationHeaders(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	addBooks(t, lib, 7)
+	_, router := newTestServer(t, lib, nil)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"limit=3", `<http://example.com/api/books?limit=3&offset=3>; rel="next", ` +
+			`<http://example.com/api/books?limit=3&offset=6>; rel="last"`},
+		{"limit=3&offset=3", `<http://example.com/api/books?limit=3&offset=6>; rel="next", ` +
+			`<http://example.com/api/books?limit=3&offset=0>; rel="prev", ` +
+			`<http://example.com/api/books?limit=3&offset=6>; rel="last"`},
+		{"limit=3&offset=6", `<http://example.com/api/books?limit=3&offset=3>; rel="prev", ` +
+			`<http://example.com/api/books?limit=3&offset=6>; rel="last"`},
+		// 偏移不是整页时prev不小于0，过滤参数保留在链接中
+		{"limit=3&offset=2&search=Book", `<http://example.com/api/books?limit=3&offset=5&search=Book>; rel="next", ` +
+			`<http://example.com/api/books?limit=3&offset=0&search=Book>; rel="prev", ` +
+			`<http://example.com/api/books?limit=3&offset=6&search=Book>; rel="last"`},
+	}
+	for _, tt := range tests {
+		rec := get(router, "/api/books?"+tt.query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.query, rec.Code)
+		}
+		if got := rec.Header().Get("X-Total-Count"); got != "7" {
+			t.Errorf("%s: X-Total-Count = %q, want 7", tt.query, got)
+		}
+		if got := rec.Header().Get("Link"); got != tt.want {
+			t.Errorf("%s: Link =\n%s\nwant\n%s", tt.query, got, tt.want)
+		}
+	}
+
+	rec := get(router, "/api/books?search=nothing-matches", nil)
+	if rec.Header().Get("X-Total-Count") != "0" || rec.Header().Get("Link") != "" {
+		t.Errorf("empty result: X-Total-Count %q, Link %q", rec.Header().Get("X-Total-Count"), rec.Header().Get("Link"))
+	}
+}
+
+func TestAPIFormats(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+
+	// 空书库返回空数组而不是null
+	_, router := newTestServer(t, lib, nil)
+	if body := get(router, "/api/formats", nil).Body.String(); body != `{"formats":[]}` {
+		t.Errorf("empty library: %s", body)
+	}
+
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"A"}, Formats: []string{"EPUB", "PDF"}})
+	lib.AddBook(t, testutil.Book{Title: "Emma", Authors: []string{"A"}, Formats: []string{"EPUB", "MOBI"}})
+	lib.AddBook(t, testutil.Book{Title: "Ulysses", Authors: []string{"A"}, Formats: []string{"PDF"}})
+	rec := get(router, "/api/formats", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var got struct {
+		Formats []database.FormatInfo `json:"formats"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	// 按书籍数量倒序，数量相同时按格式名排序；大小为各文件uncompressed_size之和
+	want := []database.FormatInfo{
+		{Format: "EPUB", BookCount: 2, TotalSize: int64(len("Dune EPUB") + len("Emma EPUB"))},
+		{Format: "PDF", BookCount: 2, TotalSize: int64(len("Dune PDF") + len("Ulysses PDF"))},
+		{Format: "MOBI", BookCount: 1, TotalSize: int64(len("Emma MOBI"))},
+	}
+	if !reflect.DeepEqual(got.Formats, want) {
+		t.Errorf("formats = %+v, want %+v", got.Formats, want)
+	}
+}
+
+func TestTagsRecentSort(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	day := func(year int) time.Time { return time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC) }
+	lib.AddBook(t, testutil.Book{Title: "Old", Authors: []string{"A"}, Tags: []string{"gamma"}, Added: day(2019)})
+	lib.AddBook(t, testutil.Book{Title: "Alpha", Authors: []string{"A"}, Tags: []string{"alpha"}, Added: day(2020)})
+	lib.AddBook(t, testutil.Book{Title: "Gamma", Authors: []string{"A"}, Tags: []string{"gamma"}, Added: day(2022)})
+	lib.AddBook(t, testutil.Book{Title: "Beta", Authors: []string{"A"}, Tags: []string{"beta"}, Added: day(2024)})
+	_, router := newTestServer(t, lib, nil)
+
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"name", []string{"alpha", "beta", "gamma"}},
+		{"count", []string{"gamma", "alpha", "beta"}},
+		// 按带有该标签的书籍最近修改时间倒序
+		{"recent", []string{"beta", "gamma", "alpha"}},
+	}
+	for _, tt := range tests {
+		rec := get(router, "/api/tags?sort="+tt.sort, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("sort=%s: status %d", tt.sort, rec.Code)
+		}
+		var got struct {
+			Tags []database.Tag `json:"tags"`
+			Sort string         `json:"sort"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, tag := range got.Tags {
+			names = append(names, tag.Name)
+		}
+		if !reflect.DeepEqual(names, tt.want) || got.Sort != tt.sort {
+			t.Errorf("api sort=%s: tags %v (sort %q), want %v", tt.sort, names, got.Sort, tt.want)
+		}
+
+		feed := parseFeed(t, get(router, "/opds/tags?sort="+tt.sort, nil).Body.Bytes())
+		names = nil
+		for _, entry := range feed.Entries {
+			names = append(names, strings.Fields(entry.Title)[0])
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("opds sort=%s: tags %v, want %v", tt.sort, names, tt.want)
+		}
+	}
+
+	// API拒绝未知的排序方式，OPDS回退到按名称排序
+	if rec := get(router, "/api/tags?sort=bogus", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("api sort=bogus: status %d, want 400", rec.Code)
+	}
+	feed := parseFeed(t, get(router, "/opds/tags?sort=bogus", nil).Body.Bytes())
+	if len(feed.Entries) != 3 || !strings.HasPrefix(feed.Entries[0].Title, "alpha") {
+		t.Errorf("opds sort=bogus: want name order")
+	}
+}
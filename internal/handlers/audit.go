@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/audit"
+	"github.com/ricci/calibre-opds-go/internal/metrics"
+	"github.com/ricci/calibre-opds-go/pkg/logger"
+)
+
+// SetAuditLog 设置下载审计日志，为nil时不记录
+func (h *Handler) SetAuditLog(auditLog *audit.Log) {
+	h.auditLog = auditLog
+}
+
+// SetMetrics 设置Prometheus指标，同时输出各缓存的命中统计；为nil时不统计下载
+func (h *Handler) SetMetrics(m *metrics.Metrics) {
+	h.metrics = m
+	if m != nil {
+		m.SetCacheStats(h.cacheMetrics)
+	}
+}
+
+// auditDownload 记录一次下载；series为系列打包下载的系列名，单本下载时为空
+func (h *Handler) auditDownload(c *gin.Context, bookID int, format, series string) {
+	if h.metrics != nil {
+		h.metrics.IncDownload(format)
+	}
+	if h.auditLog == nil {
+		return
+	}
+
+	record := audit.Record{
+		Time:     time.Now().UTC(),
+		ClientIP: h.clientIP(c),
+		BookID:   bookID,
+		Format:   format,
+		Series:   series,
+	}
+	if user := c.GetHeader("X-Remote-User"); user != "" && h.fromTrustedProxy(c) {
+		record.User = user
+	}
+
+	if err := h.auditLog.Write(record); err != nil {
+		logger.Error.Printf("Failed to write download audit record: %v", err)
+	}
+}
+
+// countsAsDownload 判断已写出的响应是否算作一次下载：只统计GET的完整响应和从头开始的Range请求，
+// 断点续传、304和HEAD不重复计数
+func countsAsDownload(c *gin.Context) bool {
+	if c.Request.Method != http.MethodGet {
+		return false
+	}
+	switch c.Writer.Status() {
+	case http.StatusOK:
+		return true
+	case http.StatusPartialContent:
+		spec, ok := strings.CutPrefix(strings.TrimSpace(c.GetHeader("Range")), "bytes=")
+		return ok && strings.HasPrefix(strings.TrimSpace(spec), "0-")
+	}
+	return false
+}
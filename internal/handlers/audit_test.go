@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/audit"
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+func TestDownloadAuditSkipsResumesAndRevalidation(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Book", Authors: []string{"Author"}, Formats: []string{"EPUB"}})
+	h, router := newTestServer(t, lib, nil)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+	h.SetAuditLog(auditLog)
+
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	requests := []struct {
+		header map[string]string
+		status int
+	}{
+		{nil, http.StatusOK}, // 完整下载，记录
+		{map[string]string{"Range": "bytes=0-3"}, http.StatusPartialContent}, // 从头开始，记录
+		{map[string]string{"Range": "bytes=4-"}, http.StatusPartialContent},  // 断点续传，不记录
+		{map[string]string{"If-Modified-Since": future}, http.StatusNotModified},
+	}
+	for _, r := range requests {
+		if rec := get(router, "/download/1/EPUB", r.header); rec.Code != r.status {
+			t.Fatalf("%v: status %d, want %d", r.header, rec.Code, r.status)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("%d audit records, want 2", lines)
+	}
+}
+
+func TestDownloadAuditClientIP(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Book", Authors: []string{"Author"}, Formats: []string{"EPUB"}})
+
+	// httptest请求的RemoteAddr为192.0.2.1
+	tests := []struct {
+		name    string
+		proxies string
+		want    string
+	}{
+		{"no trusted proxies", "", "192.0.2.1"},
+		{"untrusted peer", "10.0.0.0/8", "192.0.2.1"},
+		{"trusted proxy", "192.0.2.1", "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, router := newTestServer(t, lib, map[string]string{"OPDS_TRUSTED_PROXIES": tt.proxies})
+			path := filepath.Join(t.TempDir(), "audit.log")
+			auditLog, err := audit.Open(path, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer auditLog.Close()
+			h.SetAuditLog(auditLog)
+
+			// 访问日志和慢请求日志使用gin的ClientIP，同样不能被伪造
+			router.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+			header := map[string]string{"X-Forwarded-For": "203.0.113.7"}
+			if rec := get(router, "/download/1/EPUB", header); rec.Code != http.StatusOK {
+				t.Fatalf("status %d", rec.Code)
+			}
+			if rec := get(router, "/ip", header); rec.Body.String() != tt.want {
+				t.Errorf("gin ClientIP = %q, want %q", rec.Body.String(), tt.want)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var record audit.Record
+			if err := json.Unmarshal(data, &record); err != nil {
+				t.Fatalf("invalid audit record %q: %v", data, err)
+			}
+			if record.ClientIP != tt.want {
+				t.Errorf("audit client IP = %q, want %q", record.ClientIP, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/metrics"
+)
+
+// cacheCounters 缓存的命中和未命中次数
+type cacheCounters struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func (cc *cacheCounters) hit()  { cc.hits.Add(1) }
+func (cc *cacheCounters) miss() { cc.misses.Add(1) }
+
+// counterStats 返回命中次数、未命中次数、命中率和当前条目数
+func (cc *cacheCounters) counterStats(size int) gin.H {
+	hits, misses := cc.hits.Load(), cc.misses.Load()
+	hitRatio := 0.0
+	if hits+misses > 0 {
+		hitRatio = float64(hits) / float64(hits+misses)
+	}
+
+	return gin.H{
+		"hits":      hits,
+		"misses":    misses,
+		"hit_ratio": hitRatio,
+		"size":      size,
+	}
+}
+
+// statsCache 带命中统计的并发安全缓存
+type statsCache struct {
+	cacheCounters
+	entries sync.Map
+}
+
+// Load 读取缓存条目，不计入统计；调用方确认条目有效后调用hit或miss
+func (sc *statsCache) Load(key interface{}) (interface{}, bool) {
+	return sc.entries.Load(key)
+}
+
+// Store 写入缓存条目
+func (sc *statsCache) Store(key, value interface{}) {
+	sc.entries.Store(key, value)
+}
+
+// size 当前条目数
+func (sc *statsCache) size() int {
+	size := 0
+	sc.entries.Range(func(_, _ interface{}) bool {
+		size++
+		return true
+	})
+	return size
+}
+
+// Stats 返回命中次数、未命中次数、命中率和当前条目数
+func (sc *statsCache) Stats() gin.H {
+	return sc.counterStats(sc.size())
+}
+
+// APICacheStats 缓存命中统计
+func (h *Handler) APICacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"cover_types": h.coverTypes.Stats(),
+		"opf":         h.opfCache.Stats(),
+		"cover_info":  h.coverInfos.Stats(),
+		"thumbnails":  h.thumbnails.Stats(),
+	})
+}
+
+// cacheMetrics 各缓存的命中统计，输出为Prometheus指标
+func (h *Handler) cacheMetrics() []metrics.CacheStats {
+	stats := []metrics.CacheStats{
+		{Name: "cover_types", Hits: h.coverTypes.hits.Load(), Misses: h.coverTypes.misses.Load(), Entries: h.coverTypes.size()},
+		{Name: "opf", Hits: h.opfCache.hits.Load(), Misses: h.opfCache.misses.Load(), Entries: h.opfCache.size()},
+		{Name: "cover_info", Hits: h.coverInfos.hits.Load(), Misses: h.coverInfos.misses.Load(), Entries: h.coverInfos.size()},
+	}
+	files, _ := h.thumbnails.usage()
+	stats = append(stats, metrics.CacheStats{
+		Name: "thumbnails", Hits: h.thumbnails.hits.Load(), Misses: h.thumbnails.misses.Load(), Entries: files,
+	})
+	return stats
+}
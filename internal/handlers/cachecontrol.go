@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/opds"
+)
+
+// 缓存策略类别
+const (
+	CacheFeed     = "feed"     // OPDS feed，短时间缓存
+	CacheCover    = "cover"    // 封面，带版本号时长期缓存
+	CacheDownload = "download" // 书籍下载，每次重新验证
+)
+
+// coverVersionKey 上下文中标记封面请求的v参数与书籍当前修改时间一致
+const coverVersionKey = "coverVersionMatches"
+
+// CacheControl 按类别设置Cache-Control和Vary响应头，各类别的max-age由配置决定；
+// 只缓存成功（2xx）和304响应，错误响应不带Cache-Control
+func (h *Handler) CacheControl(category string) gin.HandlerFunc {
+	vary := h.varyHeaders(category)
+	return func(c *gin.Context) {
+		w := &cacheControlWriter{ResponseWriter: c.Writer}
+		w.commit = func() {
+			header := w.Header()
+			for _, name := range vary {
+				header.Add("Vary", name)
+			}
+			if status := w.Status(); (status >= 200 && status < 300) || status == http.StatusNotModified {
+				header.Set("Cache-Control", h.cacheControlValue(category, c.GetBool(coverVersionKey)))
+			} else {
+				header.Del("Cache-Control")
+			}
+		}
+		c.Writer = w
+		c.Next()
+		// 没有写出正文的响应（如304）也需要补上响应头
+		w.WriteHeaderNow()
+	}
+}
+
+// varyHeaders 返回影响响应内容的请求头：书籍根目录可由X-Books-Root切换；
+// feed中的链接为绝对地址，未配置CANONICAL_BASE_URL时随Host和采信的X-Forwarded-*变化
+func (h *Handler) varyHeaders(category string) []string {
+	var vary []string
+	if len(h.config.BooksRoots) > 0 {
+		vary = append(vary, "X-Books-Root")
+	}
+	if category == CacheFeed && h.config.CanonicalBaseURL == "" {
+		vary = append(vary, "Host")
+		if h.config.TrustProxyHeaders {
+			vary = append(vary, "X-Forwarded-Proto", "X-Forwarded-Host", "X-Forwarded-Prefix")
+		}
+	}
+	return vary
+}
+
+// cacheControlWriter 在响应头写出前按最终状态码设置缓存相关的响应头
+type cacheControlWriter struct {
+	gin.ResponseWriter
+	commit func()
+}
+
+// before 在响应头写出前调用一次commit
+func (w *cacheControlWriter) before() {
+	if !w.Written() && w.commit != nil {
+		w.commit()
+		w.commit = nil
+	}
+}
+
+func (w *cacheControlWriter) WriteHeaderNow() {
+	w.before()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *cacheControlWriter) Write(data []byte) (int, error) {
+	w.before()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cacheControlWriter) WriteString(s string) (int, error) {
+	w.before()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *cacheControlWriter) Flush() {
+	w.before()
+	w.ResponseWriter.Flush()
+}
+
+// cacheControlValue 返回类别对应的Cache-Control值，versioned表示地址中的版本号与书籍当前的修改时间一致
+func (h *Handler) cacheControlValue(category string, versioned bool) string {
+	switch category {
+	case CacheCover:
+		if versioned {
+			return maxAge(h.config.CoverCacheMaxAge) + ", immutable"
+		}
+		// 不带版本号的封面地址可能指向更新后的图片，按feed缓存
+		return maxAge(h.config.FeedCacheMaxAge)
+	case CacheDownload:
+		if h.config.DownloadCacheMaxAge <= 0 {
+			return maxAge(0)
+		}
+		return maxAge(h.config.DownloadCacheMaxAge) + ", must-revalidate"
+	default:
+		return maxAge(h.config.FeedCacheMaxAge)
+	}
+}
+
+// maxAge 生成public缓存的max-age指令，不大于0时要求每次重新验证
+func maxAge(d time.Duration) string {
+	if d <= 0 {
+		return "public, no-cache"
+	}
+	return fmt.Sprintf("public, max-age=%d", int(d.Seconds()))
+}
+
+// feedMimeType OPDS feed的响应类型
+const feedMimeType = "application/atom+xml;charset=utf-8"
+
+// 可协商的feed格式
+const (
+	atomMediaType = "application/atom+xml"
+	jsonMediaType = opds.JSONMimeType
+)
+
+// feedMediaType 按Accept请求头的q值在Atom和OPDS-JSON之间选择feed格式；
+// 请求头缺失、两者同样可接受或都不可接受时使用配置的默认格式
+func (h *Handler) feedMediaType(c *gin.Context) string {
+	supported := []string{atomMediaType, jsonMediaType}
+	if h.config.DefaultFeedFormat == "json" {
+		supported = []string{jsonMediaType, atomMediaType}
+	}
+	if mediaType := negotiate(c.GetHeader("Accept"), supported, supported[0]); mediaType != "" {
+		return mediaType
+	}
+	return supported[0]
+}
+
+// serveFeed 输出feed，按Accept请求头输出Atom或OPDS-JSON，按内容设置ETag、按feed和数据库的更新时间设置Last-Modified，
+// 客户端缓存仍然有效时返回304
+func (h *Handler) serveFeed(c *gin.Context, gen *opds.Generator, data []byte) {
+	c.Writer.Header().Add("Vary", "Accept")
+	mimeType := feedMimeType
+	if h.feedMediaType(c) == jsonMediaType {
+		jsonData, err := gen.FeedJSON()
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Failed to generate feed")
+			return
+		}
+		data, mimeType = jsonData, jsonMediaType+";charset=utf-8"
+	}
+
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	// 删除书籍、修改关联等变化不会更新条目自身的修改时间，Last-Modified取条目和数据库文件中较晚的时间
+	lastModified := gen.LastUpdated()
+	if dbModified := h.db.ModTime().UTC().Truncate(time.Second); dbModified.After(lastModified) {
+		lastModified = dbModified
+	}
+
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(c, etag, lastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, mimeType, data)
+}
+
+// notModified 判断条件请求是否命中；If-None-Match优先于If-Modified-Since
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := c.GetHeader("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(since); err == nil {
+			return !lastModified.Truncate(time.Second).After(t)
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"image/color"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+func TestFeedLastModifiedIncludesDatabase(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	added := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	lib.AddBook(t, testutil.Book{Title: "Old", Authors: []string{"A"}, Formats: []string{"EPUB"}, Added: added})
+	_, router := newTestServer(t, lib, nil)
+
+	// 数据库文件比书籍晚修改，例如删除了另一本书
+	dbModified := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := os.Chtimes(lib.DBPath, dbModified, dbModified); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := get(router, "/opds/books", nil)
+	if got, want := rec.Header().Get("Last-Modified"), dbModified.Format(http.TimeFormat); got != want {
+		t.Errorf("Last-Modified = %q, want %q", got, want)
+	}
+
+	rec = get(router, "/opds/books", map[string]string{"If-Modified-Since": added.Format(http.TimeFormat)})
+	if rec.Code != http.StatusOK {
+		t.Errorf("If-Modified-Since newest book: status %d, want 200", rec.Code)
+	}
+	rec = get(router, "/opds/books", map[string]string{"If-Modified-Since": dbModified.Format(http.TimeFormat)})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since database: status %d, want 304", rec.Code)
+	}
+}
+
+func TestCoverCacheControl(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	id := lib.AddBook(t, testutil.Book{Title: "Cover", Authors: []string{"A"}})
+	lib.SetCover(t, id, testJPEG(t, 20, 30, color.Black))
+	_, router := newTestServer(t, lib, nil)
+
+	// feed中的封面地址带有书籍当前修改时间作为版本号
+	var coverHref string
+	for _, entry := range parseFeed(t, get(router, "/opds/books", nil).Body.Bytes()).Entries {
+		for _, link := range entry.Links {
+			if link.Rel == "http://opds-spec.org/image" {
+				coverHref = link.Href
+			}
+		}
+	}
+	u, err := url.Parse(coverHref)
+	if err != nil || u.Query().Get("v") == "" {
+		t.Fatalf("cover link %q has no version", coverHref)
+	}
+
+	tests := []struct {
+		target    string
+		status    int
+		immutable bool
+		cached    bool
+	}{
+		{u.RequestURI(), http.StatusOK, true, true},
+		{"/opds/cover/1?v=12345", http.StatusOK, false, true}, // 过期的版本号
+		{"/opds/cover/1", http.StatusOK, false, true},
+		{"/opds/cover/99?v=12345", http.StatusNotFound, false, false},
+		{"/opds/cover/abc", http.StatusBadRequest, false, false},
+	}
+	for _, tt := range tests {
+		rec := get(router, tt.target, nil)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, rec.Code, tt.status)
+			continue
+		}
+		cc := rec.Header().Get("Cache-Control")
+		if (cc != "") != tt.cached {
+			t.Errorf("%s: Cache-Control = %q, cached = %v", tt.target, cc, tt.cached)
+		}
+		if strings.Contains(cc, "immutable") != tt.immutable {
+			t.Errorf("%s: Cache-Control = %q, immutable = %v", tt.target, cc, tt.immutable)
+		}
+	}
+
+	// 304同样带有Cache-Control
+	rec := get(router, u.RequestURI(), nil)
+	rec = get(router, u.RequestURI(), map[string]string{"If-None-Match": rec.Header().Get("ETag")})
+	if rec.Code != http.StatusNotModified || !strings.Contains(rec.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("revalidation: status %d, Cache-Control %q", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+}
+
+func TestFeedVary(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Book", Authors: []string{"A"}})
+
+	tests := []struct {
+		env     map[string]string
+		want    []string
+		notWant []string
+	}{
+		{nil, []string{"Accept", "Host"}, []string{"X-Forwarded-Host", "X-Books-Root"}},
+		{map[string]string{"TRUST_PROXY_HEADERS": "true"}, []string{"Host", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Forwarded-Prefix"}, nil},
+		{map[string]string{"CANONICAL_BASE_URL": "https://books.example.com"}, []string{"Accept"}, []string{"Host"}},
+		{map[string]string{"CALIBRE_BOOKS_ROOTS": lib.Root}, []string{"X-Books-Root"}, nil},
+	}
+	for _, tt := range tests {
+		_, router := newTestServer(t, lib, tt.env)
+		rec := get(router, "/opds/books", nil)
+		vary := strings.Join(rec.Header().Values("Vary"), ", ")
+		for _, name := range tt.want {
+			if !strings.Contains(vary, name) {
+				t.Errorf("%v: Vary %q missing %s", tt.env, vary, name)
+			}
+		}
+		for _, name := range tt.notWant {
+			if strings.Contains(vary, name) {
+				t.Errorf("%v: Vary %q should not contain %s", tt.env, vary, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/opds"
+)
+
+// clientProfile 针对特定OPDS阅读器的输出调整
+type clientProfile struct {
+	name string
+	// agents 识别该阅读器的User-Agent片段（不区分大小写）
+	agents []string
+	// content 覆盖OPDS_ENTRY_CONTENT，为nil时使用配置
+	content *bool
+	// extraRels 在配置之外为下载链接额外输出的rel
+	extraRels []string
+}
+
+// defaultClientProfile 未识别的客户端使用的中性配置，不做任何调整
+var defaultClientProfile = clientProfile{name: "default"}
+
+// clientProfiles 已知阅读器的输出调整，按顺序匹配
+var clientProfiles = []clientProfile{
+	// KOReader只显示summary，内容块会重复显示简介
+	{name: "koreader", agents: []string{"KOReader"}, content: boolPtr(false)},
+	// Thorium按XHTML渲染条目内容，内容块可以显示封面和下载按钮
+	{name: "thorium", agents: []string{"Thorium"}, content: boolPtr(true)},
+	// Calibre Companion只读取summary
+	{name: "calibre-companion", agents: []string{"Calibre Companion", "CalibreCompanion"}, content: boolPtr(false)},
+	// Moon+ Reader不识别open-access下载链接
+	{name: "moonreader", agents: []string{"Moon+", "MoonReader"}, extraRels: []string{"http://opds-spec.org/acquisition"}},
+}
+
+// clientProfile 根据User-Agent选择客户端配置；CLIENT_PROFILE_AGENTS中的片段优先于内置片段
+func (h *Handler) clientProfile(c *gin.Context) clientProfile {
+	if !h.config.ClientProfiles {
+		return defaultClientProfile
+	}
+
+	userAgent := strings.ToLower(c.GetHeader("User-Agent"))
+	if userAgent == "" {
+		return defaultClientProfile
+	}
+
+	names := make([]string, 0, len(h.config.ClientProfileAgents))
+	for name := range h.config.ClientProfileAgents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if profile, ok := findClientProfile(name); ok && containsAny(userAgent, h.config.ClientProfileAgents[name]) {
+			return profile
+		}
+	}
+	for _, profile := range clientProfiles {
+		if containsAny(userAgent, profile.agents) {
+			return profile
+		}
+	}
+	return defaultClientProfile
+}
+
+// findClientProfile 按名称查找内置的客户端配置
+func findClientProfile(name string) (clientProfile, bool) {
+	if strings.EqualFold(name, defaultClientProfile.name) {
+		return defaultClientProfile, true
+	}
+	for _, profile := range clientProfiles {
+		if strings.EqualFold(profile.name, name) {
+			return profile, true
+		}
+	}
+	return clientProfile{}, false
+}
+
+// containsAny 判断s（已转为小写）是否包含任一片段
+func containsAny(s string, fragments []string) bool {
+	for _, fragment := range fragments {
+		if fragment != "" && strings.Contains(s, strings.ToLower(fragment)) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply 将客户端配置应用到生成器
+func (p clientProfile) apply(gen *opds.Generator) {
+	if p.content != nil {
+		gen.IncludeContent = *p.content
+	}
+	for _, rel := range p.extraRels {
+		if !slices.Contains(gen.ExtraAcquisitionRels, rel) {
+			// Clip避免修改配置中的切片
+			gen.ExtraAcquisitionRels = append(slices.Clip(gen.ExtraAcquisitionRels), rel)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // 注册GIF解码器，EPUB封面可能是GIF
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/opds"
+	"github.com/ricci/calibre-opds-go/pkg/logger"
+)
+
+// CoverInfo 封面图片信息
+type CoverInfo struct {
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Format   string `json:"format"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	ETag     string `json:"etag"`
+}
+
+// maxManifestIDs 封面清单一次最多查询的书籍数
+const maxManifestIDs = 100
+
+// CoverManifestEntry 封面清单中的一项，尺寸为实际输出的尺寸
+type CoverManifestEntry struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	CoverInfo
+}
+
+// cachedCoverInfo 已缓存的封面信息
+type cachedCoverInfo struct {
+	modTime time.Time
+	info    CoverInfo
+}
+
+// APICoverInfo 返回封面的尺寸、格式和大小，只读取图片头部
+func (h *Handler) APICoverInfo(c *gin.Context) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	book, err := h.db.GetBookDetailContext(c.Request.Context(), bookID)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get book"})
+		return
+	}
+	if book == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+
+	coverPath, mimeType := h.findBookCover(h.booksPath(c), book)
+	if coverPath == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cover not found"})
+		return
+	}
+
+	info, err := h.coverInfo(coverPath, mimeType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read cover"})
+		return
+	}
+
+	// 配置了宽高比时返回填充后实际输出的尺寸
+	info.Width, info.Height = paddedSize(info.Width, info.Height, h.config.CoverAspect)
+
+	c.JSON(http.StatusOK, info)
+}
+
+// APICoverManifest 一次返回多本书的封面地址、尺寸和ETag，便于网格视图预取；
+// 不存在或没有封面的书籍列入missing，不影响其他书籍
+func (h *Handler) APICoverManifest(c *gin.Context) {
+	ids, err := parseBookIDs(c.Query("ids"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(ids) > maxManifestIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Too many ids, at most %d allowed", maxManifestIDs)})
+		return
+	}
+
+	root := h.booksPath(c)
+	baseURL := h.baseURL(c)
+	covers := make([]CoverManifestEntry, 0, len(ids))
+	missing := []int{}
+	for _, id := range ids {
+		book, err := h.db.GetBookDetailContext(c.Request.Context(), id)
+		if err != nil {
+			c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get book"})
+			return
+		}
+		if book == nil {
+			missing = append(missing, id)
+			continue
+		}
+
+		coverPath, mimeType := h.findBookCover(root, book)
+		if coverPath == "" {
+			missing = append(missing, id)
+			continue
+		}
+		info, err := h.coverInfo(coverPath, mimeType)
+		if err != nil {
+			missing = append(missing, id)
+			continue
+		}
+		info.Width, info.Height = paddedSize(info.Width, info.Height, h.config.CoverAspect)
+		covers = append(covers, CoverManifestEntry{ID: id, URL: opds.CoverURL(baseURL, book), CoverInfo: info})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"covers": covers, "missing": missing})
+}
+
+// parseBookIDs 解析逗号分隔的书籍ID列表，忽略重复的ID
+func parseBookIDs(value string) ([]int, error) {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid book ID %q", part)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("Missing ids")
+	}
+	return ids, nil
+}
+
+// coverETag 根据封面文件的大小和修改时间生成ETag；填充输出与原图不同，宽高比也计入ETag
+func coverETag(stat os.FileInfo, aspect float64) string {
+	tag := strconv.FormatInt(stat.Size(), 16) + "-" + strconv.FormatInt(stat.ModTime().UnixNano(), 16)
+	if aspect > 0 {
+		tag += "-" + strconv.FormatFloat(aspect, 'f', 4, 64)
+	}
+	return `"` + tag + `"`
+}
+
+// coverInfo 读取封面图片信息，结果按文件路径缓存，文件修改后失效
+func (h *Handler) coverInfo(path, mimeType string) (CoverInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return CoverInfo{}, err
+	}
+
+	if v, ok := h.coverInfos.Load(path); ok {
+		cached := v.(cachedCoverInfo)
+		if cached.modTime.Equal(stat.ModTime()) {
+			h.coverInfos.hit()
+			return cached.info, nil
+		}
+	}
+	h.coverInfos.miss()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return CoverInfo{}, err
+	}
+	defer file.Close()
+
+	info := CoverInfo{
+		MimeType: mimeType,
+		Size:     stat.Size(),
+		ETag:     coverETag(stat, h.config.CoverAspect),
+	}
+	// 图片损坏时仍返回大小和ETag，尺寸和格式留空，与GetCover退回原图一致
+	if config, format, err := image.DecodeConfig(file); err == nil {
+		info.Width, info.Height, info.Format = config.Width, config.Height, format
+	} else {
+		logger.Warning.Printf("Failed to decode cover %s: %v", path, err)
+	}
+	h.coverInfos.Store(path, cachedCoverInfo{modTime: stat.ModTime(), info: info})
+	return info, nil
+}
+
+// padCoverFile 读取封面并填充到目标宽高比，返回与原文件相同格式的编码结果
+func padCoverFile(path, mimeType string, aspect float64, background color.Color) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	padded := padImage(src, aspect, background)
+	if mimeType == "image/png" {
+		err = png.Encode(&buf, padded)
+	} else {
+		err = jpeg.Encode(&buf, padded, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// paddedSize 返回填充到宽高比aspect后的尺寸
+func paddedSize(width, height int, aspect float64) (int, int) {
+	if aspect <= 0 || width == 0 || height == 0 {
+		return width, height
+	}
+	if float64(width)/float64(height) > aspect {
+		return width, int(math.Round(float64(width) / aspect))
+	}
+	return int(math.Round(float64(height) * aspect)), height
+}
+
+// padImage 在图片两侧或上下填充背景色，使其宽高比等于aspect（宽/高），原图居中且不缩放
+func padImage(src image.Image, aspect float64, background color.Color) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if aspect <= 0 || width == 0 || height == 0 {
+		return src
+	}
+
+	targetWidth, targetHeight := paddedSize(width, height, aspect)
+	if targetWidth == width && targetHeight == height {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	offset := image.Pt((targetWidth-width)/2, (targetHeight-height)/2)
+	draw.Draw(dst, image.Rectangle{Min: offset, Max: offset.Add(bounds.Size())}, src, bounds.Min, draw.Over)
+	return dst
+}
+
+// coverDataURI 生成封面缩略图的data URI（JPEG），配置了宽高比时先填充；透明区域使用背景色
+func coverDataURI(path string, aspect float64, background color.Color) (string, error) {
+	data, err := resizeCover(path, opds.ThumbnailWidth, opds.ThumbnailHeight, aspect, background, 80)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// resizeCover 将封面按比例缩小到不超过maxWidth×maxHeight并编码为JPEG，配置了宽高比时先填充；透明区域使用背景色
+func resizeCover(path string, maxWidth, maxHeight int, aspect float64, background color.Color, quality int) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return resizeCoverReader(file, maxWidth, maxHeight, aspect, background, quality)
+}
+
+// resizeCoverReader 同resizeCover，从r读取封面；带EXIF方向的JPEG先旋转为正向
+func resizeCoverReader(r io.Reader, maxWidth, maxHeight int, aspect float64, background color.Color, quality int) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if format == "jpeg" {
+		src = orientImage(src, jpegOrientation(data))
+	}
+
+	resized := shrinkImage(padImage(src, aspect, background), maxWidth, maxHeight)
+	flat := image.NewRGBA(resized.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), resized, resized.Bounds().Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resizedCoverFile 返回缩放后封面的缓存文件，不存在时生成；缓存按书库根目录、书籍、尺寸、原图修改时间、
+// 宽高比和背景色区分，原图更新后自动使用新的缓存文件。width或height为0表示该方向不限制
+func (h *Handler) resizedCoverFile(root string, bookID int, coverPath string, width, height int) (string, error) {
+	stat, err := os.Stat(coverPath)
+	if err != nil {
+		return "", err
+	}
+	return h.resizedCover(root, bookID, coverPath, stat.ModTime(), width, height, func() (io.ReadCloser, error) {
+		return os.Open(coverPath)
+	})
+}
+
+// resizedCover 同resizedCoverFile，封面不是单独的文件时（如EPUB内的封面）由open读取，source和modTime用于区分缓存
+func (h *Handler) resizedCover(root string, bookID int, source string, modTime time.Time, width, height int, open func() (io.ReadCloser, error)) (string, error) {
+	name := thumbnailKey(root, source, modTime, bookID, width, height,
+		h.config.CoverAspect, h.config.CoverBackground) + ".jpg"
+	if cached, ok := h.thumbnails.get(name); ok {
+		return cached, nil
+	}
+
+	if width <= 0 {
+		width = math.MaxInt32
+	}
+	if height <= 0 {
+		height = math.MaxInt32
+	}
+	r, err := open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	data, err := resizeCoverReader(r, width, height, h.config.CoverAspect, h.config.CoverBackground, 85)
+	if err != nil {
+		return "", err
+	}
+	return h.thumbnails.put(name, data)
+}
+
+// paddedCoverFile 返回填充到配置宽高比的原尺寸封面缓存文件，不存在时生成；与缩略图共用磁盘缓存，
+// 输出格式与原图相同
+func (h *Handler) paddedCoverFile(root string, bookID int, coverPath, mimeType string) (string, error) {
+	stat, err := os.Stat(coverPath)
+	if err != nil {
+		return "", err
+	}
+	ext := ".jpg"
+	if mimeType == "image/png" {
+		ext = ".png"
+	}
+	name := thumbnailKey(root, coverPath, stat.ModTime(), bookID, 0, 0,
+		h.config.CoverAspect, h.config.CoverBackground) + ext
+	if cached, ok := h.thumbnails.get(name); ok {
+		return cached, nil
+	}
+
+	data, err := padCoverFile(coverPath, mimeType, h.config.CoverAspect, h.config.CoverBackground)
+	if err != nil {
+		return "", err
+	}
+	return h.thumbnails.put(name, data)
+}
+
+// shrinkImage 按比例缩小图片使其不超过maxWidth×maxHeight，每个目标像素取对应源区域的平均值；不放大
+func shrinkImage(src image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxWidth && height <= maxHeight || width == 0 || height == 0 {
+		return src
+	}
+
+	scale := math.Min(float64(maxWidth)/float64(width), float64(maxHeight)/float64(height))
+	dstWidth := max(1, int(math.Round(float64(width)*scale)))
+	dstHeight := max(1, int(math.Round(float64(height)*scale)))
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0 := bounds.Min.Y + y*height/dstHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/dstHeight)
+		for x := 0; x < dstWidth; x++ {
+			x0 := bounds.Min.X + x*width/dstWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/dstWidth)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
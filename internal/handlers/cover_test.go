@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ricci/calibre-opds-go/internal/opds"
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+// testJPEG 生成纯色JPEG
+func testJPEG(t *testing.T, width, height int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGetCoverCorruptImageFallsBack(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	id := lib.AddBook(t, testutil.Book{Title: "Broken", Authors: []string{"Author"}})
+	// 截断的JPEG：头部有效，图像数据不完整
+	corrupt := testJPEG(t, 300, 400, color.White)[:200]
+	lib.SetCover(t, id, corrupt)
+	_, router := newTestServer(t, lib, nil)
+
+	for _, target := range []string{"/opds/cover/1?width=200&height=300", "/opds/cover/1"} {
+		rec := get(router, target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want 200", target, rec.Code)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), corrupt) {
+			t.Errorf("%s: body is not the original cover", target)
+		}
+	}
+
+	rec := get(router, "/api/book/1/cover/info", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cover info: status %d, want 200", rec.Code)
+	}
+	var info CoverInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(corrupt)) || info.Width != 0 {
+		t.Errorf("cover info = %+v", info)
+	}
+}
+
+func TestGetCoverCorruptImageFallsBackWhenPadding(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	id := lib.AddBook(t, testutil.Book{Title: "Broken", Authors: []string{"Author"}})
+	corrupt := []byte("not an image at all")
+	lib.SetCover(t, id, corrupt)
+	_, router := newTestServer(t, lib, map[string]string{"COVER_ASPECT": "2:3"})
+
+	rec := get(router, "/opds/cover/1", nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), corrupt) {
+		t.Errorf("status %d, want 200 with the original bytes", rec.Code)
+	}
+}
+
+func TestPadImage(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	background := color.RGBA{0, 255, 0, 255}
+
+	tests := []struct {
+		width, height int
+		aspect        float64
+		wantW, wantH  int
+		fill          image.Point // 应为背景色的像素
+	}{
+		{300, 300, 2.0 / 3, 300, 450, image.Pt(150, 10)}, // 方形封面上下填充
+		{200, 600, 2.0 / 3, 400, 600, image.Pt(10, 300)}, // 细长封面左右填充
+		{200, 300, 2.0 / 3, 200, 300, image.Pt(-1, -1)},  // 已符合宽高比，不填充
+	}
+	for _, tt := range tests {
+		src := image.NewRGBA(image.Rect(0, 0, tt.width, tt.height))
+		draw.Draw(src, src.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+
+		dst := padImage(src, tt.aspect, background)
+		if b := dst.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("%dx%d: padded to %dx%d, want %dx%d", tt.width, tt.height, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			continue
+		}
+		if w, h := paddedSize(tt.width, tt.height, tt.aspect); w != tt.wantW || h != tt.wantH {
+			t.Errorf("%dx%d: paddedSize = %dx%d, want %dx%d", tt.width, tt.height, w, h, tt.wantW, tt.wantH)
+		}
+		if tt.fill.X >= 0 {
+			if got := color.RGBAModel.Convert(dst.At(tt.fill.X, tt.fill.Y)); got != background {
+				t.Errorf("%dx%d: pixel %v = %v, want background", tt.width, tt.height, tt.fill, got)
+			}
+		}
+		center := image.Pt(tt.wantW/2, tt.wantH/2)
+		if got := color.RGBAModel.Convert(dst.At(center.X, center.Y)); got != red {
+			t.Errorf("%dx%d: center = %v, want the original image", tt.width, tt.height, got)
+		}
+	}
+}
+
+func TestGetCoverPadded(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	id := lib.AddBook(t, testutil.Book{Title: "Square", Authors: []string{"Author"}})
+	lib.SetCover(t, id, testJPEG(t, 300, 300, color.Black))
+	_, router := newTestServer(t, lib, map[string]string{"COVER_ASPECT": "2:3", "COVER_BACKGROUND": "#FFFFFF"})
+
+	for i := 0; i < 2; i++ { // 第二次从磁盘缓存读取
+		rec := get(router, "/opds/cover/1", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d", rec.Code)
+		}
+		img, err := jpeg.Decode(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 300 || b.Dy() != 450 {
+			t.Fatalf("size = %dx%d, want 300x450", b.Dx(), b.Dy())
+		}
+		if r, g, b, _ := img.At(150, 5).RGBA(); r>>8 < 240 || g>>8 < 240 || b>>8 < 240 {
+			t.Errorf("padding is not white: %d,%d,%d", r>>8, g>>8, b>>8)
+		}
+	}
+}
+
+func TestCacheStatsCountHits(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	id := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}})
+	lib.SetCover(t, id, testJPEG(t, 300, 400, color.White))
+	_, router := newTestServer(t, lib, nil)
+
+	stats := func() map[string]map[string]float64 {
+		t.Helper()
+		rec := get(router, "/api/cache-stats", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("cache stats: status %d, want 200", rec.Code)
+		}
+		var out map[string]map[string]float64
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := get(router, "/api/book/1/cover/info", nil); rec.Code != http.StatusOK {
+			t.Fatalf("cover info: status %d, want 200", rec.Code)
+		}
+		if rec := get(router, "/opds/cover/1?width=100&height=150", nil); rec.Code != http.StatusOK {
+			t.Fatalf("thumbnail: status %d, want 200", rec.Code)
+		}
+	}
+
+	got := stats()
+	for _, name := range []string{"cover_info", "thumbnails"} {
+		if got[name]["misses"] != 1 || got[name]["hits"] != 1 {
+			t.Errorf("%s = %v, want 1 hit and 1 miss", name, got[name])
+		}
+	}
+	if got["thumbnails"]["size"] != 1 || got["thumbnails"]["bytes"] <= 0 {
+		t.Errorf("thumbnails = %v, want one cached file", got["thumbnails"])
+	}
+
+	get(router, "/api/book/1/cover/info", nil)
+	if hits := stats()["cover_info"]["hits"]; hits != 2 {
+		t.Errorf("cover_info hits = %v after another request, want 2", hits)
+	}
+}
+
+func TestPNGCoverLinkTypes(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	id := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Formats: []string{"EPUB"}})
+	img := image.NewRGBA(image.Rect(0, 0, 300, 400))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	lib.WriteFile(t, id, "cover.png", buf.Bytes())
+	lib.Exec(t, `UPDATE books SET has_cover = 1 WHERE id = ?`, id)
+	_, router := newTestServer(t, lib, nil)
+
+	rec := get(router, "/opds/books", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	feed := parseFeed(t, rec.Body.Bytes())
+	if len(feed.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(feed.Entries))
+	}
+
+	// 原图保持PNG，缩略图由服务端缩放后统一编码为JPEG
+	types := map[string]string{
+		"http://opds-spec.org/image":           "image/png",
+		"http://opds-spec.org/image/thumbnail": "image/jpeg",
+	}
+	for _, link := range feed.Entries[0].Links {
+		want, ok := types[link.Rel]
+		if !ok {
+			continue
+		}
+		delete(types, link.Rel)
+		if link.Type != want {
+			t.Errorf("%s: type %q, want %q", link.Rel, link.Type, want)
+		}
+		href := strings.TrimPrefix(link.Href, "http://example.com")
+		rec := get(router, href, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want 200", href, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Type"); got != want {
+			t.Errorf("%s: Content-Type %q, want %q", href, got, want)
+		}
+	}
+	for rel := range types {
+		t.Errorf("missing %s link", rel)
+	}
+}
+
+func TestCoversPathFallback(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	override := lib.AddBook(t, testutil.Book{Title: "Override", Authors: []string{"Author"}})
+	fallback := lib.AddBook(t, testutil.Book{Title: "Fallback", Authors: []string{"Author"}})
+	library := []byte("library cover")
+	lib.SetCover(t, override, library)
+	lib.SetCover(t, fallback, library)
+
+	// 封面目录与书籍目录结构相同，只为第一本书提供封面
+	covers := t.TempDir()
+	rel, err := filepath.Rel(lib.Root, lib.BookDir(t, override))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(covers, rel), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	external := testJPEG(t, 20, 30, color.White)
+	if err := os.WriteFile(filepath.Join(covers, rel, "cover.jpg"), external, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, router := newTestServer(t, lib, map[string]string{"COVERS_PATH": covers})
+
+	tests := []struct {
+		id   int
+		want []byte
+	}{
+		{override, external},
+		{fallback, library},
+	}
+	for _, tt := range tests {
+		rec := get(router, "/opds/cover/"+strconv.Itoa(tt.id), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("book %d: status %d, want 200", tt.id, rec.Code)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), tt.want) {
+			t.Errorf("book %d: got %q", tt.id, rec.Body.String())
+		}
+	}
+}
+
+func TestAPICoverInfoDimensions(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	jpgID := lib.AddBook(t, testutil.Book{Title: "JPEG", Authors: []string{"Author"}})
+	jpgData := testJPEG(t, 300, 400, color.White)
+	lib.SetCover(t, jpgID, jpgData)
+
+	pngID := lib.AddBook(t, testutil.Book{Title: "PNG", Authors: []string{"Author"}})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 120, 80))); err != nil {
+		t.Fatal(err)
+	}
+	pngData := buf.Bytes()
+	lib.WriteFile(t, pngID, "cover.png", pngData)
+	lib.Exec(t, `UPDATE books SET has_cover = 1 WHERE id = ?`, pngID)
+
+	lib.AddBook(t, testutil.Book{Title: "No Cover", Authors: []string{"Author"}})
+	_, router := newTestServer(t, lib, nil)
+
+	tests := []struct {
+		id               int
+		width, height    int
+		format, mimeType string
+		size             int
+	}{
+		{jpgID, 300, 400, "jpeg", "image/jpeg", len(jpgData)},
+		{pngID, 120, 80, "png", "image/png", len(pngData)},
+	}
+	for _, tt := range tests {
+		rec := get(router, "/api/book/"+strconv.Itoa(tt.id)+"/cover/info", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("book %d: status %d, want 200", tt.id, rec.Code)
+		}
+		var info CoverInfo
+		if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+			t.Fatal(err)
+		}
+		if info.Width != tt.width || info.Height != tt.height || info.Format != tt.format ||
+			info.MimeType != tt.mimeType || info.Size != int64(tt.size) || info.ETag == "" {
+			t.Errorf("book %d: info = %+v", tt.id, info)
+		}
+	}
+
+	// 封面文件修改后缓存失效，返回新的尺寸
+	coverPath := filepath.Join(lib.BookDir(t, jpgID), "cover.jpg")
+	lib.SetCover(t, jpgID, testJPEG(t, 100, 50, color.White))
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(coverPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	var info CoverInfo
+	rec := get(router, "/api/book/1/cover/info", nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 100 || info.Height != 50 {
+		t.Errorf("after replacing the cover: %dx%d, want 100x50", info.Width, info.Height)
+	}
+
+	for _, target := range []string{"/api/book/3/cover/info", "/api/book/42/cover/info"} {
+		if rec := get(router, target, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", target, rec.Code)
+		}
+	}
+}
+
+func TestAPIBookDetailIncludeCover(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	id := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}})
+	lib.SetCover(t, id, testJPEG(t, 600, 900, color.White))
+	lib.AddBook(t, testutil.Book{Title: "No Cover", Authors: []string{"Author"}})
+	_, router := newTestServer(t, lib, nil)
+
+	coverURI := func(target string) string {
+		t.Helper()
+		rec := get(router, target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", target, rec.Code)
+		}
+		var book struct {
+			CoverDataURI *string `json:"cover_data_uri"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &book); err != nil {
+			t.Fatal(err)
+		}
+		if book.CoverDataURI == nil {
+			return ""
+		}
+		return *book.CoverDataURI
+	}
+
+	// 默认不内嵌，没有封面时省略
+	for _, target := range []string{"/api/book/1", "/api/book/1?include_cover=false", "/api/book/2?include_cover=true"} {
+		if uri := coverURI(target); uri != "" {
+			t.Errorf("%s: unexpected cover_data_uri", target)
+		}
+	}
+
+	uri := coverURI("/api/book/1?include_cover=true")
+	const prefix = "data:image/jpeg;base64,"
+	if !strings.HasPrefix(uri, prefix) {
+		t.Fatalf("cover_data_uri = %.40q", uri)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, prefix))
+	if err != nil {
+		t.Fatalf("invalid base64: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid JPEG: %v", err)
+	}
+	// 内嵌的是缩略图而不是原图
+	if size := img.Bounds().Size(); size.X > opds.ThumbnailWidth || size.Y > opds.ThumbnailHeight {
+		t.Errorf("embedded cover is %v, want at most %dx%d", size, opds.ThumbnailWidth, opds.ThumbnailHeight)
+	}
+}
+
+func TestAPICoverManifest(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	withCover := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}})
+	lib.SetCover(t, withCover, testJPEG(t, 30, 40, color.White))
+	lib.AddBook(t, testutil.Book{Title: "No Cover", Authors: []string{"Author"}})
+	_, router := newTestServer(t, lib, nil)
+
+	// 不存在或没有封面的书籍列入missing，重复的ID只返回一次
+	rec := get(router, "/api/covers/manifest?ids=1,2,42,1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var manifest struct {
+		Covers  []CoverManifestEntry `json:"covers"`
+		Missing []int                `json:"missing"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Covers) != 1 {
+		t.Fatalf("got %d covers, want 1", len(manifest.Covers))
+	}
+	cover := manifest.Covers[0]
+	if cover.ID != withCover || cover.Width != 30 || cover.Height != 40 || cover.ETag == "" ||
+		!strings.HasPrefix(cover.URL, "http://example.com/opds/cover/1") {
+		t.Errorf("cover = %+v", cover)
+	}
+	if !reflect.DeepEqual(manifest.Missing, []int{2, 42}) {
+		t.Errorf("missing = %v, want [2 42]", manifest.Missing)
+	}
+
+	// 清单中的ETag与封面信息一致
+	var info CoverInfo
+	json.Unmarshal(get(router, "/api/book/1/cover/info", nil).Body.Bytes(), &info)
+	if info.ETag != cover.ETag {
+		t.Errorf("manifest ETag %q, cover info ETag %q", cover.ETag, info.ETag)
+	}
+
+	ids := make([]string, maxManifestIDs+1)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i + 1)
+	}
+	for _, target := range []string{
+		"/api/covers/manifest",
+		"/api/covers/manifest?ids=1,abc",
+		"/api/covers/manifest?ids=" + strings.Join(ids, ","),
+	} {
+		if rec := get(router, target, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%.60s: status %d, want 400", target, rec.Code)
+		}
+	}
+}
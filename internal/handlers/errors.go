@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/pkg/logger"
+)
+
+// maxErrorMessageSize 记录的错误信息最大字节数
+const maxErrorMessageSize = 512
+
+// ErrorEntry 最近发生的错误
+type ErrorEntry struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method"`
+	Route   string    `json:"route"`
+	Path    string    `json:"path"`
+	Status  int       `json:"status"`
+	Message string    `json:"message"`
+}
+
+// errorLog 保存最近N条错误的环形缓冲区
+type errorLog struct {
+	mu      sync.Mutex
+	entries []ErrorEntry
+	next    int
+	full    bool
+}
+
+// newErrorLog 创建容量为size的错误缓冲区，size不大于0时不记录
+func newErrorLog(size int) *errorLog {
+	if size < 0 {
+		size = 0
+	}
+	return &errorLog{entries: make([]ErrorEntry, size)}
+}
+
+// add 记录一条错误，缓冲区满时覆盖最早的记录
+func (l *errorLog) add(entry ErrorEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) == 0 {
+		return
+	}
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// recent 返回缓冲区中的错误，最新的在前
+func (l *errorLog) recent() []ErrorEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+
+	result := make([]ErrorEntry, 0, count)
+	for i := 1; i <= count; i++ {
+		result = append(result, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return result
+}
+
+// errorCapture 记录5xx响应正文开头部分的ResponseWriter
+type errorCapture struct {
+	gin.ResponseWriter
+	body []byte
+}
+
+func (w *errorCapture) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *errorCapture) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *errorCapture) capture(data []byte) {
+	if w.Status() < http.StatusInternalServerError || len(w.body) >= maxErrorMessageSize {
+		return
+	}
+	if remaining := maxErrorMessageSize - len(w.body); len(data) > remaining {
+		data = data[:remaining]
+	}
+	w.body = append(w.body, data...)
+}
+
+// RecordErrors 记录返回5xx状态码的请求到最近错误缓冲区
+func (h *Handler) RecordErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		capture := &errorCapture{ResponseWriter: c.Writer}
+		c.Writer = capture
+		c.Next()
+
+		status := c.Writer.Status()
+		if status < http.StatusInternalServerError {
+			return
+		}
+
+		message := errorMessage(capture.body)
+		if len(c.Errors) > 0 {
+			message = c.Errors.String()
+		}
+		h.recordError(c, status, message)
+	}
+}
+
+// RecoverPanic 处理请求中的panic，记录到最近错误缓冲区后返回500
+func (h *Handler) RecoverPanic(c *gin.Context, recovered interface{}) {
+	logger.Error.Printf("Panic recovered on %s %s: %v", c.Request.Method, c.Request.URL.Path, recovered)
+	h.recordError(c, http.StatusInternalServerError, fmt.Sprintf("panic: %v", recovered))
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
+
+// recordError 将当前请求的错误加入缓冲区
+func (h *Handler) recordError(c *gin.Context, status int, message string) {
+	h.recentErrors.add(ErrorEntry{
+		Time:    time.Now().UTC(),
+		Method:  c.Request.Method,
+		Route:   c.FullPath(),
+		Path:    c.Request.URL.Path,
+		Status:  status,
+		Message: message,
+	})
+}
+
+// errorMessage 从错误响应正文中提取信息，JSON格式时取error字段
+func errorMessage(body []byte) string {
+	var envelope struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error != "" {
+		return envelope.Error
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// RequireAdmin 要求请求携带Authorization: Bearer <ADMIN_TOKEN>，未配置令牌时接口不可用
+func (h *Handler) RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.authorizeAdmin(c) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// authorizeAdmin 校验管理令牌，未通过时中止请求并返回404（未配置令牌）或401
+func (h *Handler) authorizeAdmin(c *gin.Context) bool {
+	token := h.config.AdminToken
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return false
+	}
+
+	provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+		c.Header("WWW-Authenticate", `Bearer realm="opds"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return false
+	}
+	return true
+}
+
+// APIErrors 最近发生的错误
+func (h *Handler) APIErrors(c *gin.Context) {
+	entries := h.recentErrors.recent()
+	c.JSON(http.StatusOK, gin.H{
+		"errors": entries,
+		"total":  len(entries),
+	})
+}
+
+// dbRetryAfter 数据库不可用时建议客户端重试的间隔（秒）
+const dbRetryAfter = "30"
+
+// dbErrorStatus 数据库查询失败时的响应状态码：查询超过DB_CONNECTION_TIMEOUT时返回504，
+// 数据库不可用时返回503并设置Retry-After，其他查询错误返回500
+func (h *Handler) dbErrorStatus(c *gin.Context, err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	if h.db.IsUnavailable(err) {
+		c.Header("Retry-After", dbRetryAfter)
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
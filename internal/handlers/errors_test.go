@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+func TestClosedDatabaseReturns503(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Book", Authors: []string{"Author"}, Formats: []string{"EPUB"}})
+	h, router := newTestServer(t, lib, nil)
+
+	// 关闭前正常返回
+	if rec := get(router, "/api/book/1", nil); rec.Code != http.StatusOK {
+		t.Fatalf("before close: status %d", rec.Code)
+	}
+
+	h.db.Close()
+	for _, target := range []string{
+		"/opds/books",
+		"/opds/book/1",
+		"/opds/cover/1",
+		"/download/1/EPUB",
+		"/api/books",
+		"/api/book/1",
+		"/api/book/1/cover/info",
+	} {
+		rec := get(router, target, nil)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: status %d, want 503", target, rec.Code)
+			continue
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: missing Retry-After", target)
+		}
+	}
+}
+
+func TestMissingBookReturns404(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	_, router := newTestServer(t, lib, nil)
+
+	for _, target := range []string{"/opds/book/42", "/opds/cover/42", "/download/42/EPUB", "/api/book/42"} {
+		if rec := get(router, target, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", target, rec.Code)
+		}
+	}
+}
+
+func TestQueryTimeoutReturns504(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Book", Authors: []string{"Author"}})
+	_, router := newTestServer(t, lib, map[string]string{"DB_CONNECTION_TIMEOUT": "1ns"})
+
+	for _, target := range []string{"/opds/books", "/api/books"} {
+		rec := get(router, target, nil)
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("%s: status %d, want 504", target, rec.Code)
+		}
+		if rec.Header().Get("Retry-After") != "" {
+			t.Errorf("%s: unexpected Retry-After on a timeout", target)
+		}
+	}
+}
+
+func TestDiagnoseIntegrityCheckRequiresAdmin(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+
+	// 未配置管理令牌时不能执行完整性检查，普通诊断信息仍可访问
+	_, router := newTestServer(t, lib, nil)
+	if rec := get(router, "/api/diagnose", nil); rec.Code != http.StatusOK {
+		t.Errorf("diagnose: status %d, want 200", rec.Code)
+	}
+	if rec := get(router, "/api/diagnose?integrity_check=1", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("integrity check without ADMIN_TOKEN: status %d, want 404", rec.Code)
+	}
+
+	_, router = newTestServer(t, lib, map[string]string{"ADMIN_TOKEN": "secret"})
+	for token, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		rec := get(router, "/api/diagnose?integrity_check=1", map[string]string{"Authorization": token})
+		if rec.Code != want {
+			t.Errorf("Authorization %q: status %d, want %d", token, rec.Code, want)
+		}
+	}
+}
+
+func TestRecentErrorsBuffer(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	h, _ := newTestServer(t, lib, map[string]string{"ADMIN_TOKEN": "secret", "ERROR_LOG_SIZE": "2"})
+
+	router := gin.New()
+	router.Use(gin.CustomRecovery(h.RecoverPanic), h.RecordErrors())
+	router.GET("/api/books", h.APIBooks)
+	router.GET("/api/errors", h.RequireAdmin(), h.APIErrors)
+	router.GET("/boom/:id", func(c *gin.Context) { panic("boom") })
+
+	auth := map[string]string{"Authorization": "Bearer secret"}
+	if rec := get(router, "/api/errors", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status %d, want 401", rec.Code)
+	}
+
+	// 4xx响应不记录
+	get(router, "/missing", nil)
+	h.db.Close()
+	get(router, "/api/books", nil)
+	get(router, "/boom/7", nil)
+
+	rec := get(router, "/api/errors", auth)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var result struct {
+		Errors []ErrorEntry `json:"errors"`
+		Total  int          `json:"total"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 2 || len(result.Errors) != 2 {
+		t.Fatalf("recorded %d errors, want 2: %+v", result.Total, result.Errors)
+	}
+
+	// 最新的在前
+	panicked, dbErr := result.Errors[0], result.Errors[1]
+	if panicked.Method != http.MethodGet || panicked.Route != "/boom/:id" || panicked.Path != "/boom/7" ||
+		panicked.Status != http.StatusInternalServerError || panicked.Message != "panic: boom" {
+		t.Errorf("panic entry = %+v", panicked)
+	}
+	if dbErr.Route != "/api/books" || dbErr.Status != http.StatusServiceUnavailable || dbErr.Message == "" {
+		t.Errorf("database entry = %+v", dbErr)
+	}
+	if panicked.Time.IsZero() || panicked.Time.Before(dbErr.Time) {
+		t.Errorf("times out of order: %v before %v", panicked.Time, dbErr.Time)
+	}
+
+	// 缓冲区满时覆盖最早的记录
+	get(router, "/boom/8", nil)
+	rec = get(router, "/api/errors", auth)
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if len(result.Errors) != 2 || result.Errors[0].Path != "/boom/8" || result.Errors[1].Path != "/boom/7" {
+		t.Errorf("after overflow: %+v", result.Errors)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+// exifJPEG 生成左半红、右半蓝的宽图JPEG，并插入带方向标签的EXIF段
+func exifJPEG(t *testing.T, width, height, orientation int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, image.Rect(0, 0, width/2, height), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(width/2, 0, width, height), image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// TIFF头（大端）+ 只有方向一个条目的IFD0
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientationTag)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3) // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	for orientation := 1; orientation <= 8; orientation++ {
+		if got := jpegOrientation(exifJPEG(t, 8, 4, orientation)); got != orientation {
+			t.Errorf("orientation %d: got %d", orientation, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil)
+	if got := jpegOrientation(buf.Bytes()); got != 1 {
+		t.Errorf("no EXIF: got %d, want 1", got)
+	}
+	if got := jpegOrientation([]byte("not a jpeg")); got != 1 {
+		t.Errorf("not a JPEG: got %d, want 1", got)
+	}
+}
+
+func TestResizeCoverAppliesOrientation(t *testing.T) {
+	// 方向6表示需要顺时针旋转90°：存储的左红右蓝宽图显示为上红下蓝的竖图
+	data := exifJPEG(t, 80, 40, 6)
+	out, err := resizeCoverReader(bytes.NewReader(data), 1000, 1000, 0, color.White, 95)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 80 {
+		t.Fatalf("size = %dx%d, want 40x80", b.Dx(), b.Dy())
+	}
+	if r, _, b, _ := img.At(20, 10).RGBA(); r>>8 < 200 || b>>8 > 60 {
+		t.Errorf("top is not red: r=%d b=%d", r>>8, b>>8)
+	}
+	if r, _, b, _ := img.At(20, 70).RGBA(); b>>8 < 200 || r>>8 > 60 {
+		t.Errorf("bottom is not blue: r=%d b=%d", r>>8, b>>8)
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// 2×1图片：左上(0,0)为红色
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red := color.RGBA{255, 0, 0, 255}
+	src.Set(0, 0, red)
+
+	// 各方向正向显示后红色像素所在的位置
+	want := map[int]image.Point{
+		1: {0, 0}, 2: {1, 0}, 3: {1, 0}, 4: {0, 0},
+		5: {0, 0}, 6: {0, 0}, 7: {0, 1}, 8: {0, 1},
+	}
+	for orientation, p := range want {
+		dst := orientImage(src, orientation)
+		if got := color.RGBAModel.Convert(dst.At(p.X, p.Y)); got != red {
+			t.Errorf("orientation %d: pixel %v = %v, want red", orientation, p, got)
+		}
+	}
+}
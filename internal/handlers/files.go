@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"archive/zip"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,10 +12,14 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ricci/calibre-opds-go/internal/database"
+	"github.com/ricci/calibre-opds-go/internal/epub"
 	"github.com/ricci/calibre-opds-go/internal/opds"
+	"github.com/ricci/calibre-opds-go/internal/opf"
+	"github.com/ricci/calibre-opds-go/pkg/logger"
 )
 
 // GetCover 获取书籍封面
@@ -24,33 +30,148 @@ func (h *Handler) GetCover(c *gin.Context) {
 		return
 	}
 
-	book, err := h.db.GetBookDetail(bookID)
-	if err != nil || book == nil {
+	book, err := h.db.GetBookDetailContext(c.Request.Context(), bookID)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get book")
+		return
+	}
+	if book == nil {
 		c.String(http.StatusNotFound, "Book not found")
 		return
 	}
 
-	basePath := h.config.BooksPath
-	bookPath := strings.ReplaceAll(book.Path, "\\", "/")
+	// 版本号与书籍当前的修改时间一致时封面地址不会再指向其他图片，可以按immutable缓存
+	if v := c.Query("v"); v != "" && v == strconv.FormatInt(book.LastModified.Unix(), 10) {
+		c.Set(coverVersionKey, true)
+	}
+
+	// 禁止浏览器按内容猜测类型，EPUB内的封面来自上传的书籍文件
+	c.Header("X-Content-Type-Options", "nosniff")
+
+	// 请求了缩略图尺寸时输出缩放后的JPEG，只给出一个方向时按原图比例缩放；尺寸取整到固定的几档
+	width := thumbnailBucket(getIntParam(c, "width", 0, 0))
+	height := thumbnailBucket(getIntParam(c, "height", 0, 0))
 
 	// 尝试不同的封面扩展名
-	coverExtensions := []string{".jpg", ".png"}
-	for _, ext := range coverExtensions {
-		coverPath := filepath.Join(basePath, bookPath, "cover"+ext)
-		if _, err := os.Stat(coverPath); err == nil {
-			mimeType := "image/jpeg"
-			if ext == ".png" {
-				mimeType = "image/png"
+	root := h.booksPath(c)
+	if coverPath, mimeType := h.findBookCover(root, book); coverPath != "" {
+		if width > 0 || height > 0 {
+			resized, err := h.resizedCoverFile(root, book.ID, coverPath, width, height)
+			if err == nil {
+				serveResizedCover(c, resized)
+				return
 			}
-			c.File(coverPath)
-			c.Header("Content-Type", mimeType)
-			return
+			logger.Warning.Printf("Failed to resize cover %s: %v", coverPath, err)
+		}
+
+		// ETag与封面清单中的一致，c.File会据此处理If-None-Match
+		if stat, err := os.Stat(coverPath); err == nil {
+			c.Header("ETag", coverETag(stat, h.config.CoverAspect))
 		}
+
+		// 配置了宽高比时输出缓存的填充封面，失败时退回原图
+		if h.config.CoverAspect > 0 {
+			padded, err := h.paddedCoverFile(root, book.ID, coverPath, mimeType)
+			if err == nil {
+				c.Header("Content-Type", mimeType)
+				c.File(padded)
+				return
+			}
+			logger.Warning.Printf("Failed to pad cover %s: %v", coverPath, err)
+			c.Writer.Header().Del("ETag")
+		}
+
+		// Content-Type必须在写出文件前设置，否则c.File会按内容猜测
+		c.Header("Content-Type", mimeType)
+		c.File(coverPath)
+		return
+	}
+
+	// 磁盘上没有封面文件时从EPUB中提取
+	if h.serveEPUBCover(c, root, book, width, height) {
+		return
 	}
 
 	c.String(http.StatusNotFound, "Cover not found")
 }
 
+// serveResizedCover 输出缩放后的封面缓存文件，c.File据ETag处理If-None-Match
+func serveResizedCover(c *gin.Context, path string) {
+	if stat, err := os.Stat(path); err == nil {
+		c.Header("ETag", coverETag(stat, 0))
+	}
+	c.Header("Content-Type", "image/jpeg")
+	c.File(path)
+}
+
+// epubCoverTypes 允许直接输出的EPUB封面类型，其他类型（如SVG）可能包含脚本
+var epubCoverTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// serveEPUBCover 从书籍的EPUB文件中提取封面并输出，请求了尺寸时与磁盘封面一样缩放；没有可用封面时返回false
+func (h *Handler) serveEPUBCover(c *gin.Context, root string, book *database.Book, width, height int) bool {
+	format := findFormat(book, "EPUB")
+	if format == nil {
+		return false
+	}
+	filePath := findBookFile(bookDir(root, book), format)
+	if filePath == "" {
+		return false
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return false
+	}
+
+	epubBook, err := epub.Open(filePath)
+	if err != nil {
+		logger.Warning.Printf("Failed to open EPUB %s: %v", filePath, err)
+		return false
+	}
+	defer epubBook.Close()
+
+	name, mimeType, err := epubBook.CoverImage()
+	if err != nil {
+		return false
+	}
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	if !epubCoverTypes[mimeType] {
+		logger.Warning.Printf("Ignoring cover %s of type %q in %s", name, mimeType, filePath)
+		return false
+	}
+
+	if width > 0 || height > 0 {
+		resized, err := h.resizedCover(root, book.ID, filePath+"#"+name, stat.ModTime(), width, height, func() (io.ReadCloser, error) {
+			return epubBook.Open(name)
+		})
+		if err == nil {
+			serveResizedCover(c, resized)
+			return true
+		}
+		logger.Warning.Printf("Failed to resize cover %s from %s: %v", name, filePath, err)
+	}
+
+	data, err := epubBook.ReadFile(name)
+	if err != nil {
+		logger.Warning.Printf("Failed to read cover %s from %s: %v", name, filePath, err)
+		return false
+	}
+
+	// 封面随EPUB文件变化，ETag取自EPUB文件
+	etag := coverETag(stat, 0)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	c.Data(http.StatusOK, mimeType, data)
+	return true
+}
+
 // DownloadBook 下载书籍
 func (h *Handler) DownloadBook(c *gin.Context) {
 	bookID, err := strconv.Atoi(c.Param("id"))
@@ -61,93 +182,459 @@ func (h *Handler) DownloadBook(c *gin.Context) {
 
 	requestedFormat := strings.ToUpper(c.Param("format"))
 
-	book, err := h.db.GetBookDetail(bookID)
-	if err != nil || book == nil {
+	book, err := h.db.GetBookDetailContext(c.Request.Context(), bookID)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get book")
+		return
+	}
+	if book == nil {
 		c.String(http.StatusNotFound, "Book not found")
 		return
 	}
 
 	// 查找匹配的格式
-	var targetFormat *database.Format
-	for i := range book.Formats {
-		if strings.ToUpper(book.Formats[i].Format) == requestedFormat {
-			targetFormat = &book.Formats[i]
-			break
+	targetFormat := findFormat(book, requestedFormat)
+	if targetFormat == nil {
+		c.String(http.StatusNotFound, fmt.Sprintf("Format %s not found", requestedFormat))
+		return
+	}
+
+	fullPath := findBookFile(bookDir(h.booksPath(c), book), targetFormat)
+	if fullPath == "" {
+		c.String(http.StatusNotFound, "File not found")
+		return
+	}
+
+	// 生成安全的文件名
+	safeFilename := generateSafeFilename(book.Title, targetFormat.Format)
+
+	// 设置响应头
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.QueryEscape(safeFilename)))
+	mimeType := opds.GetMimeType(targetFormat.Format)
+	c.Header("Content-Type", mimeType)
+
+	// 发送文件
+	file, err := os.Open(fullPath)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to open file")
+		return
+	}
+	defer file.Close()
+	// 响应写完后按状态码判断是否记录下载
+	defer func() {
+		if countsAsDownload(c) {
+			h.auditDownload(c, book.ID, targetFormat.Format, "")
+		}
+	}()
+
+	// 文本类格式按客户端支持进行gzip压缩，压缩后长度未知，不设置Content-Length
+	if h.config.DownloadCompression && isCompressibleMimeType(mimeType) {
+		c.Header("Vary", "Accept-Encoding")
+		if acceptsGzip(c) {
+			c.Header("Content-Encoding", "gzip")
+			c.Status(http.StatusOK)
+			gz := gzip.NewWriter(c.Writer)
+			defer gz.Close()
+			io.Copy(gz, file)
+			return
 		}
 	}
 
-	if targetFormat == nil {
-		c.String(http.StatusNotFound, fmt.Sprintf("Format %s not found", requestedFormat))
+	// ServeContent处理Range、If-Range和条件请求，支持断点续传
+	var modTime time.Time
+	if fileInfo, err := file.Stat(); err == nil {
+		modTime = fileInfo.ModTime()
+	}
+	http.ServeContent(c.Writer, c.Request, safeFilename, modTime, file)
+}
+
+// DownloadBestFormat 按首选格式重定向到书籍的下载地址；prefer参数（如 EPUB,PDF）优先于配置的首选格式
+func (h *Handler) DownloadBestFormat(c *gin.Context) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid book ID")
 		return
 	}
 
-	// 构建文件路径
-	basePath := h.config.BooksPath
-	bookPath := strings.ReplaceAll(book.Path, "\\", "/")
+	preferred := h.config.PreferredFormats
+	if prefer := c.Query("prefer"); prefer != "" {
+		requested, err := parsePreferredFormats(prefer)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		// 请求的格式都不存在时继续按配置选择
+		preferred = append(requested, preferred...)
+	}
+
+	book, err := h.db.GetBookDetailContext(c.Request.Context(), bookID)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get book")
+		return
+	}
+	if book == nil {
+		c.String(http.StatusNotFound, "Book not found")
+		return
+	}
+
+	format := preferredFormat(book, preferred)
+	if format == nil {
+		c.String(http.StatusNotFound, "No formats available")
+		return
+	}
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("%s/download/%d/%s", h.baseURL(c), bookID, url.PathEscape(format.Format)))
+}
+
+// parsePreferredFormats 解析逗号分隔的格式列表，包含未知格式时返回错误
+func parsePreferredFormats(value string) ([]string, error) {
+	var formats []string
+	for _, format := range strings.Split(value, ",") {
+		format = strings.ToUpper(strings.TrimSpace(format))
+		if format == "" {
+			continue
+		}
+		if !opds.IsKnownFormat(format) {
+			return nil, fmt.Errorf("Unknown format %s", format)
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// coverExtensions 封面文件扩展名及对应的MIME类型，按查找顺序排列
+var coverExtensions = []struct {
+	ext      string
+	mimeType string
+}{
+	{".jpg", "image/jpeg"},
+	{".png", "image/png"},
+}
+
+// cachedCoverType 已缓存的封面类型
+type cachedCoverType struct {
+	lastModified time.Time
+	mimeType     string
+}
+
+// coverMimeType 返回书籍封面的实际MIME类型，结果按书籍目录缓存，书籍修改后失效
+func (h *Handler) coverMimeType(root string, book *database.Book) string {
+	dir := bookDir(root, book)
+	if v, ok := h.coverTypes.Load(dir); ok {
+		cached := v.(cachedCoverType)
+		if cached.lastModified.Equal(book.LastModified) {
+			h.coverTypes.hit()
+			return cached.mimeType
+		}
+	}
+	h.coverTypes.miss()
 
-	// 尝试多个可能的文件路径
+	_, mimeType := h.findBookCover(root, book)
+	if mimeType == "" {
+		mimeType = "image/jpeg"
+	}
+	h.coverTypes.Store(dir, cachedCoverType{
+		lastModified: book.LastModified,
+		mimeType:     mimeType,
+	})
+	return mimeType
+}
+
+// BookFilePath 在默认书籍目录下查找书籍指定格式的文件路径，找不到时返回空字符串
+func (h *Handler) BookFilePath(book *database.Book, format *database.Format) string {
+	return findBookFile(bookDir(h.config.BooksPath, book), format)
+}
+
+// findBookFile 在书籍目录下查找指定格式的文件
+func findBookFile(bookDir string, format *database.Format) string {
+	// Calibre按data.name加小写格式名保存文件，优先精确匹配，
+	// 避免AZW和AZW3等扩展名相近的格式互相误取
+	exact := filepath.Join(bookDir, format.Filename+"."+strings.ToLower(format.Format))
+	if info, err := os.Stat(exact); err == nil && !info.IsDir() {
+		return exact
+	}
+
+	// 精确匹配失败时再尝试多个可能的文件路径
 	possiblePaths := []string{
-		filepath.Join(basePath, bookPath, targetFormat.Filename),
+		filepath.Join(bookDir, format.Filename),
 	}
 
 	// 添加扩展名的变体
-	ext := getFileExtension(targetFormat.Format)
-	if ext != "" && !strings.HasSuffix(strings.ToLower(targetFormat.Filename), ext) {
-		possiblePaths = append(possiblePaths, filepath.Join(basePath, bookPath, targetFormat.Filename+ext))
+	ext := getFileExtension(format.Format)
+	if ext != "" && !strings.HasSuffix(strings.ToLower(format.Filename), ext) {
+		possiblePaths = append(possiblePaths, filepath.Join(bookDir, format.Filename+ext))
+	}
+	for _, altExt := range alternateExtensions[strings.ToUpper(format.Format)] {
+		possiblePaths = append(possiblePaths, filepath.Join(bookDir, format.Filename+altExt))
 	}
 
 	// 查找存在的文件
-	var fullPath string
 	for _, path := range possiblePaths {
 		if _, err := os.Stat(path); err == nil {
-			fullPath = path
-			break
+			return path
 		}
 	}
+	return ""
+}
 
-	if fullPath == "" {
-		c.String(http.StatusNotFound, "File not found")
+// cachedOPF 已缓存的metadata.opf解析结果
+type cachedOPF struct {
+	modTime time.Time
+	pkg     *opf.Package
+}
+
+// applyOPFFallback 数据库缺少简介或ISBN时，从书籍目录的metadata.opf补充
+func (h *Handler) applyOPFFallback(root string, book *database.Book) {
+	if !h.config.OPFFallback {
+		return
+	}
+	if book.Comments != "" && book.ISBN != nil && *book.ISBN != "" {
 		return
 	}
 
-	// 生成安全的文件名
-	safeFilename := generateSafeFilename(book.Title, targetFormat.Format)
+	pkg := h.loadOPF(bookDir(root, book))
+	if pkg == nil {
+		return
+	}
 
-	// 设置响应头
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.QueryEscape(safeFilename)))
-	c.Header("Content-Type", opds.GetMimeType(targetFormat.Format))
-	c.Header("Cache-Control", "public, max-age=3600")
+	if book.Comments == "" {
+		book.Comments = strings.TrimSpace(pkg.Metadata.Description)
+	}
+	if book.ISBN == nil || *book.ISBN == "" {
+		if isbn := pkg.Metadata.Identifier("ISBN"); isbn != "" {
+			book.ISBN = &isbn
+		}
+	}
+}
 
-	// 发送文件
-	file, err := os.Open(fullPath)
+// loadOPF 按需解析书籍目录下的metadata.opf，结果按文件修改时间缓存
+func (h *Handler) loadOPF(dir string) *opf.Package {
+	opfPath := filepath.Join(dir, "metadata.opf")
+	info, err := os.Stat(opfPath)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to open file")
+		return nil
+	}
+
+	if v, ok := h.opfCache.Load(opfPath); ok {
+		cached := v.(cachedOPF)
+		if cached.modTime.Equal(info.ModTime()) {
+			h.opfCache.hit()
+			return cached.pkg
+		}
+	}
+	h.opfCache.miss()
+
+	pkg, err := opf.ReadFile(opfPath)
+	if err != nil {
+		logger.Error.Printf("Failed to read metadata.opf: %v", err)
+	}
+	h.opfCache.Store(opfPath, cachedOPF{modTime: info.ModTime(), pkg: pkg})
+	return pkg
+}
+
+// booksPath 返回本次请求使用的书籍根目录。来自受信任代理的X-Books-Root请求头
+// 可以将根目录切换为CALIBRE_BOOKS_ROOTS允许列表中的目录
+func (h *Handler) booksPath(c *gin.Context) string {
+	override := c.GetHeader("X-Books-Root")
+	if override == "" || !h.fromTrustedProxy(c) {
+		return h.config.BooksPath
+	}
+
+	override = filepath.Clean(override)
+	for _, root := range h.config.BooksRoots {
+		if filepath.Clean(root) == override {
+			return root
+		}
+	}
+
+	logger.Warning.Printf("Ignoring X-Books-Root %q: not in allowed books roots", override)
+	return h.config.BooksPath
+}
+
+// bookDir 返回书籍所在目录的完整路径
+func bookDir(root string, book *database.Book) string {
+	return filepath.Join(root, strings.ReplaceAll(book.Path, "\\", "/"))
+}
+
+// seriesFile 系列打包中的单个文件
+type seriesFile struct {
+	path   string
+	name   string
+	size   int64
+	bookID int
+	format string
+}
+
+// DownloadSeries 将整个系列按序号打包为ZIP下载，每本书使用首选格式；系列名称用通配参数匹配，可以包含"/"
+func (h *Handler) DownloadSeries(c *gin.Context) {
+	seriesName := strings.TrimSuffix(strings.TrimPrefix(c.Param("name"), "/"), ".zip")
+	if seriesName == "" {
+		c.String(http.StatusNotFound, "Series not found")
 		return
 	}
-	defer file.Close()
 
-	fileInfo, err := file.Stat()
-	if err == nil {
-		c.Header("Content-Length", strconv.FormatInt(fileInfo.Size(), 10))
+	books, err := h.db.GetSeriesBooksContext(c.Request.Context(), seriesName)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get series books")
+		return
+	}
+	if len(books) == 0 {
+		c.String(http.StatusNotFound, "Series not found")
+		return
 	}
 
-	io.Copy(c.Writer, file)
+	files := h.collectSeriesFiles(h.booksPath(c), books)
+	if len(files) == 0 {
+		c.String(http.StatusNotFound, "No files found for series")
+		return
+	}
+
+	zipName := generateSafeFilename(seriesName, "") + ".zip"
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.QueryEscape(zipName)))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	defer zw.Close()
+
+	for _, f := range files {
+		if err := writeZipFile(zw, f); err != nil {
+			logger.Error.Printf("Failed to add %s to series zip: %v", f.path, err)
+			return
+		}
+		h.auditDownload(c, f.bookID, f.format, seriesName)
+	}
+}
+
+// collectSeriesFiles 按序号选出系列中每本书的首选格式文件，跳过缺失的文件，总大小不超过配置上限
+func (h *Handler) collectSeriesFiles(root string, books []database.Book) []seriesFile {
+	var files []seriesFile
+	var totalSize int64
+	for i := range books {
+		book := &books[i]
+		format := preferredFormat(book, h.config.PreferredFormats)
+		if format == nil {
+			continue
+		}
+
+		path := findBookFile(bookDir(root, book), format)
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if totalSize+info.Size() > h.config.SeriesZipMaxSize {
+			logger.Warning.Printf("Series zip size limit reached, stopping before book %d", book.ID)
+			break
+		}
+		totalSize += info.Size()
+
+		files = append(files, seriesFile{
+			path:   path,
+			name:   fmt.Sprintf("%02d - %s", len(files)+1, generateSafeFilename(book.Title, format.Format)),
+			size:   info.Size(),
+			bookID: book.ID,
+			format: format.Format,
+		})
+	}
+	return files
+}
+
+// writeZipFile 将文件不压缩地写入ZIP（电子书格式大多已经压缩）
+func writeZipFile(zw *zip.Writer, f seriesFile) error {
+	src, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	header := &zip.FileHeader{
+		Name:   f.name,
+		Method: zip.Store,
+	}
+	header.SetMode(0644)
+	header.Modified = time.Now()
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+// preferredFormat 按首选格式列表选择书籍的下载格式，都不匹配时返回按名称排序的第一个格式
+func preferredFormat(book *database.Book, preferred []string) *database.Format {
+	for _, name := range preferred {
+		if format := findFormat(book, name); format != nil {
+			return format
+		}
+	}
+	if len(book.Formats) > 0 {
+		return &book.Formats[0]
+	}
+	return nil
 }
 
 // 辅助函数
+func findFormat(book *database.Book, format string) *database.Format {
+	for i := range book.Formats {
+		if strings.EqualFold(book.Formats[i].Format, format) {
+			return &book.Formats[i]
+		}
+	}
+	return nil
+}
+
+// findBookCover 查找书籍封面，配置了封面目录时优先在其中按书籍的相对路径查找
+func (h *Handler) findBookCover(root string, book *database.Book) (string, string) {
+	if h.config.CoversPath != "" {
+		if coverPath, mimeType := findCover(bookDir(h.config.CoversPath, book)); coverPath != "" {
+			return coverPath, mimeType
+		}
+	}
+	return findCover(bookDir(root, book))
+}
+
+func findCover(bookDir string) (string, string) {
+	for _, ce := range coverExtensions {
+		coverPath := filepath.Join(bookDir, "cover"+ce.ext)
+		if _, err := os.Stat(coverPath); err == nil {
+			return coverPath, ce.mimeType
+		}
+	}
+	return "", ""
+}
+
 func getFileExtension(format string) string {
-	extensions := map[string]string{
-		"EPUB": ".epub",
-		"PDF":  ".pdf",
-		"MOBI": ".mobi",
-		"AZW3": ".azw3",
-		"FB2":  ".fb2",
-		"RTF":  ".rtf",
-		"TXT":  ".txt",
-		"HTML": ".html",
-		"LIT":  ".lit",
-	}
-	return extensions[strings.ToUpper(format)]
+	return opds.FileExtension(format)
+}
+
+// alternateExtensions 部分格式在磁盘上可能使用的其他扩展名
+var alternateExtensions = map[string][]string{
+	"KEPUB": {".kepub"},
+	"FBZ":   {".fb2.zip"},
+	"DJVU":  {".djv"},
+}
+
+// isCompressibleMimeType 判断MIME类型是否值得压缩，EPUB、PDF等已压缩的格式不再压缩
+func isCompressibleMimeType(mimeType string) bool {
+	if strings.HasPrefix(mimeType, "text/") {
+		return true
+	}
+	switch mimeType {
+	case "application/x-fictionbook+xml", "application/rtf":
+		return true
+	}
+	return false
+}
+
+// acceptsGzip 判断客户端是否接受gzip编码，按q值协商，gzip;q=0视为拒绝，与identity同等可接受时使用gzip
+func acceptsGzip(c *gin.Context) bool {
+	return negotiate(c.GetHeader("Accept-Encoding"), []string{"gzip", "identity"}, "identity") == "gzip"
 }
 
 func generateSafeFilename(title, format string) string {
@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+// testEPUB 生成最小的EPUB：每个章节一个XHTML文件，按顺序加入spine；extra为额外的压缩包文件
+func testEPUB(t *testing.T, chapters []string, extra map[string][]byte) []byte {
+	t.Helper()
+	var manifest, spine strings.Builder
+	files := map[string][]byte{
+		"META-INF/container.xml": []byte(`<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`),
+	}
+	for i, chapter := range chapters {
+		name := fmt.Sprintf("chapter%d.xhtml", i+1)
+		fmt.Fprintf(&manifest, `<item id="c%d" href="%s" media-type="application/xhtml+xml"/>`, i+1, name)
+		fmt.Fprintf(&spine, `<itemref idref="c%d"/>`, i+1)
+		files["OEBPS/"+name] = []byte(`<html xmlns="http://www.w3.org/1999/xhtml"><body><p>` + chapter + `</p></body></html>`)
+	}
+	files["OEBPS/content.opf"] = []byte(`<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Test</dc:title></metadata>
+  <manifest>` + manifest.String() + `</manifest>
+  <spine>` + spine.String() + `</spine>
+</package>`)
+	for name, data := range extra {
+		files[name] = data
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("application/epub+zip"))
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadSeriesZip(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	// 插入顺序与系列序号不同，包内应按序号排列
+	for _, book := range []struct {
+		title string
+		index float64
+	}{
+		{"Third", 3},
+		{"First", 1},
+		{"Second", 2},
+	} {
+		lib.AddBook(t, testutil.Book{
+			Title:       book.title,
+			Authors:     []string{"Author"},
+			Series:      "AC/DC",
+			SeriesIndex: book.index,
+			Formats:     []string{"EPUB"},
+		})
+	}
+	_, router := newTestServer(t, lib, nil)
+
+	rec := get(router, "/download/series/"+url.PathEscape("AC/DC")+".zip", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	want := []string{"01 - First.epub", "02 - Second.epub", "03 - Third.epub"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("zip entries = %v, want %v", names, want)
+	}
+
+	for _, target := range []string{"/download/series/Unknown.zip", "/download/series/"} {
+		if rec := get(router, target, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", target, rec.Code)
+		}
+	}
+}
+
+func TestBookPreview(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	short := lib.AddBook(t, testutil.Book{Title: "Short", Authors: []string{"A"}, Formats: []string{"EPUB"}})
+	lib.WriteFile(t, short, "Short - A.epub", testEPUB(t, []string{"第一章 开头", "Chapter two"}, nil))
+	long := lib.AddBook(t, testutil.Book{Title: "Long", Authors: []string{"A"}, Formats: []string{"EPUB"}})
+	lib.WriteFile(t, long, "Long - A.epub", testEPUB(t, []string{strings.Repeat("长", 40000)}, nil))
+	lib.AddBook(t, testutil.Book{Title: "Paper", Authors: []string{"A"}, Formats: []string{"PDF"}})
+	_, router := newTestServer(t, lib, nil)
+
+	type preview struct {
+		Text      string `json:"text"`
+		Size      int    `json:"size"`
+		Truncated bool   `json:"truncated"`
+	}
+	fetch := func(target string) preview {
+		t.Helper()
+		rec := get(router, target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", target, rec.Code, rec.Body.String())
+		}
+		var p preview
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	if got := fetch("/api/book/1/preview"); got.Text != "第一章 开头\n\nChapter two" || got.Truncated || got.Size != len(got.Text) {
+		t.Errorf("full preview = %+v", got)
+	}
+
+	// 截断时不切断多字节字符
+	got := fetch("/api/book/1/preview?size=8")
+	if got.Text != "第一" || !got.Truncated || !utf8.ValidString(got.Text) {
+		t.Errorf("size=8 preview = %+v", got)
+	}
+
+	// 请求的大小不超过上限
+	got = fetch(fmt.Sprintf("/api/book/%d/preview?size=%d", long, 10*maxPreviewSize))
+	if got.Size > maxPreviewSize || got.Size < maxPreviewSize-3 || !got.Truncated || !utf8.ValidString(got.Text) {
+		t.Errorf("capped preview: size %d, truncated %v", got.Size, got.Truncated)
+	}
+
+	if rec := get(router, "/api/book/3/preview", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("PDF preview: status %d, want 404", rec.Code)
+	}
+}
+
+func TestDownloadKepub(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	// Calibre以EPUB格式记录、文件名为.kepub.epub的书籍
+	recorded := lib.AddBook(t, testutil.Book{Title: "Kobo", Authors: []string{"A"}})
+	lib.Exec(t, `INSERT INTO data(book, format, uncompressed_size, name) VALUES (?, 'EPUB', 5, 'Kobo - A.kepub')`, recorded)
+	lib.WriteFile(t, recorded, "Kobo - A.kepub.epub", []byte("kepub"))
+	// 以KEPUB格式记录、扩展名为.kepub的书籍
+	native := lib.AddBook(t, testutil.Book{Title: "Native", Authors: []string{"A"}, Formats: []string{"KEPUB"}})
+	_, router := newTestServer(t, lib, nil)
+
+	feed := parseFeed(t, get(router, "/opds/books", nil).Body.Bytes())
+	for _, entry := range feed.Entries {
+		var found bool
+		for _, link := range entry.Links {
+			if strings.HasPrefix(link.Rel, "http://opds-spec.org/acquisition") {
+				found = true
+				if link.Type != "application/kepub+zip" || !strings.HasSuffix(link.Href, "/KEPUB") {
+					t.Errorf("%s: acquisition link %+v", entry.Title, link)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: missing acquisition link", entry.Title)
+		}
+	}
+
+	for _, tt := range []struct {
+		id           int
+		wantFilename string
+		wantBody     string
+	}{
+		{recorded, "Kobo.kepub.epub", "kepub"},
+		{native, "Native.kepub.epub", "Native KEPUB"},
+	} {
+		rec := get(router, fmt.Sprintf("/download/%d/KEPUB", tt.id), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("book %d: status %d", tt.id, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/kepub+zip" {
+			t.Errorf("book %d: Content-Type %q", tt.id, got)
+		}
+		if got := rec.Header().Get("Content-Disposition"); !strings.HasSuffix(got, "''"+tt.wantFilename) {
+			t.Errorf("book %d: Content-Disposition %q, want filename %s", tt.id, got, tt.wantFilename)
+		}
+		if rec.Body.String() != tt.wantBody {
+			t.Errorf("book %d: body %q, want %q", tt.id, rec.Body.String(), tt.wantBody)
+		}
+	}
+}
+
+func TestBooksRootOverride(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	id := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Formats: []string{"EPUB"}})
+	rel, err := filepath.Rel(lib.Root, lib.BookDir(t, id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 在另外两个根目录中放置同一路径、内容不同的文件
+	newRoot := func(content string) string {
+		root := t.TempDir()
+		dir := filepath.Join(root, rel)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "Dune - Frank Herbert.epub"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+	allowed := newRoot("allowed volume")
+	other := newRoot("other volume")
+
+	tests := []struct {
+		name    string
+		proxies string
+		root    string
+		want    string
+	}{
+		{"no header", "192.0.2.0/24", "", "Dune EPUB"},
+		{"allowed root from trusted proxy", "192.0.2.0/24", allowed, "allowed volume"},
+		{"allowed root with trailing slash", "192.0.2.0/24", allowed + "/", "allowed volume"},
+		{"root outside allow-list", "192.0.2.0/24", other, "Dune EPUB"},
+		{"allowed root from untrusted client", "10.0.0.0/8", allowed, "Dune EPUB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, router := newTestServer(t, lib, map[string]string{
+				"CALIBRE_BOOKS_ROOTS":  allowed,
+				"OPDS_TRUSTED_PROXIES": tt.proxies,
+			})
+			header := map[string]string{}
+			if tt.root != "" {
+				header["X-Books-Root"] = tt.root
+			}
+			rec := get(router, fmt.Sprintf("/download/%d/EPUB", id), header)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200", rec.Code)
+			}
+			if rec.Body.String() != tt.want {
+				t.Errorf("body %q, want %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadCompression(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	id := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Formats: []string{"EPUB", "TXT"}})
+	_, router := newTestServer(t, lib, nil)
+	gzipHeader := map[string]string{"Accept-Encoding": "gzip"}
+
+	// TXT在客户端接受gzip时压缩
+	rec := get(router, fmt.Sprintf("/download/%d/TXT", id), gzipHeader)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("TXT: status %d", rec.Code)
+	}
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("TXT: headers %v, want gzip", rec.Header())
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "Dune TXT" {
+		t.Errorf("TXT: decompressed body %q", body)
+	}
+
+	// 不接受gzip时原样输出
+	rec = get(router, fmt.Sprintf("/download/%d/TXT", id), nil)
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "Dune TXT" {
+		t.Errorf("TXT without gzip: encoding %q, body %q", rec.Header().Get("Content-Encoding"), rec.Body.String())
+	}
+
+	// EPUB已经压缩，不再gzip
+	rec = get(router, fmt.Sprintf("/download/%d/EPUB", id), gzipHeader)
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "Dune EPUB" {
+		t.Errorf("EPUB: encoding %q, body %q", rec.Header().Get("Content-Encoding"), rec.Body.String())
+	}
+}
+
+func TestDownloadComicAndFictionBookTypes(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Comic", Authors: []string{"A"}, Formats: []string{"CBZ", "CBR", "DJVU", "FBZ"}})
+	_, router := newTestServer(t, lib, nil)
+
+	tests := []struct {
+		format, mimeType, filename string
+	}{
+		{"CBZ", "application/vnd.comicbook+zip", "Comic.cbz"},
+		{"CBR", "application/vnd.comicbook-rar", "Comic.cbr"},
+		{"DJVU", "image/vnd.djvu", "Comic.djvu"},
+		{"FBZ", "application/x-zip-compressed-fb2", "Comic.fbz"},
+	}
+	for _, tt := range tests {
+		rec := get(router, "/download/1/"+tt.format, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.format, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.mimeType {
+			t.Errorf("%s: Content-Type %q, want %q", tt.format, got, tt.mimeType)
+		}
+		if got := rec.Header().Get("Content-Disposition"); !strings.HasSuffix(got, "''"+tt.filename) {
+			t.Errorf("%s: Content-Disposition %q, want filename %s", tt.format, got, tt.filename)
+		}
+		if want := "Comic " + tt.format; rec.Body.String() != want {
+			t.Errorf("%s: body %q, want %q", tt.format, rec.Body.String(), want)
+		}
+	}
+
+	// feed中的获取链接使用相同的MIME类型
+	feed := parseFeed(t, get(router, "/opds/books", nil).Body.Bytes())
+	types := map[string]bool{}
+	for _, link := range feed.Entries[0].Links {
+		if strings.HasPrefix(link.Rel, "http://opds-spec.org/acquisition") {
+			types[link.Type] = true
+		}
+	}
+	for _, tt := range tests {
+		if !types[tt.mimeType] {
+			t.Errorf("missing %s acquisition link, got %v", tt.mimeType, types)
+		}
+	}
+}
+
+func TestDownloadBestFormatPrefer(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"A"}, Formats: []string{"TXT", "PDF", "MOBI"}})
+
+	tests := []struct {
+		preferred string
+		target    string
+		want      string
+	}{
+		{"PDF,EPUB", "/download/1/best", "PDF"},
+		// 请求参数优先于配置
+		{"PDF,EPUB", "/download/1/best?prefer=mobi", "MOBI"},
+		{"PDF,EPUB", "/download/1/best?prefer=AZW3,+txt", "TXT"},
+		// 请求的格式都不存在时按配置选择
+		{"PDF,EPUB", "/download/1/best?prefer=AZW3", "PDF"},
+		// 配置的格式也不存在时按格式名排序取第一个
+		{"EPUB", "/download/1/best?prefer=AZW3", "MOBI"},
+	}
+	for _, tt := range tests {
+		_, router := newTestServer(t, lib, map[string]string{"PREFERRED_FORMATS": tt.preferred})
+		rec := get(router, tt.target, nil)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("%s: status %d, want 302", tt.target, rec.Code)
+		}
+		if got, want := rec.Header().Get("Location"), "http://example.com/download/1/"+tt.want; got != want {
+			t.Errorf("PREFERRED_FORMATS=%s %s: Location %q, want %q", tt.preferred, tt.target, got, want)
+		}
+	}
+
+	_, router := newTestServer(t, lib, nil)
+	if rec := get(router, "/download/1/best?prefer=EPUB,BOGUS", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: status %d, want 400", rec.Code)
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Formats: []string{"EPUB"}})
+	_, router := newTestServer(t, lib, nil)
+	// 文件内容为"Dune EPUB"，共9字节
+	stale := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+
+	tests := []struct {
+		name       string
+		header     map[string]string
+		wantStatus int
+		wantRange  string
+		wantBody   string
+	}{
+		{"no range", nil, http.StatusOK, "", "Dune EPUB"},
+		{"suffix", map[string]string{"Range": "bytes=5-"}, http.StatusPartialContent, "bytes 5-8/9", "EPUB"},
+		{"middle", map[string]string{"Range": "bytes=1-3"}, http.StatusPartialContent, "bytes 1-3/9", "une"},
+		{"unsatisfiable", map[string]string{"Range": "bytes=100-"}, http.StatusRequestedRangeNotSatisfiable, "bytes */9", ""},
+		// 文件在If-Range的时间之后修改过，返回完整文件
+		{"stale if-range", map[string]string{"Range": "bytes=5-", "If-Range": stale}, http.StatusOK, "", "Dune EPUB"},
+	}
+	for _, tt := range tests {
+		rec := get(router, "/download/1/EPUB", tt.header)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.wantStatus)
+			continue
+		}
+		if got := rec.Header().Get("Content-Range"); got != tt.wantRange {
+			t.Errorf("%s: Content-Range %q, want %q", tt.name, got, tt.wantRange)
+		}
+		if tt.wantStatus != http.StatusRequestedRangeNotSatisfiable && rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: body %q, want %q", tt.name, rec.Body.String(), tt.wantBody)
+		}
+		if rec.Code == http.StatusOK && rec.Header().Get("Accept-Ranges") != "bytes" {
+			t.Errorf("%s: missing Accept-Ranges", tt.name)
+		}
+	}
+}
+
+func TestDownloadNearDuplicateFormats(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	// AZW和AZW3的文件名只差扩展名末尾
+	lib.AddBook(t, testutil.Book{Title: "Kindle", Authors: []string{"A"}, Formats: []string{"AZW3", "AZW"}})
+	// data.name已经带有扩展名的旧书库，精确匹配失败后回退到按文件名查找
+	legacy := lib.AddBook(t, testutil.Book{Title: "Legacy", Authors: []string{"A"}})
+	lib.Exec(t, `INSERT INTO data(book, format, uncompressed_size, name) VALUES (?, 'PDF', 6, 'Legacy.pdf')`, legacy)
+	lib.WriteFile(t, legacy, "Legacy.pdf", []byte("legacy"))
+	_, router := newTestServer(t, lib, nil)
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/download/1/AZW", "Kindle AZW"},
+		{"/download/1/AZW3", "Kindle AZW3"},
+		{"/download/1/azw", "Kindle AZW"},
+		{"/download/2/PDF", "legacy"},
+	}
+	for _, tt := range tests {
+		rec := get(router, tt.target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.target, rec.Code)
+		}
+		if rec.Body.String() != tt.want {
+			t.Errorf("%s: body %q, want %q", tt.target, rec.Body.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/config"
+	"github.com/ricci/calibre-opds-go/internal/database"
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestServer 以测试书库创建处理器和路由，env为额外的环境变量配置
+func newTestServer(t *testing.T, lib *testutil.Library, env map[string]string) (*Handler, *gin.Engine) {
+	t.Helper()
+
+	t.Setenv("CALIBRE_DB_PATH", lib.DBPath)
+	t.Setenv("CALIBRE_BOOKS_PATH", lib.Root)
+	t.Setenv("THUMBNAIL_CACHE_DIR", t.TempDir())
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	cfg := config.Load()
+
+	db, err := database.NewDB(lib.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetAuthorAliases(cfg.AuthorAliases)
+	db.SetQueryTimeout(cfg.ConnectionTimeout)
+
+	h := NewHandler(db, cfg)
+	return h, newTestRouter(h)
+}
+
+// newTestRouter 注册与cmd/server相同路径的路由
+func newTestRouter(h *Handler) *gin.Engine {
+	router := gin.New()
+	if err := h.ConfigureTrustedProxies(router); err != nil {
+		panic(err)
+	}
+	root := router.Group(h.config.BasePath)
+
+	opds := root.Group("/opds")
+	feeds := opds.Group("", h.CacheControl(CacheFeed))
+	feeds.GET("", h.OPDSRoot)
+	feeds.GET("/books", h.OPDSBooks)
+	feeds.GET("/search.xml", h.OPDSSearch)
+	feeds.GET("/all", h.OPDSAll)
+	feeds.GET("/crawlable", h.OPDSAll)
+	feeds.GET("/book/:id", h.OPDSBookDetail)
+	feeds.GET("/tags", h.OPDSTags)
+	feeds.GET("/tag/*name", h.OPDSTag)
+	feeds.GET("/publishers", h.OPDSPublishers)
+	feeds.GET("/decades", h.OPDSDecades)
+	feeds.GET("/changelog", h.OPDSChangelog)
+	opds.GET("/continue", h.OPDSContinueReading)
+	opds.GET("/cover/:id", h.CacheControl(CacheCover), h.GetCover)
+	opds.GET("/book/:id/acquire/:format", h.OPDSAcquire)
+
+	downloads := root.Group("/download", h.CacheControl(CacheDownload))
+	downloads.GET("/:id/best", h.DownloadBestFormat)
+	downloads.GET("/:id/:format", h.DownloadBook)
+	downloads.GET("/series/*name", h.DownloadSeries)
+
+	api := root.Group("/api")
+	api.GET("/books", h.APIBooks)
+	api.POST("/books/search", h.LimitBody(), h.APISearchBooks)
+	api.GET("/book/:id", h.APIBookDetail)
+	api.GET("/book/:id/similar", h.APIBookSimilar)
+	api.GET("/book/:id/cover/info", h.APICoverInfo)
+	api.GET("/covers/manifest", h.APICoverManifest)
+	api.GET("/book/:id/preview", h.APIBookPreview)
+	api.GET("/search/suggest", h.APISearchSuggest)
+	api.GET("/health", h.APIHealth)
+	api.GET("/diagnose", h.APIDiagnose)
+	api.GET("/cache-stats", h.APICacheStats)
+	api.GET("/config", h.APIConfig)
+	api.GET("/taxonomy", h.APITaxonomy)
+	api.GET("/formats", h.APIFormats)
+	api.GET("/tags", h.APITags)
+	api.GET("/changelog", h.APIChangelog)
+	api.POST("/book/:id/progress", h.LimitBody(), h.APIUpdateProgress)
+	return router
+}
+
+// get 发送GET请求并返回响应，header为额外的请求头
+func get(router http.Handler, target string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// post 发送带JSON请求体的POST请求并返回响应
+func post(router http.Handler, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// testFeed 测试中解析的Atom feed
+type testFeed struct {
+	Title   string     `xml:"title"`
+	Links   []testLink `xml:"link"`
+	Entries []struct {
+		Title      string     `xml:"title"`
+		ID         string     `xml:"id"`
+		Summary    string     `xml:"summary"`
+		Updated    string     `xml:"updated"`
+		Links      []testLink `xml:"link"`
+		Categories []struct {
+			Term string `xml:"term,attr"`
+		} `xml:"category"`
+	} `xml:"entry"`
+}
+
+// testLink 测试中解析的feed链接
+type testLink struct {
+	Rel   string `xml:"rel,attr"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr"`
+}
+
+// parseFeed 解析Atom feed
+func parseFeed(t *testing.T, data []byte) testFeed {
+	t.Helper()
+	var feed testFeed
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		t.Fatalf("invalid feed: %v\n%s", err, data)
+	}
+	return feed
+}
+
+// link 返回第一个rel匹配的链接，没有时返回false
+func (f testFeed) link(rel string) (testLink, bool) {
+	for _, link := range f.Links {
+		if link.Rel == rel {
+			return link, true
+		}
+	}
+	return testLink{}, false
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LimitBody 限制写接口的请求体大小和读取时间：超过大小返回413，读取超时返回408
+func (h *Handler) LimitBody() gin.HandlerFunc {
+	maxBytes := h.config.MaxRequestBodySize
+	timeout := h.config.WriteRequestTimeout
+
+	return func(c *gin.Context) {
+		if maxBytes > 0 {
+			if c.Request.ContentLength > maxBytes {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		}
+
+		if timeout > 0 {
+			// 慢速客户端读取请求体超时后连接读取失败，避免长期占用goroutine
+			rc := http.NewResponseController(c.Writer)
+			if err := rc.SetReadDeadline(time.Now().Add(timeout)); err == nil {
+				body := &deadlineBody{ReadCloser: c.Request.Body}
+				c.Request.Body = body
+				defer func() {
+					// 超时后保留已过期的截止时间：服务器丢弃剩余请求体时立即失败并关闭连接，
+					// 否则会继续等待慢速客户端，408响应迟迟发不出去
+					if !body.timedOut {
+						rc.SetReadDeadline(time.Time{})
+					}
+				}()
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// deadlineBody 记录读取请求体时是否超时
+type deadlineBody struct {
+	io.ReadCloser
+	timedOut bool
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		b.timedOut = true
+	}
+	return n, err
+}
+
+// bodyErrorStatus 根据读取请求体的错误返回对应的状态码
+func bodyErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusRequestTimeout
+	}
+
+	return http.StatusBadRequest
+}
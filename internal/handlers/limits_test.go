@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+// limitedRouter 注册一个带请求体限制的测试写接口
+func limitedRouter(h *Handler) *gin.Engine {
+	router := gin.New()
+	router.POST("/limited", h.LimitBody(), func(c *gin.Context) {
+		var req map[string]interface{}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(bodyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, req)
+	})
+	return router
+}
+
+func TestLimitBodyTooLarge(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	h, _ := newTestServer(t, lib, map[string]string{"MAX_REQUEST_BODY_KB": "1"})
+	router := limitedRouter(h)
+
+	large := `{"q": "` + strings.Repeat("x", 2048) + `"}`
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		want    int
+	}{
+		{"small body", `{"q": "dune"}`, false, http.StatusOK},
+		{"declared length too large", large, false, http.StatusRequestEntityTooLarge},
+		// 分块传输没有Content-Length，读取超过上限时才发现
+		{"chunked body too large", large, true, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/limited", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+}
+
+func TestLimitBodyReadTimeout(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	h, _ := newTestServer(t, lib, map[string]string{"WRITE_REQUEST_TIMEOUT": "100ms"})
+	server := httptest.NewServer(limitedRouter(h))
+	t.Cleanup(server.Close)
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 声明的请求体长度大于实际发送的内容，服务器应在超时后返回408
+	io.WriteString(conn, "POST /limited HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/json\r\nContent-Length: 64\r\n\r\n{\"q\":")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("status %d, want 408", resp.StatusCode)
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+)
+
+// acceptRange Accept类请求头中的一项及其q值
+type acceptRange struct {
+	value string
+	q     float64
+}
+
+// parseAccept 解析Accept、Accept-Encoding等请求头，q值缺失时为1，无法解析时为0
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		value := strings.ToLower(strings.TrimSpace(fields[0]))
+		if value == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil || parsed < 0 {
+				parsed = 0
+			}
+			q = min(parsed, 1)
+		}
+		ranges = append(ranges, acceptRange{value: value, q: q})
+	}
+	return ranges
+}
+
+// acceptQuality 返回value在已解析请求头中的q值，取最具体的匹配项（精确 > type/* > *），没有匹配时返回0
+func acceptQuality(ranges []acceptRange, value string) float64 {
+	value = strings.ToLower(value)
+	mainType, _, _ := strings.Cut(value, "/")
+
+	quality, specificity := 0.0, -1
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.value == value:
+			s = 2
+		case strings.HasSuffix(r.value, "/*") && strings.TrimSuffix(r.value, "/*") == mainType:
+			s = 1
+		case r.value == "*" || r.value == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			quality, specificity = r.q, s
+		}
+	}
+	return quality
+}
+
+// negotiate 从supported中选出客户端q值最高的取值；请求头缺失时返回fallback，
+// q值相同时按supported的顺序（服务端的偏好）；没有可接受的取值时返回空字符串
+func negotiate(header string, supported []string, fallback string) string {
+	if strings.TrimSpace(header) == "" {
+		return fallback
+	}
+
+	ranges := parseAccept(header)
+	best, bestQ := "", 0.0
+	for _, candidate := range supported {
+		if q := acceptQuality(ranges, candidate); q > bestQ {
+			best, bestQ = candidate, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+func TestNegotiate(t *testing.T) {
+	feeds := []string{atomMediaType, jsonMediaType}
+	tests := []struct {
+		name      string
+		header    string
+		supported []string
+		want      string
+	}{
+		{"missing header", "", feeds, atomMediaType},
+		{"higher q wins", "application/opds+json;q=0.9, application/atom+xml;q=0.8", feeds, jsonMediaType},
+		{"order does not matter", "application/atom+xml;q=0.8, application/opds+json;q=0.9", feeds, jsonMediaType},
+		{"implicit q=1", "application/atom+xml;q=0.5, application/opds+json", feeds, jsonMediaType},
+		{"wildcard tie uses server order", "*/*", feeds, atomMediaType},
+		{"wildcard tie with json first", "*/*", []string{jsonMediaType, atomMediaType}, jsonMediaType},
+		{"exact beats wildcard", "*/*;q=0.1, application/opds+json", feeds, jsonMediaType},
+		{"type wildcard", "application/*;q=0.5, application/atom+xml;q=0.2", feeds, jsonMediaType},
+		{"q=0 rejects", "application/atom+xml;q=0, */*", feeds, jsonMediaType},
+		{"nothing acceptable", "text/html", feeds, ""},
+		{"gzip tie prefers gzip", "gzip, identity", []string{"gzip", "identity"}, "gzip"},
+		{"identity preferred by q", "gzip;q=0.5, identity", []string{"gzip", "identity"}, "identity"},
+		{"gzip refused", "gzip;q=0", []string{"gzip", "identity"}, ""},
+	}
+	for _, tt := range tests {
+		if got := negotiate(tt.header, tt.supported, tt.supported[0]); got != tt.want {
+			t.Errorf("%s: negotiate(%q) = %q, want %q", tt.name, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestFeedContentNegotiation(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Formats: []string{"EPUB"}})
+
+	tests := []struct {
+		defaultFormat string
+		accept        string
+		want          string
+	}{
+		{"atom", "", "application/atom+xml"},
+		{"atom", "*/*", "application/atom+xml"},
+		{"atom", "application/opds+json;q=0.9, application/atom+xml;q=0.8", "application/opds+json"},
+		{"json", "", "application/opds+json"},
+		{"json", "*/*", "application/opds+json"},
+		{"json", "application/opds+json;q=0.5, application/atom+xml", "application/atom+xml"},
+		{"json", "text/html", "application/opds+json"},
+	}
+	for _, tt := range tests {
+		_, router := newTestServer(t, lib, map[string]string{"OPDS_DEFAULT_FORMAT": tt.defaultFormat})
+		rec := get(router, "/opds/books", map[string]string{"Accept": tt.accept})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("default %s, Accept %q: status %d", tt.defaultFormat, tt.accept, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("default %s, Accept %q: Content-Type %q, want %s", tt.defaultFormat, tt.accept, got, tt.want)
+		}
+		if !strings.Contains(strings.Join(rec.Header().Values("Vary"), ","), "Accept") {
+			t.Errorf("default %s, Accept %q: missing Vary: Accept", tt.defaultFormat, tt.accept)
+		}
+	}
+}
+
+func TestFeedJSON(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Formats: []string{"EPUB"}})
+	_, router := newTestServer(t, lib, nil)
+
+	rec := get(router, "/opds/books", map[string]string{"Accept": "application/opds+json"})
+	var feed struct {
+		Metadata struct {
+			Title string `json:"title"`
+		} `json:"metadata"`
+		Links []struct {
+			Rel  string `json:"rel"`
+			Type string `json:"type"`
+		} `json:"links"`
+		Publications []struct {
+			Metadata struct {
+				Title  string `json:"title"`
+				Author []struct {
+					Name string `json:"name"`
+				} `json:"author"`
+			} `json:"metadata"`
+			Links []struct {
+				Rel  string `json:"rel"`
+				Href string `json:"href"`
+				Type string `json:"type"`
+			} `json:"links"`
+		} `json:"publications"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, rec.Body.String())
+	}
+
+	if feed.Metadata.Title == "" {
+		t.Error("missing feed title")
+	}
+	for _, link := range feed.Links {
+		if link.Rel == "self" && link.Type != "application/opds+json" {
+			t.Errorf("self link type = %q, want application/opds+json", link.Type)
+		}
+	}
+	if len(feed.Publications) != 1 {
+		t.Fatalf("got %d publications, want 1", len(feed.Publications))
+	}
+	pub := feed.Publications[0]
+	if pub.Metadata.Title != "Dune" || len(pub.Metadata.Author) != 1 || pub.Metadata.Author[0].Name != "Frank Herbert" {
+		t.Errorf("metadata = %+v", pub.Metadata)
+	}
+	found := false
+	for _, link := range pub.Links {
+		if strings.HasPrefix(link.Rel, "http://opds-spec.org/acquisition") && link.Type == "application/epub+zip" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("missing EPUB acquisition link: %+v", pub.Links)
+	}
+}
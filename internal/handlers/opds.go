@@ -1,41 +1,107 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/audit"
 	"github.com/ricci/calibre-opds-go/internal/config"
 	"github.com/ricci/calibre-opds-go/internal/database"
+	"github.com/ricci/calibre-opds-go/internal/metrics"
 	"github.com/ricci/calibre-opds-go/internal/opds"
+	"github.com/ricci/calibre-opds-go/internal/progress"
+	"github.com/ricci/calibre-opds-go/pkg/logger"
+)
+
+// 分页大小
+const (
+	defaultBooksPageSize = 20  // 书籍列表默认每页数量
+	defaultListPageSize  = 50  // 作者/系列/标签列表默认每页数量
+	maxPageSize          = 100 // 每页最大数量
+
+	relatedLinksLimit = 5 // 详情feed中相似书籍链接的数量
+
+	defaultRandomCount = 1 // 随机书籍默认数量
 )
 
 // Handler HTTP处理器
 type Handler struct {
 	db     *database.DB
 	config *config.Config
+
+	// trustedProxies 受信任的代理地址
+	trustedProxies []*net.IPNet
+
+	// coverTypes 缓存书籍封面的MIME类型，键为书籍目录
+	coverTypes statsCache
+	// opfCache 缓存解析过的metadata.opf，键为文件路径
+	opfCache statsCache
+	// coverInfos 缓存封面的尺寸信息，键为封面文件路径
+	coverInfos statsCache
+	// thumbnails 缩放和填充后封面的磁盘缓存
+	thumbnails *thumbnailCache
+
+	// recentErrors 最近发生的错误
+	recentErrors *errorLog
+
+	// progress 阅读进度存储，为nil时不支持阅读进度
+	progress *progress.Store
+
+	// auditLog 下载审计日志，为nil时不记录
+	auditLog *audit.Log
+	// metrics Prometheus指标，为nil时不统计
+	metrics *metrics.Metrics
+
+	// integrity 最近一次数据库完整性检查的结果
+	integrity atomic.Pointer[database.IntegrityResult]
+	// integrityRunning 同一时间只执行一次完整性检查
+	integrityRunning sync.Mutex
 }
 
 // NewHandler 创建新的处理器
 func NewHandler(db *database.DB, cfg *config.Config) *Handler {
 	return &Handler{
-		db:     db,
-		config: cfg,
+		db:             db,
+		config:         cfg,
+		trustedProxies: parseTrustedProxies(cfg.TrustedProxies),
+		recentErrors:   newErrorLog(cfg.ErrorLogSize),
+		thumbnails:     newThumbnailCache(cfg.ThumbnailCacheDir, cfg.ThumbnailCacheMaxSize),
 	}
 }
 
 // OPDSRoot OPDS根目录
 func (h *Handler) OPDSRoot(c *gin.Context) {
-	baseURL := getBaseURL(c)
-	gen := opds.NewGenerator(baseURL)
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
 
 	entries := []opds.Entry{
-		gen.CreateNavigationEntry("最新书籍", "/opds/books", "按最近添加或修改的时间排序"),
+		gen.CreateNavigationEntry("最近添加", "/opds/recent", "按添加到书库的时间排序"),
+		gen.CreateNavigationEntry("最近修改", "/opds/books", "按最近修改元数据的时间排序"),
+		gen.CreateNavigationEntry("随便看看", "/opds/random", "从书库中随机挑选的书籍"),
 		gen.CreateNavigationEntry("按作者浏览", "/opds/authors", "按作者分类的书籍"),
+		gen.CreateNavigationEntry("按作者首字母浏览", "/opds/authors/letters", "按作者姓名首字母快速跳转"),
 		gen.CreateNavigationEntry("按系列浏览", "/opds/series", "按系列分类的书籍"),
 		gen.CreateNavigationEntry("按标签浏览", "/opds/tags", "按标签分类的书籍"),
+		gen.CreateNavigationEntry("按出版社浏览", "/opds/publishers", "按出版社分类的书籍"),
+		gen.CreateNavigationEntry("按出版年代浏览", "/opds/decades", "按出版年代分组的书籍"),
+	}
+	if h.progress != nil {
+		entries = append(entries, gen.CreateNavigationEntry("继续阅读", "/opds/continue", "最近阅读但尚未读完的书籍"))
+	}
+	if h.libraryEmpty(c) {
+		// 空书库不展示指向空分类的导航
+		entries = []opds.Entry{
+			gen.CreateNavigationEntry(emptyLibraryTitle, "/opds/books", "在Calibre中向书库添加书籍后，这里会显示按作者、系列和标签分类的目录"),
+		}
 	}
 
 	links := []opds.Link{
@@ -44,6 +110,16 @@ func (h *Handler) OPDSRoot(c *gin.Context) {
 			Href: baseURL + "/opds",
 			Type: "application/atom+xml;type=feed;profile=opds-catalog",
 		},
+		{
+			Rel:  "http://opds-spec.org/crawlable",
+			Href: baseURL + "/opds/all",
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+		{
+			Rel:  "search",
+			Href: baseURL + "/opds/search.xml",
+			Type: opds.OpenSearchMimeType,
+		},
 	}
 
 	xmlData, err := gen.CreateFeed("Calibre OPDS 目录", entries, links, nil)
@@ -52,7 +128,20 @@ func (h *Handler) OPDSRoot(c *gin.Context) {
 		return
 	}
 
-	c.Data(http.StatusOK, "application/atom+xml;charset=utf-8", xmlData)
+	h.serveFeed(c, gen, xmlData)
+}
+
+// OPDSSearch OpenSearch描述文档，搜索模板指向书籍列表的search参数
+func (h *Handler) OPDSSearch(c *gin.Context) {
+	gen := h.newGenerator(c)
+
+	xmlData, err := gen.CreateOpenSearchDescription("Calibre", "搜索书名和作者")
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to generate search description")
+		return
+	}
+
+	c.Data(http.StatusOK, opds.OpenSearchMimeType+";charset=utf-8", xmlData)
 }
 
 // OPDSBooks OPDS书籍列表
@@ -60,29 +149,100 @@ func (h *Handler) OPDSBooks(c *gin.Context) {
 	search := c.Query("search")
 	author := c.Query("author")
 	series := c.Query("series")
-	tag := c.Query("tag")
-	limit := getIntParam(c, "limit", 20, 100)
+	tags := getTagsParam(c)
+	lang := c.Query("lang")
+	publisher := c.Query("publisher")
+	format := strings.ToUpper(c.Query("format"))
+	showAll := c.Query("all") == "1"
+	noSeries := c.Query("no_series") == "1"
+	hasCover := getBoolParam(c, "has_cover")
+	yearFrom := getIntParam(c, "year_from", 0, 0)
+	yearTo := getIntParam(c, "year_to", 0, 0)
+	pubDateFrom := getDateParam(c, "pubdate_from")
+	pubDateTo := getDateParam(c, "pubdate_to")
+	noPubDate := c.Query("no_pubdate") == "1"
+	minRating := getRatingParam(c, "rating")
+	sortField, sortOrder := getBookSortParams(c)
+	limit := getLimitParam(c, defaultBooksPageSize, maxPageSize)
 	offset := getIntParam(c, "offset", 0, 0)
 
-	baseURL := getBaseURL(c)
-	gen := opds.NewGenerator(baseURL)
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
 
-	// 获取过滤后的书籍
-	books, err := h.db.GetBooksFiltered(limit, offset, search, author, series, tag)
-	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to get books")
-		return
+	filter := database.BookFilter{
+		Search:    search,
+		Series:    series,
+		NoSeries:  noSeries,
+		HasCover:  hasCover,
+		NoPubDate: noPubDate,
+		MinRating: minRating,
+		Language:  lang,
+		Publisher: publisher,
+		Format:    format,
+		Sort:      sortField,
+		Order:     sortOrder,
+	}
+	if yearFrom > 0 {
+		filter.PubDateFrom = fmt.Sprintf("%04d-01-01", yearFrom)
+	}
+	if yearTo > 0 {
+		filter.PubDateTo = fmt.Sprintf("%04d-12-31", yearTo)
+	}
+	// 精确日期优先于年份
+	if pubDateFrom != "" {
+		filter.PubDateFrom = pubDateFrom
+	}
+	if pubDateTo != "" {
+		filter.PubDateTo = pubDateTo
+	}
+	if author != "" {
+		filter.Authors = []string{author}
 	}
+	filter.Tags = tags
 
-	// 获取总数
-	totalBooks, err := h.db.GetBooksCountFiltered(search, author, series, tag)
-	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to get book count")
-		return
+	// 只有关键字搜索时优先使用全文索引，按相关度排序
+	var books []database.Book
+	var totalBooks int
+	var err error
+	ranked := false
+	if filter.IsSearchOnly() {
+		books, totalBooks, err = h.db.GetBooksFTSContext(c.Request.Context(), search, limit, offset)
+		if err != nil && !errors.Is(err, database.ErrFTSUnavailable) {
+			c.String(h.dbErrorStatus(c, err), "Failed to search books")
+			return
+		}
+		ranked = err == nil
+	}
+	if !ranked {
+		// 获取总数
+		totalBooks, err = h.db.GetBooksCountFilteredContext(c.Request.Context(), filter)
+		if err != nil {
+			c.String(h.dbErrorStatus(c, err), "Failed to get book count")
+			return
+		}
+
+		// 作者书籍过多时按系列分组展示
+		threshold := h.config.AuthorCollapseThreshold
+		if author != "" && !filter.HasNonAuthorFilters() && !showAll && threshold > 0 && totalBooks > threshold {
+			h.opdsAuthorGroups(c, gen, author, totalBooks)
+			return
+		}
+
+		// 获取过滤后的书籍
+		books, err = h.db.GetBooksFilteredContext(c.Request.Context(), limit, offset, filter)
+		if err != nil {
+			c.String(h.dbErrorStatus(c, err), "Failed to get books")
+			return
+		}
 	}
 
 	// 创建条目
 	var entries []opds.Entry
+	if series != "" && offset == 0 {
+		if entry, ok := h.seriesBundleEntry(c, gen, series); ok {
+			entries = append(entries, entry)
+		}
+	}
 	for _, book := range books {
 		entries = append(entries, gen.CreateBookEntry(&book))
 	}
@@ -91,45 +251,33 @@ func (h *Handler) OPDSBooks(c *gin.Context) {
 	currentPage := offset/limit + 1
 	totalPages := (totalBooks + limit - 1) / limit
 
-	queryParams := url.Values{}
-	if search != "" {
-		queryParams.Set("search", search)
+	queryParams := bookFilterParams(filter)
+	if showAll {
+		queryParams.Set("all", "1")
 	}
-	if author != "" {
-		queryParams.Set("author", author)
-	}
-	if series != "" {
-		queryParams.Set("series", series)
+	if gen.Minimal {
+		queryParams.Set("verbose", "0")
 	}
-	if tag != "" {
-		queryParams.Set("tag", tag)
-	}
-	queryParams.Set("limit", strconv.Itoa(limit))
-	queryParams.Set("offset", strconv.Itoa(offset))
+	facetLinks := coverFacetLinks(baseURL, queryParams, hasCover)
+	facetLinks = append(facetLinks, bookSortFacetLinks(baseURL, queryParams, sortField)...)
+
+	selfParams := cloneParams(queryParams)
+	selfParams.Set("limit", strconv.Itoa(limit))
+	selfParams.Set("offset", strconv.Itoa(offset))
 
 	links := []opds.Link{
 		{
 			Rel:  "self",
-			Href: fmt.Sprintf("%s/opds/books?%s", baseURL, queryParams.Encode()),
+			Href: fmt.Sprintf("%s/opds/books?%s", baseURL, selfParams.Encode()),
 			Type: "application/atom+xml;type=feed;profile=opds-catalog",
 		},
 	}
 
+	links = append(links, facetLinks...)
+
 	// 下一页链接
 	if offset+limit < totalBooks {
-		nextParams := url.Values{}
-		if search != "" {
-			nextParams.Set("search", search)
-		}
-		if author != "" {
-			nextParams.Set("author", author)
-		}
-		if series != "" {
-			nextParams.Set("series", series)
-		}
-		if tag != "" {
-			nextParams.Set("tag", tag)
-		}
+		nextParams := cloneParams(queryParams)
 		nextParams.Set("limit", strconv.Itoa(limit))
 		nextParams.Set("offset", strconv.Itoa(offset+limit))
 
@@ -147,19 +295,7 @@ func (h *Handler) OPDSBooks(c *gin.Context) {
 		if prevOffset < 0 {
 			prevOffset = 0
 		}
-		prevParams := url.Values{}
-		if search != "" {
-			prevParams.Set("search", search)
-		}
-		if author != "" {
-			prevParams.Set("author", author)
-		}
-		if series != "" {
-			prevParams.Set("series", series)
-		}
-		if tag != "" {
-			prevParams.Set("tag", tag)
-		}
+		prevParams := cloneParams(queryParams)
 		prevParams.Set("limit", strconv.Itoa(limit))
 		prevParams.Set("offset", strconv.Itoa(prevOffset))
 
@@ -177,16 +313,23 @@ func (h *Handler) OPDSBooks(c *gin.Context) {
 		title = fmt.Sprintf("作者: %s - 第 %d/%d 页", author, currentPage, totalPages)
 	} else if series != "" {
 		title = fmt.Sprintf("系列: %s - 第 %d/%d 页", series, currentPage, totalPages)
-	} else if tag != "" {
-		title = fmt.Sprintf("标签: %s - 第 %d/%d 页", tag, currentPage, totalPages)
+	} else if len(tags) > 0 {
+		title = fmt.Sprintf("标签: %s - 第 %d/%d 页", strings.Join(tags, " + "), currentPage, totalPages)
 	} else if search != "" {
 		title = fmt.Sprintf("搜索结果: \"%s\" - 第 %d/%d 页", search, currentPage, totalPages)
 	}
 
+	if totalBooks == 0 && h.config.EmptyLibraryHint {
+		title = "没有符合条件的书籍"
+		if h.libraryEmpty(c) {
+			title = emptyLibraryTitle
+		}
+	}
+
 	feedInfo := &opds.FeedInfo{
-		TotalResults:  totalBooks,
-		StartIndex:    offset,
-		ItemsPerPage:  limit,
+		TotalResults: totalBooks,
+		StartIndex:   offset,
+		ItemsPerPage: limit,
 	}
 
 	xmlData, err := gen.CreateFeed(title, entries, links, feedInfo)
@@ -195,197 +338,1254 @@ func (h *Handler) OPDSBooks(c *gin.Context) {
 		return
 	}
 
-	c.Data(http.StatusOK, "application/atom+xml;charset=utf-8", xmlData)
+	h.serveFeed(c, gen, xmlData)
 }
 
-// OPDSBookDetail OPDS书籍详情
-func (h *Handler) OPDSBookDetail(c *gin.Context) {
-	bookID, err := strconv.Atoi(c.Param("id"))
+// opdsAuthorGroups 按系列分组展示作者的书籍，并提供查看全部的入口
+func (h *Handler) opdsAuthorGroups(c *gin.Context, gen *opds.Generator, author string, totalBooks int) {
+	seriesList, err := h.db.GetAuthorSeriesContext(c.Request.Context(), author)
 	if err != nil {
-		c.String(http.StatusBadRequest, "Invalid book ID")
+		c.String(h.dbErrorStatus(c, err), "Failed to get series")
 		return
 	}
 
-	book, err := h.db.GetBookDetail(bookID)
+	standalone, err := h.db.GetBooksCountFilteredContext(c.Request.Context(), database.BookFilter{
+		Authors:  []string{author},
+		NoSeries: true,
+	})
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to get book")
+		c.String(h.dbErrorStatus(c, err), "Failed to get book count")
 		return
 	}
-	if book == nil {
-		c.String(http.StatusNotFound, "Book not found")
+
+	authorParam := url.QueryEscape(author)
+	entries := []opds.Entry{
+		gen.CreateNavigationEntry(
+			fmt.Sprintf("显示全部 (%d 本书)", totalBooks),
+			fmt.Sprintf("/opds/books?author=%s&all=1", authorParam),
+			fmt.Sprintf("%s 的全部书籍", author),
+		),
+	}
+	for _, series := range seriesList {
+		entries = append(entries, gen.CreateNavigationEntry(
+			fmt.Sprintf("%s (%d 本书)", series.Name, series.BookCount),
+			fmt.Sprintf("/opds/books?author=%s&series=%s", authorParam, url.QueryEscape(series.Name)),
+			fmt.Sprintf("系列: %s", series.Name),
+		))
+	}
+	if standalone > 0 {
+		entries = append(entries, gen.CreateNavigationEntry(
+			fmt.Sprintf("其他作品 (%d 本书)", standalone),
+			fmt.Sprintf("/opds/books?author=%s&no_series=1", authorParam),
+			"不属于任何系列的书籍",
+		))
+	}
+
+	links := []opds.Link{
+		{
+			Rel:  "self",
+			Href: fmt.Sprintf("%s/opds/books?author=%s", gen.BaseURL, authorParam),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+	}
+
+	xmlData, err := gen.CreateFeed(fmt.Sprintf("作者: %s", author), entries, links, nil)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to generate feed")
+		return
+	}
+
+	h.serveFeed(c, gen, xmlData)
+}
+
+// OPDSAll 可爬取的完整书籍feed，按添加时间排序；配置了分块大小时通过next链接分块输出，single=1时强制输出单个文档
+func (h *Handler) OPDSAll(c *gin.Context) {
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
+
+	filter := database.BookFilter{Sort: "added", Order: "asc"}
+	totalBooks, err := h.db.GetBooksCountFilteredContext(c.Request.Context(), filter)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get book count")
 		return
 	}
 
-	baseURL := getBaseURL(c)
-	gen := opds.NewGenerator(baseURL)
+	pageSize := h.config.CrawlablePageSize
+	offset := 0
+	if pageSize <= 0 || c.Query("single") == "1" {
+		pageSize = totalBooks
+	} else {
+		offset = getIntParam(c, "offset", 0, 0)
+	}
 
-	entries := []opds.Entry{gen.CreateBookEntry(book)}
+	selfHref := baseURL + "/opds/all"
+	if offset > 0 {
+		selfHref = fmt.Sprintf("%s?offset=%d", selfHref, offset)
+	}
 	links := []opds.Link{
 		{
 			Rel:  "self",
-			Href: fmt.Sprintf("%s/opds/book/%d", baseURL, bookID),
+			Href: selfHref,
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+		{
+			Rel:  "start",
+			Href: baseURL + "/opds",
 			Type: "application/atom+xml;type=feed;profile=opds-catalog",
 		},
 	}
+	if pageSize > 0 && offset+pageSize < totalBooks {
+		nextHref := fmt.Sprintf("%s/opds/all?offset=%d", baseURL, offset+pageSize)
+		if gen.Minimal {
+			nextHref += "&verbose=0"
+		}
+		links = append(links, opds.Link{
+			Rel:  "next",
+			Href: nextHref,
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		})
+	}
 
-	xmlData, err := gen.CreateFeed(fmt.Sprintf("书籍详情: %s", book.Title), entries, links, nil)
+	feedInfo := &opds.FeedInfo{
+		TotalResults: totalBooks,
+		StartIndex:   offset,
+		ItemsPerPage: pageSize,
+	}
+
+	title := "全部书籍"
+	if totalBooks == 0 && h.config.EmptyLibraryHint {
+		title = emptyLibraryTitle
+	}
+
+	// 完整书库可能有数万本书，边查询边输出，不在内存中生成整个feed；
+	// 无法预先计算ETag，只按数据库修改时间处理If-Modified-Since
+	if !gen.Updated.IsZero() {
+		lastModified := gen.Updated.UTC().Truncate(time.Second)
+		c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+		if notModified(c, "", lastModified) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+
+	c.Header("Content-Type", feedMimeType)
+	c.Status(http.StatusOK)
+	fw, err := gen.NewFeedWriter(c.Writer, title, links, feedInfo)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to generate feed")
+		logger.Error.Printf("Failed to write crawlable feed: %v", err)
 		return
 	}
+	if pageSize > 0 {
+		err = h.db.StreamBooksFilteredContext(c.Request.Context(), pageSize, offset, filter, func(book *database.Book) error {
+			return fw.WriteEntry(gen.CreateBookEntry(book))
+		})
+		if err != nil {
+			// 响应已经开始，只能记录错误并结束feed
+			logger.Error.Printf("Failed to stream crawlable feed: %v", err)
+		}
+	}
+	if err := fw.Close(); err != nil {
+		logger.Error.Printf("Failed to write crawlable feed: %v", err)
+	}
+}
+
+// coverFacetLinks 生成按有无封面过滤的分面链接，params为不含分页参数的当前过滤条件
+func coverFacetLinks(baseURL string, params url.Values, active *bool) []opds.Link {
+	facets := []struct {
+		title string
+		value *bool
+	}{
+		{"全部", nil},
+		{"有封面", boolPtr(true)},
+		{"无封面", boolPtr(false)},
+	}
+
+	links := make([]opds.Link, 0, len(facets))
+	for _, facet := range facets {
+		facetParams := cloneParams(params)
+		facetParams.Del("has_cover")
+		if facet.value != nil {
+			facetParams.Set("has_cover", boolParam(*facet.value))
+		}
+
+		link := opds.Link{
+			Rel:        "http://opds-spec.org/facet",
+			Href:       baseURL + "/opds/books",
+			Type:       "application/atom+xml;type=feed;profile=opds-catalog",
+			Title:      facet.title,
+			FacetGroup: "封面",
+		}
+		if len(facetParams) > 0 {
+			link.Href += "?" + facetParams.Encode()
+		}
+		if (facet.value == nil && active == nil) || (facet.value != nil && active != nil && *facet.value == *active) {
+			link.ActiveFacet = "true"
+		}
+		links = append(links, link)
+	}
 
-	c.Data(http.StatusOK, "application/atom+xml;charset=utf-8", xmlData)
+	return links
 }
 
-// OPDSAuthors OPDS作者列表
-func (h *Handler) OPDSAuthors(c *gin.Context) {
-	limit := getIntParam(c, "limit", 50, 100)
+// changeLabels 变更类型在条目标题中的前缀
+var changeLabels = map[string]string{
+	database.ChangeAdded:    "新增",
+	database.ChangeModified: "修改",
+}
+
+// OPDSChangelog 书库变更记录，列出最近新增和修改的书籍
+func (h *Handler) OPDSChangelog(c *gin.Context) {
+	limit := getLimitParam(c, defaultBooksPageSize, maxPageSize)
 	offset := getIntParam(c, "offset", 0, 0)
 
-	baseURL := getBaseURL(c)
-	gen := opds.NewGenerator(baseURL)
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
 
-	authors, err := h.db.GetAuthors(limit, offset)
+	totalBooks, err := h.db.GetBooksCountContext(c.Request.Context(), "")
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to get authors")
+		c.String(h.dbErrorStatus(c, err), "Failed to get book count")
 		return
 	}
 
-	var entries []opds.Entry
-	for _, author := range authors {
-		entry := gen.CreateNavigationEntry(
-			fmt.Sprintf("%s (%d 本书)", author.Name, author.BookCount),
-			fmt.Sprintf("/opds/books?author=%s", url.QueryEscape(author.Name)),
-			fmt.Sprintf("作者: %s", author.Name),
-		)
+	changes, err := h.db.GetChangelogContext(c.Request.Context(), limit, offset)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get changelog")
+		return
+	}
+
+	entries := make([]opds.Entry, 0, len(changes))
+	for _, change := range changes {
+		entry := gen.CreateBookEntry(&change.Book)
+		entry.Title = fmt.Sprintf("%s: %s", changeLabels[change.Change], entry.Title)
+		entry.Updated = change.ChangedAt.UTC().Format(time.RFC3339)
 		entries = append(entries, entry)
 	}
 
+	pageURL := func(offset int) string {
+		return fmt.Sprintf("%s/opds/changelog?limit=%d&offset=%d", baseURL, limit, offset)
+	}
 	links := []opds.Link{
 		{
 			Rel:  "self",
-			Href: fmt.Sprintf("%s/opds/authors?limit=%d&offset=%d", baseURL, limit, offset),
+			Href: pageURL(offset),
 			Type: "application/atom+xml;type=feed;profile=opds-catalog",
 		},
+		{
+			Rel:  "start",
+			Href: baseURL + "/opds",
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+	}
+	if offset+limit < totalBooks {
+		links = append(links, opds.Link{
+			Rel:  "next",
+			Href: pageURL(offset + limit),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		})
+	}
+	if offset > 0 {
+		links = append(links, opds.Link{
+			Rel:  "previous",
+			Href: pageURL(max(offset-limit, 0)),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		})
 	}
 
-	currentPage := offset/limit + 1
-	xmlData, err := gen.CreateFeed(fmt.Sprintf("按作者分类 - 第 %d 页", currentPage), entries, links, nil)
+	feedInfo := &opds.FeedInfo{
+		TotalResults: totalBooks,
+		StartIndex:   offset,
+		ItemsPerPage: limit,
+	}
+
+	xmlData, err := gen.CreateFeed("最近变更", entries, links, feedInfo)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Failed to generate feed")
 		return
 	}
 
-	c.Data(http.StatusOK, "application/atom+xml;charset=utf-8", xmlData)
+	h.serveFeed(c, gen, xmlData)
 }
 
-// OPDSSeries OPDS系列列表
-func (h *Handler) OPDSSeries(c *gin.Context) {
-	limit := getIntParam(c, "limit", 50, 100)
+// OPDSRecent 最近添加的书籍，按添加时间倒序，修改元数据不会改变书籍的位置
+func (h *Handler) OPDSRecent(c *gin.Context) {
+	limit := getLimitParam(c, defaultBooksPageSize, maxPageSize)
 	offset := getIntParam(c, "offset", 0, 0)
 
-	baseURL := getBaseURL(c)
-	gen := opds.NewGenerator(baseURL)
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
 
-	seriesList, err := h.db.GetSeries(limit, offset)
+	totalBooks, err := h.db.GetBooksCountContext(c.Request.Context(), "")
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to get series")
+		c.String(h.dbErrorStatus(c, err), "Failed to get book count")
 		return
 	}
 
-	var entries []opds.Entry
-	for _, series := range seriesList {
-		entry := gen.CreateNavigationEntry(
-			fmt.Sprintf("%s (%d 本书)", series.Name, series.BookCount),
-			fmt.Sprintf("/opds/books?series=%s", url.QueryEscape(series.Name)),
-			fmt.Sprintf("系列: %s", series.Name),
-		)
-		entries = append(entries, entry)
+	books, err := h.db.GetRecentlyAddedContext(c.Request.Context(), limit, offset)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get books")
+		return
+	}
+
+	entries := make([]opds.Entry, 0, len(books))
+	for _, book := range books {
+		entries = append(entries, gen.CreateBookEntry(&book))
 	}
 
+	pageURL := func(offset int) string {
+		return fmt.Sprintf("%s/opds/recent?limit=%d&offset=%d", baseURL, limit, offset)
+	}
 	links := []opds.Link{
 		{
 			Rel:  "self",
-			Href: fmt.Sprintf("%s/opds/series?limit=%d&offset=%d", baseURL, limit, offset),
+			Href: pageURL(offset),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+		{
+			Rel:  "start",
+			Href: baseURL + "/opds",
 			Type: "application/atom+xml;type=feed;profile=opds-catalog",
 		},
 	}
+	if offset+limit < totalBooks {
+		links = append(links, opds.Link{
+			Rel:  "next",
+			Href: pageURL(offset + limit),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		})
+	}
+	if offset > 0 {
+		links = append(links, opds.Link{
+			Rel:  "previous",
+			Href: pageURL(max(offset-limit, 0)),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		})
+	}
 
-	currentPage := offset/limit + 1
-	xmlData, err := gen.CreateFeed(fmt.Sprintf("按系列分类 - 第 %d 页", currentPage), entries, links, nil)
+	feedInfo := &opds.FeedInfo{
+		TotalResults: totalBooks,
+		StartIndex:   offset,
+		ItemsPerPage: limit,
+	}
+
+	xmlData, err := gen.CreateFeed("最近添加", entries, links, feedInfo)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Failed to generate feed")
 		return
 	}
 
-	c.Data(http.StatusOK, "application/atom+xml;charset=utf-8", xmlData)
+	h.serveFeed(c, gen, xmlData)
 }
 
-// OPDSTags OPDS标签列表
-func (h *Handler) OPDSTags(c *gin.Context) {
-	limit := getIntParam(c, "limit", 50, 100)
-	offset := getIntParam(c, "offset", 0, 0)
+// OPDSRandom 随机挑选的书籍，count参数指定数量；每次请求结果不同，因此不使用缓存和条件请求
+func (h *Handler) OPDSRandom(c *gin.Context) {
+	count := getRandomCount(c)
 
-	baseURL := getBaseURL(c)
-	gen := opds.NewGenerator(baseURL)
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
 
-	tags, err := h.db.GetTags(limit, offset)
+	books, err := h.db.GetRandomBooksContext(c.Request.Context(), count)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to get tags")
+		c.String(h.dbErrorStatus(c, err), "Failed to get books")
 		return
 	}
 
-	var entries []opds.Entry
-	for _, tag := range tags {
-		entry := gen.CreateNavigationEntry(
-			fmt.Sprintf("%s (%d 本书)", tag.Name, tag.BookCount),
-			fmt.Sprintf("/opds/books?tag=%s", url.QueryEscape(tag.Name)),
-			fmt.Sprintf("标签: %s", tag.Name),
-		)
-		entries = append(entries, entry)
+	entries := make([]opds.Entry, 0, len(books))
+	for _, book := range books {
+		entries = append(entries, gen.CreateBookEntry(&book))
 	}
 
 	links := []opds.Link{
 		{
 			Rel:  "self",
-			Href: fmt.Sprintf("%s/opds/tags?limit=%d&offset=%d", baseURL, limit, offset),
+			Href: fmt.Sprintf("%s/opds/random?count=%d", baseURL, count),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+		{
+			Rel:  "start",
+			Href: baseURL + "/opds",
 			Type: "application/atom+xml;type=feed;profile=opds-catalog",
 		},
 	}
 
-	currentPage := offset/limit + 1
-	xmlData, err := gen.CreateFeed(fmt.Sprintf("按标签分类 - 第 %d 页", currentPage), entries, links, nil)
+	xmlData, err := gen.CreateFeed("随便看看", entries, links, nil)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Failed to generate feed")
 		return
 	}
 
-	c.Data(http.StatusOK, "application/atom+xml;charset=utf-8", xmlData)
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, feedMimeType, xmlData)
 }
 
-// 辅助函数
-func getBaseURL(c *gin.Context) string {
-	scheme := "http"
-	if c.Request.TLS != nil {
-		scheme = "https"
+// OPDSTag 单个标签的书籍列表，以路径形式提供便于收藏和分享的固定地址；标签名可以包含斜杠等特殊字符
+func (h *Handler) OPDSTag(c *gin.Context) {
+	tag := strings.TrimPrefix(c.Param("name"), "/")
+	if tag == "" {
+		c.String(http.StatusNotFound, "Tag not found")
+		return
 	}
-	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
-}
+	limit := getLimitParam(c, defaultBooksPageSize, maxPageSize)
+	offset := getIntParam(c, "offset", 0, 0)
 
-func getIntParam(c *gin.Context, key string, defaultValue, maxValue int) int {
-	val := c.Query(key)
-	if val == "" {
-		return defaultValue
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
+	filter := database.BookFilter{Tags: []string{tag}}
+
+	totalBooks, err := h.db.GetBooksCountFilteredContext(c.Request.Context(), filter)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get book count")
+		return
+	}
+	if totalBooks == 0 {
+		c.String(http.StatusNotFound, "Tag not found")
+		return
 	}
 
-	intVal, err := strconv.Atoi(val)
+	books, err := h.db.GetBooksFilteredContext(c.Request.Context(), limit, offset, filter)
 	if err != nil {
-		return defaultValue
+		c.String(h.dbErrorStatus(c, err), "Failed to get books")
+		return
 	}
 
-	if maxValue > 0 && intVal > maxValue {
-		return maxValue
+	entries := make([]opds.Entry, 0, len(books))
+	for _, book := range books {
+		entries = append(entries, gen.CreateBookEntry(&book))
 	}
 
-	return intVal
+	tagURL := baseURL + "/opds/tag/" + url.PathEscape(tag)
+	pageURL := func(offset int) string {
+		return fmt.Sprintf("%s?limit=%d&offset=%d", tagURL, limit, offset)
+	}
+	links := []opds.Link{
+		{
+			Rel:  "self",
+			Href: pageURL(offset),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+		{
+			Rel:  "up",
+			Href: baseURL + "/opds/tags",
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+		{
+			Rel:  "start",
+			Href: baseURL + "/opds",
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+	}
+	if offset+limit < totalBooks {
+		links = append(links, opds.Link{
+			Rel:  "next",
+			Href: pageURL(offset + limit),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		})
+	}
+	if offset > 0 {
+		links = append(links, opds.Link{
+			Rel:  "previous",
+			Href: pageURL(max(offset-limit, 0)),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		})
+	}
+
+	currentPage := offset/limit + 1
+	totalPages := (totalBooks + limit - 1) / limit
+	feedInfo := &opds.FeedInfo{
+		TotalResults: totalBooks,
+		StartIndex:   offset,
+		ItemsPerPage: limit,
+	}
+
+	xmlData, err := gen.CreateFeed(fmt.Sprintf("标签: %s - 第 %d/%d 页", tag, currentPage, totalPages), entries, links, feedInfo)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to generate feed")
+		return
+	}
+
+	h.serveFeed(c, gen, xmlData)
+}
+
+// getBookSortParams 读取书籍列表的sort和order参数，不支持的排序字段按默认排序处理；
+// 未指定方向时书名和作者正序，其他字段倒序
+func getBookSortParams(c *gin.Context) (string, string) {
+	sortField := c.Query("sort")
+	if !database.IsValidSort(sortField) {
+		return "", ""
+	}
+
+	order := strings.ToLower(c.Query("order"))
+	if order != "asc" && order != "desc" {
+		order = "desc"
+		if sortField == "title" || sortField == "author" {
+			order = "asc"
+		}
+	}
+	return sortField, order
+}
+
+// bookSortFacetLinks 生成书籍列表排序方式的分面链接，保留其他过滤参数
+func bookSortFacetLinks(baseURL string, params url.Values, active string) []opds.Link {
+	facets := []struct {
+		title string
+		sort  string
+	}{
+		{"最近修改", "modified"},
+		{"书名", "title"},
+		{"作者", "author"},
+		{"出版日期", "pubdate"},
+		{"最近添加", "added"},
+	}
+	if active == "" {
+		active = "modified"
+	}
+
+	links := make([]opds.Link, 0, len(facets))
+	for _, facet := range facets {
+		facetParams := cloneParams(params)
+		facetParams.Del("order")
+		facetParams.Set("sort", facet.sort)
+
+		link := opds.Link{
+			Rel:        "http://opds-spec.org/facet",
+			Href:       baseURL + "/opds/books?" + facetParams.Encode(),
+			Type:       "application/atom+xml;type=feed;profile=opds-catalog",
+			Title:      facet.title,
+			FacetGroup: "排序",
+		}
+		if facet.sort == active {
+			link.ActiveFacet = "true"
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// tagSortFacetLinks 生成标签列表排序方式的分面链接
+func tagSortFacetLinks(baseURL, active string) []opds.Link {
+	facets := []struct {
+		title string
+		sort  string
+	}{
+		{"按名称", "name"},
+		{"按书籍数量", "count"},
+		{"最近使用", "recent"},
+	}
+
+	links := make([]opds.Link, 0, len(facets))
+	for _, facet := range facets {
+		link := opds.Link{
+			Rel:        "http://opds-spec.org/facet",
+			Href:       fmt.Sprintf("%s/opds/tags?sort=%s", baseURL, facet.sort),
+			Type:       "application/atom+xml;type=feed;profile=opds-catalog",
+			Title:      facet.title,
+			FacetGroup: "排序",
+		}
+		if facet.sort == active {
+			link.ActiveFacet = "true"
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// seriesBundleEntry 创建系列打包下载条目
+func (h *Handler) seriesBundleEntry(c *gin.Context, gen *opds.Generator, series string) (opds.Entry, bool) {
+	books, err := h.db.GetSeriesBooksContext(c.Request.Context(), series)
+	if err != nil || len(books) == 0 {
+		return opds.Entry{}, false
+	}
+
+	var contentTypes []string
+	seen := make(map[string]bool)
+	for i := range books {
+		if format := preferredFormat(&books[i], h.config.PreferredFormats); format != nil {
+			mimeType := opds.GetMimeType(format.Format)
+			if !seen[mimeType] {
+				seen[mimeType] = true
+				contentTypes = append(contentTypes, mimeType)
+			}
+		}
+	}
+
+	return gen.CreateSeriesBundleEntry(series, len(books), contentTypes), true
+}
+
+// OPDSBookDetail OPDS书籍详情
+func (h *Handler) OPDSBookDetail(c *gin.Context) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	book, err := h.db.GetBookDetailContext(c.Request.Context(), bookID)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get book")
+		return
+	}
+	if book == nil {
+		c.String(http.StatusNotFound, "Book not found")
+		return
+	}
+	h.applyOPFFallback(h.booksPath(c), book)
+
+	gen := h.newGenerator(c)
+	gen.Minimal = false // 详情feed始终输出完整条目和全部作者
+	gen.MaxAuthors = 0
+	baseURL := gen.BaseURL
+
+	entry := gen.CreateBookEntry(book)
+	if similar, err := h.db.GetSimilarByTagsContext(c.Request.Context(), bookID, relatedLinksLimit); err == nil {
+		for _, related := range similar {
+			entry.Links = append(entry.Links, opds.Link{
+				Rel:   "related",
+				Href:  fmt.Sprintf("%s/opds/book/%d", baseURL, related.ID),
+				Type:  "application/atom+xml;type=feed;profile=opds-catalog",
+				Title: related.Title,
+			})
+		}
+	}
+
+	entries := []opds.Entry{entry}
+	links := []opds.Link{
+		{
+			Rel:  "self",
+			Href: fmt.Sprintf("%s/opds/book/%d", baseURL, bookID),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+	}
+
+	xmlData, err := gen.CreateFeed(fmt.Sprintf("书籍详情: %s", book.Title), entries, links, nil)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to generate feed")
+		return
+	}
+
+	h.serveFeed(c, gen, xmlData)
+}
+
+// OPDSAcquire OPDS命名空间下的下载地址，重定向到实际的下载链接
+func (h *Handler) OPDSAcquire(c *gin.Context) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	target := fmt.Sprintf("%s/download/%d/%s", h.baseURL(c), bookID, url.PathEscape(c.Param("format")))
+	if c.Request.URL.RawQuery != "" {
+		target += "?" + c.Request.URL.RawQuery
+	}
+
+	c.Redirect(http.StatusFound, target)
+}
+
+// OPDSAuthors OPDS作者列表
+func (h *Handler) OPDSAuthors(c *gin.Context) {
+	limit := getLimitParam(c, defaultListPageSize, maxPageSize)
+	offset := getIntParam(c, "offset", 0, 0)
+
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
+
+	starts := c.Query("starts")
+
+	authors, err := h.db.GetAuthorsContext(c.Request.Context(), limit, offset, starts)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get authors")
+		return
+	}
+
+	var entries []opds.Entry
+	for _, author := range authors {
+		entry := gen.CreateNavigationEntry(
+			fmt.Sprintf("%s (%d 本书)", author.Name, author.BookCount),
+			fmt.Sprintf("/opds/books?author=%s", url.QueryEscape(author.Name)),
+			fmt.Sprintf("作者: %s", author.Name),
+		)
+		entries = append(entries, entry)
+	}
+
+	startsParam := ""
+	title := "按作者分类"
+	if starts != "" {
+		startsParam = "&starts=" + url.QueryEscape(starts)
+		title = fmt.Sprintf("按作者分类 (%s)", initialLabel(starts))
+	}
+
+	links := []opds.Link{
+		{
+			Rel:  "self",
+			Href: fmt.Sprintf("%s/opds/authors?limit=%d&offset=%d%s", baseURL, limit, offset, startsParam),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+		{
+			Rel:   "subsection",
+			Href:  baseURL + "/opds/authors/letters",
+			Type:  "application/atom+xml;type=feed;profile=opds-catalog",
+			Title: "按首字母浏览",
+		},
+	}
+
+	currentPage := offset/limit + 1
+	xmlData, err := gen.CreateFeed(fmt.Sprintf("%s - 第 %d 页", title, currentPage), entries, links, nil)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to generate feed")
+		return
+	}
+
+	h.serveFeed(c, gen, xmlData)
+}
+
+// OPDSAuthorLetters OPDS作者首字母导航
+func (h *Handler) OPDSAuthorLetters(c *gin.Context) {
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
+
+	initials, err := h.db.GetAuthorInitialsContext(c.Request.Context())
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get author initials")
+		return
+	}
+
+	var entries []opds.Entry
+	for _, initial := range initials {
+		entry := gen.CreateNavigationEntry(
+			fmt.Sprintf("%s (%d 位作者)", initialLabel(initial.Initial), initial.AuthorCount),
+			fmt.Sprintf("/opds/authors?starts=%s", url.QueryEscape(initial.Initial)),
+			fmt.Sprintf("首字母: %s", initialLabel(initial.Initial)),
+		)
+		entries = append(entries, entry)
+	}
+
+	links := []opds.Link{
+		{
+			Rel:  "self",
+			Href: baseURL + "/opds/authors/letters",
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+	}
+
+	xmlData, err := gen.CreateFeed("按作者首字母浏览", entries, links, nil)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to generate feed")
+		return
+	}
+
+	h.serveFeed(c, gen, xmlData)
+}
+
+// OPDSDecades OPDS按出版年代浏览
+func (h *Handler) OPDSDecades(c *gin.Context) {
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
+
+	decades, unknown, err := h.db.GetPubDecadesContext(c.Request.Context())
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get decades")
+		return
+	}
+
+	var entries []opds.Entry
+	for _, decade := range decades {
+		entries = append(entries, gen.CreateNavigationEntry(
+			fmt.Sprintf("%d年代 (%d 本书)", decade.Decade, decade.BookCount),
+			fmt.Sprintf("/opds/books?year_from=%d&year_to=%d", decade.Decade, decade.Decade+9),
+			fmt.Sprintf("%d-%d年出版的书籍", decade.Decade, decade.Decade+9),
+		))
+	}
+	if unknown > 0 {
+		entries = append(entries, gen.CreateNavigationEntry(
+			fmt.Sprintf("未知 (%d 本书)", unknown),
+			"/opds/books?no_pubdate=1",
+			"出版日期未知的书籍",
+		))
+	}
+
+	links := []opds.Link{
+		{
+			Rel:  "self",
+			Href: baseURL + "/opds/decades",
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+	}
+
+	xmlData, err := gen.CreateFeed("按出版年代浏览", entries, links, nil)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to generate feed")
+		return
+	}
+
+	h.serveFeed(c, gen, xmlData)
+}
+
+// initialLabel 首字母分组的显示名称
+func initialLabel(initial string) string {
+	switch initial {
+	case database.InitialDigits:
+		return "数字"
+	case database.InitialCJK:
+		return "中日韩"
+	case database.InitialOther:
+		return "其他"
+	}
+	return initial
+}
+
+// OPDSSeries OPDS系列列表
+func (h *Handler) OPDSSeries(c *gin.Context) {
+	limit := getLimitParam(c, defaultListPageSize, maxPageSize)
+	offset := getIntParam(c, "offset", 0, 0)
+
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
+
+	seriesList, err := h.db.GetSeriesContext(c.Request.Context(), limit, offset)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get series")
+		return
+	}
+
+	var entries []opds.Entry
+	for _, series := range seriesList {
+		entry := gen.CreateNavigationEntry(
+			fmt.Sprintf("%s (%d 本书)", series.Name, series.BookCount),
+			fmt.Sprintf("/opds/books?series=%s", url.QueryEscape(series.Name)),
+			fmt.Sprintf("系列: %s", series.Name),
+		)
+		entries = append(entries, entry)
+	}
+
+	links := []opds.Link{
+		{
+			Rel:  "self",
+			Href: fmt.Sprintf("%s/opds/series?limit=%d&offset=%d", baseURL, limit, offset),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+	}
+
+	currentPage := offset/limit + 1
+	xmlData, err := gen.CreateFeed(fmt.Sprintf("按系列分类 - 第 %d 页", currentPage), entries, links, nil)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to generate feed")
+		return
+	}
+
+	h.serveFeed(c, gen, xmlData)
+}
+
+// OPDSPublishers OPDS出版社列表
+func (h *Handler) OPDSPublishers(c *gin.Context) {
+	limit := getLimitParam(c, defaultListPageSize, maxPageSize)
+	offset := getIntParam(c, "offset", 0, 0)
+
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
+
+	publishers, err := h.db.GetPublishersContext(c.Request.Context(), limit, offset)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get publishers")
+		return
+	}
+
+	var entries []opds.Entry
+	for _, publisher := range publishers {
+		entry := gen.CreateNavigationEntry(
+			fmt.Sprintf("%s (%d 本书)", publisher.Name, publisher.BookCount),
+			fmt.Sprintf("/opds/books?publisher=%s", url.QueryEscape(publisher.Name)),
+			fmt.Sprintf("出版社: %s", publisher.Name),
+		)
+		entries = append(entries, entry)
+	}
+
+	links := []opds.Link{
+		{
+			Rel:  "self",
+			Href: fmt.Sprintf("%s/opds/publishers?limit=%d&offset=%d", baseURL, limit, offset),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+	}
+
+	currentPage := offset/limit + 1
+	xmlData, err := gen.CreateFeed(fmt.Sprintf("按出版社分类 - 第 %d 页", currentPage), entries, links, nil)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to generate feed")
+		return
+	}
+
+	h.serveFeed(c, gen, xmlData)
+}
+
+// OPDSTags OPDS标签列表
+func (h *Handler) OPDSTags(c *gin.Context) {
+	limit := getLimitParam(c, defaultListPageSize, maxPageSize)
+	offset := getIntParam(c, "offset", 0, 0)
+
+	gen := h.newGenerator(c)
+	baseURL := gen.BaseURL
+
+	tagSort := c.DefaultQuery("sort", "name")
+	if !database.IsValidTagSort(tagSort) {
+		tagSort = "name"
+	}
+
+	tags, err := h.db.GetTagsContext(c.Request.Context(), limit, offset, tagSort)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get tags")
+		return
+	}
+
+	var entries []opds.Entry
+	for _, tag := range tags {
+		entry := gen.CreateNavigationEntry(
+			fmt.Sprintf("%s (%d 本书)", tag.Name, tag.BookCount),
+			fmt.Sprintf("/opds/books?tag=%s", url.QueryEscape(tag.Name)),
+			fmt.Sprintf("标签: %s", tag.Name),
+		)
+		entries = append(entries, entry)
+	}
+
+	links := []opds.Link{
+		{
+			Rel:  "self",
+			Href: fmt.Sprintf("%s/opds/tags?limit=%d&offset=%d&sort=%s", baseURL, limit, offset, tagSort),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+	}
+	links = append(links, tagSortFacetLinks(baseURL, tagSort)...)
+
+	currentPage := offset/limit + 1
+	xmlData, err := gen.CreateFeed(fmt.Sprintf("按标签分类 - 第 %d 页", currentPage), entries, links, nil)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to generate feed")
+		return
+	}
+
+	h.serveFeed(c, gen, xmlData)
+}
+
+// emptyLibraryTitle 书库为空时feed和提示条目的标题
+const emptyLibraryTitle = "书库中还没有书籍"
+
+// libraryEmpty 判断是否需要显示空书库提示；查询失败时按非空处理
+func (h *Handler) libraryEmpty(c *gin.Context) bool {
+	if !h.config.EmptyLibraryHint {
+		return false
+	}
+	count, err := h.db.GetBooksCountContext(c.Request.Context(), "")
+	return err == nil && count == 0
+}
+
+// newGenerator 创建绑定当前请求的OPDS生成器
+func (h *Handler) newGenerator(c *gin.Context) *opds.Generator {
+	root := h.booksPath(c)
+	gen := opds.NewGenerator(h.baseURL(c))
+	gen.CoverType = func(book *database.Book) string {
+		return h.coverMimeType(root, book)
+	}
+	gen.ExtraAcquisitionRels = h.config.ExtraAcquisitionRels
+	gen.IncludeContent = h.config.EntryContent
+	gen.CatalogAcquisition = h.config.CatalogAcquisition
+	gen.MaxAuthors = h.config.EntryMaxAuthors
+	gen.NewSince = h.newSince()
+	gen.Updated = h.db.ModTime()
+	gen.Minimal = minimalEntries(c)
+	gen.Language = h.config.Language
+	if h.config.ClientProfiles {
+		// 输出随客户端不同，缓存需要区分User-Agent
+		c.Writer.Header().Add("Vary", "User-Agent")
+		h.clientProfile(c).apply(gen)
+	}
+	return gen
+}
+
+// minimalEntries 客户端通过verbose=0或minimal=1请求精简的书籍条目
+func minimalEntries(c *gin.Context) bool {
+	return c.Query("verbose") == "0" || c.Query("minimal") == "1"
+}
+
+// newSince 返回新书窗口的起始时间，未配置窗口时返回零值
+func (h *Handler) newSince() time.Time {
+	if h.config.NewWindow <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-h.config.NewWindow)
+}
+
+// markNewBooks 标记在新书窗口内添加的书籍
+func (h *Handler) markNewBooks(books ...*database.Book) {
+	since := h.newSince()
+	if since.IsZero() {
+		return
+	}
+	for _, book := range books {
+		book.IsNew = book.Timestamp.After(since)
+	}
+}
+
+// baseURL 返回生成链接使用的基础URL，包含配置的路径前缀；配置了规范URL时直接使用规范URL
+func (h *Handler) baseURL(c *gin.Context) string {
+	if h.config.CanonicalBaseURL != "" {
+		return h.config.CanonicalBaseURL
+	}
+	if h.trustProxyHeaders(c) {
+		return forwardedBaseURL(c) + h.config.BasePath
+	}
+	return getBaseURL(c) + h.config.BasePath
+}
+
+// trustProxyHeaders 判断是否采信请求中的X-Forwarded-*头；配置了受信任代理时只采信来自这些代理的请求
+func (h *Handler) trustProxyHeaders(c *gin.Context) bool {
+	if !h.config.TrustProxyHeaders {
+		return false
+	}
+	return len(h.trustedProxies) == 0 || h.fromTrustedProxy(c)
+}
+
+// forwardedBaseURL 按X-Forwarded-Proto、X-Forwarded-Host和X-Forwarded-Prefix还原客户端看到的基础URL，
+// 缺失或不合法的头使用请求本身的值
+func forwardedBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := strings.ToLower(firstHeaderValue(c, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	host := c.Request.Host
+	if forwarded := firstHeaderValue(c, "X-Forwarded-Host"); forwarded != "" && !strings.ContainsAny(forwarded, "/\\@ ") {
+		host = forwarded
+	}
+
+	prefix := strings.Trim(firstHeaderValue(c, "X-Forwarded-Prefix"), "/")
+	if prefix != "" && !strings.ContainsAny(prefix, "?#\\ ") {
+		prefix = "/" + prefix
+	} else {
+		prefix = ""
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, host, prefix)
+}
+
+// firstHeaderValue 返回请求头中第一个逗号分隔的值，多级代理会追加多个值
+func firstHeaderValue(c *gin.Context, key string) string {
+	value, _, _ := strings.Cut(c.GetHeader(key), ",")
+	return strings.TrimSpace(value)
+}
+
+// fromTrustedProxy 判断请求是否直接来自受信任的代理
+func (h *Handler) fromTrustedProxy(c *gin.Context) bool {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range h.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 返回请求的客户端地址；只有直接来自受信任代理的请求才采信X-Forwarded-For，
+// 与X-Remote-User的判断一致，其他请求使用连接的对端地址
+func (h *Handler) clientIP(c *gin.Context) string {
+	if h.fromTrustedProxy(c) {
+		return c.ClientIP()
+	}
+	return c.RemoteIP()
+}
+
+// ConfigureTrustedProxies 让gin的ClientIP只采信来自受信任代理的X-Forwarded-For，
+// 未配置受信任代理时始终使用连接的对端地址（访问日志、慢请求日志使用ClientIP）
+func (h *Handler) ConfigureTrustedProxies(router *gin.Engine) error {
+	if len(h.trustedProxies) == 0 {
+		return router.SetTrustedProxies(nil)
+	}
+	proxies := make([]string, 0, len(h.trustedProxies))
+	for _, network := range h.trustedProxies {
+		proxies = append(proxies, network.String())
+	}
+	return router.SetTrustedProxies(proxies)
+}
+
+// parseTrustedProxies 解析受信任代理列表，支持单个IP和CIDR
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			logger.Warning.Printf("Ignoring invalid trusted proxy %q: %v", proxy, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// 辅助函数
+func getBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+}
+
+func getIntParam(c *gin.Context, key string, defaultValue, maxValue int) int {
+	val := c.Query(key)
+	if val == "" {
+		return defaultValue
+	}
+
+	intVal, err := strconv.Atoi(val)
+	if err != nil {
+		return defaultValue
+	}
+
+	if maxValue > 0 && intVal > maxValue {
+		return maxValue
+	}
+	// 所有整数参数（分页、尺寸、年份）都不能为负数
+	if intVal < 0 {
+		return 0
+	}
+
+	return intVal
+}
+
+// getLimitParam 获取每页数量参数，限制在1到maxValue之间；
+// 0会导致计算页码时除以零，负数在SQLite的LIMIT中表示不限制
+func getLimitParam(c *gin.Context, defaultValue, maxValue int) int {
+	return max(getIntParam(c, "limit", defaultValue, maxValue), 1)
+}
+
+// getRandomCount 获取随机书籍数量参数，最少1本，最多maxPageSize本
+func getRandomCount(c *gin.Context) int {
+	return max(getIntParam(c, "count", defaultRandomCount, maxPageSize), 1)
+}
+
+// getTagsParam 获取标签参数，支持重复的tag参数和逗号分隔的多个标签；Calibre的标签名不含逗号
+func getTagsParam(c *gin.Context) []string {
+	var tags []string
+	for _, value := range c.QueryArray("tag") {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// getDateParam 获取YYYY-MM-DD格式的日期参数，缺失或格式不正确时返回空字符串
+func getDateParam(c *gin.Context, key string) string {
+	date := c.Query(key)
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return ""
+	}
+	return date
+}
+
+// getRatingParam 获取评分参数（Calibre的0-10分制），缺失或超出范围时返回nil
+func getRatingParam(c *gin.Context, key string) *int {
+	rating, err := strconv.Atoi(c.Query(key))
+	if err != nil || rating < 0 || rating > 10 {
+		return nil
+	}
+	return &rating
+}
+
+// getBoolParam 获取布尔参数，支持1/0和true/false，缺失或无法解析时返回nil
+func getBoolParam(c *gin.Context, key string) *bool {
+	val, err := strconv.ParseBool(c.Query(key))
+	if err != nil {
+		return nil
+	}
+	return &val
+}
+
+// boolParam 将布尔值编码为查询参数
+func boolParam(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// boolPtr 返回布尔值的指针
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// bookFilterParams 把书籍过滤条件编码为/opds/books的查询参数，用于生成翻页和分面链接；
+// 年份范围已转换为出版日期范围，按pubdate_from/pubdate_to输出
+func bookFilterParams(filter database.BookFilter) url.Values {
+	params := url.Values{}
+	if filter.Search != "" {
+		params.Set("search", filter.Search)
+	}
+	for _, author := range filter.Authors {
+		params.Add("author", author)
+	}
+	if filter.Series != "" {
+		params.Set("series", filter.Series)
+	}
+	for _, tag := range filter.Tags {
+		params.Add("tag", tag)
+	}
+	if filter.Language != "" {
+		params.Set("lang", filter.Language)
+	}
+	if filter.Publisher != "" {
+		params.Set("publisher", filter.Publisher)
+	}
+	if filter.Format != "" {
+		params.Set("format", filter.Format)
+	}
+	if filter.NoSeries {
+		params.Set("no_series", "1")
+	}
+	if filter.HasCover != nil {
+		params.Set("has_cover", boolParam(*filter.HasCover))
+	}
+	if filter.PubDateFrom != "" {
+		params.Set("pubdate_from", filter.PubDateFrom)
+	}
+	if filter.PubDateTo != "" {
+		params.Set("pubdate_to", filter.PubDateTo)
+	}
+	if filter.NoPubDate {
+		params.Set("no_pubdate", "1")
+	}
+	if filter.MinRating != nil {
+		params.Set("rating", strconv.Itoa(*filter.MinRating))
+	}
+	if filter.Sort != "" {
+		params.Set("sort", filter.Sort)
+		params.Set("order", filter.Order)
+	}
+	return params
+}
+
+// cloneParams 复制查询参数，修改副本不影响原参数
+func cloneParams(params url.Values) url.Values {
+	clone := make(url.Values, len(params))
+	for key, values := range params {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
 }
@@ -0,0 +1,889 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+func TestBookListLinksPreserveFilter(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	for _, title := range []string{"A", "B", "C"} {
+		lib.AddBook(t, testutil.Book{
+			Title:   title,
+			Authors: []string{"Ann"},
+			Tags:    []string{"x", "y"},
+			Formats: []string{"EPUB"},
+			PubDate: time.Date(1995, 6, 1, 0, 0, 0, 0, time.UTC),
+		})
+	}
+	_, router := newTestServer(t, lib, nil)
+
+	rec := get(router, "/opds/books?author=Ann&tag=x,y&format=epub&year_from=1990&has_cover=0&sort=title&order=asc&verbose=0&limit=1&offset=1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	feed := parseFeed(t, rec.Body.Bytes())
+
+	want := url.Values{
+		"author":       {"Ann"},
+		"tag":          {"x", "y"},
+		"format":       {"EPUB"},
+		"pubdate_from": {"1990-01-01"},
+		"has_cover":    {"0"},
+		"sort":         {"title"},
+		"order":        {"asc"},
+		"verbose":      {"0"},
+		"limit":        {"1"},
+	}
+	for rel, offset := range map[string]string{"self": "1", "next": "2", "previous": "0"} {
+		link, ok := feed.link(rel)
+		if !ok {
+			t.Fatalf("missing %s link", rel)
+		}
+		u, err := url.Parse(link.Href)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := u.Query()
+		if got.Get("offset") != offset {
+			t.Errorf("%s offset = %q, want %s", rel, got.Get("offset"), offset)
+		}
+		got.Del("offset")
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s params = %v, want %v", rel, got, want)
+		}
+	}
+
+	// 分面链接保留其他过滤条件，不带分页参数
+	for _, link := range feed.Links {
+		if link.Rel != "http://opds-spec.org/facet" {
+			continue
+		}
+		u, _ := url.Parse(link.Href)
+		if q := u.Query(); !reflect.DeepEqual(q["tag"], []string{"x", "y"}) || q.Get("author") != "Ann" || q.Has("offset") {
+			t.Errorf("facet %q params = %v", link.Title, q)
+		}
+	}
+}
+
+func TestAuthorFeedCollapse(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	for i, series := range []string{"S1", "S1", "S2", "", ""} {
+		lib.AddBook(t, testutil.Book{
+			Title:   string(rune('A' + i)),
+			Authors: []string{"Prolific"},
+			Series:  series,
+			Formats: []string{"EPUB"},
+		})
+	}
+	_, router := newTestServer(t, lib, map[string]string{"AUTHOR_COLLAPSE_THRESHOLD": "3"})
+
+	// 超过阈值时按系列分组：显示全部、两个系列和其他作品
+	feed := parseFeed(t, get(router, "/opds/books?author=Prolific", nil).Body.Bytes())
+	if len(feed.Entries) != 4 {
+		t.Fatalf("collapsed feed has %d entries, want 4", len(feed.Entries))
+	}
+	for _, entry := range feed.Entries {
+		if len(entry.Links) != 1 || entry.Links[0].Rel != "http://opds-spec.org/subsection" {
+			t.Errorf("entry %q is not a navigation entry", entry.Title)
+		}
+	}
+
+	// 有其他过滤条件或all=1时不分组
+	for _, target := range []string{
+		"/opds/books?author=Prolific&all=1",
+		"/opds/books?author=Prolific&sort=title",
+		"/opds/books?author=Prolific&format=epub",
+	} {
+		if feed := parseFeed(t, get(router, target, nil).Body.Bytes()); len(feed.Entries) != 5 {
+			t.Errorf("%s: %d entries, want 5 books", target, len(feed.Entries))
+		}
+	}
+
+	// 未超过阈值时直接列出
+	_, router = newTestServer(t, lib, map[string]string{"AUTHOR_COLLAPSE_THRESHOLD": "5"})
+	if feed := parseFeed(t, get(router, "/opds/books?author=Prolific", nil).Body.Bytes()); len(feed.Entries) != 5 {
+		t.Errorf("below threshold: %d entries, want 5 books", len(feed.Entries))
+	}
+}
+
+func TestClientProfilesDisabledByDefault(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Book", Authors: []string{"A"}, Formats: []string{"EPUB"}})
+
+	for env, want := range map[string]bool{"": false, "true": true} {
+		_, router := newTestServer(t, lib, map[string]string{"CLIENT_PROFILES": env})
+		rec := get(router, "/opds/books", map[string]string{"User-Agent": "KOReader/2024.01"})
+		varies := false
+		for _, value := range rec.Header().Values("Vary") {
+			varies = varies || strings.Contains(value, "User-Agent")
+		}
+		if varies != want {
+			t.Errorf("CLIENT_PROFILES=%q: Vary User-Agent = %v, want %v", env, varies, want)
+		}
+	}
+}
+
+func TestBookListTagIntersection(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Tags: []string{"scifi", "hugo-winner"}, Formats: []string{"EPUB"}})
+	lib.AddBook(t, testutil.Book{Title: "Solaris", Authors: []string{"Stanislaw Lem"}, Tags: []string{"scifi"}, Formats: []string{"EPUB"}})
+	lib.AddBook(t, testutil.Book{Title: "Middlemarch", Authors: []string{"George Eliot"}, Tags: []string{"classic", "hugo-winner"}, Formats: []string{"EPUB"}})
+	_, router := newTestServer(t, lib, nil)
+
+	for _, query := range []string{"tag=scifi&tag=hugo-winner", "tag=scifi,hugo-winner", "tag=hugo-winner,+scifi"} {
+		rec := get(router, "/opds/books?"+query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", query, rec.Code)
+		}
+		feed := parseFeed(t, rec.Body.Bytes())
+		if len(feed.Entries) != 1 || feed.Entries[0].Title != "Dune" {
+			t.Errorf("%s: got %d entries %+v, want only Dune", query, len(feed.Entries), feed.Entries)
+		}
+	}
+
+	// 单个标签的行为不变
+	feed := parseFeed(t, get(router, "/opds/books?tag=scifi", nil).Body.Bytes())
+	if len(feed.Entries) != 2 {
+		t.Errorf("tag=scifi: got %d entries, want 2", len(feed.Entries))
+	}
+}
+
+func TestCrawlableFeedVisitsEveryBookOnce(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	added := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	const total = 23
+	for i := 0; i < total; i++ {
+		lib.AddBook(t, testutil.Book{
+			Title:   fmt.Sprintf("Book %02d", i),
+			Authors: []string{"Ann"},
+			Formats: []string{"EPUB"},
+			// 每三本书的添加时间相同，分块边界上需要按ID区分
+			Added: added.Add(time.Duration(i/3) * time.Hour),
+		})
+	}
+	_, router := newTestServer(t, lib, map[string]string{"CRAWLABLE_PAGE_SIZE": "5"})
+
+	seen := map[string]int{}
+	target := "/opds/crawlable"
+	pages := 0
+	for target != "" {
+		pages++
+		if pages > total {
+			t.Fatal("next links do not terminate")
+		}
+		rec := get(router, target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", target, rec.Code)
+		}
+		feed := parseFeed(t, rec.Body.Bytes())
+		if len(feed.Entries) > 5 {
+			t.Errorf("%s: %d entries, want at most 5", target, len(feed.Entries))
+		}
+		for _, entry := range feed.Entries {
+			seen[entry.ID]++
+		}
+
+		target = ""
+		if next, ok := feed.link("next"); ok {
+			u, err := url.Parse(next.Href)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target = u.RequestURI()
+		}
+	}
+
+	if pages != 5 {
+		t.Errorf("followed %d pages, want 5", pages)
+	}
+	if len(seen) != total {
+		t.Errorf("saw %d distinct books, want %d", len(seen), total)
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("book %s appeared %d times", id, n)
+		}
+	}
+}
+
+func TestForwardedHeadersInLinks(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Formats: []string{"EPUB"}})
+
+	forwarded := map[string]string{
+		"X-Forwarded-Proto":  "https",
+		"X-Forwarded-Host":   "books.example.org",
+		"X-Forwarded-Prefix": "/library/",
+	}
+	// httptest请求的Host为example.com，RemoteAddr为192.0.2.1
+	tests := []struct {
+		name   string
+		env    map[string]string
+		header map[string]string
+		want   string
+	}{
+		{"headers ignored by default", nil, forwarded, "http://example.com"},
+		{"trusted from any address", map[string]string{"TRUST_PROXY_HEADERS": "true"}, forwarded, "https://books.example.org/library"},
+		{"trusted proxy IP", map[string]string{"TRUST_PROXY_HEADERS": "true", "OPDS_TRUSTED_PROXIES": "192.0.2.1"}, forwarded, "https://books.example.org/library"},
+		{"trusted proxy CIDR", map[string]string{"TRUST_PROXY_HEADERS": "true", "OPDS_TRUSTED_PROXIES": "192.0.2.0/24"}, forwarded, "https://books.example.org/library"},
+		{"untrusted proxy", map[string]string{"TRUST_PROXY_HEADERS": "true", "OPDS_TRUSTED_PROXIES": "10.0.0.0/8"}, forwarded, "http://example.com"},
+		{"first value of each header", map[string]string{"TRUST_PROXY_HEADERS": "true"}, map[string]string{
+			"X-Forwarded-Proto": "https, http",
+			"X-Forwarded-Host":  "books.example.org, proxy.internal",
+		}, "https://books.example.org"},
+		{"invalid values ignored", map[string]string{"TRUST_PROXY_HEADERS": "true"}, map[string]string{
+			"X-Forwarded-Proto":  "javascript",
+			"X-Forwarded-Host":   "evil.example/path",
+			"X-Forwarded-Prefix": "/a b",
+		}, "http://example.com"},
+		{"canonical URL wins", map[string]string{"TRUST_PROXY_HEADERS": "true", "CANONICAL_BASE_URL": "https://opds.example.net"}, forwarded, "https://opds.example.net"},
+	}
+	for _, tt := range tests {
+		// 子测试结束时恢复环境变量，各用例的配置互不影响
+		t.Run(tt.name, func(t *testing.T) {
+			_, router := newTestServer(t, lib, tt.env)
+
+			rec := get(router, "/opds/books", tt.header)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d", rec.Code)
+			}
+			feed := parseFeed(t, rec.Body.Bytes())
+			if self, ok := feed.link("self"); !ok || !strings.HasPrefix(self.Href, tt.want+"/opds/books?") {
+				t.Errorf("self link = %q, want prefix %q", self.Href, tt.want+"/opds/books?")
+			}
+			if len(feed.Entries) != 1 || len(feed.Entries[0].Links) == 0 {
+				t.Fatal("missing book entry")
+			}
+			for _, link := range feed.Entries[0].Links {
+				if !strings.HasPrefix(link.Href, tt.want+"/") {
+					t.Errorf("entry link %q does not start with %q", link.Href, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTagFeed(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	const tag = "C++/Sci-Fi & 科幻?#"
+	lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Tags: []string{tag}, Formats: []string{"EPUB"}})
+	lib.AddBook(t, testutil.Book{Title: "Hyperion", Authors: []string{"Dan Simmons"}, Tags: []string{tag}, Formats: []string{"EPUB"}})
+	lib.AddBook(t, testutil.Book{Title: "Emma", Authors: []string{"Jane Austen"}, Tags: []string{"C++"}, Formats: []string{"EPUB"}})
+	_, router := newTestServer(t, lib, nil)
+
+	rec := get(router, "/opds/tag/"+url.PathEscape(tag), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	feed := parseFeed(t, rec.Body.Bytes())
+	if len(feed.Entries) != 2 {
+		t.Errorf("got %d entries, want 2", len(feed.Entries))
+	}
+
+	// self链接可以再次请求，得到同一标签
+	self, ok := feed.link("self")
+	if !ok {
+		t.Fatal("missing self link")
+	}
+	u, err := url.Parse(self.Href)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(router, u.RequestURI(), nil); rec.Code != http.StatusOK || len(parseFeed(t, rec.Body.Bytes()).Entries) != 2 {
+		t.Errorf("self link %s: status %d", self.Href, rec.Code)
+	}
+
+	if rec := get(router, "/opds/tag/"+url.PathEscape("No such tag"), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown tag: status %d, want 404", rec.Code)
+	}
+
+	// limit=0按每页1本处理，不能除以零
+	for _, limit := range []string{"0", "-5"} {
+		rec := get(router, "/opds/tag/"+url.PathEscape(tag)+"?limit="+limit, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("limit=%s: status %d", limit, rec.Code)
+		}
+		if n := len(parseFeed(t, rec.Body.Bytes()).Entries); n != 1 {
+			t.Errorf("limit=%s: %d entries, want 1", limit, n)
+		}
+	}
+}
+
+func TestPublishersFeedLimit(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	for _, publisher := range []string{"Ace", "Bantam", "Tor"} {
+		lib.AddBook(t, testutil.Book{Title: publisher + " Book", Authors: []string{"Ann"}, Publisher: publisher})
+	}
+	_, router := newTestServer(t, lib, nil)
+
+	tests := []struct {
+		limit string
+		want  int
+	}{
+		{"", 3},
+		{"2", 2},
+		{"0", 1},
+		{"-1", 1},
+	}
+	for _, tt := range tests {
+		rec := get(router, "/opds/publishers?limit="+tt.limit, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("limit=%s: status %d", tt.limit, rec.Code)
+		}
+		if n := len(parseFeed(t, rec.Body.Bytes()).Entries); n != tt.want {
+			t.Errorf("limit=%s: %d entries, want %d", tt.limit, n, tt.want)
+		}
+	}
+}
+
+func TestCatalogAcquisition(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	id := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Formats: []string{"EPUB"}})
+	_, router := newTestServer(t, lib, map[string]string{"OPDS_CATALOG_ACQUISITION": "true"})
+
+	feed := parseFeed(t, get(router, fmt.Sprintf("/opds/book/%d", id), nil).Body.Bytes())
+	if len(feed.Entries) != 1 {
+		t.Fatalf("got %d entries", len(feed.Entries))
+	}
+	var href string
+	for _, link := range feed.Entries[0].Links {
+		if strings.HasPrefix(link.Rel, "http://opds-spec.org/acquisition") {
+			href = link.Href
+			break
+		}
+	}
+	want := fmt.Sprintf("http://example.com/opds/book/%d/acquire/EPUB", id)
+	if href != want {
+		t.Fatalf("acquisition link = %q, want %q", href, want)
+	}
+
+	u, _ := url.Parse(href)
+	rec := get(router, u.RequestURI()+"?token=abc", nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status %d, want 302", rec.Code)
+	}
+	if got, want := rec.Header().Get("Location"), fmt.Sprintf("http://example.com/download/%d/EPUB?token=abc", id); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("redirect has feed Cache-Control %q", cc)
+	}
+
+	target, _ := url.Parse(rec.Header().Get("Location"))
+	if rec := get(router, target.RequestURI(), nil); rec.Code != http.StatusOK {
+		t.Errorf("redirect target: status %d", rec.Code)
+	}
+
+	// 默认仍使用/download地址
+	_, router = newTestServer(t, lib, map[string]string{"OPDS_CATALOG_ACQUISITION": "false"})
+	feed = parseFeed(t, get(router, fmt.Sprintf("/opds/book/%d", id), nil).Body.Bytes())
+	for _, link := range feed.Entries[0].Links {
+		if strings.HasPrefix(link.Rel, "http://opds-spec.org/acquisition") && !strings.Contains(link.Href, "/download/") {
+			t.Errorf("default acquisition link = %q", link.Href)
+		}
+	}
+}
+
+func TestCanonicalBaseURL(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	addBooks(t, lib, 3)
+	_, router := newTestServer(t, lib, map[string]string{
+		"CANONICAL_BASE_URL":   "https://opds.example.net/books/",
+		"TRUST_PROXY_HEADERS":  "true",
+		"OPDS_TRUSTED_PROXIES": "192.0.2.0/24",
+	})
+	// 来自受信任代理的转发头和请求的Host都不影响链接
+	header := map[string]string{
+		"X-Forwarded-Proto":  "http",
+		"X-Forwarded-Host":   "internal.example.org",
+		"X-Forwarded-Prefix": "/proxy",
+	}
+	const want = "https://opds.example.net/books/"
+
+	rec := get(router, "http://other.example.com/opds/books?limit=1", header)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("feed: status %d", rec.Code)
+	}
+	feed := parseFeed(t, rec.Body.Bytes())
+	var hrefs []string
+	for _, link := range feed.Links {
+		hrefs = append(hrefs, link.Href)
+	}
+	for _, entry := range feed.Entries {
+		for _, link := range entry.Links {
+			hrefs = append(hrefs, link.Href)
+		}
+	}
+	if _, ok := feed.link("next"); !ok {
+		t.Error("missing next link")
+	}
+	for _, href := range hrefs {
+		if !strings.HasPrefix(href, want) {
+			t.Errorf("feed link %q does not start with %q", href, want)
+		}
+	}
+
+	rec = get(router, "http://other.example.com/api/books?limit=1", header)
+	if link := rec.Header().Get("Link"); !strings.Contains(link, "<"+want+"api/books?") || strings.Contains(link, "example.org") || strings.Contains(link, "other.example.com") {
+		t.Errorf("API Link header = %q, want links under %q", link, want)
+	}
+}
+
+func TestNewWindowCategory(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	now := time.Now().UTC()
+	recent := lib.AddBook(t, testutil.Book{Title: "Recent", Authors: []string{"A"}, Added: now.Add(-time.Hour)})
+	old := lib.AddBook(t, testutil.Book{Title: "Old", Authors: []string{"A"}, Added: now.Add(-30 * 24 * time.Hour)})
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want map[string]bool
+	}{
+		{"disabled", nil, map[string]bool{"Recent": false, "Old": false}},
+		{"one week", map[string]string{"NEW_WINDOW": "168h"}, map[string]bool{"Recent": true, "Old": false}},
+		{"two months", map[string]string{"NEW_WINDOW": "1440h"}, map[string]bool{"Recent": true, "Old": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, router := newTestServer(t, lib, tt.env)
+
+			rec := get(router, "/opds/books", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d", rec.Code)
+			}
+			for _, entry := range parseFeed(t, rec.Body.Bytes()).Entries {
+				isNew := false
+				for _, category := range entry.Categories {
+					if category.Term == "new" {
+						isNew = true
+					}
+				}
+				if isNew != tt.want[entry.Title] {
+					t.Errorf("feed %s: new = %v, want %v", entry.Title, isNew, tt.want[entry.Title])
+				}
+			}
+
+			for title, id := range map[string]int{"Recent": recent, "Old": old} {
+				rec := get(router, fmt.Sprintf("/api/book/%d", id), nil)
+				var book struct {
+					IsNew bool `json:"is_new"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &book); err != nil {
+					t.Fatal(err)
+				}
+				if book.IsNew != tt.want[title] {
+					t.Errorf("API %s: is_new = %v, want %v", title, book.IsNew, tt.want[title])
+				}
+			}
+		})
+	}
+}
+
+func TestBookDetailNullComments(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	present := lib.AddBook(t, testutil.Book{Title: "Present", Authors: []string{"A"}, Comments: "<p>Spice</p>"})
+	null := lib.AddBook(t, testutil.Book{Title: "Null", Authors: []string{"A"}})
+	lib.Exec(t, `INSERT INTO comments(book, text) VALUES (?, NULL)`, null)
+	_, router := newTestServer(t, lib, map[string]string{"OPDS_ENTRY_CONTENT": "true"})
+
+	for id, want := range map[int]string{present: "Spice", null: ""} {
+		rec := get(router, fmt.Sprintf("/opds/book/%d", id), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("book %d: status %d", id, rec.Code)
+		}
+		feed := parseFeed(t, rec.Body.Bytes())
+		if len(feed.Entries) != 1 {
+			t.Fatalf("book %d: %d entries", id, len(feed.Entries))
+		}
+		if summary := feed.Entries[0].Summary; !strings.Contains(summary, want) || (want == "" && summary != "") {
+			t.Errorf("book %d: summary %q, want %q", id, summary, want)
+		}
+	}
+}
+
+func TestCrawlableFeedChunks(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	for i := 0; i < 10; i++ {
+		lib.AddBook(t, testutil.Book{Title: fmt.Sprintf("Book %02d", i), Authors: []string{"Ann"}, Formats: []string{"EPUB"}})
+	}
+
+	// follow 沿next链接读取全部分块，返回每块的条目数
+	follow := func(t *testing.T, router http.Handler, target string) []int {
+		t.Helper()
+		var sizes []int
+		for target != "" && len(sizes) <= 10 {
+			rec := get(router, target, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: status %d", target, rec.Code)
+			}
+			feed := parseFeed(t, rec.Body.Bytes())
+			sizes = append(sizes, len(feed.Entries))
+			target = ""
+			if next, ok := feed.link("next"); ok {
+				u, err := url.Parse(next.Href)
+				if err != nil {
+					t.Fatal(err)
+				}
+				target = u.RequestURI()
+			}
+		}
+		return sizes
+	}
+
+	tests := []struct {
+		name     string
+		pageSize string
+		want     []int
+	}{
+		{"single document", "0", []int{10}},
+		// 书籍数正好是块大小的整数倍时，最后一块之后没有空块
+		{"exact multiple", "5", []int{5, 5}},
+		{"remainder", "4", []int{4, 4, 2}},
+		{"page larger than library", "50", []int{10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, router := newTestServer(t, lib, map[string]string{"CRAWLABLE_PAGE_SIZE": tt.pageSize})
+			for _, target := range []string{"/opds/all", "/opds/crawlable"} {
+				if got := follow(t, router, target); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: chunk sizes %v, want %v", target, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecadesFeed(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	for _, book := range []struct {
+		title string
+		year  int
+	}{
+		{"Neuromancer", 1984},
+		{"Snow Crash", 1992},
+		{"Diamond Age", 1995},
+		{"Anathem", 2008},
+	} {
+		lib.AddBook(t, testutil.Book{Title: book.title, Authors: []string{"Author"}, PubDate: time.Date(book.year, 6, 1, 0, 0, 0, 0, time.UTC)})
+	}
+	lib.AddBook(t, testutil.Book{Title: "Undated", Authors: []string{"Author"}})
+	// Calibre用0101年表示未知的出版日期
+	sentinel := lib.AddBook(t, testutil.Book{Title: "Sentinel", Authors: []string{"Author"}})
+	lib.Exec(t, `UPDATE books SET pubdate = '0101-01-01 00:00:00+00:00' WHERE id = ?`, sentinel)
+	_, router := newTestServer(t, lib, nil)
+
+	rec := get(router, "/opds/decades", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	feed := parseFeed(t, rec.Body.Bytes())
+
+	tests := []struct {
+		title string
+		href  string
+		books []string
+	}{
+		{"2000年代 (1 本书)", "/opds/books?year_from=2000&year_to=2009", []string{"Anathem"}},
+		{"1990年代 (2 本书)", "/opds/books?year_from=1990&year_to=1999", []string{"Diamond Age", "Snow Crash"}},
+		{"1980年代 (1 本书)", "/opds/books?year_from=1980&year_to=1989", []string{"Neuromancer"}},
+		{"未知 (2 本书)", "/opds/books?no_pubdate=1", []string{"Sentinel", "Undated"}},
+	}
+	if len(feed.Entries) != len(tests) {
+		t.Fatalf("got %d entries, want %d", len(feed.Entries), len(tests))
+	}
+	for i, tt := range tests {
+		entry := feed.Entries[i]
+		if entry.Title != tt.title {
+			t.Errorf("entry %d: title %q, want %q", i, entry.Title, tt.title)
+		}
+		if len(entry.Links) == 0 {
+			t.Fatalf("%s: no link", tt.title)
+		}
+		href := strings.TrimPrefix(entry.Links[0].Href, "http://example.com")
+		if href != tt.href {
+			t.Errorf("%s: href %q, want %q", tt.title, href, tt.href)
+		}
+
+		// 链接到的书籍列表只包含该年代的书
+		var titles []string
+		for _, book := range parseFeed(t, get(router, href, nil).Body.Bytes()).Entries {
+			titles = append(titles, book.Title)
+		}
+		sort.Strings(titles)
+		if !reflect.DeepEqual(titles, tt.books) {
+			t.Errorf("%s: books %v, want %v", href, titles, tt.books)
+		}
+	}
+}
+
+func TestMinimalEntries(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	id := lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, Formats: []string{"EPUB", "PDF"}, Comments: "<p>Spice</p>"})
+	lib.SetCover(t, id, testJPEG(t, 30, 40, color.White))
+	lib.Exec(t, `UPDATE books SET isbn = '9780441172719' WHERE id = ?`, id)
+	_, router := newTestServer(t, lib, map[string]string{
+		"OPDS_ENTRY_CONTENT":          "true",
+		"OPDS_EXTRA_ACQUISITION_RELS": "alternate",
+	})
+
+	// 列表feed不读取简介，简介只在详情feed的条目中输出
+	optional := []string{"<content", "<dc:identifier", "<dcterms:extent", `rel="alternate"`}
+	required := []string{
+		`rel="http://opds-spec.org/acquisition/open-access"`,
+		`rel="http://opds-spec.org/acquisition"`,
+		`rel="http://opds-spec.org/image"`,
+		`rel="http://opds-spec.org/image/thumbnail"`,
+	}
+	tests := []struct {
+		target  string
+		minimal bool
+	}{
+		{"/opds/books", false},
+		{"/opds/books?verbose=0", true},
+		{"/opds/books?minimal=1", true},
+		{"/opds/all?verbose=0", true},
+		// 详情feed始终输出完整条目
+		{"/opds/book/1?verbose=0", false},
+	}
+	for _, tt := range tests {
+		rec := get(router, tt.target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.target, rec.Code)
+		}
+		body := rec.Body.String()
+		for _, element := range optional {
+			if got := strings.Contains(body, element); got == tt.minimal {
+				t.Errorf("%s: contains %s = %v, want %v", tt.target, element, got, !tt.minimal)
+			}
+		}
+		for _, link := range required {
+			if !strings.Contains(body, link) {
+				t.Errorf("%s: missing %s", tt.target, link)
+			}
+		}
+	}
+	if body := get(router, "/opds/book/1?verbose=0", nil).Body.String(); !strings.Contains(body, "<summary") {
+		t.Errorf("detail feed: missing summary")
+	}
+}
+
+func TestEmptyLibraryHint(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+
+	t.Run("empty library", func(t *testing.T) {
+		_, router := newTestServer(t, lib, nil)
+		root := parseFeed(t, get(router, "/opds", nil).Body.Bytes())
+		if len(root.Entries) != 1 {
+			t.Fatalf("root: got %d entries, want only the hint", len(root.Entries))
+		}
+		hint := root.Entries[0]
+		if hint.Title != emptyLibraryTitle || !strings.Contains(hint.Summary, "Calibre") {
+			t.Errorf("hint entry = %q: %q", hint.Title, hint.Summary)
+		}
+		for _, rel := range []string{"search", "http://opds-spec.org/crawlable"} {
+			if _, ok := root.link(rel); !ok {
+				t.Errorf("root: missing %s link", rel)
+			}
+		}
+
+		for _, target := range []string{"/opds/books", "/opds/books?tag=x", "/opds/all"} {
+			feed := parseFeed(t, get(router, target, nil).Body.Bytes())
+			if feed.Title != emptyLibraryTitle || len(feed.Entries) != 0 {
+				t.Errorf("%s: title %q with %d entries", target, feed.Title, len(feed.Entries))
+			}
+		}
+	})
+
+	t.Run("hint disabled", func(t *testing.T) {
+		_, router := newTestServer(t, lib, map[string]string{"EMPTY_LIBRARY_HINT": "false"})
+		root := parseFeed(t, get(router, "/opds", nil).Body.Bytes())
+		if len(root.Entries) < 2 || root.Entries[0].Title == emptyLibraryTitle {
+			t.Errorf("root: want the normal navigation, got %d entries", len(root.Entries))
+		}
+	})
+
+	t.Run("filter without matches", func(t *testing.T) {
+		lib := testutil.NewLibrary(t)
+		lib.AddBook(t, testutil.Book{Title: "Dune", Authors: []string{"Frank Herbert"}})
+		_, router := newTestServer(t, lib, nil)
+		if root := parseFeed(t, get(router, "/opds", nil).Body.Bytes()); root.Entries[0].Title == emptyLibraryTitle {
+			t.Errorf("root shows the empty hint for a non-empty library")
+		}
+		if feed := parseFeed(t, get(router, "/opds/books?tag=missing", nil).Body.Bytes()); feed.Title != "没有符合条件的书籍" {
+			t.Errorf("filtered feed title = %q", feed.Title)
+		}
+	})
+}
+
+func TestKOReaderProfile(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Book", Authors: []string{"A"}, Formats: []string{"EPUB"}})
+	env := map[string]string{"CLIENT_PROFILES": "true", "OPDS_ENTRY_CONTENT": "true"}
+
+	tests := []struct {
+		agents      string
+		userAgent   string
+		wantContent bool
+	}{
+		{"", "Mozilla/5.0", true},
+		// KOReader只显示summary，不输出内容块
+		{"", "KOReader/2024.01 (https://koreader.rocks/)", false},
+		// CLIENT_PROFILE_AGENTS可以把其他阅读器映射到KOReader配置，或取消内置的匹配
+		{"koreader=MyReader", "MyReader/1.0", false},
+		{"default=KOReader", "KOReader/2024.01", true},
+	}
+	for _, tt := range tests {
+		env["CLIENT_PROFILE_AGENTS"] = tt.agents
+		_, router := newTestServer(t, lib, env)
+		rec := get(router, "/opds/books", map[string]string{"User-Agent": tt.userAgent})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.userAgent, rec.Code)
+		}
+		if got := strings.Contains(rec.Body.String(), "<content"); got != tt.wantContent {
+			t.Errorf("agents %q, User-Agent %q: content = %v, want %v", tt.agents, tt.userAgent, got, tt.wantContent)
+		}
+	}
+}
+
+func TestChangelogFeed(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	day := func(month int) time.Time { return time.Date(2024, time.Month(month), 1, 0, 0, 0, 0, time.UTC) }
+	lib.AddBook(t, testutil.Book{Title: "Added", Authors: []string{"A"}, Added: day(1)})
+	modified := lib.AddBook(t, testutil.Book{Title: "Modified", Authors: []string{"A"}, Added: day(1).AddDate(-1, 0, 0)})
+	lib.Exec(t, `UPDATE books SET last_modified = ? WHERE id = ?`, day(3).Format("2006-01-02 15:04:05+00:00"), modified)
+	// 添加后几分钟内的修改仍视为新增
+	touched := lib.AddBook(t, testutil.Book{Title: "Touched", Authors: []string{"A"}, Added: day(2)})
+	lib.Exec(t, `UPDATE books SET last_modified = ? WHERE id = ?`, day(2).Add(2*time.Minute).Format("2006-01-02 15:04:05+00:00"), touched)
+	_, router := newTestServer(t, lib, nil)
+
+	want := []struct {
+		title, change string
+		changedAt     time.Time
+	}{
+		{"Modified", "modified", day(3)},
+		{"Touched", "added", day(2)},
+		{"Added", "added", day(1)},
+	}
+
+	rec := get(router, "/api/changelog", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("api: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var result struct {
+		Changes []struct {
+			Title     string    `json:"title"`
+			Change    string    `json:"change"`
+			ChangedAt time.Time `json:"changed_at"`
+		} `json:"changes"`
+		Total int `json:"total"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Changes) != len(want) || result.Total != len(want) {
+		t.Fatalf("api: got %d changes (total %d), want %d", len(result.Changes), result.Total, len(want))
+	}
+	for i, w := range want {
+		got := result.Changes[i]
+		if got.Title != w.title || got.Change != w.change || !got.ChangedAt.Equal(w.changedAt) {
+			t.Errorf("api change %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	labels := map[string]string{"added": "新增", "modified": "修改"}
+	feed := parseFeed(t, get(router, "/opds/changelog", nil).Body.Bytes())
+	if len(feed.Entries) != len(want) {
+		t.Fatalf("opds: got %d entries, want %d", len(feed.Entries), len(want))
+	}
+	for i, w := range want {
+		entry := feed.Entries[i]
+		if title := labels[w.change] + ": " + w.title; entry.Title != title {
+			t.Errorf("opds entry %d: title %q, want %q", i, entry.Title, title)
+		}
+		if updated := w.changedAt.Format(time.RFC3339); entry.Updated != updated {
+			t.Errorf("opds entry %d: updated %q, want %q", i, entry.Updated, updated)
+		}
+	}
+
+	// 分页：第一页有next，第二页有previous
+	first := parseFeed(t, get(router, "/opds/changelog?limit=2", nil).Body.Bytes())
+	next, ok := first.link("next")
+	if len(first.Entries) != 2 || !ok {
+		t.Fatalf("first page: %d entries, next link %v", len(first.Entries), ok)
+	}
+	second := parseFeed(t, get(router, next.Href, nil).Body.Bytes())
+	if len(second.Entries) != 1 || second.Entries[0].Title != "新增: Added" {
+		t.Errorf("second page entries = %+v", second.Entries)
+	}
+	if _, ok := second.link("next"); ok {
+		t.Errorf("second page: unexpected next link")
+	}
+	if _, ok := second.link("previous"); !ok {
+		t.Errorf("second page: missing previous link")
+	}
+}
+
+func TestEntryMaxAuthors(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	lib.AddBook(t, testutil.Book{Title: "Anthology", Authors: []string{"A1", "A2", "A3", "A4", "A5"}})
+	lib.AddBook(t, testutil.Book{Title: "Trio", Authors: []string{"B1", "B2", "B3"}})
+
+	// authorsOf 返回feed中指定书名条目的作者名
+	authorsOf := func(t *testing.T, data []byte, title string) []string {
+		t.Helper()
+		var feed struct {
+			Entries []struct {
+				Title   string   `xml:"title"`
+				Authors []string `xml:"author>name"`
+			} `xml:"entry"`
+		}
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range feed.Entries {
+			if entry.Title == title {
+				return entry.Authors
+			}
+		}
+		t.Fatalf("no entry %q", title)
+		return nil
+	}
+	all := []string{"A1", "A2", "A3", "A4", "A5"}
+
+	t.Run("capped", func(t *testing.T) {
+		_, router := newTestServer(t, lib, map[string]string{"OPDS_ENTRY_MAX_AUTHORS": "3"})
+		list := get(router, "/opds/books", nil).Body.Bytes()
+		if got, want := authorsOf(t, list, "Anthology"), []string{"A1", "A2", "A3", "et al."}; !reflect.DeepEqual(got, want) {
+			t.Errorf("list: authors %v, want %v", got, want)
+		}
+		// 作者数不超过上限时不加et al.
+		if got, want := authorsOf(t, list, "Trio"), []string{"B1", "B2", "B3"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("list: authors %v, want %v", got, want)
+		}
+		// 详情feed始终输出全部作者
+		detail := get(router, "/opds/book/1", nil).Body.Bytes()
+		if got := authorsOf(t, detail, "Anthology"); !reflect.DeepEqual(got, all) {
+			t.Errorf("detail: authors %v, want %v", got, all)
+		}
+	})
+
+	t.Run("unlimited by default", func(t *testing.T) {
+		_, router := newTestServer(t, lib, nil)
+		if got := authorsOf(t, get(router, "/opds/books", nil).Body.Bytes(), "Anthology"); !reflect.DeepEqual(got, all) {
+			t.Errorf("list: authors %v, want %v", got, all)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/internal/database"
+	"github.com/ricci/calibre-opds-go/internal/opds"
+	"github.com/ricci/calibre-opds-go/internal/progress"
+)
+
+// 继续阅读feed最多显示的书籍数
+const continueReadingLimit = 50
+
+// SetProgressStore 设置阅读进度存储，未设置时阅读进度相关接口不可用
+func (h *Handler) SetProgressStore(store *progress.Store) {
+	h.progress = store
+}
+
+// minDeviceKeyLength 设备密钥的最短长度，太短的密钥容易被猜中
+const minDeviceKeyLength = 16
+
+// progressUser 返回当前请求的用户：受信任代理通过X-Remote-User传递的用户名，
+// 否则为客户端自行生成的设备密钥（X-Device-Key请求头或device_key参数），只保存其哈希；
+// 两者都没有时返回false，不同客户端不能共用同一份匿名进度
+func (h *Handler) progressUser(c *gin.Context) (string, bool) {
+	if user := c.GetHeader("X-Remote-User"); user != "" && h.fromTrustedProxy(c) {
+		return user, true
+	}
+	key := c.GetHeader("X-Device-Key")
+	if key == "" {
+		key = c.Query("device_key")
+	}
+	if len(key) < minDeviceKeyLength {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "device:" + hex.EncodeToString(sum[:]), true
+}
+
+// requireProgressUser 返回当前请求的用户，无法识别时返回401
+func (h *Handler) requireProgressUser(c *gin.Context) (string, bool) {
+	user, ok := h.progressUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": fmt.Sprintf("Reading progress requires X-Remote-User from a trusted proxy or an X-Device-Key of at least %d characters", minDeviceKeyLength),
+		})
+	}
+	return user, ok
+}
+
+// progressRequest 阅读进度上报请求体
+type progressRequest struct {
+	Position   string   `json:"position"`
+	Percentage *float64 `json:"percentage"`
+}
+
+// APIUpdateProgress 保存客户端上报的阅读进度
+func (h *Handler) APIUpdateProgress(c *gin.Context) {
+	if h.progress == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reading progress is disabled"})
+		return
+	}
+
+	user, ok := h.requireProgressUser(c)
+	if !ok {
+		return
+	}
+
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	var req progressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(bodyErrorStatus(err), gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if req.Percentage == nil || *req.Percentage < 0 || *req.Percentage > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "percentage is required and must be between 0 and 100"})
+		return
+	}
+
+	book, err := h.db.GetBookDetailContext(c.Request.Context(), bookID)
+	if err != nil {
+		c.JSON(h.dbErrorStatus(c, err), gin.H{"error": "Failed to get book"})
+		return
+	}
+	if book == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+
+	p := progress.Progress{
+		User:       user,
+		BookID:     bookID,
+		Position:   req.Position,
+		Percentage: *req.Percentage,
+		UpdatedAt:  time.Now().UTC(),
+	}
+	if err := h.progress.Update(p); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, p)
+}
+
+// OPDSContinueReading 当前用户未读完的书籍，按最近阅读时间排序
+func (h *Handler) OPDSContinueReading(c *gin.Context) {
+	if h.progress == nil {
+		c.String(http.StatusNotFound, "Reading progress is disabled")
+		return
+	}
+
+	user, ok := h.requireProgressUser(c)
+	if !ok {
+		return
+	}
+	// 内容因用户而异，不能由共享缓存保存
+	c.Header("Cache-Control", "private, no-cache")
+	c.Header("Vary", "X-Remote-User, X-Device-Key")
+
+	records, err := h.progress.InProgress(user, continueReadingLimit)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to get reading progress")
+		return
+	}
+
+	gen := h.newGenerator(c)
+
+	ids := make([]int, len(records))
+	for i, record := range records {
+		ids[i] = record.BookID
+	}
+	// 已从书库删除的书籍不在结果中
+	books, err := h.db.GetBooksByIDsContext(c.Request.Context(), ids)
+	if err != nil {
+		c.String(h.dbErrorStatus(c, err), "Failed to get books")
+		return
+	}
+	byID := make(map[int]*database.Book, len(books))
+	for i := range books {
+		byID[books[i].ID] = &books[i]
+	}
+
+	entries := make([]opds.Entry, 0, len(records))
+	for _, record := range records {
+		book, ok := byID[record.BookID]
+		if !ok {
+			continue
+		}
+
+		entry := gen.CreateBookEntry(book)
+		entry.Updated = record.UpdatedAt.Format(time.RFC3339)
+		entry.Summary = fmt.Sprintf("已读 %.0f%%", record.Percentage)
+		entries = append(entries, entry)
+	}
+
+	links := []opds.Link{
+		{
+			Rel:  "self",
+			Href: gen.BaseURL + "/opds/continue" + deviceKeyQuery(c),
+			Type: "application/atom+xml;type=feed;profile=opds-catalog",
+		},
+	}
+
+	xmlData, err := gen.CreateFeed("继续阅读", entries, links, nil)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to generate feed")
+		return
+	}
+
+	h.serveFeed(c, gen, xmlData)
+}
+
+// deviceKeyQuery 通过device_key参数识别设备时，feed中的链接需要带上该参数
+func deviceKeyQuery(c *gin.Context) string {
+	if key := c.Query("device_key"); key != "" {
+		return "?" + url.Values{"device_key": {key}}.Encode()
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ricci/calibre-opds-go/internal/progress"
+	"github.com/ricci/calibre-opds-go/internal/testutil"
+)
+
+func TestReadingProgressRequiresIdentity(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	for _, title := range []string{"First", "Second", "Third"} {
+		lib.AddBook(t, testutil.Book{Title: title, Authors: []string{"A"}, Formats: []string{"EPUB"}})
+	}
+	h, router := newTestServer(t, lib, nil)
+	store, err := progress.Open(filepath.Join(t.TempDir(), "progress.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	h.SetProgressStore(store)
+
+	// 没有用户或设备密钥时不接受上报，也不返回任何人的进度
+	if rec := post(router, "/api/book/1/progress", `{"percentage": 10}`, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous update: status %d, want 401", rec.Code)
+	}
+	if rec := post(router, "/api/book/1/progress", `{"percentage": 10}`, map[string]string{"X-Device-Key": "short"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("short device key: status %d, want 401", rec.Code)
+	}
+	if rec := get(router, "/opds/continue", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous continue feed: status %d, want 401", rec.Code)
+	}
+
+	phone := map[string]string{"X-Device-Key": "phone-0123456789abcdef"}
+	tablet := map[string]string{"X-Device-Key": "tablet-0123456789abcdef"}
+	for _, update := range []struct {
+		device map[string]string
+		target string
+		body   string
+	}{
+		{phone, "/api/book/1/progress", `{"percentage": 20}`},
+		{phone, "/api/book/2/progress", `{"percentage": 50}`},
+		{phone, "/api/book/3/progress", `{"percentage": 100}`}, // 读完的书不在继续阅读中
+		{tablet, "/api/book/3/progress", `{"percentage": 30}`},
+	} {
+		if rec := post(router, update.target, update.body, update.device); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", update.target, rec.Code, rec.Body.String())
+		}
+	}
+
+	titles := func(header map[string]string, target string) []string {
+		rec := get(router, target, header)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", target, rec.Code)
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != "private, no-cache" {
+			t.Errorf("Cache-Control = %q, want private", cc)
+		}
+		var got []string
+		for _, entry := range parseFeed(t, rec.Body.Bytes()).Entries {
+			got = append(got, entry.Title)
+		}
+		return got
+	}
+	// 按最近阅读时间倒序，各设备的进度相互独立
+	if got, want := titles(phone, "/opds/continue"), []string{"Second", "First"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("phone: %v, want %v", got, want)
+	}
+	if got, want := titles(tablet, "/opds/continue"), []string{"Third"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tablet: %v, want %v", got, want)
+	}
+	if got, want := titles(nil, "/opds/continue?device_key=tablet-0123456789abcdef"), []string{"Third"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tablet via query: %v, want %v", got, want)
+	}
+}
+
+func TestContinueReadingOrder(t *testing.T) {
+	lib := testutil.NewLibrary(t)
+	for _, title := range []string{"First", "Second", "Third"} {
+		lib.AddBook(t, testutil.Book{Title: title, Authors: []string{"A"}, Formats: []string{"EPUB"}})
+	}
+	h, router := newTestServer(t, lib, nil)
+	store, err := progress.Open(filepath.Join(t.TempDir(), "progress.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	h.SetProgressStore(store)
+
+	device := map[string]string{"X-Device-Key": "phone-0123456789abcdef"}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.Header.Set("X-Device-Key", device["X-Device-Key"])
+	user, ok := h.progressUser(c)
+	if !ok {
+		t.Fatal("device key not accepted")
+	}
+
+	// 直接写入带时间的进度，书籍4已从书库删除
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, p := range []progress.Progress{
+		{User: user, BookID: 1, Percentage: 10, UpdatedAt: now.Add(-3 * time.Hour)},
+		{User: user, BookID: 2, Percentage: 42, UpdatedAt: now.Add(-time.Minute)},
+		{User: user, BookID: 3, Percentage: 75, UpdatedAt: now.Add(-time.Hour)},
+		{User: user, BookID: 4, Percentage: 50, UpdatedAt: now},
+	} {
+		if err := store.Update(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := get(router, "/opds/continue", device)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	type item struct{ title, summary, updated string }
+	var got []item
+	for _, entry := range parseFeed(t, rec.Body.Bytes()).Entries {
+		got = append(got, item{entry.Title, entry.Summary, entry.Updated})
+	}
+	want := []item{
+		{"Second", "已读 42%", now.Add(-time.Minute).Format(time.RFC3339)},
+		{"Third", "已读 75%", now.Add(-time.Hour).Format(time.RFC3339)},
+		{"First", "已读 10%", now.Add(-3 * time.Hour).Format(time.RFC3339)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("continue feed = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ricci/calibre-opds-go/pkg/logger"
+)
+
+// thumbnailSizes 允许的缩略图边长，请求的尺寸向上取整到其中之一，避免任意尺寸组合占满磁盘缓存
+var thumbnailSizes = []int{100, 200, 300, 400, 600, 800, 1200}
+
+// thumbnailBucket 把请求的边长向上取整到thumbnailSizes中的尺寸，超过最大值时取最大值；不大于0表示该方向不限制
+func thumbnailBucket(size int) int {
+	if size <= 0 {
+		return 0
+	}
+	for _, bucket := range thumbnailSizes {
+		if size <= bucket {
+			return bucket
+		}
+	}
+	return thumbnailSizes[len(thumbnailSizes)-1]
+}
+
+// thumbnailKey 缓存文件名：书籍ID和尺寸便于人工排查，其余影响输出的因素（书库根目录、封面路径、
+// 修改时间、宽高比、背景色）取哈希，任一变化都使用新的缓存文件
+func thumbnailKey(root, coverPath string, modTime time.Time, bookID, width, height int, aspect float64, background color.RGBA) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		root,
+		coverPath,
+		strconv.FormatInt(modTime.UnixNano(), 16),
+		strconv.FormatFloat(aspect, 'f', 4, 64),
+		fmt.Sprintf("%02x%02x%02x%02x", background.R, background.G, background.B, background.A),
+	}, "\x00")))
+	return fmt.Sprintf("%d_%dx%d_%s", bookID, width, height, hex.EncodeToString(sum[:8]))
+}
+
+// thumbnailCache 缩放后封面的磁盘缓存，总大小超过上限时按最近使用时间淘汰
+type thumbnailCache struct {
+	cacheCounters
+
+	dir     string
+	maxSize int64 // 0表示不限制
+
+	mu      sync.Mutex
+	size    int64 // 缓存目录中文件的总大小，首次写入时统计
+	scanned bool
+}
+
+// newThumbnailCache 创建磁盘缓存，目录在首次写入时创建
+func newThumbnailCache(dir string, maxSize int64) *thumbnailCache {
+	return &thumbnailCache{dir: dir, maxSize: maxSize}
+}
+
+// get 返回已缓存的文件，并更新其修改时间作为最近使用时间
+func (tc *thumbnailCache) get(name string) (string, bool) {
+	path := filepath.Join(tc.dir, name)
+	if _, err := os.Stat(path); err != nil {
+		tc.miss()
+		return "", false
+	}
+	tc.hit()
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return path, true
+}
+
+// put 写入缓存文件并返回其路径；先写临时文件再重命名，并发请求不会读到写了一半的文件
+func (tc *thumbnailCache) put(name string, data []byte) (string, error) {
+	if err := os.MkdirAll(tc.dir, 0o755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(tc.dir, name)
+	tmp, err := os.CreateTemp(tc.dir, name+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	tc.added(name, int64(len(data)))
+	return path, nil
+}
+
+// added 记录新写入的文件，总大小超过上限时淘汰最久未使用的文件，直到降到上限的90%
+func (tc *thumbnailCache) added(name string, size int64) {
+	if tc.maxSize <= 0 {
+		return
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if !tc.scanned {
+		// 首次写入时统计目录中已有的文件（包括刚写入的文件），之后增量累加
+		tc.size = 0
+		for _, f := range tc.files() {
+			tc.size += f.size
+		}
+		tc.scanned = true
+	} else {
+		tc.size += size
+	}
+	if tc.size <= tc.maxSize {
+		return
+	}
+
+	files := tc.files()
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	target := tc.maxSize / 10 * 9
+	for _, f := range files {
+		if tc.size <= target {
+			break
+		}
+		if f.name == name {
+			continue
+		}
+		if err := os.Remove(filepath.Join(tc.dir, f.name)); err != nil && !os.IsNotExist(err) {
+			logger.Warning.Printf("Failed to evict thumbnail %s: %v", f.name, err)
+			continue
+		}
+		tc.size -= f.size
+	}
+}
+
+// cachedFile 缓存目录中的文件
+type cachedFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// usage 返回缓存目录中的文件数和总字节数
+func (tc *thumbnailCache) usage() (int, int64) {
+	files := tc.files()
+	var size int64
+	for _, f := range files {
+		size += f.size
+	}
+	return len(files), size
+}
+
+// Stats 返回命中统计、缓存文件数和总字节数
+func (tc *thumbnailCache) Stats() gin.H {
+	files, size := tc.usage()
+	stats := tc.counterStats(files)
+	stats["bytes"] = size
+	return stats
+}
+
+// files 列出缓存目录中的缓存文件，忽略临时文件
+func (tc *thumbnailCache) files() []cachedFile {
+	entries, err := os.ReadDir(tc.dir)
+	if err != nil {
+		return nil
+	}
+	files := make([]cachedFile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cachedFile{name: entry.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+	return files
+}
@@ -0,0 +1,264 @@
+package metrics
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// durationBuckets 请求和查询耗时直方图的桶上限（秒），与Prometheus客户端的默认值一致
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// unmatchedRoute 未匹配任何路由的请求使用的route标签，避免任意路径造成标签爆炸
+const unmatchedRoute = "unmatched"
+
+// requestKey 请求计数的标签
+type requestKey struct {
+	method string
+	route  string
+	status int
+}
+
+// histogram 累积直方图，counts[i]为耗时不超过durationBuckets[i]的次数
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// observe 记录一次耗时（秒）
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// histogramFor 返回key对应的直方图，不存在时创建
+func histogramFor(m map[string]*histogram, key string) *histogram {
+	h := m[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m[key] = h
+	}
+	return h
+}
+
+// CacheStats 一个缓存的命中统计
+type CacheStats struct {
+	Name    string
+	Hits    int64
+	Misses  int64
+	Entries int
+}
+
+// Metrics 以Prometheus文本格式输出的请求、数据库查询、下载、缓存和连接池指标
+type Metrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[string]*histogram
+	queries   map[string]*histogram
+	downloads map[string]uint64
+
+	// cacheStats 输出时读取各缓存的命中统计，为nil时不输出缓存指标
+	cacheStats func() []CacheStats
+}
+
+// New 创建指标集合
+func New() *Metrics {
+	return &Metrics{
+		requests:  make(map[requestKey]uint64),
+		durations: make(map[string]*histogram),
+		queries:   make(map[string]*histogram),
+		downloads: make(map[string]uint64),
+	}
+}
+
+// Middleware 按路由模板统计请求数和耗时的中间件
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		m.ObserveRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
+
+// ObserveRequest 记录一次请求
+func (m *Metrics) ObserveRequest(method, route string, status int, latency time.Duration) {
+	seconds := latency.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{method, route, status}]++
+	histogramFor(m.durations, route).observe(seconds)
+}
+
+// ObserveQuery 记录一次数据库查询的耗时，op为查询名称
+func (m *Metrics) ObserveQuery(op string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	histogramFor(m.queries, op).observe(latency.Seconds())
+}
+
+// IncDownload 记录一次书籍下载
+func (m *Metrics) IncDownload(format string) {
+	m.mu.Lock()
+	m.downloads[strings.ToUpper(format)]++
+	m.mu.Unlock()
+}
+
+// SetCacheStats 设置读取缓存命中统计的函数，需在开始输出指标之前调用
+func (m *Metrics) SetCacheStats(stats func() []CacheStats) {
+	m.cacheStats = stats
+}
+
+// Handler 输出指标的处理函数；poolStats为nil时不输出连接池指标
+func (m *Metrics) Handler(poolStats func() sql.DBStats) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+
+		w := bufio.NewWriter(c.Writer)
+		m.write(w)
+		if poolStats != nil {
+			writePoolStats(w, poolStats())
+		}
+		w.Flush()
+	}
+}
+
+// write 输出请求、数据库查询和下载指标，标签按字典序排列以保证输出稳定
+func (m *Metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP opds_http_requests_total HTTP requests by method, route and status.")
+	fmt.Fprintln(w, "# TYPE opds_http_requests_total counter")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "opds_http_requests_total{method=%s,route=%s,status=\"%d\"} %d\n",
+			quote(key.method), quote(key.route), key.status, m.requests[key])
+	}
+
+	writeHistograms(w, "opds_http_request_duration_seconds", "HTTP request latency by route.", "route", m.durations)
+	writeHistograms(w, "opds_db_query_duration_seconds", "Database query latency by query.", "query", m.queries)
+
+	fmt.Fprintln(w, "# HELP opds_downloads_total Book downloads by format.")
+	fmt.Fprintln(w, "# TYPE opds_downloads_total counter")
+	for _, format := range sortedKeys(m.downloads) {
+		fmt.Fprintf(w, "opds_downloads_total{format=%s} %d\n", quote(format), m.downloads[format])
+	}
+
+	if m.cacheStats != nil {
+		writeCacheStats(w, m.cacheStats())
+	}
+}
+
+// writeCacheStats 输出各缓存的命中、未命中次数和条目数
+func writeCacheStats(w io.Writer, stats []CacheStats) {
+	fmt.Fprintln(w, "# HELP opds_cache_hits_total Cache hits by cache.")
+	fmt.Fprintln(w, "# TYPE opds_cache_hits_total counter")
+	for _, s := range stats {
+		fmt.Fprintf(w, "opds_cache_hits_total{cache=%s} %d\n", quote(s.Name), s.Hits)
+	}
+	fmt.Fprintln(w, "# HELP opds_cache_misses_total Cache misses by cache.")
+	fmt.Fprintln(w, "# TYPE opds_cache_misses_total counter")
+	for _, s := range stats {
+		fmt.Fprintf(w, "opds_cache_misses_total{cache=%s} %d\n", quote(s.Name), s.Misses)
+	}
+	fmt.Fprintln(w, "# HELP opds_cache_entries Entries currently held by cache.")
+	fmt.Fprintln(w, "# TYPE opds_cache_entries gauge")
+	for _, s := range stats {
+		fmt.Fprintf(w, "opds_cache_entries{cache=%s} %d\n", quote(s.Name), s.Entries)
+	}
+}
+
+// writeHistograms 输出一组以label区分的直方图
+func writeHistograms(w io.Writer, name, help, label string, histograms map[string]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, key := range sortedKeys(histograms) {
+		h := histograms[key]
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s=%s,le=\"%s\"} %d\n", name, label, quote(key), formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s=%s,le=\"+Inf\"} %d\n", name, label, quote(key), h.count)
+		fmt.Fprintf(w, "%s_sum{%s=%s} %s\n", name, label, quote(key), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count{%s=%s} %d\n", name, label, quote(key), h.count)
+	}
+}
+
+// writePoolStats 输出数据库连接池指标
+func writePoolStats(w io.Writer, stats sql.DBStats) {
+	gauges := []struct {
+		name, help string
+		value      int
+	}{
+		{"opds_db_connections_open", "Open database connections.", stats.OpenConnections},
+		{"opds_db_connections_in_use", "Database connections currently in use.", stats.InUse},
+		{"opds_db_connections_idle", "Idle database connections.", stats.Idle},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.name, g.help, g.name, g.name, g.value)
+	}
+
+	fmt.Fprintln(w, "# HELP opds_db_wait_count_total Connections waited for because the pool was exhausted.")
+	fmt.Fprintln(w, "# TYPE opds_db_wait_count_total counter")
+	fmt.Fprintf(w, "opds_db_wait_count_total %d\n", stats.WaitCount)
+	fmt.Fprintln(w, "# HELP opds_db_wait_duration_seconds_total Time spent waiting for a database connection.")
+	fmt.Fprintln(w, "# TYPE opds_db_wait_duration_seconds_total counter")
+	fmt.Fprintf(w, "opds_db_wait_duration_seconds_total %s\n", formatFloat(stats.WaitDuration.Seconds()))
+}
+
+// sortedKeys 返回按字典序排列的键
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelEscaper 转义标签值中的反斜杠、双引号和换行
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote 生成带引号的标签值
+func quote(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
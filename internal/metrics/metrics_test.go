@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryHistogram(t *testing.T) {
+	m := New()
+	m.ObserveQuery("books_filtered", 3*time.Millisecond)
+	m.ObserveQuery("books_filtered", 200*time.Millisecond)
+	m.ObserveQuery("book_detail", time.Millisecond)
+
+	var sb strings.Builder
+	m.write(&sb)
+	out := sb.String()
+
+	for _, want := range []string{
+		"# TYPE opds_db_query_duration_seconds histogram",
+		`opds_db_query_duration_seconds_bucket{query="books_filtered",le="0.005"} 1`,
+		`opds_db_query_duration_seconds_bucket{query="books_filtered",le="0.25"} 2`,
+		`opds_db_query_duration_seconds_bucket{query="books_filtered",le="+Inf"} 2`,
+		`opds_db_query_duration_seconds_count{query="book_detail"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	m := New()
+	m.SetCacheStats(func() []CacheStats {
+		return []CacheStats{{Name: "opf", Hits: 3, Misses: 1, Entries: 2}}
+	})
+
+	var sb strings.Builder
+	m.write(&sb)
+	out := sb.String()
+
+	for _, want := range []string{
+		"# TYPE opds_cache_hits_total counter",
+		`opds_cache_hits_total{cache="opf"} 3`,
+		`opds_cache_misses_total{cache="opf"} 1`,
+		`opds_cache_entries{cache="opf"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
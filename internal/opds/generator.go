@@ -3,57 +3,166 @@ package opds
 import (
 	"encoding/xml"
 	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ricci/calibre-opds-go/internal/database"
+	"github.com/ricci/calibre-opds-go/internal/encoding"
 )
 
 // Feed OPDS feed结构
 type Feed struct {
-	XMLName xml.Name `xml:"feed"`
-	Xmlns   string   `xml:"xmlns,attr"`
-	XmlnsOPDS string `xml:"xmlns:opds,attr"`
-	
-	Title   string    `xml:"title"`
-	ID      string    `xml:"id"`
-	Updated string    `xml:"updated"`
-	
-	Links   []Link    `xml:"link"`
-	Entries []Entry   `xml:"entry"`
-	
+	XMLName     xml.Name `xml:"feed"`
+	Xmlns       string   `xml:"xmlns,attr"`
+	XmlnsOPDS   string   `xml:"xmlns:opds,attr"`
+	XmlnsDC     string   `xml:"xmlns:dcterms,attr"`
+	XmlnsDCE    string   `xml:"xmlns:dc,attr"`
+	XmlnsSchema string   `xml:"xmlns:schema,attr"`
+
+	Title   string `xml:"title"`
+	ID      string `xml:"id"`
+	Updated string `xml:"updated"`
+
+	Links   []Link  `xml:"link"`
+	Entries []Entry `xml:"entry"`
+
 	// 分页信息
-	TotalResults  *int `xml:"opds:totalResults,omitempty"`
-	StartIndex    *int `xml:"opds:startIndex,omitempty"`
-	ItemsPerPage  *int `xml:"opds:itemsPerPage,omitempty"`
+	TotalResults *int `xml:"opds:totalResults,omitempty"`
+	StartIndex   *int `xml:"opds:startIndex,omitempty"`
+	ItemsPerPage *int `xml:"opds:itemsPerPage,omitempty"`
 }
 
 // Entry OPDS条目
 type Entry struct {
-	Title   string   `xml:"title"`
-	ID      string   `xml:"id"`
-	Updated string   `xml:"updated,omitempty"`
-	Summary string   `xml:"summary,omitempty"`
-	Authors []Author `xml:"author,omitempty"`
-	Links   []Link   `xml:"link"`
+	Title       string     `xml:"title"`
+	ID          string     `xml:"id"`
+	Updated     string     `xml:"updated,omitempty"`
+	Summary     string     `xml:"summary,omitempty"`
+	Authors     []Author   `xml:"author,omitempty"`
+	Extent      string     `xml:"dcterms:extent,omitempty"`
+	Rating      string     `xml:"schema:rating,omitempty"` // 星级（0-5，可为半星）
+	Languages   []string   `xml:"dc:language,omitempty"`
+	Identifiers []string   `xml:"dc:identifier,omitempty"` // ISBN输出为urn:isbn:，其他来源输出为对应网站的URL
+	Categories  []Category `xml:"category,omitempty"`
+	Content     *Content   `xml:"content,omitempty"`
+	Links       []Link     `xml:"link"`
+}
+
+// Category 条目分类
+type Category struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+// Content 条目内容，type为xhtml时包含一个XHTML的div
+type Content struct {
+	Type string    `xml:"type,attr"`
+	Div  *XHTMLDiv `xml:",omitempty"`
+}
+
+// XHTMLDiv 条目内容中的XHTML块
+type XHTMLDiv struct {
+	XMLName   xml.Name        `xml:"http://www.w3.org/1999/xhtml div"`
+	Image     *XHTMLImage     `xml:"img,omitempty"`
+	Paragraph string          `xml:"p,omitempty"`
+	Downloads []XHTMLListItem `xml:"ul>li"`
 }
 
-// Author 作者
+// XHTMLListItem XHTML列表项
+type XHTMLListItem struct {
+	Anchor XHTMLAnchor `xml:"a"`
+}
+
+// XHTMLImage XHTML图片
+type XHTMLImage struct {
+	Src string `xml:"src,attr"`
+	Alt string `xml:"alt,attr"`
+}
+
+// XHTMLAnchor XHTML链接
+type XHTMLAnchor struct {
+	Href string `xml:"href,attr"`
+	Text string `xml:",chardata"`
+}
+
+// Author 作者，name始终为显示名；Atom的name元素不允许扩展属性，排序名只在OPDS-JSON中以sortAs输出
 type Author struct {
-	Name string `xml:"name"`
+	Name   string `xml:"name"`
+	SortAs string `xml:"-"` // Calibre的排序名，与显示名相同时为空
+}
+
+// etAlAuthor 作者数超过上限时代替其余作者的名称
+const etAlAuthor = "et al."
+
+// NewAuthor 创建作者，显示使用name，sort仅作为排序提示
+func NewAuthor(name, sort string) Author {
+	author := Author{Name: name}
+	if sort != "" && sort != name {
+		author.SortAs = sort
+	}
+	return author
 }
 
 // Link 链接
 type Link struct {
-	Rel   string `xml:"rel,attr"`
-	Href  string `xml:"href,attr"`
-	Type  string `xml:"type,attr"`
-	Title string `xml:"title,attr,omitempty"`
+	Rel    string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr"`
+	Title  string `xml:"title,attr,omitempty"`
 	Length string `xml:"length,attr,omitempty"`
+
+	// 分面导航属性，仅用于rel为http://opds-spec.org/facet的链接
+	FacetGroup  string `xml:"opds:facetGroup,attr,omitempty"`
+	ActiveFacet string `xml:"opds:activeFacet,attr,omitempty"`
+
+	IndirectAcquisitions []IndirectAcquisition `xml:"opds:indirectAcquisition,omitempty"`
+}
+
+// IndirectAcquisition 间接获取，描述打包文件中实际包含的内容类型
+type IndirectAcquisition struct {
+	Type string `xml:"type,attr"`
 }
 
 // Generator OPDS生成器
 type Generator struct {
 	BaseURL string
+
+	// CoverType 返回书籍封面的MIME类型，为空时默认为image/jpeg
+	CoverType func(book *database.Book) string
+
+	// ExtraAcquisitionRels 下载链接额外输出的rel，每个rel生成一个相同地址的链接
+	ExtraAcquisitionRels []string
+
+	// IncludeContent 为书籍条目生成包含封面、简介和下载链接的XHTML内容块
+	IncludeContent bool
+
+	// NewSince 添加时间晚于该时间的书籍条目输出term为new的分类，为零值时不输出
+	NewSince time.Time
+
+	// Updated 没有带更新时间的条目（如导航feed）时feed使用的更新时间，为零值时使用当前时间
+	Updated time.Time
+
+	// lastUpdated 最近一次CreateFeed生成的feed的更新时间
+	lastUpdated time.Time
+
+	// lastFeed 最近一次CreateFeed生成的feed，用于FeedJSON输出同一内容的OPDS 2.0版本
+	lastFeed *Feed
+
+	// MaxAuthors 每个条目最多输出的作者数，超出部分以一个et al.作者代替，0表示不限制
+	MaxAuthors int
+
+	// Minimal 精简书籍条目，省略简介、内容块、大小汇总和额外rel的下载链接，用于减小列表feed的体积
+	Minimal bool
+
+	// CatalogAcquisition 下载链接使用OPDS目录下的/opds/book/:id/acquire/:format，由服务端重定向到/download
+	CatalogAcquisition bool
+
+	// Language 下载链接标题的语言，见downloadLabels，为空或不支持时使用中文
+	Language string
 }
 
 // NewGenerator 创建OPDS生成器
@@ -65,14 +174,33 @@ func NewGenerator(baseURL string) *Generator {
 
 // CreateFeed 创建OPDS feed
 func (g *Generator) CreateFeed(title string, entries []Entry, links []Link, feedInfo *FeedInfo) ([]byte, error) {
+	feed := g.newFeed(title, g.feedUpdated(entries), links, feedInfo)
+	// 复制条目，补充updated时不修改调用方的切片
+	feed.Entries = append([]Entry(nil), entries...)
+
+	// Atom要求每个条目都有updated，导航条目没有自身的时间，使用feed的更新时间
+	for i := range feed.Entries {
+		if feed.Entries[i].Updated == "" {
+			feed.Entries[i].Updated = feed.Updated
+		}
+	}
+
+	g.lastFeed = &feed
+	return xml.MarshalIndent(feed, "", "  ")
+}
+
+// newFeed 创建不含条目的feed
+func (g *Generator) newFeed(title string, updated time.Time, links []Link, feedInfo *FeedInfo) Feed {
 	feed := Feed{
-		Xmlns:     "http://www.w3.org/2005/Atom",
-		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
-		Title:     title,
-		ID:        fmt.Sprintf("urn:uuid:%s", generateUUID()),
-		Updated:   time.Now().UTC().Format(time.RFC3339),
-		Links:     links,
-		Entries:   entries,
+		Xmlns:       "http://www.w3.org/2005/Atom",
+		XmlnsOPDS:   "http://opds-spec.org/2010/catalog",
+		XmlnsDC:     "http://purl.org/dc/terms/",
+		XmlnsDCE:    "http://purl.org/dc/elements/1.1/",
+		XmlnsSchema: "http://schema.org/",
+		Title:       title,
+		ID:          g.feedID(links),
+		Updated:     updated.Format(time.RFC3339),
+		Links:       links,
 	}
 
 	if feedInfo != nil {
@@ -86,28 +214,86 @@ func (g *Generator) CreateFeed(title string, entries []Entry, links []Link, feed
 			feed.ItemsPerPage = &feedInfo.ItemsPerPage
 		}
 	}
+	return feed
+}
 
-	return xml.MarshalIndent(feed, "", "  ")
+// LastUpdated 返回最近一次CreateFeed生成的feed的更新时间，用于Last-Modified响应头
+func (g *Generator) LastUpdated() time.Time {
+	return g.lastUpdated
+}
+
+// feedUpdated 返回feed的更新时间：条目中最新的更新时间，没有时使用Updated，
+// 保证内容不变时feed完全相同，ETag不会随每次请求变化
+func (g *Generator) feedUpdated(entries []Entry) time.Time {
+	latest := ""
+	for _, entry := range entries {
+		// 条目时间统一为UTC的RFC3339格式，可以直接按字符串比较
+		if entry.Updated > latest {
+			latest = entry.Updated
+		}
+	}
+
+	updated := g.Updated
+	if t, err := time.Parse(time.RFC3339, latest); err == nil {
+		updated = t
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	g.lastUpdated = updated.UTC().Truncate(time.Second)
+	return g.lastUpdated
 }
 
 // CreateBookEntry 创建书籍条目
 func (g *Generator) CreateBookEntry(book *database.Book) Entry {
 	entry := Entry{
-		Title:   book.Title,
-		ID:      fmt.Sprintf("urn:uuid:%s", book.UUID),
-		Summary: book.Comments,
+		Title: book.Title,
+		ID:    bookEntryID(book),
+	}
+	if !book.LastModified.IsZero() {
+		entry.Updated = book.LastModified.UTC().Format(time.RFC3339)
+	}
+	if !g.Minimal {
+		entry.Summary = book.Comments
 	}
 
-	// 添加作者
-	for _, author := range book.Authors {
-		entry.Authors = append(entry.Authors, Author{Name: author.Name})
+	// 添加作者，显示名和排序名分开输出，不能用author_sort代替显示名
+	authors := book.Authors
+	if g.MaxAuthors > 0 && len(authors) > g.MaxAuthors {
+		authors = authors[:g.MaxAuthors]
+	}
+	for _, author := range authors {
+		entry.Authors = append(entry.Authors, NewAuthor(author.Name, author.Sort))
+	}
+	if len(authors) < len(book.Authors) {
+		entry.Authors = append(entry.Authors, NewAuthor(etAlAuthor, ""))
+	}
+
+	entry.Languages = book.Languages
+	if !g.Minimal {
+		entry.Identifiers = identifierURIs(book)
+	}
+
+	// Calibre评分为0-10，输出为星级；没有评分时不输出
+	if book.Rating != nil {
+		entry.Rating = strconv.FormatFloat(float64(*book.Rating)/2, 'f', -1, 64)
 	}
 
 	// 添加封面链接
 	if book.HasCover {
+		coverType := "image/jpeg"
+		if g.CoverType != nil {
+			coverType = g.CoverType(book)
+		}
 		entry.Links = append(entry.Links, Link{
 			Rel:  "http://opds-spec.org/image",
-			Href: fmt.Sprintf("%s/opds/cover/%d", g.BaseURL, book.ID),
+			Href: CoverURL(g.BaseURL, book),
+			Type: coverType,
+		})
+		// 缩略图由服务端缩放后统一编码为JPEG，与原图格式无关
+		entry.Links = append(entry.Links, Link{
+			Rel:  "http://opds-spec.org/image/thumbnail",
+			Href: ThumbnailURL(g.BaseURL, book),
 			Type: "image/jpeg",
 		})
 	}
@@ -119,23 +305,157 @@ func (g *Generator) CreateBookEntry(book *database.Book) Entry {
 			rel = "http://opds-spec.org/acquisition/open-access"
 		}
 
-		entry.Links = append(entry.Links, Link{
+		link := Link{
 			Rel:    rel,
-			Href:   fmt.Sprintf("%s/download/%d/%s", g.BaseURL, book.ID, format.Format),
+			Href:   g.acquisitionURL(book.ID, format.Format),
 			Type:   GetMimeType(format.Format),
-			Title:  fmt.Sprintf("下载 %s", format.Format),
+			Title:  acquisitionTitle(g.downloadLabel(), format),
 			Length: fmt.Sprintf("%d", format.Size),
-		})
+		}
+		entry.Links = append(entry.Links, link)
+
+		if g.Minimal {
+			continue
+		}
+		for _, extraRel := range g.ExtraAcquisitionRels {
+			if extraRel == rel {
+				continue
+			}
+			link.Rel = extraRel
+			entry.Links = append(entry.Links, link)
+		}
+	}
+
+	if !g.Minimal {
+		entry.Extent = formatsExtent(book.Formats)
+	}
+
+	if !g.NewSince.IsZero() && book.Timestamp.After(g.NewSince) {
+		entry.Categories = append(entry.Categories, Category{Term: "new", Label: "新书"})
+	}
+
+	if g.IncludeContent && !g.Minimal {
+		entry.Content = g.createBookContent(book)
 	}
 
 	return entry
 }
 
-// CreateNavigationEntry 创建导航条目
+// CoverURL 返回封面地址，带上书籍修改时间作为版本号，封面更新后地址随之变化
+func CoverURL(baseURL string, book *database.Book) string {
+	return fmt.Sprintf("%s/opds/cover/%d?v=%d", baseURL, book.ID, book.LastModified.Unix())
+}
+
+// acquisitionURL 书籍某个格式的下载地址
+func (g *Generator) acquisitionURL(bookID int, format string) string {
+	if g.CatalogAcquisition {
+		return fmt.Sprintf("%s/opds/book/%d/acquire/%s", g.BaseURL, bookID, format)
+	}
+	return fmt.Sprintf("%s/download/%d/%s", g.BaseURL, bookID, format)
+}
+
+// acquisitionTitle 下载链接的标题，包含格式和便于阅读的大小，如 "下载 EPUB · 2.3 MB"
+func acquisitionTitle(download string, format database.Format) string {
+	if format.Size <= 0 {
+		return fmt.Sprintf("%s %s", download, format.Format)
+	}
+	return fmt.Sprintf("%s %s · %s", download, format.Format, FormatSize(format.Size))
+}
+
+// downloadLabels 各语言中下载链接使用的“下载”一词
+var downloadLabels = map[string]string{
+	"zh": "下载",
+	"en": "Download",
+}
+
+// downloadLabel 返回生成器语言对应的“下载”一词
+func (g *Generator) downloadLabel() string {
+	if label, ok := downloadLabels[g.Language]; ok {
+		return label
+	}
+	return downloadLabels["zh"]
+}
+
+// formatsExtent 汇总各格式的文件大小，如 "EPUB 1.2 MB, PDF 3.4 MB"
+func formatsExtent(formats []database.Format) string {
+	parts := make([]string, 0, len(formats))
+	for _, format := range formats {
+		parts = append(parts, fmt.Sprintf("%s %s", format.Format, FormatSize(format.Size)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatSize 将字节数格式化为便于阅读的大小
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit && exp < 3; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGT"[exp])
+}
+
+// contentSummaryLength 内容块中简介的最大字符数
+const contentSummaryLength = 300
+
+// createBookContent 创建包含封面缩略图、简介摘要和下载链接的XHTML内容块
+func (g *Generator) createBookContent(book *database.Book) *Content {
+	div := &XHTMLDiv{}
+
+	if book.HasCover {
+		div.Image = &XHTMLImage{
+			Src: CoverURL(g.BaseURL, book),
+			Alt: book.Title,
+		}
+	}
+
+	summary := []rune(encoding.HTMLToText(book.Comments))
+	if len(summary) > contentSummaryLength {
+		summary = append(summary[:contentSummaryLength], '…')
+	}
+	div.Paragraph = string(summary)
+
+	for _, format := range book.Formats {
+		div.Downloads = append(div.Downloads, XHTMLListItem{Anchor: XHTMLAnchor{
+			Href: g.acquisitionURL(book.ID, format.Format),
+			Text: fmt.Sprintf("%s %s", g.downloadLabel(), format.Format),
+		}})
+	}
+
+	return &Content{Type: "xhtml", Div: div}
+}
+
+// CreateSeriesBundleEntry 创建整个系列打包下载的条目，contentTypes为包内书籍文件的MIME类型
+func (g *Generator) CreateSeriesBundleEntry(seriesName string, bookCount int, contentTypes []string) Entry {
+	link := Link{
+		Rel:   "http://opds-spec.org/acquisition",
+		Href:  fmt.Sprintf("%s/download/series/%s.zip", g.BaseURL, url.PathEscape(seriesName)),
+		Type:  "application/zip",
+		Title: "下载整个系列 (ZIP)",
+	}
+	for _, contentType := range contentTypes {
+		link.IndirectAcquisitions = append(link.IndirectAcquisitions, IndirectAcquisition{Type: contentType})
+	}
+
+	return Entry{
+		Title:   fmt.Sprintf("%s - 整个系列", seriesName),
+		ID:      fmt.Sprintf("urn:calibre:series:%s", url.PathEscape(seriesName)),
+		Summary: fmt.Sprintf("按序号打包下载系列中的 %d 本书", bookCount),
+		Links:   []Link{link},
+	}
+}
+
+// CreateNavigationEntry 创建导航条目，href可以是相对地址或带BaseURL的完整地址；
+// 条目ID与feedID一样由去掉BaseURL的地址生成，不随访问域名变化
 func (g *Generator) CreateNavigationEntry(title, href, description string) Entry {
+	href = strings.TrimPrefix(href, g.BaseURL)
 	return Entry{
 		Title:   title,
-		ID:      fmt.Sprintf("urn:uuid:%d", hashString(title)),
+		ID:      "urn:uuid:" + uuidV5(href),
 		Summary: description,
 		Links: []Link{
 			{
@@ -147,42 +467,135 @@ func (g *Generator) CreateNavigationEntry(title, href, description string) Entry
 	}
 }
 
+// identifierSchemes 常见标识符类型对应的URI前缀
+var identifierSchemes = map[string]string{
+	"isbn":      "urn:isbn:",
+	"issn":      "urn:issn:",
+	"doi":       "https://doi.org/",
+	"amazon":    "https://www.amazon.com/dp/",
+	"goodreads": "https://www.goodreads.com/book/show/",
+	"google":    "https://books.google.com/books?id=",
+	"douban":    "https://book.douban.com/subject/",
+}
+
+// identifierURIs 按类型排序生成书籍标识符；identifiers表中没有isbn时使用books表的ISBN列，
+// 未知类型输出为"类型:值"
+func identifierURIs(book *database.Book) []string {
+	identifiers := make(map[string]string, len(book.Identifiers)+1)
+	for idType, value := range book.Identifiers {
+		if value = strings.TrimSpace(value); value != "" {
+			identifiers[strings.ToLower(idType)] = value
+		}
+	}
+	if _, ok := identifiers["isbn"]; !ok && book.ISBN != nil && strings.TrimSpace(*book.ISBN) != "" {
+		identifiers["isbn"] = strings.TrimSpace(*book.ISBN)
+	}
+
+	types := make([]string, 0, len(identifiers))
+	for idType := range identifiers {
+		types = append(types, idType)
+	}
+	sort.Strings(types)
+
+	uris := make([]string, 0, len(types))
+	for _, idType := range types {
+		value := identifiers[idType]
+		if prefix, ok := identifierSchemes[idType]; ok {
+			if strings.HasPrefix(prefix, "https://") {
+				// 转义空格等字符，保留DOI中的斜杠
+				value = (&url.URL{Path: value}).EscapedPath()
+			}
+			uris = append(uris, prefix+value)
+		} else {
+			uris = append(uris, idType+":"+value)
+		}
+	}
+	return uris
+}
+
 // FeedInfo feed信息
 type FeedInfo struct {
-	TotalResults  int
-	StartIndex    int
-	ItemsPerPage  int
+	TotalResults int
+	StartIndex   int
+	ItemsPerPage int
+}
+
+// formatInfo 书籍格式的MIME类型和文件扩展名
+type formatInfo struct {
+	mimeType  string
+	extension string
+}
+
+// formats 支持的书籍格式，下载和feed中的MIME类型及文件扩展名都以此为准
+var formats = map[string]formatInfo{
+	"EPUB":  {"application/epub+zip", ".epub"},
+	"PDF":   {"application/pdf", ".pdf"},
+	"MOBI":  {"application/x-mobipocket-ebook", ".mobi"},
+	"AZW3":  {"application/vnd.amazon.ebook", ".azw3"},
+	"FB2":   {"application/x-fictionbook+xml", ".fb2"},
+	"FBZ":   {"application/x-zip-compressed-fb2", ".fbz"},
+	"RTF":   {"application/rtf", ".rtf"},
+	"TXT":   {"text/plain", ".txt"},
+	"HTML":  {"text/html", ".html"},
+	"LIT":   {"application/x-ms-reader", ".lit"},
+	"KEPUB": {"application/kepub+zip", ".kepub.epub"},
+	"CBZ":   {"application/vnd.comicbook+zip", ".cbz"},
+	"CBR":   {"application/vnd.comicbook-rar", ".cbr"},
+	"CB7":   {"application/x-cb7", ".cb7"},
+	"DJVU":  {"image/vnd.djvu", ".djvu"},
+	"DOCX":  {"application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx"},
+	"M4B":   {"audio/mp4", ".m4b"},
+	"M4A":   {"audio/mp4", ".m4a"},
+	"MP3":   {"audio/mpeg", ".mp3"},
 }
 
 // GetMimeType 获取MIME类型
 func GetMimeType(format string) string {
-	mimeTypes := map[string]string{
-		"EPUB": "application/epub+zip",
-		"PDF":  "application/pdf",
-		"MOBI": "application/x-mobipocket-ebook",
-		"AZW3": "application/vnd.amazon.ebook",
-		"FB2":  "application/x-fictionbook+xml",
-		"RTF":  "application/rtf",
-		"TXT":  "text/plain",
-		"HTML": "text/html",
-		"LIT":  "application/x-ms-reader",
-	}
-
-	if mime, ok := mimeTypes[format]; ok {
-		return mime
+	if info, ok := formats[format]; ok {
+		return info.mimeType
 	}
 	return "application/octet-stream"
 }
 
-// 辅助函数
-func generateUUID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
+// FileExtension 获取格式对应的文件扩展名（不区分大小写），未知格式返回空字符串
+func FileExtension(format string) string {
+	return formats[strings.ToUpper(format)].extension
+}
+
+// IsKnownFormat 判断是否为已知的书籍格式（不区分大小写）
+func IsKnownFormat(format string) bool {
+	_, ok := formats[strings.ToUpper(format)]
+	return ok
 }
 
-func hashString(s string) int {
-	h := 0
-	for _, c := range s {
-		h = 31*h + int(c)
+// uuidPattern 合法的UUID格式
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// 缩略图的最大尺寸
+const (
+	ThumbnailWidth  = 200
+	ThumbnailHeight = 300
+)
+
+// ThumbnailURL 返回封面缩略图地址，缩略图由服务端缩放为JPEG
+func ThumbnailURL(baseURL string, book *database.Book) string {
+	return fmt.Sprintf("%s&width=%d&height=%d", CoverURL(baseURL, book), ThumbnailWidth, ThumbnailHeight)
+}
+
+// bookEntryID 返回书籍条目的ID，UUID为空或不合法时回退为基于书籍ID的URN
+func bookEntryID(book *database.Book) string {
+	if uuidPattern.MatchString(book.UUID) {
+		return fmt.Sprintf("urn:uuid:%s", strings.ToLower(book.UUID))
+	}
+	return fmt.Sprintf("urn:calibre:book:%d", book.ID)
+}
+
+// feedID 返回feed的ID：有self链接时由其地址（去掉基础URL）生成稳定的UUID，否则生成随机UUID
+func (g *Generator) feedID(links []Link) string {
+	for _, link := range links {
+		if link.Rel == "self" {
+			return "urn:uuid:" + uuidV5(strings.TrimPrefix(link.Href, g.BaseURL))
+		}
 	}
-	return h
+	return "urn:uuid:" + newUUIDv4()
 }
@@ -0,0 +1,268 @@
+package opds
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ricci/calibre-opds-go/internal/database"
+)
+
+func TestCreateFeedDoesNotModifyEntries(t *testing.T) {
+	g := NewGenerator("http://example.com")
+	g.Updated = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []Entry{g.CreateNavigationEntry("书籍", "/opds/books", "")}
+
+	if _, err := g.CreateFeed("根目录", entries, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].Updated != "" {
+		t.Errorf("caller's entry was modified: Updated = %q", entries[0].Updated)
+	}
+	if got := g.lastFeed.Entries[0].Updated; got != "2024-01-02T03:04:05Z" {
+		t.Errorf("feed entry Updated = %q, want the feed's updated time", got)
+	}
+}
+
+func TestAuthorDisplayAndSortNames(t *testing.T) {
+	g := NewGenerator("http://example.com")
+	book := &database.Book{
+		ID:    1,
+		Title: "Dune",
+		Authors: []database.Author{
+			{Name: "Frank Herbert", Sort: "Herbert, Frank"},
+			{Name: "Plato", Sort: "Plato"},
+		},
+		Formats: []database.Format{{Format: "EPUB", Size: 1024}},
+	}
+	data, err := g.CreateFeed("Books", []Entry{g.CreateBookEntry(book)}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Atom的name只包含显示名，不带扩展属性
+	xmlText := string(data)
+	if !strings.Contains(xmlText, "<name>Frank Herbert</name>") || strings.Contains(xmlText, "Herbert, Frank") {
+		t.Errorf("Atom author names:\n%s", xmlText)
+	}
+
+	// OPDS-JSON以sortAs输出不同于显示名的排序名
+	data, err = g.FeedJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var feed struct {
+		Publications []struct {
+			Metadata struct {
+				Author []jsonContributor `json:"author"`
+			} `json:"metadata"`
+		} `json:"publications"`
+	}
+	if err := json.Unmarshal(data, &feed); err != nil {
+		t.Fatal(err)
+	}
+	want := []jsonContributor{{Name: "Frank Herbert", SortAs: "Herbert, Frank"}, {Name: "Plato"}}
+	if got := feed.Publications[0].Metadata.Author; !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON authors = %+v, want %+v", got, want)
+	}
+}
+
+func TestNavigationEntryIDIgnoresBaseURL(t *testing.T) {
+	local := NewGenerator("http://localhost:1580")
+	public := NewGenerator("https://books.example.com/library")
+
+	want := local.CreateNavigationEntry("作者", "/opds/authors", "")
+	for _, href := range []string{"/opds/authors", "https://books.example.com/library/opds/authors"} {
+		entry := public.CreateNavigationEntry("作者", href, "")
+		if entry.ID != want.ID {
+			t.Errorf("href %q: ID = %q, want %q", href, entry.ID, want.ID)
+		}
+		if got := entry.Links[0].Href; got != "https://books.example.com/library/opds/authors" {
+			t.Errorf("href %q: link = %q", href, got)
+		}
+	}
+}
+
+func TestAcquisitionLinkTitle(t *testing.T) {
+	tests := []struct {
+		language string
+		format   database.Format
+		want     string
+	}{
+		{"", database.Format{Format: "EPUB", Size: 2411725}, "下载 EPUB · 2.3 MB"},
+		{"zh", database.Format{Format: "PDF", Size: 512}, "下载 PDF · 512 B"},
+		{"zh", database.Format{Format: "MOBI", Size: 1536}, "下载 MOBI · 1.5 KB"},
+		{"zh", database.Format{Format: "AZW3", Size: 3 << 30}, "下载 AZW3 · 3.0 GB"},
+		{"zh", database.Format{Format: "TXT"}, "下载 TXT"},
+		{"en", database.Format{Format: "EPUB", Size: 2411725}, "Download EPUB · 2.3 MB"},
+		{"fr", database.Format{Format: "EPUB", Size: 1024}, "下载 EPUB · 1.0 KB"},
+	}
+	for _, tt := range tests {
+		g := NewGenerator("http://example.com")
+		g.Language = tt.language
+		entry := g.CreateBookEntry(&database.Book{ID: 1, Title: "Dune", Formats: []database.Format{tt.format}})
+
+		var link *Link
+		for i := range entry.Links {
+			if strings.HasPrefix(entry.Links[i].Rel, "http://opds-spec.org/acquisition") {
+				link = &entry.Links[i]
+				break
+			}
+		}
+		if link == nil {
+			t.Fatalf("%s %s: missing acquisition link", tt.language, tt.format.Format)
+		}
+		if link.Title != tt.want {
+			t.Errorf("%s %s: title = %q, want %q", tt.language, tt.format.Format, link.Title, tt.want)
+		}
+		// length保留原始字节数
+		if want := strconv.FormatInt(tt.format.Size, 10); link.Length != want {
+			t.Errorf("%s %s: length = %q, want %q", tt.language, tt.format.Format, link.Length, want)
+		}
+	}
+}
+
+func TestExtraAcquisitionRels(t *testing.T) {
+	book := &database.Book{ID: 1, Title: "Dune", Formats: []database.Format{{Format: "EPUB"}, {Format: "PDF"}}}
+	relsOf := func(g *Generator) map[string][]string {
+		rels := map[string][]string{}
+		for _, link := range g.CreateBookEntry(book).Links {
+			rels[link.Type] = append(rels[link.Type], link.Rel)
+		}
+		return rels
+	}
+
+	g := NewGenerator("http://example.com")
+	g.ExtraAcquisitionRels = []string{"alternate", "http://opds-spec.org/acquisition"}
+	want := map[string][]string{
+		"application/epub+zip": {"http://opds-spec.org/acquisition/open-access", "alternate", "http://opds-spec.org/acquisition"},
+		// 与原rel相同的额外rel不重复输出
+		"application/pdf": {"http://opds-spec.org/acquisition", "alternate"},
+	}
+	if got := relsOf(g); !reflect.DeepEqual(got, want) {
+		t.Errorf("rels = %v, want %v", got, want)
+	}
+
+	// 精简条目不输出额外rel
+	g.Minimal = true
+	want = map[string][]string{
+		"application/epub+zip": {"http://opds-spec.org/acquisition/open-access"},
+		"application/pdf":      {"http://opds-spec.org/acquisition"},
+	}
+	if got := relsOf(g); !reflect.DeepEqual(got, want) {
+		t.Errorf("minimal rels = %v, want %v", got, want)
+	}
+}
+
+func TestBookEntryID(t *testing.T) {
+	tests := []struct {
+		uuid string
+		want string
+	}{
+		{"0B3F8D2E-1C4A-4F6B-9E7D-5A2C8B1D3E4F", "urn:uuid:0b3f8d2e-1c4a-4f6b-9e7d-5a2c8b1d3e4f"},
+		{"", "urn:calibre:book:42"},
+		{"not-a-uuid", "urn:calibre:book:42"},
+	}
+	g := NewGenerator("http://example.com")
+	for _, tt := range tests {
+		entry := g.CreateBookEntry(&database.Book{ID: 42, Title: "Dune", UUID: tt.uuid})
+		if entry.ID != tt.want {
+			t.Errorf("uuid %q: ID = %q, want %q", tt.uuid, entry.ID, tt.want)
+		}
+	}
+}
+
+func TestEntryContentIsXHTML(t *testing.T) {
+	g := NewGenerator("http://example.com")
+	g.IncludeContent = true
+	book := &database.Book{
+		ID:       1,
+		Title:    "Dune & <Sons>",
+		HasCover: true,
+		Comments: `<p>Spice <b>&amp;</b> sand</p><script>alert("x")</script>`,
+		Formats:  []database.Format{{Format: "EPUB"}, {Format: "PDF"}},
+	}
+	data, err := g.CreateFeed("Books", []Entry{g.CreateBookEntry(book)}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var feed struct {
+		Entries []struct {
+			Content struct {
+				Type  string `xml:"type,attr"`
+				Inner []byte `xml:",innerxml"`
+			} `xml:"content"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		t.Fatal(err)
+	}
+	content := feed.Entries[0].Content
+	if content.Type != "xhtml" {
+		t.Fatalf("content type = %q, want xhtml", content.Type)
+	}
+
+	// 内容块本身必须是带XHTML命名空间、格式正确的XML
+	var div struct {
+		XMLName xml.Name `xml:"div"`
+		Img     struct {
+			Src string `xml:"src,attr"`
+			Alt string `xml:"alt,attr"`
+		} `xml:"img"`
+		P     string `xml:"p"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Text string `xml:",chardata"`
+		} `xml:"ul>li>a"`
+	}
+	dec := xml.NewDecoder(bytes.NewReader(content.Inner))
+	dec.Strict = true
+	if err := dec.Decode(&div); err != nil {
+		t.Fatalf("content is not well-formed XML: %v\n%s", err, content.Inner)
+	}
+	if div.XMLName.Space != "http://www.w3.org/1999/xhtml" {
+		t.Errorf("div namespace = %q", div.XMLName.Space)
+	}
+	if div.Img.Alt != book.Title || !strings.HasPrefix(div.Img.Src, "http://example.com/opds/cover/1") {
+		t.Errorf("img = %+v", div.Img)
+	}
+	if strings.Contains(div.P, "<") || strings.Contains(div.P, "alert") || !strings.Contains(div.P, "Spice & sand") {
+		t.Errorf("paragraph = %q, want plain text summary", div.P)
+	}
+	if len(div.Links) != 2 || div.Links[0].Text != "下载 EPUB" || div.Links[1].Href != "http://example.com/download/1/PDF" {
+		t.Errorf("download links = %+v", div.Links)
+	}
+}
+
+func TestFormatsExtent(t *testing.T) {
+	book := &database.Book{ID: 1, Title: "Dune", Formats: []database.Format{
+		{Format: "EPUB", Size: 2411725},
+		{Format: "PDF", Size: 512},
+	}}
+	noFormats := &database.Book{ID: 2, Title: "Emma"}
+
+	g := NewGenerator("http://example.com")
+	data, err := g.CreateFeed("Books", []Entry{g.CreateBookEntry(book), g.CreateBookEntry(noFormats)}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	if !strings.Contains(out, `xmlns:dcterms="http://purl.org/dc/terms/"`) {
+		t.Errorf("dcterms namespace not declared:\n%s", out)
+	}
+	if strings.Count(out, "<dcterms:extent>") != 1 || !strings.Contains(out, "<dcterms:extent>EPUB 2.3 MB, PDF 512 B</dcterms:extent>") {
+		t.Errorf("want one extent for the book with formats:\n%s", out)
+	}
+
+	// 精简条目不输出大小汇总
+	g.Minimal = true
+	if entry := g.CreateBookEntry(book); entry.Extent != "" {
+		t.Errorf("minimal entry extent = %q", entry.Extent)
+	}
+}
@@ -0,0 +1,205 @@
+package opds
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// JSONMimeType OPDS 2.0 feed的响应类型
+const JSONMimeType = "application/opds+json"
+
+// jsonFeed OPDS 2.0 feed
+type jsonFeed struct {
+	Metadata     jsonFeedMetadata  `json:"metadata"`
+	Links        []jsonLink        `json:"links"`
+	Facets       []jsonFacet       `json:"facets,omitempty"`
+	Navigation   []jsonLink        `json:"navigation,omitempty"`
+	Publications []jsonPublication `json:"publications,omitempty"`
+}
+
+// jsonFeedMetadata feed元数据
+type jsonFeedMetadata struct {
+	Title         string `json:"title"`
+	Identifier    string `json:"identifier,omitempty"`
+	Modified      string `json:"modified,omitempty"`
+	NumberOfItems int    `json:"numberOfItems,omitempty"`
+	ItemsPerPage  int    `json:"itemsPerPage,omitempty"`
+}
+
+// jsonFacet 一组分面导航链接
+type jsonFacet struct {
+	Metadata struct {
+		Title string `json:"title"`
+	} `json:"metadata"`
+	Links []jsonLink `json:"links"`
+}
+
+// jsonLink OPDS 2.0链接
+type jsonLink struct {
+	Rel        string          `json:"rel,omitempty"`
+	Href       string          `json:"href"`
+	Type       string          `json:"type,omitempty"`
+	Title      string          `json:"title,omitempty"`
+	Properties *jsonProperties `json:"properties,omitempty"`
+}
+
+// jsonProperties 链接属性
+type jsonProperties struct {
+	IndirectAcquisition []jsonIndirect `json:"indirectAcquisition,omitempty"`
+}
+
+// jsonIndirect 间接获取的内容类型
+type jsonIndirect struct {
+	Type string `json:"type"`
+}
+
+// jsonPublication 书籍
+type jsonPublication struct {
+	Metadata jsonPublicationMetadata `json:"metadata"`
+	Links    []jsonLink              `json:"links"`
+	Images   []jsonLink              `json:"images,omitempty"`
+}
+
+// jsonPublicationMetadata 书籍元数据
+type jsonPublicationMetadata struct {
+	Type        string            `json:"@type"`
+	Title       string            `json:"title"`
+	Identifier  string            `json:"identifier,omitempty"`
+	Modified    string            `json:"modified,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Authors     []jsonContributor `json:"author,omitempty"`
+	Languages   []string          `json:"language,omitempty"`
+	Subjects    []string          `json:"subject,omitempty"`
+}
+
+// jsonContributor 作者，sortAs为Calibre的排序名
+type jsonContributor struct {
+	Name   string `json:"name"`
+	SortAs string `json:"sortAs,omitempty"`
+}
+
+// FeedJSON 把最近一次CreateFeed生成的feed输出为OPDS 2.0 JSON，内容与Atom版本一致：
+// 带下载链接的条目输出为publications，其余条目输出为navigation
+func (g *Generator) FeedJSON() ([]byte, error) {
+	if g.lastFeed == nil {
+		return nil, errors.New("opds: no feed created")
+	}
+	feed := g.lastFeed
+
+	out := jsonFeed{
+		Metadata: jsonFeedMetadata{
+			Title:      feed.Title,
+			Identifier: feed.ID,
+			Modified:   feed.Updated,
+		},
+		Links: []jsonLink{},
+	}
+	if feed.TotalResults != nil {
+		out.Metadata.NumberOfItems = *feed.TotalResults
+	}
+	if feed.ItemsPerPage != nil {
+		out.Metadata.ItemsPerPage = *feed.ItemsPerPage
+	}
+
+	for _, link := range feed.Links {
+		if link.Rel != "http://opds-spec.org/facet" {
+			out.Links = append(out.Links, newJSONLink(link))
+			continue
+		}
+		// 分面链接按facetGroup分组，保持首次出现的顺序
+		i := 0
+		for i < len(out.Facets) && out.Facets[i].Metadata.Title != link.FacetGroup {
+			i++
+		}
+		if i == len(out.Facets) {
+			var facet jsonFacet
+			facet.Metadata.Title = link.FacetGroup
+			out.Facets = append(out.Facets, facet)
+		}
+		jl := newJSONLink(link)
+		if link.ActiveFacet == "true" {
+			jl.Rel = "self"
+		} else {
+			jl.Rel = ""
+		}
+		out.Facets[i].Links = append(out.Facets[i].Links, jl)
+	}
+
+	for _, entry := range feed.Entries {
+		if !isPublication(entry) {
+			if len(entry.Links) > 0 {
+				nav := newJSONLink(entry.Links[0])
+				nav.Title = entry.Title
+				out.Navigation = append(out.Navigation, nav)
+			}
+			continue
+		}
+		out.Publications = append(out.Publications, newJSONPublication(entry))
+	}
+
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// isPublication 判断条目是否为书籍（带获取链接）
+func isPublication(entry Entry) bool {
+	for _, link := range entry.Links {
+		if strings.HasPrefix(link.Rel, "http://opds-spec.org/acquisition") {
+			return true
+		}
+	}
+	return false
+}
+
+// newJSONPublication 把书籍条目转换为OPDS 2.0 publication，封面链接输出为images
+func newJSONPublication(entry Entry) jsonPublication {
+	pub := jsonPublication{
+		Metadata: jsonPublicationMetadata{
+			Type:        "http://schema.org/Book",
+			Title:       entry.Title,
+			Identifier:  entry.ID,
+			Modified:    entry.Updated,
+			Description: entry.Summary,
+			Languages:   entry.Languages,
+		},
+		Links: []jsonLink{},
+	}
+	for _, author := range entry.Authors {
+		pub.Metadata.Authors = append(pub.Metadata.Authors, jsonContributor{
+			Name:   author.Name,
+			SortAs: author.SortAs,
+		})
+	}
+	for _, category := range entry.Categories {
+		if category.Label != "" {
+			pub.Metadata.Subjects = append(pub.Metadata.Subjects, category.Label)
+		} else {
+			pub.Metadata.Subjects = append(pub.Metadata.Subjects, category.Term)
+		}
+	}
+	for _, link := range entry.Links {
+		switch link.Rel {
+		case "http://opds-spec.org/image", "http://opds-spec.org/image/thumbnail":
+			pub.Images = append(pub.Images, jsonLink{Href: link.Href, Type: link.Type})
+		default:
+			pub.Links = append(pub.Links, newJSONLink(link))
+		}
+	}
+	return pub
+}
+
+// newJSONLink 转换链接；指向OPDS catalog feed的链接改为OPDS 2.0类型，
+// 同一地址按Accept请求头协商输出JSON
+func newJSONLink(link Link) jsonLink {
+	jl := jsonLink{Rel: link.Rel, Href: link.Href, Type: link.Type, Title: link.Title}
+	if strings.HasPrefix(link.Type, "application/atom+xml") && strings.Contains(link.Type, "profile=opds-catalog") {
+		jl.Type = JSONMimeType
+	}
+	if len(link.IndirectAcquisitions) > 0 {
+		jl.Properties = &jsonProperties{}
+		for _, indirect := range link.IndirectAcquisitions {
+			jl.Properties.IndirectAcquisition = append(jl.Properties.IndirectAcquisition, jsonIndirect{Type: indirect.Type})
+		}
+	}
+	return jl
+}
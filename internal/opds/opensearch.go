@@ -0,0 +1,46 @@
+package opds
+
+import "encoding/xml"
+
+// OpenSearchMimeType OpenSearch描述文档的MIME类型
+const OpenSearchMimeType = "application/opensearchdescription+xml"
+
+// OpenSearchDescription OpenSearch描述文档，阅读器据此提供搜索框
+type OpenSearchDescription struct {
+	XMLName        xml.Name        `xml:"OpenSearchDescription"`
+	Xmlns          string          `xml:"xmlns,attr"`
+	ShortName      string          `xml:"ShortName"`
+	Description    string          `xml:"Description"`
+	InputEncoding  string          `xml:"InputEncoding"`
+	OutputEncoding string          `xml:"OutputEncoding"`
+	URLs           []OpenSearchURL `xml:"Url"`
+}
+
+// OpenSearchURL 搜索地址模板，{searchTerms}由客户端替换为编码后的关键字
+type OpenSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// CreateOpenSearchDescription 创建指向书籍搜索的OpenSearch描述文档
+func (g *Generator) CreateOpenSearchDescription(shortName, description string) ([]byte, error) {
+	doc := OpenSearchDescription{
+		Xmlns:          "http://a9.com/-/spec/opensearch/1.1/",
+		ShortName:      shortName,
+		Description:    description,
+		InputEncoding:  "UTF-8",
+		OutputEncoding: "UTF-8",
+		URLs: []OpenSearchURL{
+			{
+				Type:     "application/atom+xml;type=feed;profile=opds-catalog",
+				Template: g.BaseURL + "/opds/books?search={searchTerms}",
+			},
+		},
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
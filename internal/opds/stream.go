@@ -0,0 +1,73 @@
+package opds
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// FeedWriter 逐条写出feed，用于条目很多、不宜整体放在内存中的feed
+type FeedWriter struct {
+	enc     *xml.Encoder
+	start   xml.StartElement
+	updated string
+}
+
+// NewFeedWriter 写出feed头部（标题、ID、链接和分页信息），之后通过WriteEntry逐条写出条目；
+// 条目数量事先未知，feed的更新时间使用Updated
+func (g *Generator) NewFeedWriter(w io.Writer, title string, links []Link, feedInfo *FeedInfo) (*FeedWriter, error) {
+	feed := g.newFeed(title, g.feedUpdated(nil), links, feedInfo)
+
+	fw := &FeedWriter{
+		enc: xml.NewEncoder(w),
+		start: xml.StartElement{
+			Name: xml.Name{Local: "feed"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "xmlns"}, Value: feed.Xmlns},
+				{Name: xml.Name{Local: "xmlns:opds"}, Value: feed.XmlnsOPDS},
+				{Name: xml.Name{Local: "xmlns:dcterms"}, Value: feed.XmlnsDC},
+				{Name: xml.Name{Local: "xmlns:dc"}, Value: feed.XmlnsDCE},
+				{Name: xml.Name{Local: "xmlns:schema"}, Value: feed.XmlnsSchema},
+			},
+		},
+		updated: feed.Updated,
+	}
+	fw.enc.Indent("", "  ")
+
+	if err := fw.enc.EncodeToken(fw.start); err != nil {
+		return nil, err
+	}
+	elements := []struct {
+		name  string
+		value interface{}
+	}{
+		{"title", feed.Title},
+		{"id", feed.ID},
+		{"updated", feed.Updated},
+		{"link", feed.Links},
+		{"opds:totalResults", feed.TotalResults},
+		{"opds:startIndex", feed.StartIndex},
+		{"opds:itemsPerPage", feed.ItemsPerPage},
+	}
+	for _, element := range elements {
+		if err := fw.enc.EncodeElement(element.value, xml.StartElement{Name: xml.Name{Local: element.name}}); err != nil {
+			return nil, err
+		}
+	}
+	return fw, nil
+}
+
+// WriteEntry 写出一个条目
+func (fw *FeedWriter) WriteEntry(entry Entry) error {
+	if entry.Updated == "" {
+		entry.Updated = fw.updated
+	}
+	return fw.enc.EncodeElement(entry, xml.StartElement{Name: xml.Name{Local: "entry"}})
+}
+
+// Close 结束feed并刷新缓冲区
+func (fw *FeedWriter) Close() error {
+	if err := fw.enc.EncodeToken(fw.start.End()); err != nil {
+		return err
+	}
+	return fw.enc.Flush()
+}
@@ -0,0 +1,36 @@
+package opds
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+)
+
+// urlNamespace RFC 4122中URL的命名空间，用于由地址生成v5 UUID
+var urlNamespace = [16]byte{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+// newUUIDv4 生成随机的RFC 4122 v4 UUID
+func newUUIDv4() string {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(err)
+	}
+	return formatUUID(u, 4)
+}
+
+// uuidV5 由地址生成稳定的RFC 4122 v5 UUID，相同地址总是得到相同结果
+func uuidV5(name string) string {
+	h := sha1.New()
+	h.Write(urlNamespace[:])
+	h.Write([]byte(name))
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	return formatUUID(u, 5)
+}
+
+// formatUUID 设置版本号和变体位后按标准格式输出
+func formatUUID(u [16]byte, version byte) string {
+	u[6] = u[6]&0x0f | version<<4
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
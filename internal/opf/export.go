@@ -0,0 +1,150 @@
+package opf
+
+import (
+	"encoding/xml"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ricci/calibre-opds-go/internal/database"
+)
+
+// exportPackage 导出的metadata.opf，结构与Calibre生成的文件一致
+type exportPackage struct {
+	XMLName          xml.Name       `xml:"package"`
+	Xmlns            string         `xml:"xmlns,attr"`
+	Version          string         `xml:"version,attr"`
+	UniqueIdentifier string         `xml:"unique-identifier,attr"`
+	Metadata         exportMetadata `xml:"metadata"`
+	Guide            *exportGuide   `xml:"guide,omitempty"`
+}
+
+// exportMetadata 导出的元数据，使用Dublin Core元素和Calibre的meta扩展
+type exportMetadata struct {
+	XmlnsDC     string             `xml:"xmlns:dc,attr"`
+	XmlnsOPF    string             `xml:"xmlns:opf,attr"`
+	Identifiers []exportIdentifier `xml:"dc:identifier"`
+	Title       string             `xml:"dc:title"`
+	Creators    []exportCreator    `xml:"dc:creator"`
+	Date        string             `xml:"dc:date,omitempty"`
+	Description string             `xml:"dc:description,omitempty"`
+	Subjects    []string           `xml:"dc:subject"`
+	Metas       []exportMeta       `xml:"meta"`
+}
+
+// exportIdentifier 导出的标识符
+type exportIdentifier struct {
+	ID     string `xml:"id,attr,omitempty"`
+	Scheme string `xml:"opf:scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// exportCreator 导出的作者，file-as为排序名
+type exportCreator struct {
+	FileAs string `xml:"opf:file-as,attr,omitempty"`
+	Role   string `xml:"opf:role,attr"`
+	Name   string `xml:",chardata"`
+}
+
+// exportMeta Calibre扩展元数据，如系列、评分
+type exportMeta struct {
+	Name    string `xml:"name,attr"`
+	Content string `xml:"content,attr"`
+}
+
+// exportGuide 导出的guide，指向书籍目录中的封面
+type exportGuide struct {
+	References []exportReference `xml:"reference"`
+}
+
+// exportReference guide中的引用
+type exportReference struct {
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr"`
+	Href  string `xml:"href,attr"`
+}
+
+// Export 根据数据库中的书籍信息生成Calibre兼容的metadata.opf，identifiers为identifiers表中的标识符，
+// coverFile为书籍目录中封面文件的文件名（如cover.png），为空时不输出封面引用
+func Export(book *database.Book, identifiers map[string]string, coverFile string) ([]byte, error) {
+	meta := exportMetadata{
+		XmlnsDC:  NamespaceDC,
+		XmlnsOPF: NamespaceOPF,
+		Title:    book.Title,
+		Subjects: book.Tags,
+	}
+
+	meta.Identifiers = append(meta.Identifiers, exportIdentifier{
+		ID: "calibre_id", Scheme: "calibre", Value: strconv.Itoa(book.ID),
+	})
+	if book.UUID != "" {
+		meta.Identifiers = append(meta.Identifiers, exportIdentifier{
+			ID: "uuid_id", Scheme: "uuid", Value: book.UUID,
+		})
+	}
+
+	// identifiers表中的isbn优先于books表的isbn列
+	schemes := make([]string, 0, len(identifiers))
+	for scheme := range identifiers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	hasISBN := false
+	for _, scheme := range schemes {
+		if strings.EqualFold(scheme, "isbn") {
+			hasISBN = true
+		}
+		meta.Identifiers = append(meta.Identifiers, exportIdentifier{
+			Scheme: strings.ToUpper(scheme), Value: identifiers[scheme],
+		})
+	}
+	if !hasISBN && book.ISBN != nil && *book.ISBN != "" {
+		meta.Identifiers = append(meta.Identifiers, exportIdentifier{Scheme: "ISBN", Value: *book.ISBN})
+	}
+
+	for _, author := range book.Authors {
+		meta.Creators = append(meta.Creators, exportCreator{FileAs: author.Sort, Role: "aut", Name: author.Name})
+	}
+
+	// Calibre用0101-01-01表示未知的出版日期
+	if book.PubDate != nil && *book.PubDate != "" && !strings.HasPrefix(*book.PubDate, "0") {
+		meta.Date = *book.PubDate
+	}
+	meta.Description = book.Comments
+
+	if book.Series != nil {
+		meta.Metas = append(meta.Metas, exportMeta{Name: "calibre:series", Content: book.Series.Name})
+		if book.SeriesIndex != nil {
+			meta.Metas = append(meta.Metas, exportMeta{
+				Name:    "calibre:series_index",
+				Content: strconv.FormatFloat(*book.SeriesIndex, 'f', -1, 64),
+			})
+		}
+	}
+	if book.Rating != nil {
+		meta.Metas = append(meta.Metas, exportMeta{Name: "calibre:rating", Content: strconv.Itoa(*book.Rating)})
+	}
+	if !book.Timestamp.IsZero() {
+		meta.Metas = append(meta.Metas, exportMeta{Name: "calibre:timestamp", Content: book.Timestamp.UTC().Format(time.RFC3339)})
+	}
+
+	pkg := exportPackage{
+		Xmlns:            NamespaceOPF,
+		Version:          "2.0",
+		UniqueIdentifier: "uuid_id",
+		Metadata:         meta,
+	}
+	if book.UUID == "" {
+		pkg.UniqueIdentifier = "calibre_id"
+	}
+	if book.HasCover && coverFile != "" {
+		pkg.Guide = &exportGuide{References: []exportReference{{Type: "cover", Title: "Cover", Href: coverFile}}}
+	}
+
+	data, err := xml.MarshalIndent(pkg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
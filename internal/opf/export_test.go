@@ -0,0 +1,163 @@
+package opf
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ricci/calibre-opds-go/internal/database"
+)
+
+func TestExportCoverReference(t *testing.T) {
+	tests := []struct {
+		name      string
+		hasCover  bool
+		coverFile string
+		want      string
+	}{
+		{"png cover", true, "cover.png", `href="cover.png"`},
+		{"jpg cover", true, "cover.jpg", `href="cover.jpg"`},
+		{"cover file missing", true, "", ""},
+		{"no cover", false, "cover.jpg", ""},
+	}
+	for _, tt := range tests {
+		book := &database.Book{ID: 1, Title: "Dune", UUID: "0b3f8d2e-1c4a-4f6b-9e7d-5a2c8b1d3e4f", HasCover: tt.hasCover}
+		data, err := Export(book, nil, tt.coverFile)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		out := string(data)
+		if tt.want == "" {
+			if strings.Contains(out, "<guide>") {
+				t.Errorf("%s: unexpected guide:\n%s", tt.name, out)
+			}
+			continue
+		}
+		if !strings.Contains(out, `type="cover"`) || !strings.Contains(out, tt.want) {
+			t.Errorf("%s: missing cover reference %s:\n%s", tt.name, tt.want, out)
+		}
+	}
+}
+
+func TestExportMetadata(t *testing.T) {
+	isbn := "9780441172719"
+	pubdate := "1965-08-01T00:00:00+00:00"
+	index := 1.5
+	rating := 8
+	book := &database.Book{
+		ID:        7,
+		Title:     "Dune & Sons",
+		UUID:      "0b3f8d2e-1c4a-4f6b-9e7d-5a2c8b1d3e4f",
+		ISBN:      &isbn,
+		PubDate:   &pubdate,
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Comments:  "<p>Spice</p>",
+		Rating:    &rating,
+		Authors: []database.Author{
+			{Name: "Frank Herbert", Sort: "Herbert, Frank"},
+			{Name: "Brian Herbert", Sort: "Herbert, Brian"},
+		},
+		Tags:        []string{"Science Fiction", "Classic"},
+		Series:      &database.Series{Name: "Dune"},
+		SeriesIndex: &index,
+	}
+	data, err := Export(book, map[string]string{"goodreads": "234225", "isbn": "0441172717"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pkg struct {
+		UniqueIdentifier string `xml:"unique-identifier,attr"`
+		Metadata         struct {
+			Identifiers []struct {
+				ID     string `xml:"id,attr"`
+				Scheme string `xml:"http://www.idpf.org/2007/opf scheme,attr"`
+				Value  string `xml:",chardata"`
+			} `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+			Title    string `xml:"http://purl.org/dc/elements/1.1/ title"`
+			Creators []struct {
+				FileAs string `xml:"http://www.idpf.org/2007/opf file-as,attr"`
+				Role   string `xml:"http://www.idpf.org/2007/opf role,attr"`
+				Name   string `xml:",chardata"`
+			} `xml:"http://purl.org/dc/elements/1.1/ creator"`
+			Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+			Description string   `xml:"http://purl.org/dc/elements/1.1/ description"`
+			Subjects    []string `xml:"http://purl.org/dc/elements/1.1/ subject"`
+			Metas       []struct {
+				Name    string `xml:"name,attr"`
+				Content string `xml:"content,attr"`
+			} `xml:"meta"`
+		} `xml:"http://www.idpf.org/2007/opf metadata"`
+	}
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		t.Fatalf("invalid OPF: %v\n%s", err, data)
+	}
+	meta := pkg.Metadata
+
+	if pkg.UniqueIdentifier != "uuid_id" {
+		t.Errorf("unique-identifier = %q", pkg.UniqueIdentifier)
+	}
+	identifiers := map[string]string{}
+	for _, id := range meta.Identifiers {
+		identifiers[id.Scheme] = id.Value
+	}
+	// identifiers表中的isbn优先于books表的isbn列
+	wantIdentifiers := map[string]string{
+		"calibre":   "7",
+		"uuid":      book.UUID,
+		"GOODREADS": "234225",
+		"ISBN":      "0441172717",
+	}
+	if !reflect.DeepEqual(identifiers, wantIdentifiers) {
+		t.Errorf("identifiers = %v, want %v", identifiers, wantIdentifiers)
+	}
+
+	if meta.Title != book.Title {
+		t.Errorf("title = %q", meta.Title)
+	}
+	if len(meta.Creators) != 2 || meta.Creators[0].Name != "Frank Herbert" || meta.Creators[0].FileAs != "Herbert, Frank" ||
+		meta.Creators[0].Role != "aut" || meta.Creators[1].Name != "Brian Herbert" {
+		t.Errorf("creators = %+v", meta.Creators)
+	}
+	if meta.Date != pubdate {
+		t.Errorf("date = %q, want %q", meta.Date, pubdate)
+	}
+	if meta.Description != book.Comments {
+		t.Errorf("description = %q", meta.Description)
+	}
+	if !reflect.DeepEqual(meta.Subjects, book.Tags) {
+		t.Errorf("subjects = %v, want %v", meta.Subjects, book.Tags)
+	}
+
+	metas := map[string]string{}
+	for _, m := range meta.Metas {
+		metas[m.Name] = m.Content
+	}
+	wantMetas := map[string]string{
+		"calibre:series":       "Dune",
+		"calibre:series_index": "1.5",
+		"calibre:rating":       "8",
+		"calibre:timestamp":    "2024-01-02T03:04:05Z",
+	}
+	if !reflect.DeepEqual(metas, wantMetas) {
+		t.Errorf("metas = %v, want %v", metas, wantMetas)
+	}
+}
+
+func TestExportUnknownPubDate(t *testing.T) {
+	pubdate := "0101-01-01T00:00:00+00:00"
+	data, err := Export(&database.Book{ID: 1, Title: "Undated", PubDate: &pubdate}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	if strings.Contains(out, "<dc:date>") {
+		t.Errorf("unexpected date for Calibre's unknown pubdate:\n%s", out)
+	}
+	// 没有UUID时以calibre_id作为唯一标识
+	if !strings.Contains(out, `unique-identifier="calibre_id"`) {
+		t.Errorf("missing calibre_id unique identifier:\n%s", out)
+	}
+}
@@ -0,0 +1,118 @@
+package opf
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// XML命名空间
+const (
+	NamespaceOPF = "http://www.idpf.org/2007/opf"
+	NamespaceDC  = "http://purl.org/dc/elements/1.1/"
+)
+
+// Package OPF文档（metadata.opf或EPUB内的content.opf）
+type Package struct {
+	XMLName  xml.Name `xml:"http://www.idpf.org/2007/opf package"`
+	Metadata Metadata `xml:"metadata"`
+	Manifest Manifest `xml:"manifest"`
+	Spine    Spine    `xml:"spine"`
+}
+
+// Metadata OPF元数据
+type Metadata struct {
+	Titles      []string     `xml:"http://purl.org/dc/elements/1.1/ title"`
+	Description string       `xml:"http://purl.org/dc/elements/1.1/ description"`
+	Identifiers []Identifier `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+	Metas       []Meta       `xml:"meta"`
+}
+
+// Meta OPF 2.0的meta元素，如<meta name="cover" content="cover-image"/>
+type Meta struct {
+	Name    string `xml:"name,attr"`
+	Content string `xml:"content,attr"`
+}
+
+// Identifier 书籍标识符
+type Identifier struct {
+	Scheme string `xml:"http://www.idpf.org/2007/opf scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// Manifest EPUB资源清单
+type Manifest struct {
+	Items []Item `xml:"item"`
+}
+
+// Item 清单中的资源
+type Item struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+// Spine EPUB阅读顺序
+type Spine struct {
+	ItemRefs []ItemRef `xml:"itemref"`
+}
+
+// ItemRef 阅读顺序中的资源引用
+type ItemRef struct {
+	IDRef string `xml:"idref,attr"`
+}
+
+// ReadFile 读取并解析OPF文件
+func ReadFile(path string) (*Package, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse opf %s: %w", path, err)
+	}
+	return pkg, nil
+}
+
+// Parse 解析OPF文档
+func Parse(data []byte) (*Package, error) {
+	var pkg Package
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+// Item 按ID查找清单中的资源
+func (m *Manifest) Item(id string) *Item {
+	for i := range m.Items {
+		if m.Items[i].ID == id {
+			return &m.Items[i]
+		}
+	}
+	return nil
+}
+
+// Meta 按name查找meta元素的content
+func (m *Metadata) Meta(name string) string {
+	for _, meta := range m.Metas {
+		if meta.Name == name {
+			return strings.TrimSpace(meta.Content)
+		}
+	}
+	return ""
+}
+
+// Identifier 按scheme查找标识符（不区分大小写）
+func (m *Metadata) Identifier(scheme string) string {
+	for _, id := range m.Identifiers {
+		if strings.EqualFold(id.Scheme, scheme) {
+			return strings.TrimSpace(id.Value)
+		}
+	}
+	return ""
+}
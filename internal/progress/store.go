@@ -0,0 +1,93 @@
+package progress
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Progress 用户在某本书上的阅读进度
+type Progress struct {
+	User       string    `json:"user"`
+	BookID     int       `json:"book_id"`
+	Position   string    `json:"position,omitempty"` // 客户端自定义的位置，如EPUB CFI或页码
+	Percentage float64   `json:"percentage"`         // 阅读百分比，0-100
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Store 阅读进度存储，使用独立于Calibre数据库的可写SQLite文件
+type Store struct {
+	conn *sql.DB
+}
+
+// Open 打开阅读进度数据库，不存在时创建
+func Open(path string) (*Store, error) {
+	conn, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open progress database: %w", err)
+	}
+	// SQLite同一时间只允许一个写入者
+	conn.SetMaxOpenConns(1)
+
+	_, err = conn.Exec(`
+		CREATE TABLE IF NOT EXISTS reading_progress (
+			user       TEXT    NOT NULL,
+			book       INTEGER NOT NULL,
+			position   TEXT    NOT NULL DEFAULT '',
+			percentage REAL    NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (user, book)
+		)
+	`)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create progress table: %w", err)
+	}
+
+	return &Store{conn: conn}, nil
+}
+
+// Close 关闭数据库
+func (s *Store) Close() error {
+	return s.conn.Close()
+}
+
+// Update 保存用户的阅读进度，覆盖之前的记录
+func (s *Store) Update(p Progress) error {
+	_, err := s.conn.Exec(`
+		INSERT INTO reading_progress (user, book, position, percentage, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user, book) DO UPDATE SET
+			position = excluded.position,
+			percentage = excluded.percentage,
+			updated_at = excluded.updated_at
+	`, p.User, p.BookID, p.Position, p.Percentage, p.UpdatedAt.UTC())
+	return err
+}
+
+// InProgress 返回用户已开始但未读完的书籍，按最近更新时间倒序
+func (s *Store) InProgress(user string, limit int) ([]Progress, error) {
+	rows, err := s.conn.Query(`
+		SELECT user, book, position, percentage, updated_at
+		FROM reading_progress
+		WHERE user = ? AND percentage > 0 AND percentage < 100
+		ORDER BY updated_at DESC, book DESC
+		LIMIT ?
+	`, user, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Progress
+	for rows.Next() {
+		var p Progress
+		if err := rows.Scan(&p.User, &p.BookID, &p.Position, &p.Percentage, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,204 @@
+// Package testutil 提供测试用的Calibre书库
+package testutil
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// schema Calibre metadata.db中服务器读取的表
+const schema = `
+CREATE TABLE books(id INTEGER PRIMARY KEY, title TEXT, sort TEXT, timestamp TIMESTAMP, pubdate TIMESTAMP, series_index REAL, author_sort TEXT, isbn TEXT, lccn TEXT, path TEXT, flags INT, uuid TEXT, has_cover BOOL, last_modified TIMESTAMP);
+CREATE TABLE authors(id INTEGER PRIMARY KEY, name TEXT, sort TEXT, link TEXT);
+CREATE TABLE books_authors_link(id INTEGER PRIMARY KEY, book INT, author INT);
+CREATE TABLE tags(id INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE books_tags_link(id INTEGER PRIMARY KEY, book INT, tag INT);
+CREATE TABLE series(id INTEGER PRIMARY KEY, name TEXT, sort TEXT);
+CREATE TABLE books_series_link(id INTEGER PRIMARY KEY, book INT, series INT);
+CREATE TABLE data(id INTEGER PRIMARY KEY, book INT, format TEXT, uncompressed_size INT, name TEXT);
+CREATE TABLE comments(id INTEGER PRIMARY KEY, book INT, text TEXT);
+CREATE TABLE ratings(id INTEGER PRIMARY KEY, rating INT);
+CREATE TABLE books_ratings_link(id INTEGER PRIMARY KEY, book INT, rating INT);
+CREATE TABLE identifiers(id INTEGER PRIMARY KEY, book INTEGER, type TEXT, val TEXT);
+CREATE TABLE languages(id INTEGER PRIMARY KEY, lang_code TEXT NOT NULL UNIQUE);
+CREATE TABLE books_languages_link(id INTEGER PRIMARY KEY, book INTEGER NOT NULL, lang_code INTEGER NOT NULL, item_order INTEGER NOT NULL DEFAULT 0);
+CREATE TABLE publishers(id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE, sort TEXT);
+CREATE TABLE books_publishers_link(id INTEGER PRIMARY KEY, book INTEGER NOT NULL, publisher INTEGER NOT NULL);
+`
+
+// Library 临时目录中的测试书库
+type Library struct {
+	Root   string // 书库根目录
+	DBPath string // metadata.db路径
+
+	db *sql.DB
+}
+
+// Book 添加到测试书库的书籍
+type Book struct {
+	Title       string
+	Authors     []string
+	Series      string
+	SeriesIndex float64
+	Tags        []string
+	Formats     []string // 每种格式在书籍目录中创建一个小文件
+	Comments    string
+	Publisher   string
+	Language    string
+	PubDate     time.Time
+	Added       time.Time // 为零时使用当前时间
+}
+
+// NewLibrary 在t.TempDir()中创建空的Calibre书库
+func NewLibrary(t testing.TB) *Library {
+	t.Helper()
+	return NewLibraryAt(t, t.TempDir())
+}
+
+// NewLibraryAt 在root目录中创建空的Calibre书库
+func NewLibraryAt(t testing.TB, root string) *Library {
+	t.Helper()
+
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(root, "metadata.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	return &Library{Root: root, DBPath: dbPath, db: db}
+}
+
+// Exec 直接执行SQL，用于构造特殊数据
+func (l *Library) Exec(t testing.TB, query string, args ...any) {
+	t.Helper()
+	if _, err := l.db.Exec(query, args...); err != nil {
+		t.Fatalf("exec %q: %v", query, err)
+	}
+}
+
+// AddBook 添加书籍并创建书籍目录和格式文件，返回书籍ID
+func (l *Library) AddBook(t testing.TB, book Book) int {
+	t.Helper()
+
+	added := book.Added
+	if added.IsZero() {
+		added = time.Now()
+	}
+	authorSort := ""
+	if len(book.Authors) > 0 {
+		authorSort = book.Authors[0]
+	}
+	var pubdate any
+	if !book.PubDate.IsZero() {
+		pubdate = book.PubDate.UTC().Format("2006-01-02 15:04:05+00:00")
+	}
+	stamp := added.UTC().Format("2006-01-02 15:04:05+00:00")
+
+	res, err := l.db.Exec(`INSERT INTO books(title, sort, timestamp, pubdate, series_index, author_sort, path, flags, uuid, has_cover, last_modified)
+		VALUES (?, ?, ?, ?, ?, ?, '', 1, '', 0, ?)`,
+		book.Title, book.Title, stamp, pubdate, book.SeriesIndex, authorSort, stamp)
+	if err != nil {
+		t.Fatalf("insert book: %v", err)
+	}
+	id64, _ := res.LastInsertId()
+	id := int(id64)
+
+	author := "Unknown"
+	if len(book.Authors) > 0 {
+		author = book.Authors[0]
+	}
+	path := fmt.Sprintf("%s/%s (%d)", author, book.Title, id)
+	l.Exec(t, `UPDATE books SET path = ?, uuid = ? WHERE id = ?`, path, fmt.Sprintf("uuid-%d", id), id)
+	if err := os.MkdirAll(filepath.Join(l.Root, path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range book.Authors {
+		l.link(t, id, "authors", "name", name, "books_authors_link", "author")
+	}
+	for _, name := range book.Tags {
+		l.link(t, id, "tags", "name", name, "books_tags_link", "tag")
+	}
+	if book.Series != "" {
+		l.link(t, id, "series", "name", book.Series, "books_series_link", "series")
+	}
+	if book.Publisher != "" {
+		l.link(t, id, "publishers", "name", book.Publisher, "books_publishers_link", "publisher")
+	}
+	if book.Language != "" {
+		l.link(t, id, "languages", "lang_code", book.Language, "books_languages_link", "lang_code")
+	}
+	if book.Comments != "" {
+		l.Exec(t, `INSERT INTO comments(book, text) VALUES (?, ?)`, id, book.Comments)
+	}
+
+	fileName := fmt.Sprintf("%s - %s", book.Title, author)
+	for _, format := range book.Formats {
+		content := []byte(book.Title + " " + format)
+		l.Exec(t, `INSERT INTO data(book, format, uncompressed_size, name) VALUES (?, ?, ?, ?)`,
+			id, format, len(content), fileName)
+		l.WriteFile(t, id, fileName+"."+strings.ToLower(format), content)
+	}
+	return id
+}
+
+// SetCover 写入书籍目录中的cover.jpg并设置has_cover
+func (l *Library) SetCover(t testing.TB, bookID int, data []byte) {
+	t.Helper()
+	l.WriteFile(t, bookID, "cover.jpg", data)
+	l.Exec(t, `UPDATE books SET has_cover = 1 WHERE id = ?`, bookID)
+}
+
+// WriteFile 在书籍目录中写入文件
+func (l *Library) WriteFile(t testing.TB, bookID int, name string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(l.BookDir(t, bookID), name), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BookDir 返回书籍目录的绝对路径
+func (l *Library) BookDir(t testing.TB, bookID int) string {
+	t.Helper()
+	var path string
+	if err := l.db.QueryRow(`SELECT path FROM books WHERE id = ?`, bookID).Scan(&path); err != nil {
+		t.Fatalf("book %d: %v", bookID, err)
+	}
+	return filepath.Join(l.Root, filepath.FromSlash(path))
+}
+
+// link 按名称查找或创建关联项，并关联到书籍
+func (l *Library) link(t testing.TB, bookID int, table, column, value, linkTable, linkColumn string) {
+	t.Helper()
+
+	var itemID int64
+	err := l.db.QueryRow(fmt.Sprintf(`SELECT id FROM %s WHERE %s = ?`, table, column), value).Scan(&itemID)
+	if err == sql.ErrNoRows {
+		var res sql.Result
+		if table == "tags" || table == "languages" {
+			res, err = l.db.Exec(fmt.Sprintf(`INSERT INTO %s(%s) VALUES (?)`, table, column), value)
+		} else {
+			res, err = l.db.Exec(fmt.Sprintf(`INSERT INTO %s(%s, sort) VALUES (?, ?)`, table, column), value, value)
+		}
+		if err != nil {
+			t.Fatalf("insert %s: %v", table, err)
+		}
+		itemID, _ = res.LastInsertId()
+	} else if err != nil {
+		t.Fatalf("find %s: %v", table, err)
+	}
+	l.Exec(t, fmt.Sprintf(`INSERT INTO %s(book, %s) VALUES (?, ?)`, linkTable, linkColumn), bookID, itemID)
+}
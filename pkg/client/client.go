@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Client REST API客户端
+type Client struct {
+	// BaseURL 服务器地址，包含路径前缀，如 http://localhost:1580/library
+	BaseURL string
+	// HTTPClient 发送请求使用的HTTP客户端，为nil时使用http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// New 创建客户端
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// APIError 服务器返回的错误
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("opds api: %d %s", e.StatusCode, e.Message)
+}
+
+// ListBooksOptions 书籍列表的查询条件
+type ListBooksOptions struct {
+	Search   string
+	Author   string
+	Series   string
+	Tag      string
+	HasCover *bool
+	Limit    int
+	Offset   int
+}
+
+// BookList 书籍列表
+type BookList struct {
+	Books   []Book `json:"books"`
+	Total   int    `json:"total"` // 符合条件的书籍总数
+	Count   int    `json:"count"` // 本页书籍数
+	HasMore bool   `json:"has_more"`
+	Limit   int    `json:"limit"`
+	Offset  int    `json:"offset"`
+}
+
+// ListBooks 获取书籍列表
+func (c *Client) ListBooks(ctx context.Context, opts ListBooksOptions) (*BookList, error) {
+	params := url.Values{}
+	if opts.Search != "" {
+		params.Set("search", opts.Search)
+	}
+	if opts.Author != "" {
+		params.Set("author", opts.Author)
+	}
+	if opts.Series != "" {
+		params.Set("series", opts.Series)
+	}
+	if opts.Tag != "" {
+		params.Set("tag", opts.Tag)
+	}
+	if opts.HasCover != nil {
+		params.Set("has_cover", strconv.FormatBool(*opts.HasCover))
+	}
+	if opts.Limit > 0 {
+		params.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		params.Set("offset", strconv.Itoa(opts.Offset))
+	}
+
+	resp, err := c.get(ctx, "/api/books", params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list BookList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decode books: %w", err)
+	}
+	return &list, nil
+}
+
+// GetBook 获取书籍详情
+func (c *Client) GetBook(ctx context.Context, id int) (*Book, error) {
+	resp, err := c.get(ctx, fmt.Sprintf("/api/book/%d", id), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var book Book
+	if err := json.NewDecoder(resp.Body).Decode(&book); err != nil {
+		return nil, fmt.Errorf("decode book: %w", err)
+	}
+	return &book, nil
+}
+
+// Stats 获取书库统计信息
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	resp, err := c.get(ctx, "/api/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var stats Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("decode stats: %w", err)
+	}
+	return &stats, nil
+}
+
+// Download 下载书籍文件，调用方负责关闭返回的Body
+func (c *Client) Download(ctx context.Context, id int, format string) (io.ReadCloser, error) {
+	resp, err := c.get(ctx, fmt.Sprintf("/download/%d/%s", id, url.PathEscape(format)), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// get 发送GET请求，非2xx响应转换为APIError
+func (c *Client) get(ctx context.Context, path string, params url.Values) (*http.Response, error) {
+	target := c.BaseURL + path
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, newAPIError(resp)
+	}
+	return resp, nil
+}
+
+// newAPIError 从响应中解析错误信息，JSON错误格式为{"error": "..."}，其他响应使用正文文本
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+
+	var envelope struct {
+		Error string `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error != "" {
+		message = envelope.Error
+	}
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+
+	return &APIError{StatusCode: resp.StatusCode, Message: message}
+}
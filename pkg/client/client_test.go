@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestClient 启动返回固定响应的测试服务器，并记录最近一次请求
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, **http.Request) {
+	t.Helper()
+	var last *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := New(srv.URL + "/library/")
+	c.HTTPClient = srv.Client()
+	return c, &last
+}
+
+func TestListBooks(t *testing.T) {
+	c, last := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"books":[{"id":7,"title":"Dune","authors":[{"name":"Frank Herbert","sort":"Herbert, Frank"}],
+			"series":{"name":"Dune","sort":"Dune","index":1},"formats":[{"format":"EPUB","size":1024,"filename":"Dune - Frank Herbert"}],
+			"unknown_field":true}],"total":12,"count":1,"has_more":true,"limit":1,"offset":3}`)
+	})
+
+	hasCover := true
+	list, err := c.ListBooks(context.Background(), ListBooksOptions{
+		Search: "dune", Author: "Frank Herbert", Tag: "SF", HasCover: &hasCover, Limit: 1, Offset: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := *last
+	if req.URL.Path != "/library/api/books" {
+		t.Errorf("path = %q", req.URL.Path)
+	}
+	want := map[string]string{"search": "dune", "author": "Frank Herbert", "tag": "SF", "has_cover": "true", "limit": "1", "offset": "3"}
+	for key, value := range want {
+		if got := req.URL.Query().Get(key); got != value {
+			t.Errorf("query %s = %q, want %q", key, got, value)
+		}
+	}
+	if req.URL.Query().Has("series") {
+		t.Error("empty series should not be sent")
+	}
+	if got := req.Header.Get("Accept"); got != "application/json" {
+		t.Errorf("Accept = %q", got)
+	}
+
+	if list.Total != 12 || list.Count != 1 || !list.HasMore || len(list.Books) != 1 {
+		t.Fatalf("list = %+v", list)
+	}
+	book := list.Books[0]
+	if book.ID != 7 || book.Title != "Dune" || book.Authors[0].Sort != "Herbert, Frank" {
+		t.Errorf("book = %+v", book)
+	}
+	if book.Series == nil || book.Series.Index == nil || *book.Series.Index != 1 {
+		t.Errorf("series = %+v", book.Series)
+	}
+	if len(book.Formats) != 1 || book.Formats[0].Size != 1024 {
+		t.Errorf("formats = %+v", book.Formats)
+	}
+}
+
+func TestGetBookAndStats(t *testing.T) {
+	c, last := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/library/api/book/7":
+			io.WriteString(w, `{"id":7,"title":"Dune","has_cover":true,"cover_data_uri":"data:image/jpeg;base64,AA=="}`)
+		case "/library/api/stats":
+			io.WriteString(w, `{"total_books":3,"total_authors":2,"formats":{"EPUB":3}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	book, err := c.GetBook(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.ID != 7 || !book.HasCover || book.CoverDataURI == "" {
+		t.Errorf("book = %+v", book)
+	}
+
+	stats, err := c.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*last).URL.Path != "/library/api/stats" || stats.TotalBooks != 3 || stats.Formats["EPUB"] != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		want        string
+	}{
+		{"json error", "application/json", `{"error":"Book not found"}`, http.StatusNotFound, "Book not found"},
+		{"text error", "text/plain", "database unavailable\n", http.StatusServiceUnavailable, "database unavailable"},
+		{"empty body", "text/plain", "", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)},
+	}
+	for _, tt := range tests {
+		c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tt.contentType)
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.body)
+		})
+
+		_, err := c.GetBook(context.Background(), 1)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("%s: err = %v, want *APIError", tt.name, err)
+		}
+		if apiErr.StatusCode != tt.status || apiErr.Message != tt.want {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, apiErr.StatusCode, apiErr.Message, tt.status, tt.want)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	c, last := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "book content")
+	})
+
+	body, err := c.Download(context.Background(), 7, "EPUB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "book content" {
+		t.Errorf("body = %q", data)
+	}
+	if (*last).URL.Path != "/library/download/7/EPUB" {
+		t.Errorf("path = %q", (*last).URL.Path)
+	}
+}
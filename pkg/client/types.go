@@ -0,0 +1,61 @@
+package client
+
+import "time"
+
+// 以下类型对应REST API的JSON响应，与服务端内部的数据库模型解耦，
+// 服务端新增字段不影响解码
+
+// Book 书籍
+type Book struct {
+	ID           int       `json:"id"`
+	Title        string    `json:"title"`
+	AuthorSort   string    `json:"author_sort"`
+	Path         string    `json:"path"`
+	SeriesIndex  *float64  `json:"series_index,omitempty"`
+	ISBN         *string   `json:"isbn,omitempty"`
+	PubDate      *string   `json:"pubdate,omitempty"`
+	LastModified time.Time `json:"last_modified"`
+	Timestamp    time.Time `json:"timestamp"` // 添加到书库的时间
+	HasCover     bool      `json:"has_cover"`
+	UUID         string    `json:"uuid"`
+	Comments     string    `json:"comments,omitempty"`
+	Rating       *int      `json:"rating,omitempty"` // Calibre评分，范围0-10
+	IsNew        bool      `json:"is_new,omitempty"`
+
+	Authors     []Author          `json:"authors,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Languages   []string          `json:"languages,omitempty"` // ISO 639-2语言代码，如eng、zho
+	Series      *Series           `json:"series,omitempty"`
+	Formats     []Format          `json:"formats,omitempty"`
+	Identifiers map[string]string `json:"identifiers,omitempty"` // 键为类型，如isbn、amazon、goodreads
+
+	// CoverDataURI 封面缩略图的data URI，仅在GetBook请求内嵌封面时返回
+	CoverDataURI string `json:"cover_data_uri,omitempty"`
+}
+
+// Author 作者
+type Author struct {
+	Name string `json:"name"`
+	Sort string `json:"sort"`
+}
+
+// Series 系列
+type Series struct {
+	Name  string   `json:"name"`
+	Sort  string   `json:"sort"`
+	Index *float64 `json:"index,omitempty"`
+}
+
+// Format 书籍文件格式
+type Format struct {
+	Format   string `json:"format"`
+	Size     int64  `json:"size"`
+	Filename string `json:"filename"`
+}
+
+// Stats 书库统计信息
+type Stats struct {
+	TotalBooks   int            `json:"total_books"`
+	TotalAuthors int            `json:"total_authors"`
+	Formats      map[string]int `json:"formats"` // 各格式的书籍数
+}
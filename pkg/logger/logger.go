@@ -1,22 +1,171 @@
 package logger
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
 )
 
+// flags 日志行前缀中的日期、时间和调用位置
+const flags = log.Ldate | log.Ltime | log.Lshortfile
+
+// 各级别的日志，Init之前输出到标准输出和标准错误，Init后按配置的目标和级别输出
 var (
 	// Info 信息日志
-	Info *log.Logger
+	Info = log.New(os.Stdout, "INFO: ", flags)
 	// Warning 警告日志
-	Warning *log.Logger
+	Warning = log.New(os.Stdout, "WARNING: ", flags)
 	// Error 错误日志
-	Error *log.Logger
+	Error = log.New(os.Stderr, "ERROR: ", flags)
+)
+
+// 日志级别，低于当前级别的日志被丢弃
+const (
+	LevelInfo = iota
+	LevelWarning
+	LevelError
+)
+
+// level 当前日志级别
+var level atomic.Int32
+
+// 日志输出目标，由Init根据日志文件和控制台设置确定
+var (
+	output      io.Writer = os.Stdout
+	errorOutput io.Writer = os.Stderr
 )
 
-// Init 初始化日志系统
-func Init() {
-	Info = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	Warning = log.New(os.Stdout, "WARNING: ", log.Ldate|log.Ltime|log.Lshortfile)
-	Error = log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+// Init 初始化日志系统，level为INFO、WARNING或ERROR；
+// logFile不为空时以追加方式写入该文件，toConsole同时输出到控制台，文件无法打开时退回stderr。
+// 标准库log和gin的日志也使用相同的输出目标
+func Init(level, logFile string, toConsole bool) {
+	output, errorOutput = openOutputs(logFile, toConsole)
+
+	Error.SetOutput(errorOutput)
+	log.SetOutput(errorOutput)
+	gin.DefaultWriter = output
+	gin.DefaultErrorWriter = errorOutput
+	SetLevel(level)
+}
+
+// openOutputs 打开日志文件，返回普通日志和错误日志的输出目标
+func openOutputs(logFile string, toConsole bool) (io.Writer, io.Writer) {
+	if logFile == "" {
+		return os.Stdout, os.Stderr
+	}
+
+	file, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: cannot open log file %s, logging to stderr: %v\n", logFile, err)
+		return os.Stderr, os.Stderr
+	}
+	if toConsole {
+		return io.MultiWriter(os.Stdout, file), io.MultiWriter(os.Stderr, file)
+	}
+	return file, file
+}
+
+// SetLevel 设置日志级别，低于该级别的Info/Warning输出到io.Discard；
+// 不识别的级别按INFO处理，Error始终输出
+func SetLevel(name string) {
+	l := parseLevel(name)
+	level.Store(int32(l))
+
+	Info.SetOutput(outputFor(l, LevelInfo, output))
+	Warning.SetOutput(outputFor(l, LevelWarning, output))
+}
+
+// Enabled 判断指定级别的日志是否会输出
+func Enabled(l int) bool {
+	return l >= int(level.Load())
+}
+
+// parseLevel 解析级别名称，不区分大小写
+func parseLevel(name string) int {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "WARNING", "WARN":
+		return LevelWarning
+	case "ERROR":
+		return LevelError
+	}
+	return LevelInfo
+}
+
+// outputFor 级别为l的日志低于当前级别current时丢弃输出，否则返回w
+func outputFor(current, l int, w io.Writer) io.Writer {
+	if l < current {
+		return io.Discard
+	}
+	return w
+}
+
+// SlowRequests 只记录耗时超过threshold的请求的中间件
+func SlowRequests(threshold time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		latency := time.Since(start)
+		if latency < threshold {
+			return
+		}
+
+		path := c.Request.URL.Path
+		if c.Request.URL.RawQuery != "" {
+			path += "?" + c.Request.URL.RawQuery
+		}
+		Warning.Printf("Slow request: %s %s status=%d latency=%s client=%s request_id=%s",
+			c.Request.Method, path, c.Writer.Status(), latency, c.ClientIP(), c.GetHeader("X-Request-ID"))
+	}
+}
+
+// accessEntry JSON访问日志的一条记录
+type accessEntry struct {
+	Time      string  `json:"time"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Route     string  `json:"route,omitempty"`
+	Status    int     `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	ClientIP  string  `json:"client_ip"`
+	Bytes     int     `json:"bytes"`
+	RequestID string  `json:"request_id,omitempty"`
+}
+
+// AccessLog 每个请求输出一行JSON格式访问日志的中间件；route为匹配的路由模板，便于按端点统计
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		path := c.Request.URL.Path
+		if c.Request.URL.RawQuery != "" {
+			path += "?" + c.Request.URL.RawQuery
+		}
+		entry := accessEntry{
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			Method:    c.Request.Method,
+			Path:      path,
+			Route:     c.FullPath(),
+			Status:    c.Writer.Status(),
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			ClientIP:  c.ClientIP(),
+			Bytes:     max(c.Writer.Size(), 0),
+			RequestID: c.GetHeader("X-Request-ID"),
+		}
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		// 整行一次写入，避免并发请求的日志交错
+		output.Write(append(line, '\n'))
+	}
 }
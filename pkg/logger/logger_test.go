@@ -0,0 +1,49 @@
+package logger
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSlowRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	Warning.SetOutput(&buf)
+	t.Cleanup(func() { Warning.SetOutput(os.Stdout) })
+
+	router := gin.New()
+	router.Use(SlowRequests(50 * time.Millisecond))
+	router.GET("/slow", func(c *gin.Context) {
+		time.Sleep(60 * time.Millisecond)
+		c.Status(http.StatusAccepted)
+	})
+	router.GET("/fast", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for target, requestID := range map[string]string{"/fast": "req-fast", "/slow?q=dune": "req-slow"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("X-Request-ID", requestID)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	out := buf.String()
+	if strings.Count(out, "Slow request") != 1 {
+		t.Fatalf("want exactly one slow request line:\n%s", out)
+	}
+	for _, want := range []string{"GET /slow?q=dune", "status=202", "request_id=req-slow"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in %q", want, out)
+		}
+	}
+	if strings.Contains(out, "/fast") {
+		t.Errorf("fast request logged: %q", out)
+	}
+}